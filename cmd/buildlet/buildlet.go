@@ -58,6 +58,28 @@ var (
 	hostname     = flag.String("hostname", "", "hostname to advertise to coordinator for reverse mode; default is actual hostname")
 )
 
+// hostTags are the --host-tag values: key=value pairs describing the
+// machine, such as its data center, passed to the coordinator in
+// reverse mode.
+var hostTags hostTagList
+
+func init() {
+	flag.Var(&hostTags, "host-tag", "key=value tag describing the machine, such as facility=ams1, to pass to the coordinator in reverse mode; may be repeated")
+}
+
+// hostTagList is a flag.Value accumulating key=value strings.
+type hostTagList []string
+
+func (l *hostTagList) String() string { return strings.Join(*l, ",") }
+
+func (l *hostTagList) Set(v string) error {
+	if i := strings.Index(v, "="); i <= 0 {
+		return fmt.Errorf("%q isn't of the form key=value", v)
+	}
+	*l = append(*l, v)
+	return nil
+}
+
 // Bump this whenever something notable happens, or when another
 // component needs a certain feature. This shows on the coordinator
 // per reverse client, and is also accessible via the buildlet
@@ -73,7 +95,10 @@ var (
 //   16: make macstadium builders always haltEntireOS
 //   17: make macstadium halts use sudo
 //   18: set TMPDIR and GOCACHE
-const buildletVersion = 18
+//   19: --host-tag
+//   20: leave the Windows serial port to stage0 if it has it open
+//   21: log to the serial port handle stage0 passes on Windows
+const buildletVersion = 21
 
 func defaultListenAddr() string {
 	if runtime.GOOS == "darwin" {
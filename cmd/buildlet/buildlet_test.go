@@ -59,3 +59,20 @@ func TestSetPathEnv(t *testing.T) {
 		}
 	}
 }
+
+func TestHostTagList(t *testing.T) {
+	var l hostTagList
+	for _, v := range []string{"facility=ams1", "plan=c2.large.arm"} {
+		if err := l.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	if got, want := l.String(), "facility=ams1,plan=c2.large.arm"; got != want {
+		t.Errorf("tags = %q; want %q", got, want)
+	}
+	for _, v := range []string{"ams1", "=ams1"} {
+		if err := l.Set(v); err == nil {
+			t.Errorf("Set(%q) succeeded; want error", v)
+		}
+	}
+}
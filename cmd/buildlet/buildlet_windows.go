@@ -5,8 +5,10 @@
 package main
 
 import (
+	"io"
 	"log"
 	"os"
+	"strconv"
 	"syscall"
 	"unsafe"
 
@@ -19,6 +21,24 @@ func init() {
 }
 
 func configureSerialLogOutputWindows() {
+	if v := os.Getenv("GO_STAGE0_SERIAL_HANDLE"); v != "" {
+		// stage0 handed us its handle to the port.
+		h, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			log.Printf("bad GO_STAGE0_SERIAL_HANDLE %q: %v", v, err)
+			return
+		}
+		// Also log to stderr, which stage0 keeps the tail
+		// of for failure reports.
+		log.SetOutput(io.MultiWriter(os.NewFile(uintptr(h), "COM1"), os.Stderr))
+		return
+	}
+	if port := os.Getenv("GO_STAGE0_SERIAL_LOG"); port != "" {
+		// stage0 has the port open, and only one process can,
+		// but it copies our output there.
+		log.Printf("logging to %s via stage0", port)
+		return
+	}
 	c := &serial.Config{Name: "COM1", Baud: 9600}
 	s, err := serial.OpenPort(c)
 	if err != nil {
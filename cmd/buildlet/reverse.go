@@ -23,6 +23,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/build"
@@ -161,6 +162,7 @@ func dialCoordinator() error {
 	req.Header["X-Go-Builder-Key"] = keys
 	req.Header.Set("X-Go-Builder-Hostname", *hostname)
 	req.Header.Set("X-Go-Builder-Version", strconv.Itoa(buildletVersion))
+	req.Header["X-Go-Builder-Host-Tag"] = hostTags
 	if err := req.Write(conn); err != nil {
 		return fmt.Errorf("coordinator /reverse request failed: %v", err)
 	}
@@ -174,7 +176,7 @@ func dialCoordinator() error {
 	}
 
 	log.Printf("Connected to coordinator; reverse dialing active")
-	srv := &http.Server{}
+	srv := &http.Server{Handler: heartbeatHandler(http.DefaultServeMux)}
 	ln := revdial.NewListener(bufio.NewReadWriter(
 		bufio.NewReader(conn),
 		bufio.NewWriter(deadlinePerWriteConn{conn, 60 * time.Second}),
@@ -186,6 +188,44 @@ func dialCoordinator() error {
 	return fmt.Errorf("http.Serve on reverse connection complete: %v", err)
 }
 
+// heartbeatInterval is how often the heartbeat file is touched while
+// the coordinator has a request in progress.
+const heartbeatInterval = 30 * time.Second
+
+// heartbeatHandler returns h, wrapped to touch the file named by
+// $GO_BUILDLET_HEARTBEAT_FILE, if set, whenever the coordinator is
+// talking to us: on each request, and periodically while any request
+// is running. stage0 kills the buildlet if the file goes stale, which
+// catches a dead connection to the coordinator that would otherwise
+// leave the buildlet waiting forever.
+func heartbeatHandler(h http.Handler) http.Handler {
+	file := os.Getenv("GO_BUILDLET_HEARTBEAT_FILE")
+	if file == "" {
+		return h
+	}
+	var active int32
+	touch := func() {
+		now := time.Now()
+		if err := os.Chtimes(file, now, now); os.IsNotExist(err) {
+			ioutil.WriteFile(file, nil, 0644)
+		}
+	}
+	touch()
+	go func() {
+		for range time.Tick(heartbeatInterval) {
+			if atomic.LoadInt32(&active) > 0 {
+				touch()
+			}
+		}
+	}()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		touch()
+		atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		h.ServeHTTP(w, r)
+	})
+}
+
 var coordDialer = &net.Dialer{
 	Timeout:   10 * time.Second,
 	KeepAlive: 15 * time.Second,
@@ -0,0 +1,160 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+var k8sTokenHosts = flag.String("k8s-token-hosts", ".svc,.svc.cluster.local", "when $IN_KUBERNETES=1, comma-separated hosts to send the pod's service account token to when downloading the buildlet; entries starting with a dot match subdomains")
+
+// defaultK8sTokenFile is where Kubernetes mounts a pod's service
+// account token. META_BUILDLET_BINARY_TOKEN_FILE overrides it.
+const defaultK8sTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// downloadAuth, if non-nil, returns the Authorization header to send
+// with a request to u. It's set only while downloading the buildlet,
+// so credentials are never sent with network probes or metadata
+// requests.
+var downloadAuth struct {
+	sync.Mutex
+	fn func(u *url.URL) (string, error)
+}
+
+// withDownloadAuth runs f with fn providing Authorization headers.
+func withDownloadAuth(fn func(u *url.URL) (string, error), f func()) {
+	downloadAuth.Lock()
+	downloadAuth.fn = fn
+	downloadAuth.Unlock()
+	defer func() {
+		downloadAuth.Lock()
+		downloadAuth.fn = nil
+		downloadAuth.Unlock()
+	}()
+	f()
+}
+
+// buildletAuthAttr is the optional GCE instance attribute containing
+// the Authorization header value, such as "Bearer abc123", to send
+// when downloading the buildlet. Off GCE, the META_BUILDLET_BINARY_AUTH
+// environment variable is used instead. It's only sent to the hosts
+// of the buildlet URLs, and must never be logged.
+const buildletAuthAttr = "buildlet-binary-auth"
+
+// buildletAuth provides the Authorization headers for downloading the
+// buildlet.
+type buildletAuth struct {
+	static string // from buildletAuthAttr, or empty
+
+	mu    sync.Mutex
+	hosts map[string]bool // hosts of the buildlet URLs
+}
+
+func newBuildletAuth() *buildletAuth {
+	return &buildletAuth{
+		static: metaValue(buildletAuthAttr, "META_BUILDLET_BINARY_AUTH"),
+		hosts:  map[string]bool{},
+	}
+}
+
+// addURLs records the hosts of the comma-separated buildlet URLs
+// as ones that may receive the static Authorization header.
+func (a *buildletAuth) addURLs(urls string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range splitURLs(urls) {
+		if u, err := url.Parse(s); err == nil && u.Hostname() != "" {
+			a.hosts[strings.ToLower(u.Hostname())] = true
+		}
+	}
+}
+
+// header returns the Authorization header to send with a request for
+// the buildlet to u, if any.
+func (a *buildletAuth) header(u *url.URL) (string, error) {
+	a.mu.Lock()
+	ok := a.hosts[strings.ToLower(u.Hostname())]
+	a.mu.Unlock()
+	if a.static != "" && ok {
+		return a.static, nil
+	}
+	if os.Getenv("IN_KUBERNETES") == "1" && hostMatches(u.Hostname(), *k8sTokenHosts) {
+		tok, err := k8sToken()
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + tok, nil
+	}
+	return "", nil
+}
+
+// k8sToken returns the pod's service account token.
+func k8sToken() (string, error) {
+	file := os.Getenv("META_BUILDLET_BINARY_TOKEN_FILE")
+	if file == "" {
+		file = defaultK8sTokenFile
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading Kubernetes service account token: %v", err)
+	}
+	tok := strings.TrimSpace(string(b))
+	if tok == "" {
+		return "", fmt.Errorf("Kubernetes service account token file %s is empty", file)
+	}
+	return tok, nil
+}
+
+// hostMatches reports whether host is in the comma-separated list of
+// hosts. List entries starting with a dot match any subdomain.
+func hostMatches(host, list string) bool {
+	host = strings.ToLower(host)
+	for _, h := range strings.Split(list, ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		switch {
+		case h == "":
+		case strings.HasPrefix(h, "."):
+			if strings.HasSuffix(host, h) {
+				return true
+			}
+		case host == h:
+			return true
+		}
+	}
+	return false
+}
+
+// authTransport is an http.RoundTripper that adds the Authorization
+// header given by downloadAuth, if any, to requests without one.
+// Since it's consulted for each request, including redirects, the
+// header is only ever sent to the hosts downloadAuth allows.
+type authTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	downloadAuth.Lock()
+	fn := downloadAuth.fn
+	downloadAuth.Unlock()
+	if fn == nil || req.Header.Get("Authorization") != "" {
+		return t.rt.RoundTrip(req)
+	}
+	h, err := fn(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if h != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", h)
+	}
+	return t.rt.RoundTrip(req)
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHostMatches(t *testing.T) {
+	const list = ".svc, builds.example.com"
+	tests := map[string]bool{
+		"buildlets.default.svc":       true,
+		"BUILDLETS.DEFAULT.SVC":       true,
+		"builds.example.com":          true,
+		"evil.builds.example.com":     false,
+		"svc":                         false,
+		"storage.googleapis.com":      false,
+		"buildlets.default.svc.evil":  false,
+		"buildlets.default.evilsvc":   false,
+		"buildlets.default.svc.local": false,
+	}
+	for host, want := range tests {
+		if got := hostMatches(host, list); got != want {
+			t.Errorf("hostMatches(%q) = %v; want %v", host, got, want)
+		}
+	}
+}
+
+func TestBuildletAuthK8sToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage0-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"IN_KUBERNETES", "META_BUILDLET_BINARY_TOKEN_FILE"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("IN_KUBERNETES", "1")
+	os.Setenv("META_BUILDLET_BINARY_TOKEN_FILE", tokFile)
+
+	in, _ := url.Parse("http://buildlets.default.svc/buildlet.linux-amd64")
+	out, _ := url.Parse("https://storage.googleapis.com/go-builder-data/buildlet.linux-amd64")
+	a := &buildletAuth{hosts: map[string]bool{}}
+	if h, err := a.header(in); h != "Bearer s3cret" || err != nil {
+		t.Errorf("in-cluster auth = %q, %v; want bearer token", h, err)
+	}
+	if h, err := a.header(out); h != "" || err != nil {
+		t.Errorf("public bucket auth = %q, %v; want none", h, err)
+	}
+
+	os.Setenv("META_BUILDLET_BINARY_TOKEN_FILE", filepath.Join(dir, "missing"))
+	if _, err := a.header(in); err == nil || !strings.Contains(err.Error(), "service account token") {
+		t.Errorf("missing token file error = %v", err)
+	}
+	if _, err := a.header(out); err != nil {
+		t.Errorf("missing token file affected non-allowlisted host: %v", err)
+	}
+}
+
+func TestAuthTransportRedirect(t *testing.T) {
+	var otherAuth string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherAuth = r.Header.Get("Authorization")
+	}))
+	defer other.Close()
+	// Reach the other server by a different host name.
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	var gotAuth string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		http.Redirect(w, r, otherURL+"/buildlet", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	c := &http.Client{Transport: &authTransport{http.DefaultTransport}}
+	auth := func(u *url.URL) (string, error) {
+		if u.Hostname() == "127.0.0.1" {
+			return "Bearer s3cret", nil
+		}
+		return "", nil
+	}
+	withDownloadAuth(auth, func() {
+		res, err := c.Get(origin.URL + "/buildlet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	})
+	if gotAuth != "Bearer s3cret" {
+		t.Errorf("origin got Authorization %q; want bearer token", gotAuth)
+	}
+	if otherAuth != "" {
+		t.Errorf("redirect target got Authorization %q; want none", otherAuth)
+	}
+
+	// Outside of withDownloadAuth, nothing is sent.
+	gotAuth = "unset"
+	res, err := c.Get(origin.URL + "/buildlet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if gotAuth != "" {
+		t.Errorf("Authorization %q sent outside withDownloadAuth", gotAuth)
+	}
+}
+
+func TestBuildletAuthStatic(t *testing.T) {
+	defer os.Setenv("IN_KUBERNETES", os.Getenv("IN_KUBERNETES"))
+	os.Unsetenv("IN_KUBERNETES")
+
+	a := &buildletAuth{static: "Basic dXNlcjpwYXNz", hosts: map[string]bool{}}
+	a.addURLs("https://artifacts.example.com/buildlet,https://mirror.example.net:8443/buildlet")
+
+	tests := map[string]string{
+		"https://artifacts.example.com/buildlet.sig": "Basic dXNlcjpwYXNz",
+		"https://mirror.example.net:8443/buildlet":   "Basic dXNlcjpwYXNz",
+		"https://cdn.example.org/redirected":         "",
+		"https://example.com/buildlet":               "",
+	}
+	for s, want := range tests {
+		u, _ := url.Parse(s)
+		if got, err := a.header(u); got != want || err != nil {
+			t.Errorf("header(%s) = %q, %v; want %q", s, got, err, want)
+		}
+	}
+}
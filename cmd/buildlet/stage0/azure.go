@@ -0,0 +1,60 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// azureMetadataURL is the Azure Instance Metadata Service endpoint.
+// It's a variable for tests.
+var azureMetadataURL = "http://169.254.169.254/metadata/instance?api-version=2021-02-01"
+
+// azureInstance is the subset of the Azure IMDS instance document
+// used by stage0.
+type azureInstance struct {
+	Compute struct {
+		TagsList []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"tagsList"`
+	} `json:"compute"`
+}
+
+// azureProvider is a metadataProvider for Azure. Values come from the
+// instance's tags.
+type azureProvider struct {
+	inst azureInstance
+}
+
+func (p *azureProvider) Name() string { return "Azure" }
+
+func (p *azureProvider) Detect() bool {
+	req, err := http.NewRequest("GET", azureMetadataURL, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Metadata", "true")
+	b, err := metaGet(req, 1)
+	if err != nil {
+		return false
+	}
+	if err := json.Unmarshal(b, &p.inst); err != nil {
+		log.Printf("decoding Azure instance metadata: %v", err)
+		return false
+	}
+	return true
+}
+
+func (p *azureProvider) Value(key string) string {
+	for _, tag := range p.inst.Compute.TagsList {
+		if tag.Name == key {
+			return tag.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAzureProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			http.Error(w, "missing Metadata header", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{
+  "compute": {
+    "location": "westus2",
+    "tagsList": [
+      {"name": "buildlet-binary-url", "value": "https://example.com/buildlet.windows-arm64"},
+      {"name": "owner", "value": "golang"}
+    ]
+  }
+}`)
+	}))
+	defer ts.Close()
+	defer func(old string) { azureMetadataURL = old }(azureMetadataURL)
+	azureMetadataURL = ts.URL
+
+	p := new(azureProvider)
+	if !p.Detect() {
+		t.Fatal("Detect = false; want true")
+	}
+	tests := []struct {
+		key, want string
+	}{
+		{"buildlet-binary-url", "https://example.com/buildlet.windows-arm64"},
+		{"owner", "golang"},
+		{"unknown", ""},
+	}
+	for _, tt := range tests {
+		if got := p.Value(tt.key); got != tt.want {
+			t.Errorf("Value(%q) = %q; want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+// elfArch describes the ELF header fields expected for a GOARCH.
+type elfArch struct {
+	machine elf.Machine
+	class   elf.Class
+	order   binary.ByteOrder
+}
+
+var elfArches = map[string]elfArch{
+	"386":      {elf.EM_386, elf.ELFCLASS32, binary.LittleEndian},
+	"amd64":    {elf.EM_X86_64, elf.ELFCLASS64, binary.LittleEndian},
+	"arm":      {elf.EM_ARM, elf.ELFCLASS32, binary.LittleEndian},
+	"arm64":    {elf.EM_AARCH64, elf.ELFCLASS64, binary.LittleEndian},
+	"loong64":  {elf.EM_LOONGARCH, elf.ELFCLASS64, binary.LittleEndian},
+	"mips":     {elf.EM_MIPS, elf.ELFCLASS32, binary.BigEndian},
+	"mipsle":   {elf.EM_MIPS, elf.ELFCLASS32, binary.LittleEndian},
+	"mips64":   {elf.EM_MIPS, elf.ELFCLASS64, binary.BigEndian},
+	"mips64le": {elf.EM_MIPS, elf.ELFCLASS64, binary.LittleEndian},
+	"ppc64":    {elf.EM_PPC64, elf.ELFCLASS64, binary.BigEndian},
+	"ppc64le":  {elf.EM_PPC64, elf.ELFCLASS64, binary.LittleEndian},
+	"riscv64":  {elf.EM_RISCV, elf.ELFCLASS64, binary.LittleEndian},
+	"s390x":    {elf.EM_S390, elf.ELFCLASS64, binary.BigEndian},
+}
+
+var peMachines = map[string]uint16{
+	"386":   pe.IMAGE_FILE_MACHINE_I386,
+	"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+	"arm":   pe.IMAGE_FILE_MACHINE_ARMNT,
+	"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+}
+
+var machoCPUs = map[string]macho.Cpu{
+	"386":   macho.Cpu386,
+	"amd64": macho.CpuAmd64,
+	"arm64": macho.CpuArm64,
+}
+
+// checkBinary reports an error if file isn't an executable for
+// goos/goarch. Most often the cause is an HTTP error page that was
+// saved in place of the binary, so if the file looks like text, its
+// start is logged to show the underlying error.
+func checkBinary(file, goos, goarch string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte(elf.ELFMAG)):
+		return checkELF(f, goos, goarch)
+	case bytes.HasPrefix(head, []byte("MZ")):
+		return checkPE(f, goos, goarch)
+	case isMachO(head):
+		return checkMachO(f, goos, goarch)
+	}
+	ctype := http.DetectContentType(head)
+	if len(head) == 0 {
+		ctype = "an empty file"
+	} else if bytes.HasPrefix([]byte(ctype), []byte("text/")) {
+		const max = 300
+		start := head
+		if len(start) > max {
+			start = start[:max]
+		}
+		log.Printf("%s isn't a binary; it begins: %q", file, start)
+	}
+	return fmt.Errorf("%s is not a %s/%s binary (looks like %s)", file, goos, goarch, ctype)
+}
+
+func isMachO(head []byte) bool {
+	if len(head) < 4 {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(head) {
+	case macho.Magic32, macho.Magic64, macho.MagicFat:
+		return true
+	}
+	switch binary.BigEndian.Uint32(head) {
+	case macho.Magic32, macho.Magic64, macho.MagicFat:
+		return true
+	}
+	return false
+}
+
+func checkELF(r io.ReaderAt, goos, goarch string) error {
+	switch goos {
+	case "windows", "darwin", "ios", "plan9":
+		return fmt.Errorf("got an ELF binary; want a %s binary", goos)
+	}
+	ef, err := elf.NewFile(r)
+	if err != nil {
+		return fmt.Errorf("invalid ELF binary: %v", err)
+	}
+	want, ok := elfArches[goarch]
+	if !ok {
+		return nil // unknown to us; let exec decide
+	}
+	if ef.Machine != want.machine || ef.Class != want.class || ef.ByteOrder != want.order {
+		return fmt.Errorf("ELF binary is for %v %v %v; want %v %v %v for GOARCH=%s",
+			ef.Machine, ef.Class, ef.ByteOrder, want.machine, want.class, want.order, goarch)
+	}
+	return nil
+}
+
+func checkPE(r io.ReaderAt, goos, goarch string) error {
+	if goos != "windows" {
+		return fmt.Errorf("got a Windows (PE) binary; want a %s binary", goos)
+	}
+	pf, err := pe.NewFile(r)
+	if err != nil {
+		return fmt.Errorf("invalid PE binary: %v", err)
+	}
+	want, ok := peMachines[goarch]
+	if ok && pf.Machine != want {
+		return fmt.Errorf("PE binary has machine %#x; want %#x for GOARCH=%s", pf.Machine, want, goarch)
+	}
+	return nil
+}
+
+func checkMachO(r io.ReaderAt, goos, goarch string) error {
+	if goos != "darwin" && goos != "ios" {
+		return fmt.Errorf("got a Mach-O binary; want a %s binary", goos)
+	}
+	want, ok := machoCPUs[goarch]
+	if !ok {
+		return nil
+	}
+	if ff, err := macho.NewFatFile(r); err == nil {
+		for _, a := range ff.Arches {
+			if a.Cpu == want {
+				return nil
+			}
+		}
+		return fmt.Errorf("universal Mach-O binary has no %v slice for GOARCH=%s", want, goarch)
+	}
+	mf, err := macho.NewFile(r)
+	if err != nil {
+		return fmt.Errorf("invalid Mach-O binary: %v", err)
+	}
+	if mf.Cpu != want {
+		return fmt.Errorf("Mach-O binary is for %v; want %v for GOARCH=%s", mf.Cpu, want, goarch)
+	}
+	return nil
+}
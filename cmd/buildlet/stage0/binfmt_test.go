@@ -0,0 +1,107 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeELF returns a minimal 64-bit little-endian ELF executable
+// header for the given machine.
+func fakeELF(t *testing.T, m elf.Machine) []byte {
+	h := elf.Header64{
+		Type:    uint16(elf.ET_EXEC),
+		Machine: uint16(m),
+		Version: uint32(elf.EV_CURRENT),
+		Ehsize:  64,
+	}
+	copy(h.Ident[:], elf.ELFMAG)
+	h.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	h.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	h.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, &h); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const xmlError = `<?xml version='1.0' encoding='UTF-8'?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`
+
+func TestCheckBinary(t *testing.T) {
+	file, cleanup := tempFile(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		content []byte
+		goos    string
+		goarch  string
+		wantErr string // substring; empty means success
+	}{
+		{"amd64 ELF", fakeELF(t, elf.EM_X86_64), "linux", "amd64", ""},
+		{"arm64 ELF", fakeELF(t, elf.EM_AARCH64), "linux", "arm64", ""},
+		{"wrong arch ELF", fakeELF(t, elf.EM_AARCH64), "linux", "amd64", "want EM_X86_64"},
+		{"ELF on windows", fakeELF(t, elf.EM_X86_64), "windows", "amd64", "want a windows binary"},
+		{"XML error", []byte(xmlError), "linux", "amd64", "looks like text/xml"},
+		{"empty", nil, "linux", "amd64", "looks like an empty file"},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(file, tt.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := checkBinary(file, tt.goos, tt.goarch)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v; want error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckBinarySelf(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	if err := checkBinary(exe, runtime.GOOS, runtime.GOARCH); err != nil {
+		t.Errorf("checkBinary(test binary) = %v", err)
+	}
+}
+
+func TestDownloadRetriesNonBinary(t *testing.T) {
+	var slept int
+	sleep = func(time.Duration) { slept++ }
+	defer func() { sleep = time.Sleep }()
+
+	ts := flakyServer(0, xmlError)
+	defer ts.Close()
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	err := download(file, ts.URL, func(file string) error {
+		return checkBinary(file, "linux", "amd64")
+	})
+	if err == nil {
+		t.Fatal("download of XML error succeeded")
+	}
+	if slept != *downloadRetries-1 {
+		t.Errorf("retried %d times; want %d", slept, *downloadRetries-1)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("non-binary %s left behind after failed check", file)
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/build/internal/httpdl"
+	"golang.org/x/build/internal/untar"
+)
+
+var (
+	bootstrapURLFlag     = flag.String("go-bootstrap-url", "", "if non-empty, the URL of the Go bootstrap toolchain tarball to install on hosts without one; overrides --go-bootstrap-version and the go-bootstrap-url metadata attribute")
+	bootstrapVersionFlag = flag.String("go-bootstrap-version", "", "if non-empty, the version of the Go bootstrap toolchain to install on hosts without one, such as go1.20.14, instead of the latest; overrides the go-bootstrap-version metadata attribute")
+)
+
+// bootstrapURLAttr and bootstrapVersionAttr are the optional GCE
+// instance attributes for --go-bootstrap-url and
+// --go-bootstrap-version. Off GCE, the META_GO_BOOTSTRAP_URL and
+// META_GO_BOOTSTRAP_VERSION environment variables are used instead.
+const (
+	bootstrapURLAttr     = "go-bootstrap-url"
+	bootstrapVersionAttr = "go-bootstrap-version"
+)
+
+// bootstrapVersion is the version of the bootstrap toolchain
+// initGoBootstrap installed, passed to the buildlet as
+// GO_BOOTSTRAP_VERSION so the coordinator can see it.
+var bootstrapVersion string
+
+// bootstrapURL returns the URL of the Go bootstrap toolchain tarball
+// for this platform, its version, and where the choice came from. A
+// URL pin takes precedence over a version pin, and flags over
+// metadata. Without either, it's the latest tarball, whose version is
+// "latest".
+func bootstrapURL() (url, version, source string) {
+	version, versionSrc := *bootstrapVersionFlag, "--go-bootstrap-version"
+	if version == "" {
+		version, versionSrc = metaValue(bootstrapVersionAttr, "META_GO_BOOTSTRAP_VERSION"), bootstrapVersionAttr+" metadata"
+	}
+	if version != "" && !validBootstrapVersion(version) {
+		configFatalf("%s: invalid Go bootstrap version %q", versionSrc, version)
+	}
+	url, source = *bootstrapURLFlag, "--go-bootstrap-url"
+	if url == "" && *bootstrapVersionFlag == "" {
+		url, source = metaValue(bootstrapURLAttr, "META_GO_BOOTSTRAP_URL"), bootstrapURLAttr+" metadata"
+	}
+	if url != "" {
+		if version == "" {
+			version = strings.TrimSuffix(path.Base(url), ".tar.gz")
+		}
+		return url, version, source
+	}
+	if version != "" {
+		return fmt.Sprintf("https://storage.googleapis.com/go-builder-data/gobootstrap-%s-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH, version), version, versionSrc
+	}
+	return fmt.Sprintf("https://storage.googleapis.com/go-builder-data/gobootstrap-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH), "latest", "default"
+}
+
+// validBootstrapVersion reports whether v can be part of the bootstrap
+// tarball's file name.
+func validBootstrapVersion(v string) bool {
+	for _, r := range v {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '.' || r == '-' || r == '_' || r == '+') {
+			return false
+		}
+	}
+	return v != "" && v[0] != '.'
+}
+
+// bootstrapCheckTimeout is how long checkBootstrap waits for
+// "go version".
+var bootstrapCheckTimeout = 30 * time.Second
+
+// installBootstrap installs the Go bootstrap toolchain tarball at url
+// in destDir, as initBootstrapDir does, and checks that it works. If
+// either fails, as after the disk fills mid-extraction, it wipes
+// destDir and the cached tarball and tries once more.
+func installBootstrap(url, destDir, tgzCache string) error {
+	var err error
+	for attempt := 1; attempt <= 2; attempt++ {
+		if attempt > 1 {
+			log.Printf("%v; removing %s and %s to download the Go bootstrap toolchain again", err, destDir, tgzCache)
+			os.RemoveAll(destDir)
+			for _, f := range []string{tgzCache, tgzCache + ".sha256", tgzCache + ".url"} {
+				os.Remove(f)
+			}
+		}
+		if err = initBootstrapDir(url, destDir, tgzCache); err != nil {
+			continue
+		}
+		var version string
+		if version, err = checkBootstrap(destDir); err == nil {
+			log.Printf("Go bootstrap toolchain in %s: %s", destDir, version)
+			return nil
+		}
+	}
+	return fmt.Errorf("Go bootstrap toolchain from %s still doesn't work after downloading it again: %v", url, err)
+}
+
+// checkBootstrap checks that the Go toolchain in dir runs and is for
+// this host's GOOS and GOARCH, returning its "go version" output.
+func checkBootstrap(dir string) (string, error) {
+	goBin := filepath.Join(dir, "bin", "go")
+	if runtime.GOOS == "windows" {
+		goBin += ".exe"
+	}
+	fi, err := os.Stat(goBin)
+	if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() || runtime.GOOS != "windows" && fi.Mode().Perm()&0111 == 0 {
+		return "", fmt.Errorf("%s isn't an executable file (mode %v)", goBin, fi.Mode())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), bootstrapCheckTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, goBin, "version").CombinedOutput()
+	version := strings.TrimSpace(string(out))
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("%s version: timed out after %v; output: %q", goBin, bootstrapCheckTimeout, version)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s version: %v; output: %q", goBin, err, version)
+	}
+	f := strings.Fields(version)
+	if len(f) < 4 || f[0] != "go" || f[1] != "version" {
+		return "", fmt.Errorf("%s version: unexpected output %q", goBin, version)
+	}
+	if want := runtime.GOOS + "/" + runtime.GOARCH; f[len(f)-1] != want {
+		return "", fmt.Errorf("%s is for %s, not %s: %q", goBin, f[len(f)-1], want, version)
+	}
+	return version, nil
+}
+
+// initBootstrapDir downloads the gzipped tarball at url to tgzCache,
+// unless the copy there is current (see fetchBootstrap), and extracts
+// it to destDir, replacing destDir's previous contents. The tarball is
+// extracted to a temporary directory first, so a failure leaves
+// destDir as it was.
+func initBootstrapDir(url, destDir, tgzCache string) error {
+	if err := fetchBootstrap(url, tgzCache); err != nil {
+		return err
+	}
+	f, err := os.Open(tgzCache)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	parent := filepath.Dir(destDir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(parent, filepath.Base(destDir)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := untar.Untar(f, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("extracting %s (from %s) to %s: %v", tgzCache, url, destDir, err)
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	old := destDir + ".old"
+	os.RemoveAll(old)
+	if err := os.Rename(destDir, old); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("replacing %s: %v", destDir, err)
+	}
+	if err := os.Rename(tmp, destDir); err != nil {
+		os.Rename(old, destDir)
+		os.RemoveAll(tmp)
+		return fmt.Errorf("replacing %s: %v", destDir, err)
+	}
+	os.RemoveAll(old)
+	log.Printf("extracted %s to %s", url, destDir)
+	return nil
+}
+
+// fetchBootstrap makes tgzCache a current copy of the tarball at url.
+// A cached copy of a different URL, as after the bootstrap toolchain's
+// pin changes, is discarded. The SHA-256 of the cached copy is
+// recorded in tgzCache+".sha256" and checked before the copy is
+// reused; a corrupt copy is downloaded again. If url+".sha256" is
+// published, the cached copy is reused only if it matches. Otherwise,
+// it's reused if its size and modification time match the server's.
+func fetchBootstrap(url, tgzCache string) error {
+	hashFile := tgzCache + ".sha256"
+	have, _ := fileSHA256(tgzCache) // empty if there's no cached copy
+	if from := readURLFile(tgzCache + ".url"); have != "" && from != url {
+		log.Printf("discarding cached %s: it's from %q, not %s", tgzCache, from, url)
+		os.Remove(tgzCache)
+		os.Remove(hashFile)
+		have = ""
+	}
+	if recorded := readSHA256File(hashFile); have != "" && recorded != "" && have != recorded {
+		log.Printf("cached %s is corrupt: SHA-256 %s, want %s; downloading it again", tgzCache, have, recorded)
+		os.Remove(tgzCache)
+		have = ""
+	}
+	published, err := fetchPublishedSHA256(url)
+	if err != nil {
+		log.Printf("%v; checking %s by its timestamp instead", err, tgzCache)
+	}
+	if have != "" && published != "" {
+		if have == published {
+			log.Printf("reusing cached %s: it matches the published SHA-256", tgzCache)
+			return recordBootstrapCache(tgzCache, url, have)
+		}
+		log.Printf("refreshing cached %s: the published SHA-256 changed", tgzCache)
+		// Don't let httpdl trust the copy because of its
+		// timestamp.
+		os.Remove(tgzCache)
+	}
+	if err := httpdl.Download(tgzCache, url, httpdl.WithClient(http.DefaultClient)); err != nil {
+		return fmt.Errorf("downloading %s to %s: %v", url, tgzCache, err)
+	}
+	got, err := fileSHA256(tgzCache)
+	if err != nil {
+		return err
+	}
+	if published != "" && got != published {
+		os.Remove(tgzCache)
+		return fmt.Errorf("downloading %s to %s: SHA-256 %s doesn't match the published %s", url, tgzCache, got, published)
+	}
+	if got == have {
+		log.Printf("reusing cached %s: its timestamp is current", tgzCache)
+	} else {
+		log.Printf("downloaded %s to %s, SHA-256 %s", url, tgzCache, got)
+	}
+	return recordBootstrapCache(tgzCache, url, got)
+}
+
+// recordBootstrapCache records the URL and SHA-256 of the tarball
+// cached in tgzCache, for fetchBootstrap's next run.
+func recordBootstrapCache(tgzCache, url, sum string) error {
+	if err := ioutil.WriteFile(tgzCache+".url", []byte(url+"\n"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tgzCache+".sha256", []byte(sum+"\n"), 0644)
+}
+
+// readURLFile returns the URL recorded in file, or the empty string if
+// there's none.
+func readURLFile(file string) string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// fetchPublishedSHA256 returns the SHA-256 published beside url, in
+// url+".sha256", or the empty string if there's none.
+func fetchPublishedSHA256(url string) (string, error) {
+	res, err := http.Get(url + ".sha256")
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden: // GCS says 403 for missing objects without list access
+		return "", nil
+	default:
+		return "", fmt.Errorf("fetching %s.sha256: %v", url, res.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+	if err != nil {
+		return "", fmt.Errorf("fetching %s.sha256: %v", url, err)
+	}
+	sum := parseSHA256(string(b))
+	if sum == "" {
+		return "", fmt.Errorf("%s.sha256 isn't a SHA-256", url)
+	}
+	return sum, nil
+}
+
+// readSHA256File returns the SHA-256 recorded in file, or the empty
+// string if there's none.
+func readSHA256File(file string) string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return parseSHA256(string(b))
+}
+
+// parseSHA256 returns the lowercase hex SHA-256 at the start of s, as
+// in sha256sum's output, or the empty string if there's none.
+func parseSHA256(s string) string {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return ""
+	}
+	sum := strings.ToLower(f[0])
+	if len(sum) != 64 || !isHex(sum) {
+		return ""
+	}
+	return sum
+}
@@ -0,0 +1,429 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tarGz returns a gzipped tarball of files, keyed by name. Scripts,
+// starting with "#!", are executable.
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, body := range files {
+		mode := int64(0644)
+		if strings.HasPrefix(body, "#!") {
+			mode = 0755
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestInitBootstrapDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage0-bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := tarGz(t, map[string]string{"go/VERSION": "go1.4-bootstrap", "go/bin/go": "#!/bin/true"})
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	gets := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gobootstrap.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "GET" {
+			gets++
+		}
+		http.ServeContent(w, r, "gobootstrap.tar.gz", modTime, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(dir, "go-bootstrap")
+	cache := filepath.Join(dir, "go-bootstrap.tar.gz")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dest, "stale"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := initBootstrapDir(ts.URL+"/gobootstrap.tar.gz", dest, cache); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "go", "VERSION")); err != nil || string(b) != "go1.4-bootstrap" {
+		t.Errorf("go/VERSION = %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "stale")); err == nil {
+		t.Error("previous contents of destination remain")
+	}
+	if fi, err := os.Stat(dest); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("destination: %v, %v; want mode 0755", fi.Mode(), err)
+	}
+
+	// The cached tarball is current, so it's not fetched again.
+	if err := initBootstrapDir(ts.URL+"/gobootstrap.tar.gz", dest, cache); err != nil {
+		t.Fatal(err)
+	}
+	if gets != 1 {
+		t.Errorf("tarball fetched %d times; want 1", gets)
+	}
+
+	// Failures mention the URL and leave the destination alone.
+	err = initBootstrapDir(ts.URL+"/missing.tar.gz", dest, filepath.Join(dir, "missing.tar.gz"))
+	if err == nil || !strings.Contains(err.Error(), "/missing.tar.gz") {
+		t.Errorf("missing tarball error = %v; want one mentioning the URL", err)
+	}
+	content = []byte("not a tarball")
+	modTime = modTime.Add(time.Minute)
+	err = initBootstrapDir(ts.URL+"/gobootstrap.tar.gz", dest, cache)
+	if err == nil || !strings.Contains(err.Error(), dest) {
+		t.Errorf("bad tarball error = %v; want one mentioning %s", err, dest)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "go", "VERSION")); err != nil {
+		t.Errorf("after failed extraction: %v", err)
+	}
+	if m, _ := filepath.Glob(filepath.Join(dir, "go-bootstrap.tmp*")); len(m) > 0 {
+		t.Errorf("temporary directories left behind: %q", m)
+	}
+}
+
+func TestFetchBootstrap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage0-bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := tarGz(t, map[string]string{"go/VERSION": "one"})
+	sidecar := ""
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	gets := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gobootstrap.tar.gz", "/gobootstrap-go1.20.14.tar.gz":
+			if r.Method == "GET" {
+				gets++
+			}
+			http.ServeContent(w, r, "gobootstrap.tar.gz", modTime, bytes.NewReader(content))
+		case "/gobootstrap.tar.gz.sha256", "/gobootstrap-go1.20.14.tar.gz.sha256":
+			if sidecar == "" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, "%s  gobootstrap.tar.gz\n", sidecar)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	url := ts.URL + "/gobootstrap.tar.gz"
+	cache := filepath.Join(dir, "go-bootstrap.tar.gz")
+	fetch := func(what string, wantGets int) {
+		t.Helper()
+		gets = 0
+		if err := fetchBootstrap(url, cache); err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+		if gets != wantGets {
+			t.Errorf("%s: tarball fetched %d times; want %d", what, gets, wantGets)
+		}
+		b, err := ioutil.ReadFile(cache)
+		if err != nil || !bytes.Equal(b, content) {
+			t.Errorf("%s: cached tarball isn't current (%v)", what, err)
+		}
+		if sum, _ := fileSHA256(cache); readSHA256File(cache+".sha256") != sum {
+			t.Errorf("%s: recorded SHA-256 %q; want %q", what, readSHA256File(cache+".sha256"), sum)
+		}
+	}
+
+	fetch("first fetch, without published SHA-256", 1)
+	fetch("unchanged timestamp", 0)
+
+	// Republished with the same timestamp and size: only the
+	// published SHA-256 tells.
+	content = tarGz(t, map[string]string{"go/VERSION": "two"})
+	sum := sha256.Sum256(content)
+	sidecar = hex.EncodeToString(sum[:])
+	fetch("changed published SHA-256", 1)
+	fetch("matching published SHA-256", 0)
+
+	// A corrupt cached copy is downloaded again, even though its
+	// timestamp looks current.
+	sidecar = ""
+	f, err := os.OpenFile(cache, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("XX"), 10)
+	f.Close()
+	os.Chtimes(cache, modTime, modTime)
+	fetch("corrupt cache", 1)
+
+	// Pinning a different tarball discards the cached copy, even
+	// if the new one's timestamp and size match.
+	url = ts.URL + "/gobootstrap-go1.20.14.tar.gz"
+	fetch("changed URL", 1)
+	fetch("unchanged URL", 0)
+
+	// A download that doesn't match the published SHA-256 fails.
+	sidecar = strings.Repeat("0", 64)
+	if err := fetchBootstrap(url, cache); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("mismatched download error = %v", err)
+	}
+}
+
+func TestParseSHA256(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	for in, want := range map[string]string{
+		sum:                          sum,
+		strings.ToUpper(sum) + "\n":  sum,
+		sum + "  gobootstrap.tar.gz": sum,
+		"":                           "",
+		"abc":                        "",
+		strings.Repeat("zz", 32):     "",
+	} {
+		if got := parseSHA256(in); got != want {
+			t.Errorf("parseSHA256(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestBootstrapURL(t *testing.T) {
+	for _, k := range []string{"IN_KUBERNETES", "META_GO_BOOTSTRAP_URL", "META_GO_BOOTSTRAP_VERSION"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	defer func(u, v string) { *bootstrapURLFlag, *bootstrapVersionFlag = u, v }(*bootstrapURLFlag, *bootstrapVersionFlag)
+	os.Setenv("IN_KUBERNETES", "1")
+
+	const base = "https://storage.googleapis.com/go-builder-data/gobootstrap-" + runtime.GOOS + "-" + runtime.GOARCH
+	tests := []struct {
+		urlFlag, versionFlag, urlMeta, versionMeta string
+		wantURL, wantVersion, wantSource           string
+	}{
+		{
+			wantURL:     base + ".tar.gz",
+			wantVersion: "latest",
+			wantSource:  "default",
+		},
+		{
+			versionMeta: "go1.20.14",
+			wantURL:     base + "-go1.20.14.tar.gz",
+			wantVersion: "go1.20.14",
+			wantSource:  "go-bootstrap-version metadata",
+		},
+		{
+			urlMeta:     "https://example.com/bootstrap/go1.17.13-ppc64.tar.gz",
+			versionMeta: "go1.20.14",
+			wantURL:     "https://example.com/bootstrap/go1.17.13-ppc64.tar.gz",
+			wantVersion: "go1.20.14",
+			wantSource:  "go-bootstrap-url metadata",
+		},
+		{
+			urlMeta:     "https://example.com/bootstrap/go1.17.13-ppc64.tar.gz",
+			wantURL:     "https://example.com/bootstrap/go1.17.13-ppc64.tar.gz",
+			wantVersion: "go1.17.13-ppc64",
+			wantSource:  "go-bootstrap-url metadata",
+		},
+		{
+			// The flag's version pin beats metadata's URL pin.
+			versionFlag: "go1.22.6",
+			urlMeta:     "https://example.com/bootstrap/go1.17.13-ppc64.tar.gz",
+			wantURL:     base + "-go1.22.6.tar.gz",
+			wantVersion: "go1.22.6",
+			wantSource:  "--go-bootstrap-version",
+		},
+		{
+			urlFlag:     "https://example.com/gobootstrap.tar.gz",
+			versionFlag: "go1.22.6",
+			versionMeta: "go1.20.14",
+			wantURL:     "https://example.com/gobootstrap.tar.gz",
+			wantVersion: "go1.22.6",
+			wantSource:  "--go-bootstrap-url",
+		},
+	}
+	for i, tt := range tests {
+		*bootstrapURLFlag, *bootstrapVersionFlag = tt.urlFlag, tt.versionFlag
+		os.Setenv("META_GO_BOOTSTRAP_URL", tt.urlMeta)
+		os.Setenv("META_GO_BOOTSTRAP_VERSION", tt.versionMeta)
+		url, version, source := bootstrapURL()
+		if url != tt.wantURL || version != tt.wantVersion || source != tt.wantSource {
+			t.Errorf("%d. bootstrapURL() = %q, %q, %q; want %q, %q, %q", i, url, version, source, tt.wantURL, tt.wantVersion, tt.wantSource)
+		}
+	}
+}
+
+func TestValidBootstrapVersion(t *testing.T) {
+	for v, want := range map[string]bool{
+		"go1.20.14":                true,
+		"go1.4-bootstrap-20171003": true,
+		"":                         false,
+		"../x":                     false,
+		"go1.20/x":                 false,
+		"go 1.20":                  false,
+	} {
+		if got := validBootstrapVersion(v); got != want {
+			t.Errorf("validBootstrapVersion(%q) = %v; want %v", v, got, want)
+		}
+	}
+}
+
+func TestInstallBootstrap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script as bin/go")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "stage0-bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	goVersion := func(platform string) string {
+		return "#!/bin/sh\necho go version go1.20.14 " + platform + "\n"
+	}
+	host := runtime.GOOS + "/" + runtime.GOARCH
+	tests := []struct {
+		name     string
+		tarballs []map[string]string // served in turn
+		wantErr  string
+		wantGets int
+	}{
+		{
+			name:     "works",
+			tarballs: []map[string]string{{"bin/go": goVersion(host)}},
+			wantGets: 1,
+		},
+		{
+			name: "works the second time",
+			tarballs: []map[string]string{
+				{"VERSION": "go1.20.14"}, // truncated
+				{"bin/go": goVersion(host)},
+			},
+			wantGets: 2,
+		},
+		{
+			name:     "wrong platform",
+			tarballs: []map[string]string{{"bin/go": goVersion("plan9/mips")}},
+			wantErr:  "is for plan9/mips, not " + host,
+			wantGets: 2,
+		},
+		{
+			name:     "not executable",
+			tarballs: []map[string]string{{"bin/go": "echo go version"}},
+			wantErr:  "isn't an executable file",
+			wantGets: 2,
+		},
+		{
+			name:     "fails",
+			tarballs: []map[string]string{{"bin/go": "#!/bin/sh\necho out of memory\nexit 2\n"}},
+			wantErr:  `exit status 2; output: "out of memory"`,
+			wantGets: 2,
+		},
+		{
+			name:     "unexpected output",
+			tarballs: []map[string]string{{"bin/go": "#!/bin/sh\necho hello\n"}},
+			wantErr:  `unexpected output "hello"`,
+			wantGets: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gets := 0
+			modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/gobootstrap.tar.gz" {
+					http.NotFound(w, r)
+					return
+				}
+				files := tt.tarballs[len(tt.tarballs)-1]
+				if gets < len(tt.tarballs) {
+					files = tt.tarballs[gets]
+				}
+				if r.Method == "GET" {
+					gets++
+				}
+				http.ServeContent(w, r, "gobootstrap.tar.gz", modTime, bytes.NewReader(tarGz(t, files)))
+			}))
+			defer ts.Close()
+
+			tdir := filepath.Join(dir, tt.name)
+			if err := os.Mkdir(tdir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(tdir, "go-bootstrap")
+			cache := filepath.Join(tdir, "go-bootstrap.tar.gz")
+			err := installBootstrap(ts.URL+"/gobootstrap.tar.gz", dest, cache)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("installBootstrap: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("installBootstrap error = %v; want one containing %q", err, tt.wantErr)
+			}
+			if gets != tt.wantGets {
+				t.Errorf("tarball fetched %d times; want %d", gets, tt.wantGets)
+			}
+		})
+	}
+}
+
+func TestCheckBootstrapTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script as bin/go")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	defer func(d time.Duration) { bootstrapCheckTimeout = d }(bootstrapCheckTimeout)
+	bootstrapCheckTimeout = 100 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "stage0-bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bin", "go"), []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkBootstrap(dir); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("checkBootstrap error = %v; want timeout", err)
+	}
+}
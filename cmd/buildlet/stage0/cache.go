@@ -0,0 +1,143 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var forceDownload = flag.Bool("force-download", false, "always download the buildlet, even if the previously downloaded copy is current")
+
+// cacheInfo is the sidecar file recording where a downloaded file
+// came from, so later boots can skip downloading it again.
+type cacheInfo struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+}
+
+func cacheInfoFile(file string) string { return file + ".cache.json" }
+
+// cachedIsCurrent reports whether file was previously downloaded from
+// url, is intact, and is still what the server has. Corrupt or stale
+// cache entries are removed.
+func cachedIsCurrent(file, url string) bool {
+	if *forceDownload || !isHTTPURL(url) {
+		return false
+	}
+	ci, err := readCacheInfo(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("discarding unreadable download cache for %s: %v", file, err)
+			removeCache(file)
+		}
+		return false
+	}
+	if ci.URL != url {
+		log.Printf("cached %s came from %s; downloading from %s", file, ci.URL, url)
+		return false
+	}
+	if fi, err := os.Stat(file); err != nil || fi.Size() != ci.Size {
+		log.Printf("discarding missing or partially written cached %s", file)
+		removeCache(file)
+		return false
+	}
+	if sum, err := fileSHA256(file); err != nil || sum != ci.SHA256 {
+		log.Printf("discarding corrupt cached %s", file)
+		removeCache(file)
+		return false
+	}
+	res, err := headURL(url)
+	if err != nil {
+		log.Printf("checking whether cached %s is current: %v", file, err)
+		return false
+	}
+	if etag := res.Header.Get("Etag"); etag != "" && ci.ETag != "" {
+		return etag == ci.ETag
+	}
+	lm := res.Header.Get("Last-Modified")
+	return lm != "" && lm == ci.LastModified && res.ContentLength == ci.Size
+}
+
+// writeCacheInfo records that file was just downloaded from url.
+func writeCacheInfo(file, url string) error {
+	if !isHTTPURL(url) {
+		return nil
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	sum, err := fileSHA256(file)
+	if err != nil {
+		return err
+	}
+	ci := cacheInfo{URL: url, Size: fi.Size(), SHA256: sum}
+	if res, err := headURL(url); err == nil {
+		ci.ETag = res.Header.Get("Etag")
+		ci.LastModified = res.Header.Get("Last-Modified")
+	}
+	b, err := json.MarshalIndent(ci, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := cacheInfoFile(file) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cacheInfoFile(file))
+}
+
+func readCacheInfo(file string) (*cacheInfo, error) {
+	b, err := ioutil.ReadFile(cacheInfoFile(file))
+	if err != nil {
+		return nil, err
+	}
+	ci := new(cacheInfo)
+	if err := json.Unmarshal(b, ci); err != nil {
+		return nil, err
+	}
+	if ci.URL == "" || ci.SHA256 == "" {
+		return nil, fmt.Errorf("incomplete cache info %+v", ci)
+	}
+	return ci, nil
+}
+
+// removeCache removes file and its cache sidecar.
+func removeCache(file string) {
+	os.Remove(file)
+	os.Remove(cacheInfoFile(file))
+}
+
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// headURL does a HEAD request for url, busting GCS's cache the same
+// way httpdl does.
+func headURL(url string) (*http.Response, error) {
+	if strings.HasPrefix(url, "https://storage.googleapis.com") && !strings.Contains(url, "?") {
+		url += fmt.Sprintf("?%d", time.Now().Unix())
+	}
+	res, err := http.DefaultClient.Head(url)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("HEAD %s: %v", url, res.Status)
+	}
+	return res, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadCache(t *testing.T) {
+	const content = "buildlet binary"
+	var gets int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Header().Set("Etag", `"v1"`)
+		http.ServeContent(w, r, "buildlet", time.Unix(1462292149, 0), strings.NewReader(content))
+	}))
+	defer ts.Close()
+	file, cleanup := tempFile(t)
+	defer cleanup()
+
+	dl := func(wantGets int32) {
+		t.Helper()
+		atomic.StoreInt32(&gets, 0)
+		if err := download(file, ts.URL, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt32(&gets); got != wantGets {
+			t.Errorf("download did %d GETs; want %d", got, wantGets)
+		}
+	}
+
+	dl(1) // initial download
+	dl(0) // cached
+
+	// Corrupt the cached file without changing its size or modtime.
+	if err := ioutil.WriteFile(file, []byte(strings.ToUpper(content)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dl(1)
+	dl(0)
+
+	*forceDownload = true
+	defer func() { *forceDownload = false }()
+	if cachedIsCurrent(file, ts.URL) {
+		t.Error("cachedIsCurrent = true with --force-download")
+	}
+}
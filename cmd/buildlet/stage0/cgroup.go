@@ -0,0 +1,205 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	cgroupFlag       = flag.String("cgroup", "", "if non-empty, the name of a cgroup v2 cgroup, such as buildlet, to create under /sys/fs/cgroup on Linux and run the buildlet in, so a runaway test can't take the rest of the machine down with it; overrides the buildlet-cgroup metadata attribute")
+	cgroupLimitsFlag = flag.String("cgroup-limits", "", "with --cgroup, comma-separated limits for the cgroup, such as memory.max=8G,memory.swap.max=0,pids.max=4096; overrides the buildlet-cgroup-limits metadata attribute")
+	cgroupStrict     = flag.Bool("cgroup-strict", false, "exit if the buildlet can't be run in --cgroup, instead of logging the failure and running it anyway")
+)
+
+// cgroupAttr and cgroupLimitsAttr are the optional GCE instance
+// attributes for --cgroup and --cgroup-limits. Off GCE, the
+// META_BUILDLET_CGROUP and META_BUILDLET_CGROUP_LIMITS environment
+// variables are used instead.
+const (
+	cgroupAttr       = "buildlet-cgroup"
+	cgroupLimitsAttr = "buildlet-cgroup-limits"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted. It's a
+// variable for tests.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupLimitFiles are the limits --cgroup-limits may set, and
+// whether their values are sizes in bytes.
+var cgroupLimitFiles = map[string]bool{
+	"memory.max":      true,
+	"memory.high":     true,
+	"memory.swap.max": true,
+	"pids.max":        false,
+}
+
+var errCgroupUnsupported = errors.New("cgroups are only supported on Linux")
+
+// buildletCgroup is the directory of the cgroup the buildlet is run
+// in, or the empty string if it isn't. See setupCgroup.
+var buildletCgroup string
+
+// cgroupConfig returns the configured cgroup's directory, or the empty
+// string if there's none, and its limits, each of the form
+// "file=value".
+func cgroupConfig() (dir string, limits []string, err error) {
+	name, limitList := *cgroupFlag, *cgroupLimitsFlag
+	if !flagWasSet("cgroup") {
+		name = metaValue(cgroupAttr, "META_BUILDLET_CGROUP")
+	}
+	if !flagWasSet("cgroup-limits") {
+		limitList = metaValue(cgroupLimitsAttr, "META_BUILDLET_CGROUP_LIMITS")
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "..") {
+		return "", nil, fmt.Errorf("cgroup: %q isn't a cgroup name relative to %s", name, cgroupRoot)
+	}
+	limits, err = parseCgroupLimits(limitList)
+	if err != nil {
+		return "", nil, fmt.Errorf("cgroup-limits: %v", err)
+	}
+	return filepath.Join(cgroupRoot, name), limits, nil
+}
+
+// parseCgroupLimits parses a comma-separated list of cgroup limits,
+// returning them as "file=value", with sizes in bytes.
+func parseCgroupLimits(s string) ([]string, error) {
+	var limits []string
+	for _, l := range splitList(s) {
+		i := strings.Index(l, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q isn't of the form file=value", l)
+		}
+		file, value := l[:i], strings.TrimSpace(l[i+1:])
+		isSize, ok := cgroupLimitFiles[file]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cgroup limit %q", file)
+		}
+		switch {
+		case value == "max" || value == "0":
+		case isSize:
+			n, err := parseSize(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			value = strconv.FormatInt(n, 10)
+		default:
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				return nil, fmt.Errorf("%s: invalid value %q", file, value)
+			}
+		}
+		limits = append(limits, file+"="+value)
+	}
+	return limits, nil
+}
+
+// setupCgroup creates the cgroup configured by --cgroup, if any, sets
+// its limits, and sets buildletCgroup. Failures, such as a missing
+// cgroup v2 hierarchy or lack of permission, are logged, unless
+// --cgroup-strict makes them fatal.
+func setupCgroup() {
+	dir, limits, err := cgroupConfig()
+	if err != nil {
+		configFatalf("%v", err)
+	}
+	if dir == "" {
+		return
+	}
+	if runtime.GOOS != "linux" {
+		err = errCgroupUnsupported
+	} else {
+		err = makeCgroup(dir, limits)
+	}
+	if err != nil {
+		if *cgroupStrict {
+			sleepFatalf("setting up cgroup %s: %v", dir, err)
+		}
+		log.Printf("setting up cgroup %s: %v; running the buildlet without it", dir, err)
+		return
+	}
+	log.Printf("running the buildlet in cgroup %s, with limits %s", dir, strings.Join(limits, " "))
+	buildletCgroup = dir
+}
+
+// makeCgroup creates the cgroup v2 cgroup dir, enabling the
+// controllers its limits need, and sets the limits.
+func makeCgroup(dir string, limits []string) error {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("no cgroup v2 hierarchy at %s", cgroupRoot)
+	}
+	// Enable each controller in the parent separately, so one
+	// that's already enabled doesn't fail the others.
+	parent := filepath.Dir(dir)
+	enabled := map[string]bool{}
+	for _, l := range limits {
+		c := l[:strings.Index(l, ".")]
+		if enabled[c] {
+			continue
+		}
+		enabled[c] = true
+		if err := ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+"+c), 0); err != nil {
+			return fmt.Errorf("enabling the %s controller: %v", c, err)
+		}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	for _, l := range limits {
+		i := strings.Index(l, "=")
+		if err := ioutil.WriteFile(filepath.Join(dir, l[:i]), []byte(l[i+1:]), 0); err != nil {
+			return fmt.Errorf("setting %s: %v", l, err)
+		}
+	}
+	return nil
+}
+
+// startInCgroup is set non-nil on Linux, where a process can be
+// started directly in a cgroup. It starts cmd in the cgroup dir,
+// returning the started command, which is a copy of cmd if cmd
+// couldn't be started there, and whether it's in the cgroup.
+var startInCgroup func(cmd *exec.Cmd, dir string) (started *exec.Cmd, inCgroup bool, err error)
+
+// joinCgroup moves process pid into the cgroup dir. It's the fallback
+// for when the buildlet can't be started in the cgroup (see
+// startInCgroup), so the buildlet runs outside the cgroup from when it
+// starts until joinCgroup moves it, and any process it has started by
+// then stays outside.
+func joinCgroup(dir string, pid int) error {
+	return ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0)
+}
+
+// cgroupOOMKills returns how many processes the OOM killer has killed
+// in the cgroup dir for exceeding its memory limits, per its
+// memory.events, or 0 if that's unknown.
+func cgroupOOMKills(dir string) int {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) == 2 && f[0] == "oom_kill" {
+			n, _ := strconv.Atoi(f[1])
+			return n
+		}
+	}
+	return 0
+}
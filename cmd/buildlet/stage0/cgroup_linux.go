@@ -0,0 +1,55 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func init() {
+	startInCgroup = startInCgroupLinux
+}
+
+// startInCgroupLinux starts cmd directly in the cgroup dir, with
+// clone3's CLONE_INTO_CGROUP, so the buildlet never runs outside it.
+// If dir can't be opened, or the kernel (before Linux 5.7) can't start
+// a process in a cgroup, it logs why and starts a copy of cmd outside
+// the cgroup instead, returning inCgroup false.
+func startInCgroupLinux(cmd *exec.Cmd, dir string) (started *exec.Cmd, inCgroup bool, err error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		log.Printf("opening cgroup %s: %v; moving the buildlet into it after starting it instead", dir, err)
+		return cmd, false, cmd.Start()
+	}
+	defer f.Close()
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	if err = cmd.Start(); err == nil {
+		return cmd, true, nil
+	}
+	log.Printf("starting buildlet in cgroup %s: %v; moving it in after starting it instead", dir, err)
+	// An exec.Cmd can't be started twice, even if the first Start
+	// failed, so start a copy of it.
+	attr := *cmd.SysProcAttr
+	attr.UseCgroupFD, attr.CgroupFD = false, 0
+	retry := &exec.Cmd{
+		Path:        cmd.Path,
+		Args:        cmd.Args,
+		Env:         cmd.Env,
+		Dir:         cmd.Dir,
+		Stdin:       cmd.Stdin,
+		Stdout:      cmd.Stdout,
+		Stderr:      cmd.Stderr,
+		ExtraFiles:  cmd.ExtraFiles,
+		SysProcAttr: &attr,
+	}
+	return retry, false, retry.Start()
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestStartInCgroupFallback checks that the buildlet still starts, and
+// is left for joinCgroup to move, where it can't be started directly in
+// the cgroup: when the cgroup can't be opened, and when it isn't a
+// cgroup at all, which fails clone3 just as an old kernel would.
+func TestStartInCgroupFallback(t *testing.T) {
+	bin, err := exec.LookPath("true")
+	if err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "stage0-cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, cg := range []string{filepath.Join(dir, "missing"), dir} {
+		cmd, inCgroup, err := startInCgroupLinux(exec.Command(bin), cg)
+		if err != nil {
+			t.Fatalf("startInCgroupLinux(%s): %v", cg, err)
+		}
+		if inCgroup {
+			t.Errorf("startInCgroupLinux(%s) reported starting in the cgroup", cg)
+		}
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("%s: %v", cg, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCgroupLimits(t *testing.T) {
+	got, err := parseCgroupLimits("memory.max=8G, memory.swap.max=0,pids.max=4096,memory.high=max")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"memory.max=8589934592", "memory.swap.max=0", "pids.max=4096", "memory.high=max"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCgroupLimits = %q; want %q", got, want)
+	}
+	for _, bad := range []string{"memory.max", "cpu.max=100000", "memory.max=lots", "pids.max=-1", "pids.max=4K"} {
+		if _, err := parseCgroupLimits(bad); err == nil {
+			t.Errorf("parseCgroupLimits(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCgroupConfig(t *testing.T) {
+	for _, k := range []string{"IN_KUBERNETES", "META_BUILDLET_CGROUP", "META_BUILDLET_CGROUP_LIMITS"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("IN_KUBERNETES", "1")
+	os.Setenv("META_BUILDLET_CGROUP_LIMITS", "pids.max=100")
+
+	os.Unsetenv("META_BUILDLET_CGROUP")
+	if dir, _, err := cgroupConfig(); dir != "" || err != nil {
+		t.Errorf("unconfigured: %q, %v; want no cgroup", dir, err)
+	}
+	os.Setenv("META_BUILDLET_CGROUP", "buildlet")
+	dir, limits, err := cgroupConfig()
+	if dir != filepath.Join(cgroupRoot, "buildlet") || !reflect.DeepEqual(limits, []string{"pids.max=100"}) || err != nil {
+		t.Errorf("cgroupConfig = %q, %q, %v", dir, limits, err)
+	}
+	os.Setenv("META_BUILDLET_CGROUP", "../escape")
+	if _, _, err := cgroupConfig(); err == nil {
+		t.Error("cgroupConfig accepted ../escape")
+	}
+}
+
+func TestMakeCgroup(t *testing.T) {
+	root, err := ioutil.TempDir("", "stage0-cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(v string) { cgroupRoot = v }(cgroupRoot)
+	cgroupRoot = root
+	dir := filepath.Join(root, "buildlet")
+
+	if err := makeCgroup(dir, nil); err == nil || !strings.Contains(err.Error(), "no cgroup v2") {
+		t.Errorf("without cgroup v2: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory pids\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := makeCgroup(dir, []string{"memory.max=1024", "memory.swap.max=0", "pids.max=10"}); err != nil {
+		t.Fatal(err)
+	}
+	// The real file takes each write separately; this one
+	// just has the last.
+	if b, _ := ioutil.ReadFile(filepath.Join(root, "cgroup.subtree_control")); string(b) != "+pids" {
+		t.Errorf("cgroup.subtree_control = %q; want last write +pids", b)
+	}
+	for file, want := range map[string]string{"memory.max": "1024", "memory.swap.max": "0", "pids.max": "10"} {
+		if b, _ := ioutil.ReadFile(filepath.Join(dir, file)); string(b) != want {
+			t.Errorf("%s = %q; want %q", file, b, want)
+		}
+	}
+
+	if err := joinCgroup(dir, 1234); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs")); string(b) != "1234" {
+		t.Errorf("cgroup.procs = %q; want 1234", b)
+	}
+
+	if n := cgroupOOMKills(dir); n != 0 {
+		t.Errorf("cgroupOOMKills without memory.events = %d", n)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 0\nmax 12\noom 3\noom_kill 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n := cgroupOOMKills(dir); n != 2 {
+		t.Errorf("cgroupOOMKills = %d; want 2", n)
+	}
+}
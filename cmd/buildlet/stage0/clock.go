@@ -0,0 +1,121 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var (
+	clockWait      = flag.Duration("clock-wait", 5*time.Minute, "how long to wait for the system clock to be set (by NTP, etc) before downloading over TLS, on machines without an RTC")
+	skipClockCheck = flag.Bool("skip-clock-check", false, "don't check that the system clock is sane before downloading")
+)
+
+// buildTime is when stage0 was built, in RFC 3339 format. It may be
+// set at build time with:
+//
+//	go build -ldflags="-X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Otherwise, the VCS commit time recorded by the go command is used,
+// if any.
+var buildTime string
+
+// minSaneTime is a time before which the system clock is certainly
+// wrong, for when neither buildTime nor VCS info is available.
+var minSaneTime = time.Date(2018, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+// earliestSaneTime returns the earliest time the system clock could
+// legitimately show: when this binary was built.
+func earliestSaneTime() time.Time {
+	t := minSaneTime
+	v := buildTime
+	if v == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range bi.Settings {
+				if s.Key == "vcs.time" {
+					v = s.Value
+				}
+			}
+		}
+	}
+	if bt, err := time.Parse(time.RFC3339, v); err == nil && bt.After(t) {
+		t = bt
+	}
+	return t
+}
+
+// awaitSaneClock waits up to --clock-wait for the system clock to be
+// no earlier than earliestSaneTime, since boards without an RTC boot
+// in 1970 and then fail TLS certificate validation.
+func awaitSaneClock() {
+	if *skipClockCheck {
+		return
+	}
+	min := earliestSaneTime()
+	if !time.Now().Before(min) {
+		warnClockSkew()
+		return
+	}
+	log.Printf("system clock %v is before stage0's build time %v; waiting up to %v for it to be set",
+		time.Now().UTC().Format(time.RFC3339), min.Format(time.RFC3339), *clockWait)
+	deadline := time.Now().Add(*clockWait)
+	lastSpam := time.Now()
+	for time.Now().Before(min) {
+		// Compare with the monotonic clock, not the wall clock
+		// we're waiting on.
+		if !time.Now().Before(deadline) {
+			log.Printf("gave up waiting for the system clock; downloads over TLS will likely fail")
+			warnClockSkew()
+			return
+		}
+		if time.Since(lastSpam) > 30*time.Second {
+			log.Printf("still waiting for system clock to be set; now %v", time.Now().UTC().Format(time.RFC3339))
+			lastSpam = time.Now()
+		}
+		time.Sleep(time.Second)
+	}
+	log.Printf("system clock set to %v", time.Now().UTC().Format(time.RFC3339))
+}
+
+// serverDate is the most recent Date header seen from a network
+// probe response, and the local time it was seen.
+var serverDate struct {
+	sync.Mutex
+	server, local time.Time
+}
+
+// noteServerDate records the Date header of res, if valid.
+func noteServerDate(res *http.Response) {
+	d, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	serverDate.Lock()
+	defer serverDate.Unlock()
+	serverDate.server, serverDate.local = d, time.Now()
+}
+
+// warnClockSkew logs a warning if the local clock differs much from
+// the Date reported by a network probe's server.
+func warnClockSkew() {
+	serverDate.Lock()
+	server, local := serverDate.server, serverDate.local
+	serverDate.Unlock()
+	if server.IsZero() {
+		return
+	}
+	skew := local.Round(0).Sub(server)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > time.Minute {
+		log.Printf("WARNING: system clock is off by about %v from the network probe server's Date header (%v)", prettyDuration(skew), server.UTC().Format(time.RFC3339))
+	}
+}
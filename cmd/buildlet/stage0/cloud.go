@@ -0,0 +1,216 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+// A metadataProvider is a source of instance configuration on a
+// cloud other than GCE, consulted when a value isn't set in the
+// environment.
+type metadataProvider interface {
+	// Name returns the provider's name, for logging.
+	Name() string
+
+	// Detect reports whether stage0 is running on the provider's
+	// cloud. It's called at most once, and must return quickly
+	// when not on that cloud.
+	Detect() bool
+
+	// Value returns the value of the named key, or the empty
+	// string if it's not set.
+	Value(key string) string
+}
+
+// metadataProviders are the non-GCE metadata providers, in order of
+// preference, should more than one detect its cloud.
+var metadataProviders = []metadataProvider{
+	new(ec2Provider),
+	new(azureProvider),
+	new(openstackProvider),
+	new(digitalOceanProvider),
+}
+
+// metaProbeTimeout bounds each request to a cloud metadata service,
+// so machines on other clouds (or none) aren't delayed long by the
+// probes.
+const metaProbeTimeout = 2 * time.Second
+
+// metaClient is the HTTP client used for cloud metadata requests.
+// It never uses a proxy.
+var metaClient = &http.Client{
+	Timeout:   metaProbeTimeout,
+	Transport: &http.Transport{},
+}
+
+var (
+	onGCEFlag       = flag.Bool("on-gce", false, "whether stage0 is running on GCE, to skip detecting it; by default it's detected")
+	metadataTimeout = flag.Duration("metadata-timeout", 2*time.Second, "how long to wait while detecting whether stage0 is running on GCE before assuming it isn't")
+)
+
+// gceDetect reports whether stage0 is running on GCE.
+// It's a variable for testing.
+var gceDetect = metadata.OnGCE
+
+var gce struct {
+	once sync.Once
+	on   bool
+}
+
+// onGCE reports whether stage0 is running on GCE, per --on-gce or,
+// if that's unset, detection bounded by --metadata-timeout.
+// metadata.OnGCE can otherwise take many seconds on machines with
+// broken DNS.
+func onGCE() bool {
+	gce.once.Do(func() {
+		if flagWasSet("on-gce") {
+			gce.on = *onGCEFlag
+			log.Printf("on GCE = %v, per --on-gce", gce.on)
+			return
+		}
+		t0 := time.Now()
+		c := make(chan bool, 1)
+		go func() { c <- gceDetect() }()
+		t := time.NewTimer(*metadataTimeout)
+		defer t.Stop()
+		select {
+		case gce.on = <-c:
+			log.Printf("detected on GCE = %v in %v", gce.on, prettyDuration(time.Since(t0)))
+		case <-t.C:
+			log.Printf("GCE detection timed out after %v; assuming not on GCE", *metadataTimeout)
+		}
+	})
+	return gce.on
+}
+
+var detected struct {
+	once sync.Once
+	p    metadataProvider // nil if none
+}
+
+// metaDetectTimeout bounds detecting which cloud, if any, stage0 is
+// running on. The providers are probed in parallel, so this is the
+// most detection can delay boot, however many providers there are.
+// It's long enough for a provider to make a couple of requests.
+const metaDetectTimeout = 2 * metaProbeTimeout
+
+// cloudProvider returns the metadata provider for the cloud stage0 is
+// running on, or nil if none was detected.
+func cloudProvider() metadataProvider {
+	detected.once.Do(func() {
+		t0 := time.Now()
+		detected.p = detectProvider(metadataProviders, metaDetectTimeout)
+		if detected.p != nil {
+			log.Printf("detected %s metadata in %v", detected.p.Name(), prettyDuration(time.Since(t0)))
+			return
+		}
+		log.Printf("no cloud metadata detected after %v", prettyDuration(time.Since(t0)))
+	})
+	return detected.p
+}
+
+// detectProvider runs the providers' Detect methods in parallel and
+// returns the first provider, in the order given, that detects its
+// cloud, as soon as every provider before it has said it's not on
+// theirs. Providers that haven't answered within timeout are taken
+// not to be on their clouds.
+func detectProvider(providers []metadataProvider, timeout time.Duration) metadataProvider {
+	type result struct {
+		i  int
+		on bool
+	}
+	c := make(chan result, len(providers))
+	for i, p := range providers {
+		go func(i int, p metadataProvider) { c <- result{i, p.Detect()} }(i, p)
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	answered := make([]bool, len(providers))
+	on := make([]bool, len(providers))
+	for {
+		waiting := false
+		for i, p := range providers {
+			if !answered[i] {
+				waiting = true
+				break
+			}
+			if on[i] {
+				return p
+			}
+		}
+		if !waiting {
+			return nil
+		}
+		select {
+		case r := <-c:
+			answered[r.i], on[r.i] = true, r.on
+		case <-t.C:
+			for i, p := range providers {
+				if on[i] {
+					return p
+				}
+			}
+			log.Printf("cloud metadata detection timed out after %v", timeout)
+			return nil
+		}
+	}
+}
+
+var errMetaNotFound = errors.New("not found in metadata")
+
+// metaGet does req using metaClient and returns the response body.
+// It returns errMetaNotFound if the server responds with a 404.
+// Other failures are tried up to tries times in total.
+func metaGet(req *http.Request, tries int) ([]byte, error) {
+	var lastErr error
+	for try := 1; try <= tries; try++ {
+		if try > 1 {
+			sleep(backoff(try - 1))
+		}
+		res, err := metaClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		switch {
+		case res.StatusCode == http.StatusNotFound:
+			return nil, errMetaNotFound
+		case res.StatusCode != http.StatusOK:
+			lastErr = fmt.Errorf("%s %s: %v", req.Method, req.URL, res.Status)
+		case err != nil:
+			lastErr = err
+		default:
+			return b, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// userDataValue returns the value of the first "key=value" line in
+// the user-data ud, or the empty string if there's none.
+func userDataValue(ud, key string) string {
+	bs := bufio.NewScanner(strings.NewReader(ud))
+	for bs.Scan() {
+		line := strings.TrimSpace(bs.Text())
+		if i := strings.Index(line, "="); i > 0 && strings.TrimSpace(line[:i]) == key {
+			return strings.TrimSpace(line[i+1:])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+func resetOnGCE() {
+	gce.once = sync.Once{}
+	gce.on = false
+	gceDetect = metadata.OnGCE
+}
+
+func TestOnGCETimeout(t *testing.T) {
+	defer resetOnGCE()
+	defer func(d time.Duration) { *metadataTimeout = d }(*metadataTimeout)
+	*metadataTimeout = 10 * time.Millisecond
+
+	resetOnGCE()
+	block := make(chan bool)
+	defer close(block)
+	gceDetect = func() bool { <-block; return true }
+	t0 := time.Now()
+	if onGCE() {
+		t.Error("onGCE = true after detection timed out")
+	}
+	if d := time.Since(t0); d > time.Second {
+		t.Errorf("onGCE took %v; want about %v", d, *metadataTimeout)
+	}
+
+	resetOnGCE()
+	gceDetect = func() bool { return true }
+	*metadataTimeout = time.Minute
+	if !onGCE() {
+		t.Error("onGCE = false; want detected true")
+	}
+}
+
+func TestOnGCEFlag(t *testing.T) {
+	defer resetOnGCE()
+	defer func(v bool) { *onGCEFlag = v }(*onGCEFlag)
+	resetOnGCE()
+	gceDetect = func() bool {
+		t.Error("detection ran despite --on-gce")
+		return false
+	}
+	if err := flag.Set("on-gce", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if !onGCE() {
+		t.Error("onGCE = false with --on-gce=true")
+	}
+}
+
+// detectProbe is a metadataProvider whose Detect returns on after
+// delay, or never if block.
+type detectProbe struct {
+	name  string
+	on    bool
+	delay time.Duration
+	block chan struct{}
+}
+
+func (p *detectProbe) Name() string { return p.name }
+
+func (p *detectProbe) Detect() bool {
+	if p.block != nil {
+		<-p.block
+	}
+	time.Sleep(p.delay)
+	return p.on
+}
+
+func (p *detectProbe) Value(string) string { return "" }
+
+func TestDetectProvider(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	no := &detectProbe{name: "no", delay: 100 * time.Millisecond}
+	slowYes := &detectProbe{name: "slowYes", on: true, delay: 200 * time.Millisecond}
+	yes := &detectProbe{name: "yes", on: true}
+	hung := &detectProbe{name: "hung", on: true, block: block}
+
+	tests := []struct {
+		name      string
+		providers []metadataProvider
+		want      metadataProvider
+		maxTime   time.Duration
+	}{
+		// Four 100ms probes in parallel take about 100ms, not 400ms.
+		{"none", []metadataProvider{no, no, no, no}, nil, 300 * time.Millisecond},
+		// The first match in order wins, even if a later one
+		// answers first.
+		{"order", []metadataProvider{no, slowYes, yes}, slowYes, time.Second},
+		// A match doesn't wait for the providers after it.
+		{"first", []metadataProvider{yes, hung}, yes, time.Second},
+		// A hung provider gives up its place at the deadline.
+		{"hung", []metadataProvider{hung, no, yes}, yes, 2 * time.Second},
+		{"all hung", []metadataProvider{hung, hung}, nil, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t0 := time.Now()
+		got := detectProvider(tt.providers, 500*time.Millisecond)
+		if got != tt.want {
+			t.Errorf("%s: detectProvider = %v; want %v", tt.name, got, tt.want)
+		}
+		if d := time.Since(t0); d > tt.maxTime {
+			t.Errorf("%s: detectProvider took %v; want at most %v", tt.name, d, tt.maxTime)
+		}
+	}
+}
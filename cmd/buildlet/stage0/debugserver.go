@@ -0,0 +1,178 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var debugListen = flag.String("debug-listen", "", "if non-empty, address (such as :8091) on which to serve a read-only status page and /status.json until the buildlet starts")
+
+// status is what stage0 is doing, for the debug server.
+var status struct {
+	sync.Mutex
+	probeErr    string // last network probe error
+	buildletURL string // resolved buildlet URL, once downloaded
+	downloadURL string // URL being downloaded
+	try         int    // current try of downloadURL
+	failures    int    // failed download tries, in total
+	bytes       int64  // bytes of downloadURL read so far
+	total       int64  // size of downloadURL, or -1 if unknown
+}
+
+// noteProbeError records the most recent network probe failure.
+func noteProbeError(err error) {
+	status.Lock()
+	defer status.Unlock()
+	status.probeErr = err.Error()
+}
+
+// noteDownloadTry records that try of url is starting.
+func noteDownloadTry(url string, try int) {
+	status.Lock()
+	defer status.Unlock()
+	status.downloadURL, status.try = url, try
+	status.bytes, status.total = 0, -1
+	if try > 1 {
+		status.failures++
+	}
+}
+
+// noteDownloadProgress records that n of total bytes of the current
+// download have been read.
+func noteDownloadProgress(n, total int64) {
+	status.Lock()
+	defer status.Unlock()
+	status.bytes, status.total = n, total
+}
+
+// noteBuildletURL records the URL the buildlet was downloaded from.
+func noteBuildletURL(url string) {
+	status.Lock()
+	defer status.Unlock()
+	status.buildletURL = url
+}
+
+// statusJSON is the body of /status.json.
+type statusJSON struct {
+	Version          string  `json:"version"`
+	OSArch           string  `json:"osArch"`
+	Phase            string  `json:"phase"`
+	Attempt          int     `json:"attempt"`
+	ElapsedSeconds   float64 `json:"elapsedSeconds"`
+	LastProbeError   string  `json:"lastProbeError,omitempty"`
+	BuildletURL      string  `json:"buildletURL,omitempty"`
+	DownloadURL      string  `json:"downloadURL,omitempty"`
+	DownloadTry      int     `json:"downloadTry,omitempty"`
+	DownloadFailures int     `json:"downloadFailures"`
+	DownloadBytes    int64   `json:"downloadBytes"`
+	DownloadSize     int64   `json:"downloadSize"` // or -1 if unknown
+}
+
+func currentStatus() statusJSON {
+	logState.Lock()
+	s := statusJSON{
+		Version:        stage0Version(),
+		OSArch:         osArch,
+		Phase:          logState.phase,
+		Attempt:        logState.attempt,
+		ElapsedSeconds: time.Since(timeStart).Seconds(),
+	}
+	logState.Unlock()
+	status.Lock()
+	defer status.Unlock()
+	s.LastProbeError = status.probeErr
+	s.BuildletURL = status.buildletURL
+	s.DownloadURL = status.downloadURL
+	s.DownloadTry = status.try
+	s.DownloadFailures = status.failures
+	s.DownloadBytes = status.bytes
+	s.DownloadSize = status.total
+	return s
+}
+
+var statusTmpl = template.Must(template.New("status").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"seconds": func(s float64) time.Duration {
+		return prettyDuration(time.Duration(s * float64(time.Second)))
+	},
+}).Parse(`<!DOCTYPE html>
+<html><head><title>stage0 status</title></head><body>
+<h1>stage0 {{.Version}} on {{.OSArch}}</h1>
+<table>
+<tr><td>phase</td><td>{{.Phase}}</td></tr>
+<tr><td>attempt</td><td>{{.Attempt}}</td></tr>
+<tr><td>elapsed</td><td>{{seconds .ElapsedSeconds}}</td></tr>
+<tr><td>last probe error</td><td>{{.LastProbeError}}</td></tr>
+<tr><td>buildlet URL</td><td>{{.BuildletURL}}</td></tr>
+<tr><td>downloading</td><td>{{.DownloadURL}}{{if .DownloadURL}} (try {{.DownloadTry}}){{end}}</td></tr>
+<tr><td>downloaded</td><td>{{bytes .DownloadBytes}}{{if ge .DownloadSize 0}} of {{bytes .DownloadSize}}{{end}}</td></tr>
+<tr><td>failed download tries</td><td>{{.DownloadFailures}}</td></tr>
+</table>
+<p><a href="/status.json">status.json</a></p>
+</body></html>
+`))
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTmpl.Execute(w, currentStatus()); err != nil {
+		log.Printf("debug server: %v", err)
+	}
+}
+
+func handleStatusJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(currentStatus())
+}
+
+// debugServer is the running debug server, if any.
+var debugServer *http.Server
+
+// startDebugServer starts serving status on --debug-listen, if set.
+func startDebugServer() {
+	if *debugListen == "" || debugServer != nil {
+		return
+	}
+	ln, err := net.Listen("tcp", *debugListen)
+	if err != nil {
+		log.Printf("not starting debug server: %v", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleStatus)
+	mux.HandleFunc("/status.json", handleStatusJSON)
+	debugServer = &http.Server{Handler: mux}
+	log.Printf("serving status on http://%s/", ln.Addr())
+	go func(srv *http.Server) {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("debug server: %v", err)
+		}
+	}(debugServer)
+}
+
+// stopDebugServer stops the debug server, if it's running, so the
+// buildlet can use its port.
+func stopDebugServer() {
+	if debugServer == nil {
+		return
+	}
+	if err := debugServer.Close(); err != nil {
+		log.Printf("stopping debug server: %v", err)
+	}
+	debugServer = nil
+}
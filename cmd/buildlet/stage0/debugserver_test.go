@@ -0,0 +1,59 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusHandlers(t *testing.T) {
+	setPhase("download")
+	defer setPhase("")
+	noteProbeError(errors.New("DNS lookup failed"))
+	noteDownloadTry("https://example.com/buildlet", 1)
+	noteDownloadTry("https://example.com/buildlet", 2)
+	noteDownloadProgress(1<<20, 4<<20)
+
+	rec := httptest.NewRecorder()
+	handleStatusJSON(rec, httptest.NewRequest("GET", "/status.json", nil))
+	var s statusJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatalf("bad JSON %q: %v", rec.Body.Bytes(), err)
+	}
+	if s.Phase != "download" || s.LastProbeError != "DNS lookup failed" ||
+		s.DownloadURL != "https://example.com/buildlet" || s.DownloadTry != 2 ||
+		s.DownloadFailures < 1 || s.DownloadBytes != 1<<20 || s.DownloadSize != 4<<20 {
+		t.Errorf("status = %+v", s)
+	}
+
+	rec = httptest.NewRecorder()
+	handleStatus(rec, httptest.NewRequest("GET", "/", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "1.0MB of 4.0MB") || !strings.Contains(body, "(try 2)") {
+		t.Errorf("status page missing progress:\n%s", body)
+	}
+}
+
+func TestDebugServerStartStop(t *testing.T) {
+	defer func(v string) { *debugListen = v }(*debugListen)
+	*debugListen = ""
+	startDebugServer()
+	if debugServer != nil {
+		t.Fatal("debug server started without --debug-listen")
+	}
+
+	*debugListen = "127.0.0.1:0"
+	startDebugServer()
+	if debugServer == nil {
+		t.Fatal("debug server not started")
+	}
+	stopDebugServer()
+	if debugServer != nil {
+		t.Error("debug server still set after stop")
+	}
+}
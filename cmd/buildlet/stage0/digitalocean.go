@@ -0,0 +1,50 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// digitalOceanMetadataURL is the base URL of the DigitalOcean droplet
+// metadata service. It's a variable for tests.
+var digitalOceanMetadataURL = "http://169.254.169.254/metadata/v1"
+
+// digitalOceanProvider is a metadataProvider for DigitalOcean. Values
+// come from "key=value" lines in the droplet's user-data, such as:
+//
+//	buildlet-binary-url=https://storage.googleapis.com/go-builder-data/buildlet.freebsd-amd64
+//	go-builder-env=host-freebsd-amd64-do
+type digitalOceanProvider struct {
+	userData string
+}
+
+func (p *digitalOceanProvider) Name() string { return "DigitalOcean" }
+
+func (p *digitalOceanProvider) Detect() bool {
+	if _, err := p.get("/id", 1); err != nil {
+		return false
+	}
+	ud, err := p.get("/user-data", 3)
+	if err != nil && err != errMetaNotFound {
+		log.Printf("fetching DigitalOcean user-data: %v", err)
+	}
+	p.userData = ud
+	return true
+}
+
+func (p *digitalOceanProvider) Value(key string) string {
+	return userDataValue(p.userData, key)
+}
+
+func (p *digitalOceanProvider) get(path string, tries int) (string, error) {
+	req, err := http.NewRequest("GET", digitalOceanMetadataURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	b, err := metaGet(req, tries)
+	return string(b), err
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDigitalOceanProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/id":
+			fmt.Fprint(w, "2756294")
+		case "/user-data":
+			fmt.Fprint(w, "# stage0 config\nbuildlet-binary-url=https://example.com/buildlet.freebsd-amd64\ngo-builder-env=host-freebsd-amd64-do\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	defer func(old string) { digitalOceanMetadataURL = old }(digitalOceanMetadataURL)
+	digitalOceanMetadataURL = ts.URL
+
+	p := new(digitalOceanProvider)
+	if !p.Detect() {
+		t.Fatal("Detect = false; want true")
+	}
+	tests := []struct {
+		key, want string
+	}{
+		{"buildlet-binary-url", "https://example.com/buildlet.freebsd-amd64"},
+		{"go-builder-env", "host-freebsd-amd64-do"},
+		{"buildlet-binary-sha256", ""},
+	}
+	for _, tt := range tests {
+		if got := p.Value(tt.key); got != tt.want {
+			t.Errorf("Value(%q) = %q; want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDigitalOceanNotDetected(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	defer func(old string) { digitalOceanMetadataURL = old }(digitalOceanMetadataURL)
+	digitalOceanMetadataURL = ts.URL
+
+	if new(digitalOceanProvider).Detect() {
+		t.Error("Detect = true; want false")
+	}
+}
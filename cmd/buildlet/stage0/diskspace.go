@@ -0,0 +1,165 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var minFreeSpace = byteSize(200 << 20)
+
+func init() {
+	flag.Var(&minFreeSpace, "min-free-space", "minimum free space required on the filesystem the buildlet is downloaded to, such as 200MB or 1GB; 0 disables the check")
+}
+
+// byteSize is a flag.Value for a number of bytes with an optional
+// K, M, or G (powers of 1024) suffix, optionally followed by B.
+type byteSize int64
+
+func (b *byteSize) String() string { return formatBytes(int64(*b)) }
+
+func (b *byteSize) Set(s string) error {
+	v, err := parseBytes(s)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(v)
+	return nil
+}
+
+func parseBytes(s string) (int64, error) {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"G", 1 << 30},
+		{"M", 1 << 20},
+		{"K", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	return n * mult, nil
+}
+
+// formatBytes formats n in the largest unit that keeps it at least 1.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// errDiskFreeUnsupported is returned by diskFree on platforms where
+// free space can't be determined.
+var errDiskFreeUnsupported = errors.New("free disk space check not supported on this platform")
+
+// diskFree returns the number of bytes available to unprivileged
+// users on the filesystem containing dir, and that filesystem's
+// mount point (or volume), for error messages.
+// It's a variable for testing.
+var diskFree = statDiskFree
+
+// checkFreeSpace verifies that the filesystem containing file has at
+// least --min-free-space bytes available (counting file itself, which
+// will be replaced). If not, it removes leftovers from previous runs
+// and checks again.
+func checkFreeSpace(file string) error {
+	if minFreeSpace <= 0 {
+		return nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	enough := func() (ok bool, have int64, mount string, err error) {
+		have, mount, err = diskFree(filepath.Dir(abs))
+		if err != nil {
+			return false, 0, "", err
+		}
+		if fi, err := os.Stat(abs); err == nil && fi.Mode().IsRegular() {
+			have += fi.Size()
+		}
+		return have >= int64(minFreeSpace), have, mount, nil
+	}
+	ok, have, mount, err := enough()
+	if err == errDiskFreeUnsupported {
+		return nil
+	}
+	if err != nil {
+		log.Printf("can't determine free disk space: %v; continuing", err)
+		return nil
+	}
+	if ok {
+		return nil
+	}
+	log.Printf("only %s free on %s; removing leftovers from previous runs", formatBytes(have), mount)
+	for _, f := range leftovers(abs) {
+		if err := os.Remove(f); err == nil {
+			log.Printf("removed %s", f)
+		}
+	}
+	ok, have, mount, err = enough()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("disk full: need %s, have %s on %s", formatBytes(int64(minFreeSpace)), formatBytes(have), mount)
+	}
+	return nil
+}
+
+// leftovers returns the files from previous runs of stage0 that are
+// safe to remove to reclaim space before downloading file.
+func leftovers(file string) []string {
+	files := []string{
+		file,
+		file + ".tmp",
+		file + ".sig",
+		file + ".sig.tmp",
+		cacheInfoFile(file),
+	}
+	// Temp files from interrupted downloads of anything else.
+	tmps, _ := filepath.Glob(filepath.Join(filepath.Dir(file), "*.tmp"))
+	for _, f := range tmps {
+		if !contains(files, f) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
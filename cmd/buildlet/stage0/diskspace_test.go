@@ -0,0 +1,81 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		bad  bool
+	}{
+		{in: "0", want: 0},
+		{in: "512", want: 512},
+		{in: "10KB", want: 10 << 10},
+		{in: "200MB", want: 200 << 20},
+		{in: "200mb", want: 200 << 20},
+		{in: "2 GB", want: 2 << 30},
+		{in: "500k", want: 500 << 10},
+		{in: "2M", want: 2 << 20},
+		{in: "1.5GB", bad: true},
+		{in: "-1", bad: true},
+		{in: "MB", bad: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBytes(tt.in)
+		if (err != nil) != tt.bad {
+			t.Errorf("parseBytes(%q) error = %v; want error = %v", tt.in, err, tt.bad)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseBytes(%q) = %d; want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	tmp := file + ".tmp"
+
+	defer func(v byteSize) { minFreeSpace = v }(minFreeSpace)
+	minFreeSpace = 100
+	defer func() { diskFree = statDiskFree }()
+
+	// Pretend the filesystem has 50 bytes free, plus 60 more once
+	// the leftover temp file is removed.
+	diskFree = func(dir string) (int64, string, error) {
+		if _, err := os.Stat(tmp); err == nil {
+			return 50, "/data", nil
+		}
+		return 110, "/data", nil
+	}
+	if err := ioutil.WriteFile(tmp, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkFreeSpace(file); err != nil {
+		t.Fatalf("checkFreeSpace after cleanup: %v", err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("leftover %s not removed", tmp)
+	}
+
+	diskFree = func(dir string) (int64, string, error) { return 50, "/data", nil }
+	err := checkFreeSpace(file)
+	if err == nil || !strings.Contains(err.Error(), "disk full: need 100B, have 50B on /data") {
+		t.Errorf("checkFreeSpace = %v; want disk full error", err)
+	}
+
+	diskFree = func(dir string) (int64, string, error) { return 0, "", errDiskFreeUnsupported }
+	if err := checkFreeSpace(file); err != nil {
+		t.Errorf("checkFreeSpace on unsupported platform = %v; want nil", err)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func statDiskFree(dir string) (free int64, mount string, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, "", &os.PathError{Op: "statfs", Path: dir, Err: err}
+	}
+	return int64(st.Bavail) * int64(st.Bsize), mountPoint(dir), nil
+}
+
+// mountPoint returns the mount point of the filesystem containing
+// dir, found by walking up until the device changes. If that fails,
+// it returns dir.
+func mountPoint(dir string) string {
+	dev := func(p string) (uint64, bool) {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return 0, false
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return 0, false
+		}
+		return uint64(st.Dev), true
+	}
+	d, ok := dev(dir)
+	if !ok {
+		return dir
+	}
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		pd, ok := dev(parent)
+		if !ok || pd != d {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/build/internal/httpdl"
+)
+
+var (
+	downloadRetries  = flag.Int("download-retries", 3, "maximum number of attempts for each download")
+	downloadDeadline = flag.Duration("download-deadline", 10*time.Minute, "maximum total time to spend retrying a download")
+	attemptTimeout   = flag.Duration("download-attempt-timeout", 5*time.Minute, "maximum time for each download attempt")
+	stallTimeout     = flag.Duration("download-stall-timeout", time.Minute, "abort a download attempt if no bytes arrive for this long")
+)
+
+// Retry backoff parameters. See backoff.
+const (
+	minBackoff = 1 * time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// sleep is time.Sleep, except in tests.
+var sleep = time.Sleep
+
+// download downloads url to file, retrying with backoff on failure.
+// The url may also be a file URL or an absolute path, in which case
+// it's copied, or a gs:// URL, which is fetched with GCS credentials.
+// A url whose path ends in ".gz" is decompressed into file.
+// If check is non-nil, it's run on each downloaded file; if it returns
+// an error, the file is deleted and the download is retried.
+// HTTP 4xx responses other than 408 and 429 aren't retried, and a
+// Retry-After header lengthens the wait before the next try.
+func download(file, url string, check func(file string) error) error {
+	_, err := downloadResolve(file, func() string { return url }, check)
+	return err
+}
+
+// downloadResolve is like download, but calls resolve before each
+// retry to get the URL to download, so a URL fixed in metadata while
+// stage0 is retrying takes effect. The resolved value may be a
+// comma-separated list of mirror URLs, which are tried in order, each
+// with the full retry policy. It returns the URL of the last attempt.
+func downloadResolve(file string, resolve func() string, check func(file string) error) (url string, err error) {
+	urls := splitURLs(resolve())
+	if len(urls) == 0 {
+		return "", fmt.Errorf("no URL to download %s from", file)
+	}
+	if *forceDownload {
+		removeCache(file)
+	} else {
+		for _, url := range urls {
+			if cachedIsCurrent(file, url) && (check == nil || check(file) == nil) {
+				log.Printf("previously downloaded %s is current; not downloading %s", file, url)
+				return url, nil
+			}
+		}
+	}
+	maxTry := *downloadRetries
+	deadline := time.Now().Add(*downloadDeadline)
+	var mirrorErrs []string
+	var lastErr error
+	// urlChanged reports whether resolve now returns a different URL
+	// list, in which case it starts over with that list.
+	urlChanged := func() bool {
+		n := splitURLs(resolve())
+		if len(n) == 0 || strings.Join(n, ",") == strings.Join(urls, ",") {
+			return false
+		}
+		log.Printf("URL changed from %s to %s; downloading that instead", strings.Join(urls, ","), strings.Join(n, ","))
+		urls, mirrorErrs = n, nil
+		return true
+	}
+Mirrors:
+	for i := 0; i < len(urls); i++ {
+		url = urls[i]
+		if len(urls) > 1 {
+			log.Printf("trying mirror %d/%d", i+1, len(urls))
+		}
+		logf(logFields{"url": url, "file": file}, "downloading %s to %s ...", url, file)
+		var retryAfter time.Duration
+		for try := 1; try <= maxTry; try++ {
+			if try > 1 {
+				d := backoff(try - 1)
+				if retryAfter > d {
+					log.Printf("server asked to retry after %v", prettyDuration(retryAfter))
+					d = retryAfter
+				}
+				if time.Now().Add(d).After(deadline) {
+					log.Printf("download deadline of %v reached", *downloadDeadline)
+					break Mirrors
+				}
+				log.Printf("sleeping %v before retrying", prettyDuration(d))
+				sleep(d)
+				if urlChanged() {
+					i = -1
+					continue Mirrors
+				}
+			}
+			noteDownloadTry(url, try)
+			t0 := time.Now()
+			err := fetch(file, url)
+			he := httpFailure(err)
+			if err == nil && check != nil {
+				err = check(file)
+				if err != nil {
+					removeCache(file)
+				}
+			}
+			if err == nil {
+				fi, err := os.Stat(file)
+				if err != nil {
+					return url, err
+				}
+				logf(logFields{"url": url, "file": file, "bytes": fi.Size()}, "downloaded %s (%d bytes) in %v", file, fi.Size(), prettyDuration(time.Since(t0)))
+				if len(urls) > 1 {
+					log.Printf("mirror %d/%d (%s) succeeded", i+1, len(urls), url)
+				}
+				if err := writeCacheInfo(file, url); err != nil {
+					log.Printf("recording download cache info for %s: %v", file, err)
+				}
+				return url, nil
+			}
+			lastErr = err
+			logf(logFields{"url": url, "level": "warn"}, "try %d/%d download failure after %v: %v", try, maxTry, prettyDuration(time.Since(t0)), err)
+			if he != nil && !httpdl.IsRetryable(he) {
+				// The URL may have been fixed since we resolved it
+				// (say, a metadata attribute corrected after a bad
+				// deploy), so look once more before giving up on it.
+				if time.Now().Before(deadline) && urlChanged() {
+					i = -1
+					continue Mirrors
+				}
+				log.Printf("not retrying %s: %s is a permanent failure", url, he.Status)
+				break
+			}
+			retryAfter = 0
+			if he != nil {
+				retryAfter = he.RetryAfter
+			}
+		}
+		if lastErr != nil {
+			mirrorErrs = append(mirrorErrs, fmt.Sprintf("%s: %v", url, lastErr))
+		}
+	}
+	if lastErr == nil {
+		return url, fmt.Errorf("no download attempts made (--download-retries=%d)", maxTry)
+	}
+	if len(mirrorErrs) > 1 {
+		return url, fmt.Errorf("all mirrors failed: %s", strings.Join(mirrorErrs, "; "))
+	}
+	return url, lastErr
+}
+
+// splitURLs splits a comma-separated list of URLs, ignoring empty
+// entries.
+func splitURLs(s string) []string {
+	return splitList(s)
+}
+
+// fetch does a single attempt at downloading url to file.
+// If url ends in ".gz", file is the decompressed contents.
+func fetch(file, url string) error {
+	if isGzipURL(url) {
+		return fetchGzip(file, url)
+	}
+	if src, ok := localPath(url); ok {
+		return copyFile(file, src)
+	}
+	if strings.HasPrefix(url, "gs://") {
+		return fetchGCS(file, url)
+	}
+	return httpdl.Download(file, url, httpdl.WithClient(http.DefaultClient))
+}
+
+// isGzipURL reports whether the path of u ends in ".gz".
+func isGzipURL(u string) bool {
+	if pu, err := url.Parse(u); err == nil && pu.Path != "" {
+		u = pu.Path
+	}
+	return strings.HasSuffix(u, ".gz")
+}
+
+// fetchGzip does a single attempt at downloading the gzipped url,
+// decompressing it to file. HTTP and local sources are decompressed
+// as they're read; GCS objects are fetched whole (to verify their
+// checksums) and then decompressed.
+func fetchGzip(file, u string) error {
+	if src, ok := localPath(u); ok {
+		log.Printf("decompressing local file %s to %s", src, file)
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return writeGunzip(file, f)
+	}
+	if strings.HasPrefix(u, "gs://") {
+		gz := file + ".gz"
+		defer os.Remove(gz)
+		if err := fetchGCS(gz, u); err != nil {
+			return err
+		}
+		f, err := os.Open(gz)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return writeGunzip(file, f)
+	}
+	getURL := u
+	if strings.HasPrefix(getURL, "https://storage.googleapis.com") && !strings.Contains(getURL, "?") {
+		getURL += fmt.Sprintf("?%d", time.Now().Unix())
+	}
+	res, err := http.Get(getURL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := httpdl.CheckResponse(res); err != nil {
+		return err
+	}
+	if res.Uncompressed {
+		// The server sent it with Content-Encoding: gzip and
+		// net/http already decompressed it.
+		return writeFile(file, res.Body)
+	}
+	return writeGunzip(file, res.Body)
+}
+
+// writeGunzip decompresses the gzip stream r to file.
+// A corrupt or truncated stream, or one that decompresses to
+// nothing, is an error and leaves file untouched.
+func writeGunzip(file string, r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("decompressing %s: %v", file, err)
+	}
+	if err := writeFile(file, zr); err != nil {
+		return fmt.Errorf("decompressing %s: %v", file, err)
+	}
+	return nil
+}
+
+// writeFile atomically writes the non-empty contents of r to file.
+func writeFile(file string, r io.Reader) error {
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n == 0 {
+		err = errors.New("no data")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// localPath reports whether u is a file URL or an absolute path and,
+// if so, returns the local path it refers to.
+func localPath(u string) (path string, ok bool) {
+	if !strings.HasPrefix(u, "file://") {
+		return u, filepath.IsAbs(u)
+	}
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Host != "" && pu.Host != "localhost") {
+		return "", false
+	}
+	path = pu.Path
+	// file:///C:/foo has the path /C:/foo.
+	if runtime.GOOS == "windows" && len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path), true
+}
+
+// copyFile copies the regular, non-empty file src to dst.
+func copyFile(dst, src string) error {
+	log.Printf("copying local file %s to %s", src, dst)
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	fi, err := sf.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+	if fi.Size() == 0 {
+		return fmt.Errorf("%s is empty", src)
+	}
+	tmp := dst + ".tmp"
+	df, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(df, sf)
+	if cerr := df.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("copying %s to %s: %v", src, dst, err)
+	}
+	return os.Rename(tmp, dst)
+}
+
+// backoff returns how long to wait before the nth retry (starting at
+// 1). It doubles from minBackoff up to maxBackoff, with jitter.
+func backoff(n int) time.Duration {
+	d := maxBackoff
+	if n < 16 {
+		if e := minBackoff << uint(n-1); e < maxBackoff {
+			d = e
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// configureHTTPClient sets up http.DefaultClient, which stage0 passes
+// to httpdl and uses for its network probe, to enforce
+// --download-attempt-timeout, --download-stall-timeout, and
+// --download-rate-limit, and to log progress every --progress-interval.
+// It also makes downloads use proxyFunc and dialContext, identify
+// stage0's version in their User-Agent, and use downloadAuth.
+func configureHTTPClient() {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = proxyFunc
+	tr.DialContext = dialContext
+	http.DefaultClient = &http.Client{
+		Timeout: *attemptTimeout,
+		Transport: &userAgentTransport{
+			rt: &authTransport{
+				rt: &progressTransport{
+					rt: &rateLimitTransport{
+						rt: &stallTransport{
+							rt:    tr,
+							stall: *stallTimeout,
+						},
+						rate: int64(downloadRateLimit),
+					},
+					interval: *progressInterval,
+				},
+			},
+		},
+	}
+}
+
+// stallTransport is an http.RoundTripper that aborts requests whose
+// responses make no progress for the given duration. Unlike a plain
+// timeout, it lets big downloads on slow links finish.
+type stallTransport struct {
+	rt    http.RoundTripper
+	stall time.Duration
+}
+
+func (t *stallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	sr := &stallReader{d: t.stall, cancel: cancel}
+	sr.timer = time.AfterFunc(t.stall, sr.fire)
+	res, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		sr.stop()
+		return nil, sr.wrapErr(err)
+	}
+	sr.rc = res.Body
+	res.Body = sr
+	return res, nil
+}
+
+// stallReader wraps a response body, cancelling its request if no
+// bytes are read for d.
+type stallReader struct {
+	rc      io.ReadCloser
+	d       time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	stalled int32 // atomic; 1 if the timer fired
+}
+
+func (r *stallReader) fire() {
+	atomic.StoreInt32(&r.stalled, 1)
+	r.cancel()
+}
+
+func (r *stallReader) stop() {
+	r.timer.Stop()
+	r.cancel()
+}
+
+func (r *stallReader) wrapErr(err error) error {
+	if atomic.LoadInt32(&r.stalled) == 1 {
+		return fmt.Errorf("download stalled: no progress for %v", r.d)
+	}
+	return err
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.d)
+	}
+	if err != nil && err != io.EOF {
+		err = r.wrapErr(err)
+	}
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.stop()
+	return r.rc.Close()
+}
@@ -0,0 +1,394 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer returns a server that fails the first n requests with a
+// 500 before serving content.
+func flakyServer(n int, content string) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := n > 0
+		n--
+		mu.Unlock()
+		if fail {
+			http.Error(w, "flaky", http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "buildlet", time.Unix(1462292149, 0), strings.NewReader(content))
+	}))
+}
+
+func tempFile(t *testing.T) (file string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "stage0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "buildlet.exe"), func() { os.RemoveAll(dir) }
+}
+
+func TestDownloadRetry(t *testing.T) {
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+
+	tests := []struct {
+		fails   int
+		retries int
+		wantErr bool
+	}{
+		{fails: 0, retries: 3},
+		{fails: 2, retries: 3},
+		{fails: 3, retries: 3, wantErr: true},
+		{fails: 4, retries: 5},
+	}
+	for _, tt := range tests {
+		slept = nil
+		*downloadRetries = tt.retries
+		ts := flakyServer(tt.fails, "buildlet binary")
+		file, cleanup := tempFile(t)
+		err := download(file, ts.URL, nil)
+		ts.Close()
+		cleanup()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("fails=%d, retries=%d: err = %v; want error = %v", tt.fails, tt.retries, err, tt.wantErr)
+		}
+		wantSleeps := tt.fails
+		if tt.wantErr {
+			wantSleeps = tt.retries - 1
+		}
+		if len(slept) != wantSleeps {
+			t.Errorf("fails=%d, retries=%d: slept %d times; want %d", tt.fails, tt.retries, len(slept), wantSleeps)
+		}
+	}
+	*downloadRetries = 3
+}
+
+func TestDownloadChecksumRetry(t *testing.T) {
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+
+	const good = "buildlet binary"
+	sum := sha256.Sum256([]byte(good))
+	file, cleanup := tempFile(t)
+	defer cleanup()
+
+	// The first GET serves corrupt bytes, as a bad proxy or
+	// mirror might; the second, the real binary.
+	var mu sync.Mutex
+	gets := 0
+	var leftover error // of the bad file, at the second GET
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := good
+		if r.Method == "GET" {
+			mu.Lock()
+			gets++
+			if gets == 1 {
+				content = "corrupt binary!"
+			} else if _, err := os.Stat(file); !os.IsNotExist(err) {
+				leftover = fmt.Errorf("%s still exists: %v", file, err)
+			}
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "buildlet", time.Unix(1462292149, 0), strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	err := download(file, ts.URL+"/buildlet", func(file string) error {
+		return verifySHA256(file, hex.EncodeToString(sum[:]))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if gets != 2 || len(slept) != 1 {
+		t.Errorf("downloaded %d times, sleeping %d times; want 2 downloads with 1 sleep between", gets, len(slept))
+	}
+	if leftover != nil {
+		t.Errorf("file failing its checksum wasn't removed before retrying: %v", leftover)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != good {
+		t.Errorf("downloaded file = %q, %v; want %q", b, err, good)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for n := 1; n < 100; n++ {
+		want := minBackoff << uint(n-1)
+		if n >= 16 || want > maxBackoff {
+			want = maxBackoff
+		}
+		for i := 0; i < 10; i++ {
+			if d := backoff(n); d < want/2 || d > want {
+				t.Fatalf("backoff(%d) = %v; want in [%v, %v]", n, d, want/2, want)
+			}
+		}
+	}
+}
+
+func TestDownloadStall(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+	oldClient, oldStall := http.DefaultClient, *stallTimeout
+	defer func() { http.DefaultClient, *stallTimeout = oldClient, oldStall }()
+	*stallTimeout = 100 * time.Millisecond
+	configureHTTPClient()
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", time.Unix(1462292149, 0).UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", "1000")
+		if r.Method == "HEAD" {
+			return
+		}
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	errc := make(chan error, 1)
+	go func() { errc <- download(file, ts.URL, nil) }()
+	select {
+	case err := <-errc:
+		if err == nil || !strings.Contains(err.Error(), "stalled") {
+			t.Errorf("download error = %v; want stall error", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("download didn't detect stall")
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	type localPathTest struct {
+		in     string
+		want   string
+		wantOK bool
+	}
+	tests := []localPathTest{
+		{"https://storage.googleapis.com/go-builder-data/buildlet.linux-amd64", "", false},
+		{"file:///tmp/buildlet", filepath.FromSlash("/tmp/buildlet"), true},
+		{"file://localhost/tmp/buildlet", filepath.FromSlash("/tmp/buildlet"), true},
+		{"file://otherhost/tmp/buildlet", "", false},
+		{"relative/buildlet", "", false},
+	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, localPathTest{"file:///C:/buildlet.exe", `C:\buildlet.exe`, true})
+	} else {
+		tests = append(tests, localPathTest{"/tmp/buildlet", "/tmp/buildlet", true})
+	}
+	for _, tt := range tests {
+		got, ok := localPath(tt.in)
+		if ok != tt.wantOK || (ok && got != tt.want) {
+			t.Errorf("localPath(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestDownloadLocalFile(t *testing.T) {
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	src := file + ".src"
+	if err := ioutil.WriteFile(src, []byte("buildlet binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := "file://" + filepath.ToSlash(src)
+	if runtime.GOOS == "windows" {
+		u = "file:///" + filepath.ToSlash(src)
+	}
+	if err := download(file, u, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != "buildlet binary" {
+		t.Errorf("copied file = %q, %v; want %q", b, err, "buildlet binary")
+	}
+
+	*downloadRetries = 1
+	defer func() { *downloadRetries = 3 }()
+	if err := ioutil.WriteFile(src, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := download(file, src, nil); err == nil {
+		t.Error("copying empty file succeeded; want error")
+	}
+}
+
+func TestDownloadResolve(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	good := flakyServer(0, "buildlet binary")
+	defer good.Close()
+	bad := httptest.NewServer(http.NotFoundHandler())
+	defer bad.Close()
+
+	// A fake metadata server whose buildlet URL attribute is fixed
+	// after the first lookup.
+	var mu sync.Mutex
+	attrVal := bad.URL + "/buildlet"
+	md := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(attrVal))
+		attrVal = good.URL + "/buildlet"
+	}))
+	defer md.Close()
+	resolve := func() string {
+		res, err := http.Get(md.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	url, err := downloadResolve(file, resolve, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := good.URL + "/buildlet"; url != want {
+		t.Errorf("downloaded from %q; want %q", url, want)
+	}
+}
+
+func TestDownloadMirrors(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	good := flakyServer(0, "buildlet binary")
+	defer good.Close()
+	bad := httptest.NewServer(http.NotFoundHandler())
+	defer bad.Close()
+
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	list := bad.URL + "/buildlet,, " + good.URL + "/buildlet,"
+	url, err := downloadResolve(file, func() string { return list }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := good.URL + "/buildlet"; url != want {
+		t.Errorf("downloaded from %q; want %q", url, want)
+	}
+
+	_, err = downloadResolve(file, func() string { return bad.URL + "/a," + bad.URL + "/b" }, nil)
+	if err == nil || !strings.Contains(err.Error(), "/a:") || !strings.Contains(err.Error(), "/b:") {
+		t.Errorf("error = %v; want error naming both mirrors", err)
+	}
+}
+
+func TestSplitURLs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"https://a/x", []string{"https://a/x"}},
+		{"https://a/x, https://b/x,", []string{"https://a/x", "https://b/x"}},
+		{",,", nil},
+	}
+	for _, tt := range tests {
+		got := splitURLs(tt.in)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("splitURLs(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func gzipped(t *testing.T, s string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDownloadGzip(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	gz := gzipped(t, "buildlet binary")
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		want    string // empty means error
+	}{
+		{"gzip", "/buildlet.linux-amd64.gz", gz, "buildlet binary"},
+		{"gzip with query", "/buildlet.linux-amd64.gz?generation=1", gz, "buildlet binary"},
+		{"not gzip URL", "/buildlet.linux-amd64", gz, gz},
+		{"corrupt", "/buildlet.linux-amd64.gz", gz[:len(gz)-6] + "xxxxxx", ""},
+		{"truncated", "/buildlet.linux-amd64.gz", gz[:len(gz)/2], ""},
+		{"not gzip data", "/buildlet.linux-amd64.gz", "buildlet binary", ""},
+		{"empty", "/buildlet.linux-amd64.gz", gzipped(t, ""), ""},
+	}
+	for _, tt := range tests {
+		ts := flakyServer(0, tt.content)
+		file, cleanup := tempFile(t)
+		err := download(file, ts.URL+tt.path, nil)
+		got, _ := ioutil.ReadFile(file)
+		ts.Close()
+		cleanup()
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: download succeeded; want error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadLocalGzip(t *testing.T) {
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	src := file + ".src.gz"
+	if err := ioutil.WriteFile(src, []byte(gzipped(t, "buildlet binary")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := download(file, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != "buildlet binary" {
+		t.Errorf("decompressed file = %q, %v; want %q", b, err, "buildlet binary")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	dryRun      = flag.Bool("dry-run", false, "resolve the buildlet URL, checksum, arguments, and environment from metadata and print them, then exit without waiting for the network or downloading anything")
+	dryRunProbe = flag.Bool("dry-run-probe", false, "like --dry-run, but also wait for the network")
+	jsonOutput  = flag.Bool("json", false, "with --dry-run, print JSON")
+)
+
+// dryRunConfig is what --dry-run prints.
+type dryRunConfig struct {
+	OSArch         string      `json:"osArch"`
+	BuilderEnv     string      `json:"builderEnv"`
+	BuildletURL    string      `json:"buildletURL"`
+	URLSource      string      `json:"urlSource"` // where BuildletURL came from
+	FallbackURL    string      `json:"fallbackURL"`
+	BuildletSHA256 string      `json:"buildletSHA256"`
+	BuildletAuth   string      `json:"buildletAuth"` // redacted
+	Host           []hostField `json:"host"`         // effective host configuration
+	Args           []string    `json:"args"`
+	Env            []string    `json:"env"` // added to stage0's own environment
+	NetworkUp      *bool       `json:"networkUp,omitempty"`
+}
+
+// printDryRun resolves the configuration stage0 would run the
+// buildlet with and prints it to w.
+func printDryRun(w io.Writer) error {
+	c := dryRunConfig{OSArch: osArch}
+	var netDelay time.Duration
+	if *dryRunProbe {
+		configureHTTPClient()
+		up := awaitNetwork()
+		c.NetworkUp = &up
+		netDelay = prettyDuration(time.Since(timeStart))
+	}
+	resolveBuilderEnv()
+	c.BuilderEnv = os.Getenv("GO_BUILDER_ENV")
+	h, src := resolveHost()
+	c.BuildletURL, c.URLSource = resolveBuildletURL(h, src)
+	c.FallbackURL = fallbackURL()
+	c.BuildletSHA256 = buildletSHA256()
+	if newBuildletAuth().static != "" {
+		c.BuildletAuth = "(redacted)"
+	}
+	for _, f := range hostFields(h) {
+		f.Source = src[f.Name]
+		c.Host = append(c.Host, f)
+	}
+	target := filepath.FromSlash("./buildlet.exe")
+	c.Args = append([]string{target}, buildletArgs()...)
+	c.Env = buildletEnv(netDelay, 0)
+
+	if *jsonOutput {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		return e.Encode(c)
+	}
+	fmt.Fprintf(w, "os/arch:         %s\n", c.OSArch)
+	fmt.Fprintf(w, "GO_BUILDER_ENV:  %s\n", c.BuilderEnv)
+	if c.URLSource != "" {
+		fmt.Fprintf(w, "buildlet URL:    %s (%s)\n", c.BuildletURL, c.URLSource)
+	} else {
+		fmt.Fprintf(w, "buildlet URL:    (none)\n")
+	}
+	fmt.Fprintf(w, "fallback URL:    %s\n", c.FallbackURL)
+	fmt.Fprintf(w, "buildlet SHA256: %s\n", c.BuildletSHA256)
+	fmt.Fprintf(w, "buildlet auth:   %s\n", c.BuildletAuth)
+	fmt.Fprintf(w, "host config:\n")
+	for _, f := range c.Host {
+		v := f.Value
+		if v == "" {
+			v = "(none)"
+		}
+		fmt.Fprintf(w, "  %-12s   %s (%s)\n", f.Name+":", v, f.Source)
+	}
+	fmt.Fprintf(w, "args:            %s\n", strings.Join(c.Args, " "))
+	fmt.Fprintf(w, "env:             %s\n", strings.Join(c.Env, " "))
+	if c.NetworkUp != nil {
+		fmt.Fprintf(w, "network up:      %v\n", *c.NetworkUp)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestPrintDryRunJSON(t *testing.T) {
+	if osArch != "linux/amd64" {
+		t.Skip("test assumes a linux/amd64 host with no built-in URL")
+	}
+	for _, k := range []string{"IN_KUBERNETES", "GO_BUILDER_ENV", "META_BUILDLET_BINARY_URL", "META_BUILDLET_EXTRA_ARGS", "META_BUILDLET_BINARY_AUTH", "GOARCH"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("IN_KUBERNETES", "1")
+	os.Setenv("GO_BUILDER_ENV", "host-test")
+	os.Setenv("META_BUILDLET_BINARY_URL", "https://example.com/buildlet")
+	os.Setenv("META_BUILDLET_EXTRA_ARGS", "--debug")
+	os.Setenv("META_BUILDLET_BINARY_AUTH", "Bearer s3cret")
+	os.Unsetenv("GOARCH")
+	defer func(v bool) { *jsonOutput = v }(*jsonOutput)
+	*jsonOutput = true
+
+	var buf bytes.Buffer
+	if err := printDryRun(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("s3cret")) {
+		t.Errorf("dry-run output contains the buildlet auth value:\n%s", buf.Bytes())
+	}
+	var c dryRunConfig
+	if err := json.Unmarshal(buf.Bytes(), &c); err != nil {
+		t.Fatalf("output isn't JSON: %v\n%s", err, buf.Bytes())
+	}
+	if c.BuildletURL != "https://example.com/buildlet" {
+		t.Errorf("buildletURL = %q", c.BuildletURL)
+	}
+	if c.BuildletAuth != "(redacted)" {
+		t.Errorf("buildletAuth = %q; want (redacted)", c.BuildletAuth)
+	}
+	if c.BuilderEnv != "host-test" {
+		t.Errorf("builderEnv = %q", c.BuilderEnv)
+	}
+	if len(c.Args) != 2 || c.Args[1] != "--debug" {
+		t.Errorf("args = %q; want buildlet then --debug", c.Args)
+	}
+	if c.NetworkUp != nil {
+		t.Errorf("networkUp set without --dry-run-probe")
+	}
+}
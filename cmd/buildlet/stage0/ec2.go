@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var ec2IMDSv1 = flag.Bool("ec2-imdsv1", false, "on EC2, fall back to IMDSv1 if the IMDSv2 session token can't be fetched")
+
+// ec2MetadataURL is the base URL of the EC2 instance metadata
+// service. It's a variable for tests.
+var ec2MetadataURL = "http://169.254.169.254"
+
+// ec2Provider is a metadataProvider for EC2. Values come from the
+// instance's tags (which must be exposed in the instance metadata)
+// or, failing that, from "key=value" lines in its user-data.
+type ec2Provider struct {
+	token string // IMDSv2 session token; empty for IMDSv1
+}
+
+func (p *ec2Provider) Name() string { return "EC2" }
+
+func (p *ec2Provider) Detect() bool {
+	req, err := http.NewRequest("PUT", ec2MetadataURL+"/latest/api/token", nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	tok, err := metaGet(req, 1)
+	if err == nil {
+		p.token = strings.TrimSpace(string(tok))
+		return true
+	}
+	if *ec2IMDSv1 {
+		if _, err := p.get("/latest/meta-data/instance-id", 1); err == nil {
+			log.Printf("using EC2 IMDSv1")
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ec2Provider) Value(key string) string {
+	v, err := p.get("/latest/meta-data/tags/instance/"+key, 3)
+	if err == nil {
+		return strings.TrimSpace(v)
+	}
+	if err != errMetaNotFound {
+		log.Printf("looking up EC2 tag %q: %v", key, err)
+	}
+	ud, err := p.get("/latest/user-data", 3)
+	if err != nil {
+		if err != errMetaNotFound {
+			log.Printf("fetching EC2 user-data: %v", err)
+		}
+		return ""
+	}
+	return userDataValue(ud, key)
+}
+
+// get fetches path from the EC2 metadata service.
+func (p *ec2Provider) get(path string, tries int) (string, error) {
+	req, err := http.NewRequest("GET", ec2MetadataURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if p.token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", p.token)
+	}
+	b, err := metaGet(req, tries)
+	return string(b), err
+}
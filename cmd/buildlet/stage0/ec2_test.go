@@ -0,0 +1,58 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEC2Provider(t *testing.T) {
+	const token = "secret-token"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != "PUT" {
+				http.Error(w, "bad method", http.StatusMethodNotAllowed)
+				return
+			}
+			fmt.Fprint(w, token)
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/tags/instance/buildlet-binary-url":
+			fmt.Fprint(w, "https://example.com/buildlet.linux-arm64")
+		case "/latest/user-data":
+			fmt.Fprint(w, "#!/bin/sh\nbuildlet-binary-sha256 = abc123\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	defer func(old string) { ec2MetadataURL = old }(ec2MetadataURL)
+	ec2MetadataURL = ts.URL
+
+	p := new(ec2Provider)
+	if !p.Detect() {
+		t.Fatal("Detect = false; want true")
+	}
+	tests := []struct {
+		key, want string
+	}{
+		{"buildlet-binary-url", "https://example.com/buildlet.linux-arm64"},
+		{"buildlet-binary-sha256", "abc123"},
+		{"unknown", ""},
+	}
+	for _, tt := range tests {
+		if got := p.Value(tt.key); got != tt.want {
+			t.Errorf("Value(%q) = %q; want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	envAllowlist      = flag.String("env-allowlist", strings.Join(defaultEnvAllowlist(), ","), "comma-separated names of environment variables to pass on to the buildlet; a trailing * matches any suffix")
+	envPassthroughAll = flag.Bool("env-passthrough-all", false, "pass stage0's whole environment on to the buildlet, ignoring --env-allowlist")
+)
+
+// defaultEnvAllowlist returns the default --env-allowlist: what the
+// buildlet and the builds it runs need, including the toolchain
+// settings, such as GOMIPS and CC, that some hosts set, and not, say,
+// cloud credentials or stage0's own META_* configuration.
+func defaultEnvAllowlist() []string {
+	l := []string{
+		"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR",
+		"LANG", "LC_*", "TZ", "TERM", "HOSTNAME",
+		"GO_*", "GOROOT_BOOTSTRAP", "GOPATH", "GOCACHE", "GOTMPDIR",
+		"GOOS", "GOARCH", "GOARM", "GOARM64", "GO386", "GOAMD64",
+		"GOMIPS", "GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM",
+		"GOFLAGS", "GOEXPERIMENT", "GODEBUG", "GOTOOLCHAIN",
+		"GOPROXY", "GONOPROXY", "GOSUMDB", "GONOSUMDB", "GOPRIVATE", "GOINSECURE",
+		"GOMAXPROCS", "GOGC", "GOTRACEBACK",
+		"CGO_*", "CC", "CXX", "CC_FOR_*", "CXX_FOR_*", "PKG_CONFIG",
+		"IN_KUBERNETES", "KUBERNETES_*",
+		"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+		"WORKDIR",
+	}
+	if runtime.GOOS == "windows" {
+		l = append(l,
+			"SystemRoot", "SystemDrive", "windir", "ComSpec", "PATHEXT",
+			"OS", "COMPUTERNAME", "USERNAME", "USERDOMAIN", "USERPROFILE",
+			"HOMEDRIVE", "HOMEPATH", "APPDATA", "LOCALAPPDATA", "TEMP", "TMP",
+			"ProgramData", "ProgramFiles", "ProgramFiles(x86)", "ProgramW6432",
+			"CommonProgramFiles", "CommonProgramFiles(x86)", "CommonProgramW6432",
+			"ALLUSERSPROFILE", "PUBLIC",
+			"NUMBER_OF_PROCESSORS", "PROCESSOR_*",
+		)
+	}
+	return l
+}
+
+// logFilteredEnv makes filterEnv log the names it drops only once.
+var logFilteredEnv sync.Once
+
+// filterEnv returns the KEY=VALUE entries of env allowed by
+// --env-allowlist, or all of env with --env-passthrough-all.
+func filterEnv(env []string) []string {
+	if *envPassthroughAll {
+		return env
+	}
+	allow := splitList(*envAllowlist)
+	var kept []string
+	var dropped []string
+	for _, kv := range env {
+		k := kv
+		if i := strings.Index(kv, "="); i > 0 {
+			k = kv[:i]
+		}
+		if envAllowed(k, allow) {
+			kept = append(kept, kv)
+		} else {
+			dropped = append(dropped, k)
+		}
+	}
+	if len(dropped) > 0 {
+		logFilteredEnv.Do(func() {
+			sort.Strings(dropped)
+			logf(logFields{"level": "warn"}, "not passing these environment variables to the buildlet (see --env-allowlist): %s", strings.Join(dropped, ", "))
+		})
+	}
+	return kept
+}
+
+// envAllowed reports whether the variable key matches a name or
+// prefix pattern in allow. On Windows, where environment variable
+// names are case-insensitive, so is the match.
+func envAllowed(key string, allow []string) bool {
+	if runtime.GOOS == "windows" {
+		key = strings.ToUpper(key)
+	}
+	for _, a := range allow {
+		if runtime.GOOS == "windows" {
+			a = strings.ToUpper(a)
+		}
+		if strings.HasSuffix(a, "*") {
+			if strings.HasPrefix(key, a[:len(a)-1]) {
+				return true
+			}
+		} else if key == a {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, trimming spaces and
+// dropping empty elements.
+func splitList(s string) []string {
+	var l []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			l = append(l, f)
+		}
+	}
+	return l
+}
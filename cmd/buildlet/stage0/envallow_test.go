@@ -0,0 +1,54 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterEnv(t *testing.T) {
+	defer func(a string, all bool) { *envAllowlist, *envPassthroughAll = a, all }(*envAllowlist, *envPassthroughAll)
+	env := []string{
+		"PATH=/usr/bin",
+		"HOME=/root",
+		"GO_BUILDER_ENV=host-linux-arm64-packet",
+		"GOROOT_BOOTSTRAP=/go1.4",
+		"AWS_SECRET_ACCESS_KEY=s3cret",
+		"META_BUILDLET_BINARY_AUTH=Bearer x",
+		"LC_CTYPE=C.UTF-8",
+	}
+
+	*envAllowlist = "PATH, GO_* ,LC_*"
+	want := []string{"PATH=/usr/bin", "GO_BUILDER_ENV=host-linux-arm64-packet", "LC_CTYPE=C.UTF-8"}
+	if got := filterEnv(env); !reflect.DeepEqual(got, want) {
+		t.Errorf("filterEnv = %q; want %q", got, want)
+	}
+
+	*envAllowlist = ""
+	if got := filterEnv(env); len(got) != 0 {
+		t.Errorf("with empty allowlist, filterEnv = %q; want nothing", got)
+	}
+
+	*envPassthroughAll = true
+	if got := filterEnv(env); !reflect.DeepEqual(got, env) {
+		t.Errorf("with --env-passthrough-all, filterEnv = %q; want %q", got, env)
+	}
+}
+
+func TestDefaultEnvAllowlist(t *testing.T) {
+	allow := defaultEnvAllowlist()
+	for _, k := range []string{"PATH", "HOME", "GO_BUILDER_ENV", "GO_STAGE0_VERSION", "GOROOT_BOOTSTRAP", "IN_KUBERNETES", "HTTPS_PROXY",
+		"GOMIPS", "GOMIPS64", "GO386", "GOAMD64", "GOPPC64", "GOFLAGS", "GOPROXY", "CGO_ENABLED", "CGO_CFLAGS", "CC", "CXX"} {
+		if !envAllowed(k, allow) {
+			t.Errorf("%s isn't allowed by default", k)
+		}
+	}
+	for _, k := range []string{"AWS_SECRET_ACCESS_KEY", "GOOGLE_APPLICATION_CREDENTIALS", "META_BUILDLET_BINARY_AUTH", "META_BUILDLET_BINARY_URL"} {
+		if envAllowed(k, allow) {
+			t.Errorf("%s is allowed by default", k)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	stage0EnvFile = flag.String("stage0-env-file", "", "if non-empty, a file of KEY=VALUE lines to set in stage0's own environment at startup, such as GO_BUILDER_ENV and META_BUILDLET_BINARY_URL, for machines without a metadata service")
+	envFile       = flag.String("env-file", "", "if non-empty, a file of KEY=VALUE lines to add to the buildlet's environment, overriding the buildlet-env metadata attribute")
+)
+
+// buildletEnvAttr is the optional GCE instance attribute with extra
+// environment variables for the buildlet, in the --env-file syntax.
+// Off GCE, the META_BUILDLET_ENV environment variable is used
+// instead.
+const buildletEnvAttr = "buildlet-env"
+
+// loadStage0EnvFile sets the variables in --stage0-env-file, if any,
+// in stage0's environment.
+func loadStage0EnvFile() error {
+	if *stage0EnvFile == "" {
+		return nil
+	}
+	env, err := readEnvFile(*stage0EnvFile)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		os.Setenv(kv[:i], kv[i+1:])
+		keys = append(keys, kv[:i])
+	}
+	log.Printf("set %s from %s", strings.Join(keys, ", "), *stage0EnvFile)
+	return nil
+}
+
+// buildletEnvVars returns the extra variables for the buildlet from
+// the buildlet-env metadata attribute and then --env-file, so the
+// file's settings win. Syntax errors are fatal.
+func buildletEnvVars() []string {
+	var env []string
+	if v := metaValue(buildletEnvAttr, "META_BUILDLET_ENV"); v != "" {
+		e, err := parseEnvFile(strings.NewReader(v))
+		if err != nil {
+			configFatalf("%s metadata:%v", buildletEnvAttr, err)
+		}
+		env = append(env, e...)
+	}
+	if *envFile != "" {
+		e, err := readEnvFile(*envFile)
+		if err != nil {
+			configFatalf("--env-file: %v", err)
+		}
+		env = append(env, e...)
+	}
+	return env
+}
+
+// readEnvFile reads and parses the env file at path.
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	env, err := parseEnvFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	return env, nil
+}
+
+// envHas reports whether env, a list of KEY=VALUE strings, sets key.
+func envHas(env []string, key string) bool {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEnvFile parses r as lines of KEY=VALUE, optionally preceded by
+// "export ", returning them as KEY=VALUE strings. Blank lines and
+// lines starting with # are ignored. A value may be enclosed in
+// single or double quotes, which are removed. Errors begin with the
+// line number.
+func parseEnvFile(r io.Reader) ([]string, error) {
+	var env []string
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%d: missing = in %q", n, line)
+		}
+		k, v := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if !validEnvKey(k) {
+			return nil, fmt.Errorf("%d: invalid variable name %q", n, k)
+		}
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') {
+			if v[len(v)-1] != v[0] {
+				return nil, fmt.Errorf("%d: unterminated quote in value of %s", n, k)
+			}
+			v = v[1 : len(v)-1]
+		}
+		env = append(env, k+"="+v)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// validEnvKey reports whether k is a valid shell variable name.
+func validEnvKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	for i, r := range k {
+		switch {
+		case r == '_', 'A' <= r && r <= 'Z', 'a' <= r && r <= 'z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
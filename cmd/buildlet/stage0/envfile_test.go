@@ -0,0 +1,89 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	const in = `# Mac mini config
+GO_BUILDER_ENV=host-darwin-arm64-12
+
+export META_BUILDLET_BINARY_URL = https://example.com/buildlet.$GOOS-$GOARCH
+QUOTED="a b"
+SINGLE='c=d'
+EMPTY=
+`
+	got, err := parseEnvFile(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"GO_BUILDER_ENV=host-darwin-arm64-12",
+		"META_BUILDLET_BINARY_URL=https://example.com/buildlet.$GOOS-$GOARCH",
+		"QUOTED=a b",
+		"SINGLE=c=d",
+		"EMPTY=",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseEnvFile =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"A=1\nnot a setting\n", "2: missing ="},
+		{"# c\n\n1X=2\n", "3: invalid variable name"},
+		{"A-B=2\n", "1: invalid variable name"},
+		{"A=\"open\n", "1: unterminated quote"},
+	}
+	for _, tt := range tests {
+		_, err := parseEnvFile(strings.NewReader(tt.in))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("parseEnvFile(%q) error = %v; want prefix %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestBuildletEnvVars(t *testing.T) {
+	for _, k := range []string{"IN_KUBERNETES", "META_BUILDLET_ENV"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	defer func(v string) { *envFile = v }(*envFile)
+	os.Setenv("IN_KUBERNETES", "1")
+	os.Setenv("META_BUILDLET_ENV", "GO_TEST_SHORT=1\nexport GOPROXY=https://proxy.example.com")
+
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(file, []byte("# local overrides\nGOPROXY=off\nHOME=/home/gopher\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	*envFile = file
+
+	got := buildletEnvVars()
+	want := []string{
+		"GO_TEST_SHORT=1",
+		"GOPROXY=https://proxy.example.com",
+		"GOPROXY=off",
+		"HOME=/home/gopher",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildletEnvVars =\n%q\nwant\n%q", got, want)
+	}
+
+	// The root fixups mustn't override a HOME from the env file.
+	for _, kv := range buildletEnv(0, 0) {
+		if strings.HasPrefix(kv, "HOME=") && kv != "HOME=/home/gopher" {
+			t.Errorf("buildletEnv has %s after env file's HOME=/home/gopher", kv)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// equinixMetadataURL is the Equinix Metal (formerly Packet) device
+// metadata endpoint. It's a variable for tests.
+var equinixMetadataURL = "https://metadata.platformequinix.com/metadata"
+
+var equinixClient = &http.Client{Timeout: 10 * time.Second}
+
+// equinixMetadata is the subset of the Equinix Metal device metadata
+// used by stage0. The buildlet URL and reverse type come from the
+// device's custom data, keyed by "buildlet-binary-url" and
+// "reverse-type".
+type equinixMetadata struct {
+	ID         string            `json:"id"`
+	Hostname   string            `json:"hostname"`
+	Facility   string            `json:"facility"`
+	Plan       string            `json:"plan"`
+	CustomData map[string]string `json:"customdata"`
+}
+
+// hostTags returns the buildlet's --host-tag flags describing where
+// the device is, omitting any the metadata lacks.
+func (md *equinixMetadata) hostTags() []string {
+	var args []string
+	for _, t := range []struct{ key, value string }{
+		{"facility", md.Facility},
+		{"plan", md.Plan},
+		{"id", md.ID},
+	} {
+		if t.value != "" {
+			args = append(args, "--host-tag="+t.key+"="+t.value)
+		}
+	}
+	return args
+}
+
+// equinix is the cached Equinix Metal metadata.
+var equinix struct {
+	once sync.Once
+	md   *equinixMetadata // nil if unavailable
+}
+
+// isPacketHost reports whether GO_BUILDER_ENV says this is an
+// Equinix Metal (Packet) host.
+func isPacketHost() bool {
+	return os.Getenv("GO_BUILDER_ENV") == "host-linux-arm64-packet"
+}
+
+// equinixMeta returns the Equinix Metal device metadata, or nil if
+// this isn't a Packet host or the metadata couldn't be fetched.
+func equinixMeta() *equinixMetadata {
+	if !isPacketHost() {
+		return nil
+	}
+	equinix.once.Do(func() {
+		md, err := fetchEquinixMetadata()
+		if err != nil {
+			log.Printf("fetching Equinix Metal metadata: %v", err)
+			return
+		}
+		equinix.md = md
+	})
+	return equinix.md
+}
+
+func fetchEquinixMetadata() (*equinixMetadata, error) {
+	res, err := equinixClient.Get(equinixMetadataURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %v", equinixMetadataURL, res.Status)
+	}
+	md := new(equinixMetadata)
+	if err := json.NewDecoder(res.Body).Decode(md); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", equinixMetadataURL, err)
+	}
+	return md, nil
+}
+
+// equinixValue returns the device custom data value for key, or the
+// empty string if it's not set or there's no Equinix metadata.
+func equinixValue(key string) string {
+	if md := equinixMeta(); md != nil {
+		return md.CustomData[key]
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchEquinixMetadata(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+  "id": "6b2a6f2a-2b5c-4d5c-9a34-1c0f5d0c8e11",
+  "hostname": "golang-arm64-1",
+  "facility": "ams1",
+  "customdata": {
+    "buildlet-binary-url": "https://example.com/buildlet.linux-arm64",
+    "reverse-type": "host-linux-arm64-packet"
+  }
+}`)
+	}))
+	defer ts.Close()
+	defer func(old string) { equinixMetadataURL = old }(equinixMetadataURL)
+	equinixMetadataURL = ts.URL
+
+	md, err := fetchEquinixMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := md.Hostname, "golang-arm64-1"; got != want {
+		t.Errorf("Hostname = %q; want %q", got, want)
+	}
+	if got, want := md.CustomData["buildlet-binary-url"], "https://example.com/buildlet.linux-arm64"; got != want {
+		t.Errorf("buildlet-binary-url = %q; want %q", got, want)
+	}
+	if got, want := md.CustomData["reverse-type"], "host-linux-arm64-packet"; got != want {
+		t.Errorf("reverse-type = %q; want %q", got, want)
+	}
+	if got, want := strings.Join(md.hostTags(), " "), "--host-tag=facility=ams1 --host-tag=id=6b2a6f2a-2b5c-4d5c-9a34-1c0f5d0c8e11"; got != want {
+		t.Errorf("hostTags = %q; want %q", got, want)
+	}
+	if got := (&equinixMetadata{}).hostTags(); len(got) != 0 {
+		t.Errorf("hostTags with no metadata = %q; want none", got)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An exitError is returned by runBuildlet when the buildlet process
+// fails.
+type exitError struct {
+	reason    string // from exitReason
+	oomKilled bool   // the kernel's OOM killer killed it
+}
+
+func (e *exitError) Error() string {
+	if e.oomKilled {
+		return "buildlet was OOM-killed (" + e.reason + ")"
+	}
+	return "buildlet " + e.reason
+}
+
+// isOOMKill reports whether err is from the buildlet being killed by
+// the kernel's OOM killer.
+func isOOMKill(err error) bool {
+	e, ok := err.(*exitError)
+	return ok && e.oomKilled
+}
+
+// newExitError returns the exitError for the buildlet process pid,
+// which ended with state ps after starting at kernel uptime started
+// (see kernelUptime).
+func newExitError(ps *os.ProcessState, pid int, started time.Duration) *exitError {
+	e := &exitError{reason: exitReason(ps)}
+	if killedBySIGKILL(ps) && oomKilled(pid, started) {
+		e.oomKilled = true
+		log.Printf("buildlet was OOM-killed")
+	}
+	return e
+}
+
+// oomSlack is how long before the buildlet's start the kernel log is
+// searched for its OOM kill, to allow for the kernel log's clock
+// differing from the uptime clock.
+const oomSlack = time.Minute
+
+var (
+	// kmsgRx matches a /dev/kmsg record, "6,1234,5678901234,-;msg",
+	// with the timestamp in microseconds.
+	kmsgRx = regexp.MustCompile(`^\d+,\d+,(\d+),[^;]*;(.*)$`)
+	// dmesgRx matches a dmesg line, "[ 5678.901234] msg".
+	dmesgRx = regexp.MustCompile(`^\[\s*(\d+\.\d+)\]\s*(.*)$`)
+	// oomKillRx matches the kernel's OOM kill messages, from
+	// "Out of memory: Killed process 1234 (buildlet)" (or "Kill
+	// process" in older kernels, or with "Memory cgroup out of
+	// memory:") and "oom-kill:constraint=...,pid=1234,uid=0".
+	oomKillRx = regexp.MustCompile(`Kill(?:ed)? process (\d+) \(|^oom-kill:.*[:,]pid=(\d+)(?:,|$)`)
+)
+
+// findOOMKill reports whether the kernel log in r, in /dev/kmsg or
+// dmesg format, records the OOM killer killing pid at or after uptime
+// since, less oomSlack. Lines without timestamps are considered.
+func findOOMKill(r io.Reader, pid int, since time.Duration) bool {
+	want := strconv.Itoa(pid)
+	after := since - oomSlack
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 64<<10)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if m := kmsgRx.FindStringSubmatch(line); m != nil {
+			us, _ := strconv.ParseInt(m[1], 10, 64)
+			if time.Duration(us)*time.Microsecond < after {
+				continue
+			}
+			line = m[2]
+		} else if m := dmesgRx.FindStringSubmatch(line); m != nil {
+			sec, _ := strconv.ParseFloat(m[1], 64)
+			if time.Duration(sec*float64(time.Second)) < after {
+				continue
+			}
+			line = m[2]
+		}
+		if m := oomKillRx.FindStringSubmatch(line); m != nil && (m[1] == want || m[2] == want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// kernelUptime returns the time since boot, from /proc/uptime, or 0
+// if it's unavailable.
+func kernelUptime() time.Duration {
+	b, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0
+	}
+	f := strings.Fields(string(b))
+	if len(f) == 0 {
+		return 0
+	}
+	sec, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(sec * float64(time.Second))
+}
+
+// oomKilled reports whether the kernel log records the OOM killer
+// killing pid since kernel uptime since. It reads /dev/kmsg, falling
+// back to running dmesg. It's best effort: if neither works, it
+// reports false.
+func oomKilled(pid int, since time.Duration) bool {
+	b, err := readKmsg()
+	if err != nil {
+		b, err = exec.Command("dmesg").Output()
+	}
+	if err != nil {
+		log.Printf("can't read kernel log to check for an OOM kill: %v", err)
+		return false
+	}
+	return findOOMKill(bytes.NewReader(b), pid, since)
+}
+
+// readKmsg returns the records currently in /dev/kmsg, one per line.
+func readKmsg() ([]byte, error) {
+	// Read with syscalls: os.File would wait for more records
+	// rather than returning EAGAIN at the end.
+	fd, err := syscall.Open("/dev/kmsg", syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	var out []byte
+	buf := make([]byte, 8<<10) // each read returns one record
+	for {
+		n, err := syscall.Read(fd, buf)
+		switch {
+		case err == syscall.EAGAIN:
+			return out, nil
+		case err == syscall.EPIPE:
+			// Records were overwritten before being read;
+			// the next read resumes at the oldest.
+			continue
+		case err != nil:
+			return out, err
+		case n == 0:
+			return out, nil
+		}
+		out = append(out, buf[:n]...)
+	}
+}
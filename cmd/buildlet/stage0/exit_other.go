@@ -0,0 +1,17 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import "time"
+
+// kernelUptime returns 0; it's only needed to search the Linux kernel
+// log.
+func kernelUptime() time.Duration { return 0 }
+
+// oomKilled reports false; OOM kills are only detected on Linux.
+func oomKilled(pid int, since time.Duration) bool { return false }
@@ -0,0 +1,71 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExitReasonCode(t *testing.T) {
+	cmd := helperCommand("exit3")
+	if err := cmd.Run(); err == nil {
+		t.Fatal("helper succeeded; want exit status 3")
+	}
+	if got, want := exitReason(cmd.ProcessState), "exited with status 3"; got != want {
+		t.Errorf("exitReason = %q; want %q", got, want)
+	}
+	if killedBySIGKILL(cmd.ProcessState) {
+		t.Error("killedBySIGKILL = true for an exit")
+	}
+	e := newExitError(cmd.ProcessState, cmd.Process.Pid, 0)
+	if got, want := e.Error(), "buildlet exited with status 3"; got != want || isOOMKill(e) {
+		t.Errorf("exitError = %q, OOM %v; want %q, false", got, isOOMKill(e), want)
+	}
+}
+
+func TestExitErrorOOM(t *testing.T) {
+	e := &exitError{reason: "killed by signal 9 (killed)", oomKilled: true}
+	if got, want := e.Error(), "buildlet was OOM-killed (killed by signal 9 (killed))"; got != want {
+		t.Errorf("Error = %q; want %q", got, want)
+	}
+	if !isOOMKill(e) {
+		t.Error("isOOMKill = false; want true")
+	}
+}
+
+func TestFindOOMKill(t *testing.T) {
+	const kmsg = `6,1000,5000000,-;eth0: link up
+3,1001,100000000,-;Out of memory: Killed process 4321 (buildlet) total-vm:1024kB, anon-rss:512kB
+6,1002,200000000,-;oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/buildlet,task=buildlet,pid=5555,uid=0
+3,1003,200000001,-;Memory cgroup out of memory: Killed process 5555 (buildlet) total-vm:2048kB
+`
+	const dmesg = `[    5.000000] eth0: link up
+[  100.000000] Out of memory: Kill process 4321 (buildlet) score 900 or sacrifice child
+[  100.000001] Killed process 4321 (buildlet) total-vm:1024kB, anon-rss:512kB
+`
+	tests := []struct {
+		log   string
+		pid   int
+		since time.Duration
+		want  bool
+	}{
+		{kmsg, 4321, 90 * time.Second, true},
+		{kmsg, 4321, 10 * time.Minute, false}, // before the buildlet started
+		{kmsg, 432, 0, false},
+		{kmsg, 5555, 150 * time.Second, true},
+		{kmsg, 1, 0, false},
+		{dmesg, 4321, 90 * time.Second, true},
+		{dmesg, 4321, time.Hour, false},
+		{dmesg, 1000, 0, false},
+		{"Out of memory: Killed process 77 (buildlet)\n", 77, time.Hour, true}, // no timestamp
+	}
+	for i, tt := range tests {
+		if got := findOOMKill(strings.NewReader(tt.log), tt.pid, tt.since); got != tt.want {
+			t.Errorf("%d. findOOMKill(pid %d, since %v) = %v; want %v", i, tt.pid, tt.since, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// onExitAttr is the optional GCE instance attribute for the host
+// configuration's OnBuildletExit. Off GCE, the META_ON_BUILDLET_EXIT
+// environment variable is used instead. The stage0-config attribute
+// and --host-config override it.
+const onExitAttr = "on-buildlet-exit"
+
+// What to do when the buildlet finishes, that is, exits successfully,
+// as after the coordinator asks it to halt. See
+// hostConfig.OnBuildletExit.
+const (
+	exitNone    = "none"             // stage0 exits
+	exitRestart = "restart-buildlet" // stage0 runs the buildlet again
+	exitReboot  = "reboot"           // the machine reboots
+	exitHalt    = "halt"             // the machine powers off
+)
+
+// halt is haltMachine, except in tests.
+var halt = haltMachine
+
+func validExitPolicy(p string) error {
+	switch p {
+	case "", exitNone, exitRestart, exitReboot, exitHalt:
+		return nil
+	}
+	return fmt.Errorf("%q isn't one of %s, %s, %s, or %s", p, exitNone, exitRestart, exitReboot, exitHalt)
+}
+
+// exitPolicyArgs returns the buildlet's --halt and --reboot flags for
+// policy, or nil for the empty policy, which leaves them alone.
+func exitPolicyArgs(policy string) []string {
+	switch policy {
+	case exitNone, exitRestart:
+		return []string{"--halt=false", "--reboot=false"}
+	case exitReboot:
+		return []string{"--halt=true", "--reboot=true"}
+	case exitHalt:
+		return []string{"--halt=true", "--reboot=false"}
+	}
+	return nil
+}
+
+// setExitPolicyArgs replaces any --halt and --reboot flags in args
+// with those for policy, if it's non-empty.
+func setExitPolicyArgs(args []string, policy string) []string {
+	if policy == "" {
+		return args
+	}
+	var out []string
+	for _, a := range args {
+		if name := flagName(a); name != "halt" && name != "reboot" {
+			out = append(out, a)
+		}
+	}
+	return append(out, exitPolicyArgs(policy)...)
+}
+
+// finishMachine reboots or halts the machine, per policy, after the
+// buildlet has exited successfully. The buildlet was told to do the
+// same, but stage0 makes sure of it. It only returns if that fails or
+// stage0 is running in a container.
+func finishMachine(policy string) {
+	what, do := "REBOOTING", reboot
+	if policy == exitHalt {
+		what, do = "HALTING", halt
+	}
+	if why := inContainer(); why != "" {
+		log.Printf("buildlet exited with %s=%s, but not %s: running in a container (%s)", onExitAttr, policy, strings.ToLower(what), why)
+		return
+	}
+	log.Printf("*** buildlet exited with %s=%s; %s THE MACHINE ***", onExitAttr, policy, what)
+	if err := do(); err != nil {
+		log.Printf("%s failed: %v", policy, err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExitPolicyArgs(t *testing.T) {
+	// The packet hosts' args have the reverse builder's
+	// --halt=false and their own --reboot=false.
+	h := *hosts["host-linux-arm64-packet"]
+	h.Adjust = nil
+	h.Hostname = "box1"
+	base := "--reverse-type=host-linux-arm64-packet --coordinator=farmer.golang.org:443 --workdir=/workdir --hostname=box1"
+	tests := []struct {
+		policy, want string
+	}{
+		{"", "--halt=false " + base + " --reboot=false"},
+		{exitNone, base + " --halt=false --reboot=false"},
+		{exitRestart, base + " --halt=false --reboot=false"},
+		{exitReboot, base + " --halt=true --reboot=true"},
+		{exitHalt, base + " --halt=true --reboot=false"},
+	}
+	for _, tt := range tests {
+		h.OnBuildletExit = tt.policy
+		if got := strings.Join(h.args(), " "); got != tt.want {
+			t.Errorf("with OnBuildletExit %q, args =\n%s\nwant\n%s", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestValidExitPolicy(t *testing.T) {
+	for _, p := range []string{"", "none", "restart-buildlet", "reboot", "halt"} {
+		if err := validExitPolicy(p); err != nil {
+			t.Errorf("validExitPolicy(%q) = %v", p, err)
+		}
+	}
+	for _, p := range []string{"restart", "poweroff", "Halt"} {
+		if err := validExitPolicy(p); err == nil {
+			t.Errorf("validExitPolicy(%q) = nil; want error", p)
+		}
+	}
+}
+
+func TestFinishMachine(t *testing.T) {
+	var did []string
+	reboot = func() error { did = append(did, "reboot"); return nil }
+	halt = func() error { did = append(did, "halt"); return errors.New("no power button") }
+	defer func() { reboot, halt, inContainer = rebootMachine, haltMachine, detectContainer }()
+
+	inContainer = func() string { return "" }
+	finishMachine(exitReboot)
+	finishMachine(exitHalt)
+	inContainer = func() string { return "IN_KUBERNETES=1" }
+	finishMachine(exitReboot)
+	finishMachine(exitHalt)
+	if got, want := strings.Join(did, " "), "reboot halt"; got != want {
+		t.Errorf("did %q; want %q", got, want)
+	}
+}
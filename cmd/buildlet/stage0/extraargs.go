@@ -0,0 +1,126 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// extraArgsAttr is the optional GCE instance attribute containing
+// extra arguments to pass to the buildlet, after stage0's own. Off
+// GCE, the META_BUILDLET_EXTRA_ARGS environment variable is used
+// instead. See parseArgs for the format.
+const extraArgsAttr = "buildlet-extra-args"
+
+// buildletExtraArgs returns the extra buildlet arguments configured
+// in metadata or the environment, if any.
+func buildletExtraArgs() []string {
+	v := metaValue(extraArgsAttr, "META_BUILDLET_EXTRA_ARGS")
+	if v == "" {
+		return nil
+	}
+	args, err := parseArgs(v)
+	if err != nil {
+		log.Printf("ignoring malformed %s %q: %v", extraArgsAttr, v, err)
+		return nil
+	}
+	return args
+}
+
+// parseArgs parses s as either a JSON array of strings or as
+// whitespace-separated words, where single or double quotes group
+// words containing spaces and a backslash escapes the next
+// character (outside single quotes).
+func parseArgs(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(s), &args); err != nil {
+			return nil, fmt.Errorf("invalid JSON array of strings: %v", err)
+		}
+		return args, nil
+	}
+	var (
+		args  []string
+		cur   strings.Builder
+		inArg bool
+		quote rune // 0, '\'', or '"'
+		esc   bool
+	)
+	for _, r := range s {
+		switch {
+		case esc:
+			cur.WriteRune(r)
+			esc = false
+		case r == '\\' && quote != '\'':
+			esc, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if esc {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// flagName returns the name of the flag in arg, such as "workdir" for
+// "--workdir=/tmp", or the empty string if arg isn't a flag.
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+		return ""
+	}
+	name := strings.TrimLeft(arg, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// appendExtraArgs returns args with extra appended, logging loudly
+// about any extra flags that override ones already in args, since
+// the buildlet's flag package uses the last value given.
+func appendExtraArgs(args, extra []string) []string {
+	if len(extra) == 0 {
+		return args
+	}
+	have := map[string]string{}
+	for _, a := range args {
+		if name := flagName(a); name != "" {
+			have[name] = a
+		}
+	}
+	for _, a := range extra {
+		if prev, ok := have[flagName(a)]; ok {
+			log.Printf("WARNING: extra buildlet argument %q overrides built-in %q", a, prev)
+		}
+	}
+	log.Printf("appending extra buildlet arguments %q", extra)
+	return append(args, extra...)
+}
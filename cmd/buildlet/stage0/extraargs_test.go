@@ -0,0 +1,69 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "  --debug  ", want: []string{"--debug"}},
+		{in: "--debug --workdir=/data/workdir", want: []string{"--debug", "--workdir=/data/workdir"}},
+		{in: "--debug\n\t--halt=false", want: []string{"--debug", "--halt=false"}},
+		{in: `--workdir="/mnt/big disk" -v`, want: []string{"--workdir=/mnt/big disk", "-v"}},
+		{in: `--label 'a "b" c'`, want: []string{"--label", `a "b" c`}},
+		{in: `--label a\ b`, want: []string{"--label", "a b"}},
+		{in: `--empty ""`, want: []string{"--empty", ""}},
+		{in: `'a\b'`, want: []string{`a\b`}},
+		{in: `["--debug", "--workdir=/mnt/big disk"]`, want: []string{"--debug", "--workdir=/mnt/big disk"}},
+		{in: `[]`, want: []string{}},
+		{in: `--x "unterminated`, wantErr: true},
+		{in: `--x \`, wantErr: true},
+		{in: `["--debug", 1]`, wantErr: true},
+		{in: `[--debug]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseArgs(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseArgs(%q) error = %v; want error = %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseArgs(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFlagName(t *testing.T) {
+	tests := map[string]string{
+		"--workdir=/tmp": "workdir",
+		"-halt=false":    "halt",
+		"--debug":        "debug",
+		"value":          "",
+		"-":              "",
+		"--":             "",
+	}
+	for in, want := range tests {
+		if got := flagName(in); got != want {
+			t.Errorf("flagName(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestAppendExtraArgs(t *testing.T) {
+	args := []string{"./buildlet.exe", "--halt=false", "--workdir=/workdir"}
+	got := appendExtraArgs(args, []string{"--workdir=/data", "--debug"})
+	want := []string{"./buildlet.exe", "--halt=false", "--workdir=/workdir", "--workdir=/data", "--debug"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("appendExtraArgs = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var failureReportURL = flag.String("failure-report-url", "", "if non-empty, URL to POST a JSON report to when bootstrapping fails after the network is up; defaults to the failure-report-url metadata attribute or $META_FAILURE_REPORT_URL")
+
+// failureReportTimeout bounds how long sending a failure report may
+// take, so it can't wedge shutdown.
+const failureReportTimeout = 10 * time.Second
+
+// logTailSize is how much recent log output failure reports include.
+const logTailSize = 32 << 10
+
+// logTail holds the most recent log output, for failure reports.
+var logTail = &tailBuffer{max: logTailSize}
+
+// tailBuffer is an io.Writer that keeps the last max bytes written.
+// Once full, it's used as a ring, so writes cost no more than their
+// own length.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+	off int // index of the oldest byte, once len(buf) == max
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	if n >= b.max {
+		b.buf = append(b.buf[:0], p[n-b.max:]...)
+		b.off = 0
+		return n, nil
+	}
+	if room := b.max - len(b.buf); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		b.buf = append(b.buf, p[:room]...)
+		p = p[room:]
+	}
+	for len(p) > 0 {
+		c := copy(b.buf[b.off:], p)
+		p = p[c:]
+		b.off = (b.off + c) % b.max
+	}
+	return n, nil
+}
+
+// Reset discards the buffered bytes.
+func (b *tailBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = b.buf[:0]
+	b.off = 0
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf[b.off:]) + string(b.buf[:b.off])
+}
+
+// full reports whether the buffer has dropped, or is about to drop,
+// older bytes.
+func (b *tailBuffer) full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.buf) == b.max
+}
+
+// failureReport is the JSON body of a failure report.
+type failureReport struct {
+	Hostname   string `json:"hostname"`
+	BuilderEnv string `json:"builderEnv"`
+	OSArch     string `json:"osArch"`
+	Version    string `json:"version"`
+	Phase      string `json:"phase"`
+	Error      string `json:"error"`
+	Log        string `json:"log"`
+	Output     string `json:"output,omitempty"` // the buildlet's, if it ran
+}
+
+// networkUp is set once the network has come up, after which
+// failures are reported.
+var networkUp bool
+
+// reportFailure makes a best-effort attempt to POST a failure report
+// for err to --failure-report-url or the failure-report-url metadata
+// attribute, if either is set and the network is up. It's called for
+// each failed run of the buildlet and from every fatal exit, via
+// sleepExitf.
+func reportFailure(err error) {
+	if !networkUp {
+		return
+	}
+	u := *failureReportURL
+	if u == "" {
+		u = metaValue("failure-report-url", "META_FAILURE_REPORT_URL")
+	}
+	if u == "" {
+		return
+	}
+	logState.Lock()
+	phase := logState.phase
+	logState.Unlock()
+	hostname, _ := os.Hostname()
+	body, _ := json.Marshal(failureReport{
+		Hostname:   hostname,
+		BuilderEnv: os.Getenv("GO_BUILDER_ENV"),
+		OSArch:     osArch,
+		Version:    stage0Version(),
+		Phase:      phase,
+		Error:      err.Error(),
+		Log:        logTail.String(),
+		Output:     childOutput.String(),
+	})
+	c := &http.Client{
+		Timeout:   failureReportTimeout,
+		Transport: http.DefaultClient.Transport,
+	}
+	res, err := c.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("sending failure report: %v", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		log.Printf("sending failure report to %s: %v", u, res.Status)
+		return
+	}
+	log.Printf("sent failure report to %s", u)
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 10}
+	fmt.Fprintf(b, "0123456")
+	fmt.Fprintf(b, "789abcdef")
+	if got, want := b.String(), "6789abcdef"; got != want {
+		t.Errorf("tail = %q; want %q", got, want)
+	}
+	fmt.Fprintf(b, "ghi")
+	if got, want := b.String(), "9abcdefghi"; got != want {
+		t.Errorf("after wrapping, tail = %q; want %q", got, want)
+	}
+	fmt.Fprintf(b, "0123456789ABC")
+	if got, want := b.String(), "3456789ABC"; got != want {
+		t.Errorf("after long write, tail = %q; want %q", got, want)
+	}
+	b.Reset()
+	fmt.Fprintf(b, "xy")
+	if got, want := b.String(), "xy"; got != want || b.full() {
+		t.Errorf("after Reset, tail = %q, full = %v; want %q, false", got, b.full(), want)
+	}
+}
+
+func TestReportFailure(t *testing.T) {
+	var got failureReport
+	var posts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		if r.Method != "POST" {
+			t.Errorf("method = %s; want POST", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+	defer func(v string) { *failureReportURL = v }(*failureReportURL)
+	*failureReportURL = ts.URL
+	defer func(v bool) { networkUp = v }(networkUp)
+
+	networkUp = false
+	reportFailure(errors.New("too early"))
+	if posts != 0 {
+		t.Fatalf("reported failure before network was up")
+	}
+
+	networkUp = true
+	setPhase("download")
+	defer setPhase("")
+	fmt.Fprintf(logTail, "stage0: downloading buildlet\n")
+	reportFailure(errors.New("downloading: 404 Not Found"))
+	if posts != 1 {
+		t.Fatalf("got %d reports; want 1", posts)
+	}
+	if got.Error != "downloading: 404 Not Found" || got.Phase != "download" || got.OSArch != osArch {
+		t.Errorf("report = %+v", got)
+	}
+	if !strings.Contains(got.Log, "stage0: downloading buildlet") {
+		t.Errorf("report log = %q; want recent log output", got.Log)
+	}
+	if got.Output != "" {
+		t.Errorf("report output = %q; want none before the buildlet ran", got.Output)
+	}
+
+	setPhase("exec")
+	defer childOutput.Reset()
+	fmt.Fprintf(childOutput, "panic: runtime error\n")
+	reportFailure(errors.New("exit status 2"))
+	if got.Output != "panic: runtime error\n" {
+		t.Errorf("report output = %q; want the buildlet's output", got.Output)
+	}
+}
+
+func TestFatalReportsFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleepExitf sleeps for a minute on Windows")
+	}
+	reports := make(chan failureReport, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var got failureReport
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		reports <- got
+	}))
+	defer ts.Close()
+
+	cmd := helperCommand("fatal")
+	cmd.Env = append(cmd.Env, "STAGE0_TEST_REPORT_URL="+ts.URL)
+	err := cmd.Run()
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != 1 {
+		t.Fatalf("helper exited with %v; want exit status 1", err)
+	}
+	select {
+	case got := <-reports:
+		if want := "no buildlet URL for " + osArch; got.Error != want {
+			t.Errorf("report error = %q; want %q", got.Error, want)
+		}
+	default:
+		t.Fatal("fatal exit sent no failure report")
+	}
+}
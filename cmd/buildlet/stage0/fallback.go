@@ -0,0 +1,63 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+)
+
+var fallbackHostFlag = flag.String("buildlet-fallback-host", "farmer.golang.org", `host to download the buildlet from if its usual URL fails, or "off"`)
+
+// fallbackHostAttr is the optional GCE instance attribute overriding
+// the default of --buildlet-fallback-host, for forks of the build
+// system. Off GCE, the META_BUILDLET_FALLBACK_HOST environment
+// variable is used instead.
+const fallbackHostAttr = "buildlet-fallback-host"
+
+// fallbackURL returns the URL to download the buildlet from when its
+// usual URL fails, or the empty string if there's no fallback.
+// An explicit --buildlet-fallback-host wins over metadata.
+func fallbackURL() string {
+	host := *fallbackHostFlag
+	if !flagWasSet("buildlet-fallback-host") {
+		if v := metaValue(fallbackHostAttr, "META_BUILDLET_FALLBACK_HOST"); v != "" {
+			host = v
+		}
+	}
+	host = strings.TrimSuffix(host, "/")
+	if host == "" || host == "off" {
+		return ""
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return fmt.Sprintf("%s/buildlet/%s-%s", host, runtime.GOOS, runtime.GOARCH)
+}
+
+// downloadWithFallback is like downloadResolve, but if every attempt
+// fails, it tries again with the same retry policy from fallbackURL.
+// The check, if any, applies to whichever download succeeds.
+func downloadWithFallback(file string, resolve func() string, check func(file string) error) (url string, err error) {
+	url, err = downloadResolve(file, resolve, check)
+	if err == nil {
+		return url, nil
+	}
+	fb := fallbackURL()
+	if fb == "" || contains(splitURLs(url), fb) {
+		return url, err
+	}
+	log.Printf("*** downloading %s failed: %v ***", url, err)
+	log.Printf("*** falling back to %s ***", fb)
+	furl, ferr := downloadResolve(file, func() string { return fb }, check)
+	if ferr != nil {
+		return url, fmt.Errorf("%v; fallback %s: %v", err, fb, ferr)
+	}
+	log.Printf("downloaded from fallback %s", fb)
+	return furl, nil
+}
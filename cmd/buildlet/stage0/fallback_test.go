@@ -0,0 +1,105 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFallbackURL(t *testing.T) {
+	defer os.Setenv("META_BUILDLET_FALLBACK_HOST", os.Getenv("META_BUILDLET_FALLBACK_HOST"))
+	defer func(v string) { *fallbackHostFlag = v }(*fallbackHostFlag)
+	if os.Getenv("IN_KUBERNETES") != "1" {
+		os.Setenv("IN_KUBERNETES", "1")
+		defer os.Unsetenv("IN_KUBERNETES")
+	}
+	suffix := "/buildlet/" + runtime.GOOS + "-" + runtime.GOARCH
+
+	os.Unsetenv("META_BUILDLET_FALLBACK_HOST")
+	if got, want := fallbackURL(), "https://farmer.golang.org"+suffix; got != want {
+		t.Errorf("default = %q; want %q", got, want)
+	}
+	os.Setenv("META_BUILDLET_FALLBACK_HOST", "farmer.example.com")
+	if got, want := fallbackURL(), "https://farmer.example.com"+suffix; got != want {
+		t.Errorf("from metadata = %q; want %q", got, want)
+	}
+	os.Setenv("META_BUILDLET_FALLBACK_HOST", "http://10.0.0.1:8080/")
+	if got, want := fallbackURL(), "http://10.0.0.1:8080"+suffix; got != want {
+		t.Errorf("with scheme = %q; want %q", got, want)
+	}
+	os.Setenv("META_BUILDLET_FALLBACK_HOST", "off")
+	if got := fallbackURL(); got != "" {
+		t.Errorf("off = %q; want empty", got)
+	}
+}
+
+func TestDownloadWithFallback(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+	defer os.Setenv("META_BUILDLET_FALLBACK_HOST", os.Getenv("META_BUILDLET_FALLBACK_HOST"))
+	if os.Getenv("IN_KUBERNETES") != "1" {
+		os.Setenv("IN_KUBERNETES", "1")
+		defer os.Unsetenv("IN_KUBERNETES")
+	}
+
+	var fallbackHits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/primary" {
+			http.Error(w, "regional outage", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/buildlet/"+runtime.GOOS+"-"+runtime.GOARCH {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "GET" {
+			fallbackHits++
+		}
+		w.Header().Set("Last-Modified", time.Unix(1462292149, 0).UTC().Format(http.TimeFormat))
+		w.Write([]byte("from fallback"))
+	}))
+	defer ts.Close()
+	os.Setenv("META_BUILDLET_FALLBACK_HOST", ts.URL)
+
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	resolve := func() string { return ts.URL + "/primary" }
+	u, err := downloadWithFallback(file, resolve, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fallbackURL(); u != want {
+		t.Errorf("url = %q; want %q", u, want)
+	}
+	if b, _ := ioutil.ReadFile(file); string(b) != "from fallback" {
+		t.Errorf("file = %q; want fallback content", b)
+	}
+
+	// The check applies to the fallback too.
+	removeCache(file)
+	bad := errors.New("bad checksum")
+	_, err = downloadWithFallback(file, resolve, func(string) error { return bad })
+	if err == nil || !strings.Contains(err.Error(), "fallback") || !strings.Contains(err.Error(), "bad checksum") {
+		t.Errorf("with failing check: err = %v; want fallback checksum failure", err)
+	}
+
+	// No fallback when it's off.
+	os.Setenv("META_BUILDLET_FALLBACK_HOST", "off")
+	fallbackHits = 0
+	if _, err := downloadWithFallback(file, resolve, nil); err == nil {
+		t.Error("download succeeded with fallback off")
+	}
+	if fallbackHits != 0 {
+		t.Errorf("fallback fetched %d times with fallback off", fallbackHits)
+	}
+}
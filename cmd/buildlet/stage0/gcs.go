@@ -0,0 +1,144 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/build/internal/httpdl"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsAPIBase is the base URL of the GCS JSON API. It's a variable for
+// tests.
+var gcsAPIBase = "https://storage.googleapis.com/storage/v1"
+
+// gcsTokenSource returns the credentials used for gs:// URLs. It's a
+// variable for tests.
+var gcsTokenSource = func(ctx context.Context) (oauth2.TokenSource, error) {
+	return google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/devstorage.read_only")
+}
+
+// gcsObjectAttrs is the subset of a GCS object resource used by
+// stage0.
+type gcsObjectAttrs struct {
+	Size   string `json:"size"`
+	MD5    string `json:"md5Hash"` // base64; absent for composite objects
+	CRC32C string `json:"crc32c"`  // base64 of big-endian uint32
+}
+
+// parseGCSURL parses a URL of the form gs://bucket/object.
+func parseGCSURL(u string) (bucket, object string, ok bool) {
+	if !strings.HasPrefix(u, "gs://") {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(u, "gs://")
+	i := strings.Index(rest, "/")
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// fetchGCS downloads the GCS object named by the gs:// URL u to file,
+// authenticating with the instance's service account on GCE or
+// GOOGLE_APPLICATION_CREDENTIALS elsewhere, and verifies the object's
+// stored MD5 and CRC32C.
+func fetchGCS(file, u string) error {
+	bucket, object, ok := parseGCSURL(u)
+	if !ok {
+		return fmt.Errorf("malformed GCS URL %q; want gs://bucket/object", u)
+	}
+	ctx := context.Background()
+	ts, err := gcsTokenSource(ctx)
+	if err != nil {
+		return fmt.Errorf("no credentials to fetch %s: on GCE, the instance's default service account is used; elsewhere, set GOOGLE_APPLICATION_CREDENTIALS to a service account key file: %v", u, err)
+	}
+	c := &http.Client{
+		Timeout: http.DefaultClient.Timeout,
+		Transport: &oauth2.Transport{
+			Source: ts,
+			Base:   http.DefaultClient.Transport,
+		},
+	}
+	objURL := gcsAPIBase + "/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(object)
+
+	var attrs gcsObjectAttrs
+	res, err := c.Get(objURL)
+	if err != nil {
+		return err
+	}
+	if err := httpdl.CheckResponse(res); err != nil {
+		res.Body.Close()
+		return fmt.Errorf("fetching attributes of %s: %w", u, err)
+	}
+	err = json.NewDecoder(res.Body).Decode(&attrs)
+	res.Body.Close()
+	if err != nil {
+		return fmt.Errorf("decoding attributes of %s: %v", u, err)
+	}
+
+	res, err = c.Get(objURL + "?alt=media")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := httpdl.CheckResponse(res); err != nil {
+		return fmt.Errorf("fetching %s: %w", u, err)
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	md5h := md5.New()
+	crch := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	_, err = io.Copy(io.MultiWriter(f, md5h, crch), res.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = attrs.verify(md5h.Sum(nil), crch.Sum32())
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("fetching %s: %v", u, err)
+	}
+	log.Printf("verified GCS checksums of %s", u)
+	return os.Rename(tmp, file)
+}
+
+// verify checks the provided MD5 and CRC32C of downloaded object
+// contents against the object's stored hashes.
+func (a *gcsObjectAttrs) verify(gotMD5 []byte, gotCRC uint32) error {
+	if a.MD5 != "" {
+		if got := base64.StdEncoding.EncodeToString(gotMD5); got != a.MD5 {
+			return fmt.Errorf("MD5 mismatch: got %s, want %s", got, a.MD5)
+		}
+	}
+	if a.CRC32C != "" {
+		want, err := base64.StdEncoding.DecodeString(a.CRC32C)
+		if err != nil || len(want) != 4 {
+			return fmt.Errorf("malformed CRC32C %q", a.CRC32C)
+		}
+		if w := binary.BigEndian.Uint32(want); w != gotCRC {
+			return fmt.Errorf("CRC32C mismatch: got %08x, want %08x", gotCRC, w)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestParseGCSURL(t *testing.T) {
+	tests := []struct {
+		in             string
+		bucket, object string
+		ok             bool
+	}{
+		{"gs://go-builder-data/buildlet.linux-amd64", "go-builder-data", "buildlet.linux-amd64", true},
+		{"gs://bucket/dir/obj", "bucket", "dir/obj", true},
+		{"gs://bucket/", "", "", false},
+		{"gs://bucket", "", "", false},
+		{"https://storage.googleapis.com/bucket/obj", "", "", false},
+	}
+	for _, tt := range tests {
+		bucket, object, ok := parseGCSURL(tt.in)
+		if bucket != tt.bucket || object != tt.object || ok != tt.ok {
+			t.Errorf("parseGCSURL(%q) = %q, %q, %v; want %q, %q, %v", tt.in, bucket, object, ok, tt.bucket, tt.object, tt.ok)
+		}
+	}
+}
+
+func TestFetchGCS(t *testing.T) {
+	const content = "private buildlet"
+	md5sum := md5.Sum([]byte(content))
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.Checksum([]byte(content), crc32.MakeTable(crc32.Castagnoli)))
+	attrs := gcsObjectAttrs{
+		Size:   fmt.Sprint(len(content)),
+		MD5:    base64.StdEncoding.EncodeToString(md5sum[:]),
+		CRC32C: base64.StdEncoding.EncodeToString(crc[:]),
+	}
+	body := content
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.EscapedPath() != "/b/bucket/o/dir%2Fbuildlet" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.FormValue("alt") == "media" {
+			fmt.Fprint(w, body)
+			return
+		}
+		json.NewEncoder(w).Encode(attrs)
+	}))
+	defer ts.Close()
+	defer func(old string) { gcsAPIBase = old }(gcsAPIBase)
+	gcsAPIBase = ts.URL
+	defer func(old func(context.Context) (oauth2.TokenSource, error)) { gcsTokenSource = old }(gcsTokenSource)
+	gcsTokenSource = func(context.Context) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"}), nil
+	}
+
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	if err := fetchGCS(file, "gs://bucket/dir/buildlet"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != content {
+		t.Errorf("downloaded %q, %v; want %q", b, err, content)
+	}
+
+	body = "corrupted buildlet"
+	if err := fetchGCS(file, "gs://bucket/dir/buildlet"); err == nil {
+		t.Error("fetch of corrupted object succeeded; want checksum error")
+	}
+}
@@ -0,0 +1,456 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A hostConfig describes how stage0 sets up and runs the buildlet on
+// one kind of host. Its JSON form is used to override the built-in
+// configuration: see hostOverride.
+type hostConfig struct {
+	// URL is the default buildlet URL, used if the environment
+	// and metadata don't give one. See resolveBuildletURL.
+	URL string `json:"url,omitempty"`
+
+	// ReverseType, if non-empty, is the reverse host type the
+	// buildlet dials the coordinator as. (one of the keys of the
+	// x/build/dashboard.Hosts map) See reverseHostTypeArgs.
+	ReverseType string `json:"reverseType,omitempty"`
+
+	// Workdir, if non-empty, is the buildlet's --workdir. It's
+	// expanded with os.ExpandEnv.
+	Workdir string `json:"workdir,omitempty"`
+
+	// Hostname, if non-empty, is the buildlet's --hostname. It's
+	// expanded with os.ExpandEnv; if that's empty, a stable name
+	// is derived. See hostConfig.hostname. If Hostname is empty,
+	// the buildlet uses the machine's (or container's) hostname.
+	Hostname string `json:"hostname,omitempty"`
+
+	// Args are additional buildlet arguments, expanded with
+	// os.ExpandEnv.
+	Args []string `json:"args,omitempty"`
+
+	// Writes are sysctl and sysfs settings made before running
+	// the buildlet, each of the form "key=value". A key starting
+	// with "/" is a file under /sys or /proc/sys, possibly with
+	// glob wildcards; otherwise, it's a sysctl name such as
+	// vm.swappiness. See applySysWrites.
+	Writes []string `json:"writes,omitempty"`
+
+	// Packages are OS packages to install, with the system's
+	// package manager, before running the buildlet. See
+	// installPackages.
+	Packages []string `json:"packages,omitempty"`
+
+	// OnBuildletExit, if non-empty, is what to do when the
+	// buildlet exits successfully: "none", "restart-buildlet",
+	// "reboot", or "halt". The buildlet is given the matching
+	// --halt and --reboot flags, and stage0 enforces it: see
+	// finishMachine and loopEnabled. Failures are handled the same
+	// regardless. If it's empty, the buildlet's flags are left
+	// alone and stage0 exits, unless --loop-on-exit is set.
+	OnBuildletExit string `json:"onBuildletExit,omitempty"`
+
+	// DownloadTimeout, if non-zero, is the default
+	// --download-attempt-timeout for slow hosts. The default
+	// --download-deadline is then long enough for all attempts.
+	DownloadTimeout time.Duration `json:"-"`
+
+	// Init, if non-nil, prepares the host once, before the first
+	// run of the buildlet.
+	Init func() `json:"-"`
+
+	// Adjust, if non-nil, updates the configuration from things
+	// only known at run time, such as the environment or the
+	// host's metadata service. It's given the GO_BUILDER_ENV value.
+	Adjust func(h *hostConfig, env string) `json:"-"`
+
+	// generic is set for configurations from genericHost.
+	generic bool
+}
+
+// hosts is the built-in configuration of each host type stage0 has
+// specific support for, keyed by GO_BUILDER_ENV value or, for hosts
+// identified only by their GOOS and GOARCH, by osArch. See lookupHost.
+//
+// GO_BUILDER_ENV is set by some builders. It's increasingly set by new
+// ones. It predates the buildtype-vs-hosttype split, so the values
+// aren't always host types, but they're often host types. They should
+// probably be host types in the future, or we can introduce
+// GO_BUILD_HOST_TYPE to be explicit and kill off GO_BUILDER_ENV.
+var hosts = map[string]*hostConfig{
+	"linux-arm-arm5spacemonkey": {
+		URL:         "https://storage.googleapis.com/go-builder-data/buildlet.linux-arm-arm5",
+		ReverseType: "host-linux-arm5spacemonkey",
+		Workdir:     "${WORKDIR}",
+	},
+	"host-linux-arm-scaleway": {
+		ReverseType: "host-linux-arm-scaleway",
+		Hostname:    "${HOSTNAME}",
+		Adjust:      adjustScaleway,
+	},
+	"host-linux-arm64-packet": {
+		URL:         "https://storage.googleapis.com/go-builder-data/buildlet.linux-arm64",
+		ReverseType: "host-linux-arm64-packet",
+		Workdir:     "/workdir",
+		Hostname:    "${HOSTNAME}", // if empty, derived
+		Args:        []string{"--reboot=false"},
+		Adjust:      adjustEquinix,
+	},
+	"host-linux-arm64-linaro": {
+		URL:         "https://storage.googleapis.com/go-builder-data/buildlet.linux-arm64",
+		ReverseType: "host-linux-arm64-linaro",
+		Workdir:     "/workdir",
+		Hostname:    "${HOSTNAME}", // if empty, derived
+		Args:        []string{"--reboot=false"},
+	},
+	"linux/amd64": {
+		Adjust: func(h *hostConfig, env string) {
+			// Issue 25760: the s390x cross-compile builder is
+			// working under Kubernetes (which sets
+			// IN_KUBERNETES=1 in the env), but isn't working when
+			// run under Docker in COS (a Container-Optimized OS
+			// VM on GCE). Maybe something is hiding the GCE
+			// metadata service from the COS container now. As a
+			// test, just hard code the s390x builder:
+			if os.Getenv("GOARCH") == "s390x" {
+				h.URL = "https://storage.googleapis.com/go-builder-data/buildlet.linux-amd64"
+			}
+		},
+	},
+	"linux/s390x": {
+		URL:         "https://storage.googleapis.com/go-builder-data/buildlet.linux-s390x",
+		ReverseType: "host-linux-s390x",
+		Workdir:     "/data/golang/workdir",
+	},
+	// Assume OSU (osuosl.org) host types for ppc64 and ppc64le
+	// for now. If we get more, use GO_BUILD_HOST_TYPE, as for
+	// Solaris.
+	"linux/ppc64": {
+		URL:         "https://storage.googleapis.com/go-builder-data/buildlet.linux-ppc64",
+		ReverseType: "host-linux-ppc64-osu",
+		Packages:    []string{"gcc", "strace", "libc6-dev", "gdb"},
+		Init:        initGoBootstrap,
+	},
+	"linux/ppc64le": {
+		URL:         "https://storage.googleapis.com/go-builder-data/buildlet.linux-ppc64le",
+		ReverseType: "host-linux-ppc64le-osu",
+		Packages:    []string{"gcc", "strace", "libc6-dev", "gdb"},
+		Init:        initGoBootstrap,
+	},
+	"linux/riscv64": {
+		URL:         "https://storage.googleapis.com/go-builder-data/buildlet.linux-riscv64",
+		ReverseType: "host-linux-riscv64",
+		Workdir:     "/workdir",
+		Hostname:    "${HOSTNAME}",
+		Packages:    []string{"gcc", "libc6-dev"},
+		Init:        initGoBootstrap,
+		Adjust: func(h *hostConfig, env string) {
+			if strings.HasPrefix(env, "host-linux-riscv64") {
+				// Such as host-linux-riscv64-unmatched.
+				h.ReverseType = env
+			}
+		},
+	},
+	"linux/loong64": {
+		URL:         "https://storage.googleapis.com/go-builder-data/buildlet.linux-loong64",
+		ReverseType: "host-linux-loong64",
+		Workdir:     "/workdir",
+		Hostname:    "${HOSTNAME}",
+		Packages:    []string{"gcc", "libc6-dev"},
+		Init:        initGoBootstrap,
+		Adjust: func(h *hostConfig, env string) {
+			if strings.HasPrefix(env, "host-linux-loong64") {
+				h.ReverseType = env
+			}
+			// Several identical boards register, so
+			// distinguish them by machine ID if they
+			// don't have their own hostnames.
+			if os.Getenv("HOSTNAME") == "" {
+				if id := machineID(); id != "" {
+					h.Hostname = "loong64-" + id
+				}
+			}
+		},
+	},
+	"linux/mips64le": mipsHost("mips64le"),
+	"linux/mips64":   mipsHost("mips64"),
+	"linux/mipsle":   mipsHost("mipsle"),
+	// The BSD arm64 builders get their buildlet URL from the
+	// environment, perhaps via --stage0-env-file. See --install-rcd.
+	// They need no packages installed.
+	"netbsd/arm64":  bsdHost("netbsd", "host-netbsd-arm64-bsiegert"),
+	"openbsd/arm64": bsdHost("openbsd", "host-openbsd-arm64-joelsing"),
+	"solaris/amd64": {
+		URL: "https://storage.googleapis.com/go-builder-data/buildlet.solaris-amd64",
+		// If there's no GO_BUILD_HOST_TYPE or GO_BUILDER_ENV,
+		// assume it's the old Joyent builders, which are
+		// currently GOOS=solaris, but will be illumos after
+		// golang.org/issue/20603.
+		ReverseType: "host-solaris-amd64",
+		Adjust: func(h *hostConfig, env string) {
+			if ht := os.Getenv("GO_BUILD_HOST_TYPE"); ht != "" {
+				// Explicit host type given.
+				h.ReverseType = ht
+			} else if env != "" {
+				// Explicit value given. Treat it like a host type.
+				h.ReverseType = env
+			}
+		},
+	},
+	"darwin/amd64": {
+		URL:    "https://storage.googleapis.com/go-builder-data/buildlet.darwin-amd64",
+		Init:   initMacStadium,
+		Adjust: adjustDarwin,
+	},
+	"darwin/arm64": {
+		URL:    "https://storage.googleapis.com/go-builder-data/buildlet.darwin-arm64",
+		Adjust: adjustDarwin,
+	},
+}
+
+// mipsHost returns the configuration for MIPS reverse builders with
+// the given GOARCH, whose GO_BUILDER_ENV is their host type, such as
+// host-linux-mips64le-rtrk.
+func mipsHost(goarch string) *hostConfig {
+	return &hostConfig{
+		URL:     "https://storage.googleapis.com/go-builder-data/buildlet.linux-" + goarch,
+		Workdir: "/workdir",
+		Args:    []string{"--reboot=false"},
+		// These boards, and often their links, are slow.
+		DownloadTimeout: 30 * time.Minute,
+		Adjust: func(h *hostConfig, env string) {
+			if strings.HasPrefix(env, "host-linux-"+goarch+"-") {
+				h.ReverseType = env
+			}
+		},
+	}
+}
+
+// bsdHost returns the configuration for arm64 reverse builders
+// running goos, of the reverse host type reverseType unless
+// GO_BUILDER_ENV names another arm64 one.
+func bsdHost(goos, reverseType string) *hostConfig {
+	return &hostConfig{
+		ReverseType: reverseType,
+		Adjust: func(h *hostConfig, env string) {
+			if strings.HasPrefix(env, "host-"+goos+"-arm64") {
+				h.ReverseType = env
+			}
+		},
+	}
+}
+
+// builderEnvRequired lists the osArch values whose hosts are only
+// known by their GO_BUILDER_ENV. Without a known value, they get the
+// generic reverse builder configuration from genericHost.
+var builderEnvRequired = map[string]bool{
+	"linux/arm":   true,
+	"linux/arm64": true,
+}
+
+// lookupHost returns the built-in configuration for a host with the
+// given osArch and GO_BUILDER_ENV value, before any adjustment. A
+// host with neither a known GO_BUILDER_ENV value nor its own osArch
+// entry gets a generic configuration: see genericHost.
+func lookupHost(osArch, env string) *hostConfig {
+	if h, ok := hosts[env]; ok {
+		return h
+	}
+	if builderEnvRequired[osArch] {
+		return genericHost(env)
+	}
+	if h, ok := hosts[osArch]; ok {
+		return h
+	}
+	return genericHost("")
+}
+
+// unknownBuilderEnv reports whether env is a GO_BUILDER_ENV value, or
+// the lack of one, that stage0 has no specific setup for on osArch,
+// which needs one. Such hosts get the generic reverse builder setup.
+func unknownBuilderEnv(osArch, env string) bool {
+	return lookupHost(osArch, env).generic && builderEnvRequired[osArch]
+}
+
+// genericHost returns the configuration for a host stage0 has no
+// specific support for: no special initialization, a buildlet URL
+// only from --buildlet-url, the environment, or metadata, and, if env
+// is non-empty, the arguments for the reverse host type named env.
+func genericHost(env string) *hostConfig {
+	return &hostConfig{ReverseType: env, generic: true}
+}
+
+// currentHost returns the configuration of the host stage0 is
+// running on: the built-in one, adjusted, then overridden by the
+// stage0-config attribute and --host-config. Invalid overrides are
+// fatal.
+func currentHost() *hostConfig {
+	h, _ := resolveHost()
+	return h
+}
+
+// resolveHost is like currentHost, but also returns where each field's
+// value came from, keyed by JSON field name.
+func resolveHost() (*hostConfig, map[string]string) {
+	env := os.Getenv("GO_BUILDER_ENV")
+	h := *lookupHost(osArch, env)
+	h.Args = append([]string(nil), h.Args...)
+	h.Writes = append([]string(nil), h.Writes...)
+	h.Packages = append([]string(nil), h.Packages...)
+	src := make(map[string]string)
+	for _, f := range hostFields(&h) {
+		src[f.Name] = "built-in"
+		if h.generic {
+			src[f.Name] = "generic"
+		}
+	}
+	if v := metaValue(onExitAttr, "META_ON_BUILDLET_EXIT"); v != "" {
+		if err := validExitPolicy(v); err != nil {
+			configFatalf("%s metadata: %v", onExitAttr, err)
+		}
+		h.OnBuildletExit = v
+		src["onBuildletExit"] = onExitAttr + " metadata"
+	}
+	if h.Adjust != nil {
+		before := hostFields(&h)
+		h.Adjust(&h, env)
+		for i, f := range hostFields(&h) {
+			if f.Value != before[i].Value {
+				src[f.Name] = "built-in, adjusted at run time"
+			}
+		}
+	}
+	for _, o := range hostOverrides() {
+		o.apply(&h, src)
+	}
+	return &h, src
+}
+
+// applyDownloadTimeout makes h's DownloadTimeout, if any, the
+// default download timeouts.
+func (h *hostConfig) applyDownloadTimeout() {
+	if h.DownloadTimeout == 0 {
+		return
+	}
+	if !flagWasSet("download-attempt-timeout") && *attemptTimeout < h.DownloadTimeout {
+		*attemptTimeout = h.DownloadTimeout
+	}
+	if d := h.DownloadTimeout * time.Duration(*downloadRetries); !flagWasSet("download-deadline") && *downloadDeadline < d {
+		*downloadDeadline = d
+	}
+	log.Printf("slow host: download attempt timeout %v, deadline %v", *attemptTimeout, *downloadDeadline)
+}
+
+// args returns the buildlet arguments for h.
+func (h *hostConfig) args() []string {
+	var args []string
+	if h.ReverseType != "" {
+		args = append(args, reverseHostTypeArgs(h.ReverseType)...)
+	}
+	if h.Workdir != "" {
+		args = append(args, "--workdir="+os.ExpandEnv(h.Workdir))
+	}
+	if hn := h.hostname(); hn != "" {
+		args = append(args, "--hostname="+hn)
+	}
+	for _, a := range h.Args {
+		args = append(args, os.ExpandEnv(a))
+	}
+	return setExitPolicyArgs(args, h.OnBuildletExit)
+}
+
+// adjustEquinix applies the Equinix Metal (formerly Packet) device
+// metadata, if any, to h.
+func adjustEquinix(h *hostConfig, env string) {
+	if v := os.Getenv("META_BUILDLET_BINARY_URL"); v != "" {
+		h.URL = expandBuildletURL(v)
+	} else if v := equinixValue(attr); v != "" {
+		h.URL = expandBuildletURL(v)
+	}
+	md := equinixMeta()
+	if md == nil {
+		return
+	}
+	if os.Getenv("HOSTNAME") == "" && md.Hostname != "" {
+		h.Hostname = md.Hostname
+	}
+	if v := md.CustomData["reverse-type"]; v != "" {
+		h.ReverseType = v
+	}
+	h.Args = append(h.Args[:len(h.Args):len(h.Args)], md.hostTags()...)
+}
+
+// machineIDFile is the systemd machine ID file. It's a variable for
+// tests.
+var machineIDFile = "/etc/machine-id"
+
+// machineID returns the first 12 characters of the machine's ID, from
+// machineIDFile, or the empty string if it has none.
+func machineID() string {
+	b, err := ioutil.ReadFile(machineIDFile)
+	if err != nil {
+		return ""
+	}
+	id := strings.TrimSpace(string(b))
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
+
+// knownOSArches returns the GOOS/GOARCH pairs stage0 has built-in
+// configuration for, sorted.
+func knownOSArches() []string {
+	var list []string
+	for k := range hosts {
+		if strings.Contains(k, "/") {
+			list = append(list, k)
+		}
+	}
+	for k := range builderEnvRequired {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// adjustDarwin configures Mac reverse builders, such as Mac minis run
+// by launchd (see --install-launchd), whose GO_BUILDER_ENV is their
+// host type. There's no metadata service for Macs, so their
+// configuration comes from the environment, perhaps via
+// --stage0-env-file.
+func adjustDarwin(h *hostConfig, env string) {
+	if strings.HasPrefix(env, "host-darwin-") {
+		h.ReverseType = env
+	}
+}
+
+// macStadiumVM is whether this is a MacStadium VM. See initMacStadium.
+var macStadiumVM bool
+
+// initMacStadium detects whether this is a MacStadium VM, as opposed
+// to a Mac reverse builder.
+func initMacStadium() {
+	if strings.HasPrefix(os.Getenv("GO_BUILDER_ENV"), "host-darwin-") {
+		return
+	}
+	// The MacStadium builders' baked-in stage0.sh
+	// bootstrap file doesn't set GO_BUILDER_ENV
+	// unfortunately, so use the filename it runs its
+	// downloaded bootstrap URL to determine whether we're
+	// in that environment.
+	macStadiumVM = len(os.Args) > 0 && strings.HasSuffix(os.Args[0], "run-builder")
+	log.Printf("isMacStadiumVM = %v", macStadiumVM)
+	os.Setenv("GO_BUILDER_ENV", "macstadium_vm")
+}
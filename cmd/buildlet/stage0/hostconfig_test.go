@@ -0,0 +1,347 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostArgs(t *testing.T) {
+	for _, k := range []string{"HOSTNAME", "WORKDIR", "GO_BUILDER_ENV", "GO_BUILD_HOST_TYPE", "META_BUILDLET_BINARY_URL", "GOARCH"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Unsetenv(k)
+	}
+	os.Setenv("HOSTNAME", "box1")
+	os.Setenv("WORKDIR", "/var/buildlet")
+
+	rev := func(hostType string, more ...string) string {
+		return strings.Join(append(reverseHostTypeArgs(hostType), more...), " ")
+	}
+	want := map[string]string{
+		"linux-arm-arm5spacemonkey": rev("host-linux-arm5spacemonkey", "--workdir=/var/buildlet"),
+		"host-linux-arm-scaleway":   rev("host-linux-arm-scaleway", "--hostname=box1"),
+		"host-linux-arm64-packet":   rev("host-linux-arm64-packet", "--workdir=/workdir", "--hostname=box1", "--reboot=false"),
+		"host-linux-arm64-linaro":   rev("host-linux-arm64-linaro", "--workdir=/workdir", "--hostname=box1", "--reboot=false"),
+		"linux/amd64":               "",
+		"linux/s390x":               rev("host-linux-s390x", "--workdir=/data/golang/workdir"),
+		"linux/ppc64":               rev("host-linux-ppc64-osu"),
+		"linux/ppc64le":             rev("host-linux-ppc64le-osu"),
+		"linux/loong64":             rev("host-linux-loong64", "--workdir=/workdir", "--hostname=box1"),
+		"linux/mips64le":            "--workdir=/workdir --reboot=false",
+		"linux/mips64":              "--workdir=/workdir --reboot=false",
+		"linux/mipsle":              "--workdir=/workdir --reboot=false",
+		"linux/riscv64":             rev("host-linux-riscv64", "--workdir=/workdir", "--hostname=box1"),
+		"netbsd/arm64":              rev("host-netbsd-arm64-bsiegert"),
+		"openbsd/arm64":             rev("host-openbsd-arm64-joelsing"),
+		"solaris/amd64":             rev("host-solaris-amd64"),
+		"darwin/amd64":              "",
+		"darwin/arm64":              "",
+	}
+	for name, h := range hosts {
+		w, ok := want[name]
+		if !ok {
+			t.Errorf("no test for host %q", name)
+			continue
+		}
+		c := *h
+		if c.Adjust != nil {
+			env := name
+			if strings.Contains(name, "/") {
+				env = ""
+			}
+			c.Adjust(&c, env)
+		}
+		if got := strings.Join(c.args(), " "); got != w {
+			t.Errorf("host %q args:\n got: %s\nwant: %s", name, got, w)
+		}
+	}
+}
+
+func TestHostAdjust(t *testing.T) {
+	for _, k := range []string{"GO_BUILD_HOST_TYPE", "META_BUILDLET_BINARY_URL", "GOARCH", "IN_KUBERNETES"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Unsetenv(k)
+	}
+	rev := func(hostType string, more ...string) string {
+		return strings.Join(append(reverseHostTypeArgs(hostType), more...), " ")
+	}
+	adjusted := func(name, env string) hostConfig {
+		c := *hosts[name]
+		c.Adjust(&c, env)
+		return c
+	}
+
+	if c := adjusted("solaris/amd64", "host-illumos-amd64-jclulow"); c.ReverseType != "host-illumos-amd64-jclulow" {
+		t.Errorf("solaris with GO_BUILDER_ENV: reverse type %q", c.ReverseType)
+	}
+	os.Setenv("GO_BUILD_HOST_TYPE", "host-solaris-oracle-amd64-oraclerel")
+	if c := adjusted("solaris/amd64", "host-illumos-amd64-jclulow"); c.ReverseType != "host-solaris-oracle-amd64-oraclerel" {
+		t.Errorf("solaris with GO_BUILD_HOST_TYPE: reverse type %q", c.ReverseType)
+	}
+
+	c := adjusted("darwin/arm64", "host-darwin-arm64-12")
+	if got := strings.Join(c.args(), " "); got != strings.Join(reverseHostTypeArgs("host-darwin-arm64-12"), " ") {
+		t.Errorf("Mac reverse builder args = %s", got)
+	}
+	if c.URL != "https://storage.googleapis.com/go-builder-data/buildlet.darwin-arm64" {
+		t.Errorf("Mac reverse builder URL = %q", c.URL)
+	}
+
+	c = adjusted("linux/mips64le", "host-linux-mips64le-rtrk")
+	if got, want := strings.Join(c.args(), " "), rev("host-linux-mips64le-rtrk", "--workdir=/workdir", "--reboot=false"); got != want {
+		t.Errorf("mips64le args:\n got: %s\nwant: %s", got, want)
+	}
+	if !strings.HasSuffix(c.URL, "/buildlet.linux-mips64le") {
+		t.Errorf("mips64le URL = %q", c.URL)
+	}
+	if c := adjusted("openbsd/arm64", "host-openbsd-arm64-other"); c.ReverseType != "host-openbsd-arm64-other" || c.URL != "" {
+		t.Errorf("openbsd/arm64 = %+v; want GO_BUILDER_ENV reverse type and no URL", c)
+	}
+	if c := adjusted("netbsd/arm64", "host-linux-arm64-packet"); c.ReverseType != "host-netbsd-arm64-bsiegert" {
+		t.Errorf("netbsd/arm64 took reverse type %q", c.ReverseType)
+	}
+	if c := adjusted("linux/mipsle", "host-linux-mips64le-rtrk"); c.ReverseType != "" {
+		t.Errorf("mipsle took mips64le reverse type %q", c.ReverseType)
+	}
+	if c := adjusted("linux/riscv64", "host-linux-riscv64-unmatched"); c.ReverseType != "host-linux-riscv64-unmatched" || !strings.HasSuffix(c.URL, "/buildlet.linux-riscv64") {
+		t.Errorf("riscv64 = %+v; want GO_BUILDER_ENV reverse type and default URL", c)
+	}
+
+	if c := adjusted("linux/amd64", ""); c.URL != "" {
+		t.Errorf("linux/amd64 URL = %q; want none", c.URL)
+	}
+	os.Setenv("GOARCH", "s390x")
+	if c := adjusted("linux/amd64", ""); !strings.HasSuffix(c.URL, "/buildlet.linux-amd64") {
+		t.Errorf("s390x cross-compile builder URL = %q", c.URL)
+	}
+}
+
+func TestLookupHost(t *testing.T) {
+	tests := []struct {
+		osArch, env string
+		want        string // key in hosts, or "" for generic
+		reverseType string // for generic
+	}{
+		{"linux/arm", "linux-arm-arm5spacemonkey", "linux-arm-arm5spacemonkey", ""},
+		{"linux/arm", "host-linux-arm-scaleway", "host-linux-arm-scaleway", ""},
+		{"linux/arm", "host-linux-arm-aws", "", "host-linux-arm-aws"},
+		{"linux/arm", "", "", ""},
+		{"linux/arm64", "host-linux-arm64-packet", "host-linux-arm64-packet", ""},
+		{"linux/arm64", "host-linux-arm64-newcloud", "", "host-linux-arm64-newcloud"},
+		{"linux/ppc64le", "", "linux/ppc64le", ""},
+		{"solaris/amd64", "host-illumos-amd64-jclulow", "solaris/amd64", ""},
+		{"darwin/arm64", "host-darwin-arm64-12", "darwin/arm64", ""},
+		{"windows/amd64", "", "", ""},
+	}
+	for _, tt := range tests {
+		h := lookupHost(tt.osArch, tt.env)
+		if tt.want != "" {
+			if h != hosts[tt.want] {
+				t.Errorf("lookupHost(%q, %q) = %+v; want hosts[%q]", tt.osArch, tt.env, h, tt.want)
+			}
+			continue
+		}
+		if !h.generic || h.ReverseType != tt.reverseType {
+			t.Errorf("lookupHost(%q, %q) = %+v; want generic with reverse type %q", tt.osArch, tt.env, h, tt.reverseType)
+		}
+	}
+}
+
+func TestUnknownBuilderEnv(t *testing.T) {
+	tests := []struct {
+		osArch, env string
+		want        bool
+	}{
+		{"linux/arm", "linux-arm-arm5spacemonkey", false},
+		{"linux/arm", "host-linux-arm-scaleway", false},
+		{"linux/arm", "host-linux-arm-aws", true},
+		{"linux/arm", "", true},
+		{"linux/arm64", "host-linux-arm64-packet", false},
+		{"linux/arm64", "host-linux-arm64-linaro", false},
+		{"linux/arm64", "host-linux-arm64-newcloud", true},
+		{"linux/arm64", "", true},
+		{"linux/amd64", "whatever", false},
+		{"darwin/arm64", "", false},
+	}
+	for _, tt := range tests {
+		if got := unknownBuilderEnv(tt.osArch, tt.env); got != tt.want {
+			t.Errorf("unknownBuilderEnv(%q, %q) = %v; want %v", tt.osArch, tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestGenericHostArgs(t *testing.T) {
+	got := genericHost("host-linux-arm64-newcloud").args()
+	want := []string{
+		"--halt=false",
+		"--reverse-type=host-linux-arm64-newcloud",
+		"--coordinator=farmer.golang.org:443",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("generic host args = %q; want %q", got, want)
+	}
+	if got := genericHost("").args(); got != nil {
+		t.Errorf("generic host args without GO_BUILDER_ENV = %q; want none", got)
+	}
+	if h := genericHost(""); h.URL != "" || h.Init != nil {
+		t.Errorf("generic host = %+v; want no URL or Init", h)
+	}
+}
+
+func TestLoong64Hostname(t *testing.T) {
+	defer os.Setenv("HOSTNAME", os.Getenv("HOSTNAME"))
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(file, []byte("0123456789abcdef0123456789abcdef\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(v string) { machineIDFile = v }(machineIDFile)
+	machineIDFile = file
+
+	adjusted := func() string {
+		c := *hosts["linux/loong64"]
+		c.Adjust(&c, "host-linux-loong64")
+		return strings.Join(c.args(), " ")
+	}
+	os.Setenv("HOSTNAME", "loongson-7")
+	if got := adjusted(); !strings.Contains(got, "--hostname=loongson-7") {
+		t.Errorf("with $HOSTNAME, args = %s", got)
+	}
+	os.Unsetenv("HOSTNAME")
+	if got := adjusted(); !strings.Contains(got, "--hostname=loong64-0123456789ab") {
+		t.Errorf("without $HOSTNAME, args = %s; want hostname from machine ID", got)
+	}
+	machineIDFile = file + ".missing"
+	defer func(f func() (string, error)) { osHostname = f }(osHostname)
+	osHostname = func() (string, error) { return "loongson-box", nil }
+	if got := adjusted(); !strings.Contains(got, "--hostname=loongson-box") {
+		t.Errorf("without $HOSTNAME or machine ID, args = %s; want derived hostname", got)
+	}
+}
+
+func TestKnownOSArches(t *testing.T) {
+	got := strings.Join(knownOSArches(), ",")
+	for _, want := range []string{"linux/arm64", "linux/loong64", "linux/riscv64", "darwin/arm64"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("knownOSArches = %s; missing %s", got, want)
+		}
+	}
+}
+
+func TestApplyDownloadTimeout(t *testing.T) {
+	defer func(a, d time.Duration, r int) {
+		*attemptTimeout, *downloadDeadline, *downloadRetries = a, d, r
+	}(*attemptTimeout, *downloadDeadline, *downloadRetries)
+	*attemptTimeout, *downloadDeadline, *downloadRetries = 5*time.Minute, 10*time.Minute, 3
+
+	(&hostConfig{}).applyDownloadTimeout()
+	if *attemptTimeout != 5*time.Minute || *downloadDeadline != 10*time.Minute {
+		t.Errorf("without DownloadTimeout: %v, %v; want defaults", *attemptTimeout, *downloadDeadline)
+	}
+	hosts["linux/mips64le"].applyDownloadTimeout()
+	if *attemptTimeout != 30*time.Minute || *downloadDeadline != 90*time.Minute {
+		t.Errorf("mips64le: attempt timeout %v, deadline %v; want 30m, 1h30m", *attemptTimeout, *downloadDeadline)
+	}
+}
+
+// fakeProvider is a metadataProvider with fixed values.
+type fakeProvider map[string]string
+
+func (fakeProvider) Name() string            { return "fake" }
+func (fakeProvider) Detect() bool            { return true }
+func (p fakeProvider) Value(k string) string { return p[k] }
+
+func TestResolveBuildletURL(t *testing.T) {
+	for _, k := range []string{"META_BUILDLET_BINARY_URL", "IN_KUBERNETES"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("IN_KUBERNETES", "1") // not GCE; use the environment
+	os.Unsetenv("META_BUILDLET_BINARY_URL")
+	defer func(v string) { *buildletURLFlag = v }(*buildletURLFlag)
+	*buildletURLFlag = ""
+	meta := fakeProvider{}
+	defer func(v []metadataProvider) {
+		metadataProviders = v
+		detected.once = sync.Once{}
+		detected.p = nil
+	}(metadataProviders)
+	metadataProviders = []metadataProvider{meta}
+	detected.once = sync.Once{}
+
+	h := *hosts["linux/s390x"]
+	src := map[string]string{"url": "built-in"}
+	check := func(what, wantURL, wantSrc string) {
+		t.Helper()
+		if u, s := resolveBuildletURL(&h, src); u != wantURL || s != wantSrc {
+			t.Errorf("%s: got %q from %q; want %q from %q", what, u, s, wantURL, wantSrc)
+		}
+	}
+
+	check("no configuration", hosts["linux/s390x"].URL, "hardcoded default")
+	meta[attr] = "https://example.com/meta"
+	check("metadata", "https://example.com/meta", "fake metadata")
+	os.Setenv("META_BUILDLET_BINARY_URL", "https://example.com/env.$GOARCH")
+	check("env", "https://example.com/env."+runtime.GOARCH, "env")
+	h.URL, src["url"] = "https://example.com/override", "--host-config=x.json"
+	check("host config override", "https://example.com/override", "--host-config=x.json")
+	*buildletURLFlag = "https://example.com/flag"
+	check("flag", "https://example.com/flag", "--buildlet-url")
+
+	*buildletURLFlag = ""
+	os.Unsetenv("META_BUILDLET_BINARY_URL")
+	delete(meta, attr)
+	h = *genericHost("")
+	check("generic host", "", "")
+}
+
+func TestHostPackages(t *testing.T) {
+	defer func(f func(string) (string, error)) { lookPath = f }(lookPath)
+	installed := "apt-get"
+	lookPath = func(name string) (string, error) {
+		if name == installed {
+			return "/usr/bin/" + name, nil
+		}
+		return "", errors.New("not found")
+	}
+	tests := []struct {
+		host, manager, want string
+	}{
+		{"linux/ppc64", "apt-get", "/usr/bin/apt-get --yes --quiet install gcc strace libc6-dev gdb"},
+		{"linux/ppc64le", "apt-get", "/usr/bin/apt-get --yes --quiet install gcc strace libc6-dev gdb"},
+		{"linux/ppc64le", "dnf", "/usr/bin/dnf --assumeyes install gcc strace libc6-dev gdb"},
+		{"linux/riscv64", "apt-get", "/usr/bin/apt-get --yes --quiet install gcc libc6-dev"},
+		{"linux/loong64", "apt-get", "/usr/bin/apt-get --yes --quiet install gcc libc6-dev"},
+	}
+	for _, tt := range tests {
+		installed = tt.manager
+		_, argv, err := installCommand(hosts[tt.host].Packages)
+		if err != nil {
+			t.Errorf("%s with %s: %v", tt.host, tt.manager, err)
+			continue
+		}
+		if got := strings.Join(argv, " "); got != tt.want {
+			t.Errorf("%s with %s installs with:\n got: %s\nwant: %s", tt.host, tt.manager, got, tt.want)
+		}
+	}
+
+	// An override's empty list means to install nothing.
+	o, err := parseHostOverride([]byte(`{"packages": []}`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := *hosts["linux/ppc64"]
+	o.apply(&h, map[string]string{})
+	if len(h.Packages) != 0 {
+		t.Errorf("after empty override, packages = %q", h.Packages)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+var hostnameFlag = flag.String("hostname", "", "if non-empty, the --hostname to pass to the buildlet, overriding the host configuration and any derived name")
+
+// osHostname and primaryMAC are os.Hostname and interfaceMAC, except
+// in tests.
+var (
+	osHostname = os.Hostname
+	primaryMAC = interfaceMAC
+)
+
+// hostname returns the buildlet's --hostname for h, or the empty
+// string to let the buildlet use the machine's. If h's Hostname
+// expands to the empty string, such as when $HOSTNAME is unset, a
+// stable name is derived instead: see derivedHostname.
+func (h *hostConfig) hostname() string {
+	if *hostnameFlag != "" {
+		return *hostnameFlag
+	}
+	if h.Hostname == "" {
+		return ""
+	}
+	if v := os.ExpandEnv(h.Hostname); v != "" {
+		return v
+	}
+	name, src := derivedHostname(os.Getenv("GO_BUILDER_ENV"))
+	logHostname.Do(func() {
+		if name == "" {
+			log.Printf("no hostname for the buildlet: no $HOSTNAME, hostname, machine ID, or MAC address")
+			return
+		}
+		log.Printf("using buildlet hostname %q, from the %s", name, src)
+	})
+	return name
+}
+
+// logHostname is used to log the derived hostname only once.
+var logHostname sync.Once
+
+// derivedHostname returns a stable name for the machine, with the
+// prefix env, if any, and a description of where it came from. It
+// uses the first of the machine's hostname, unless that's just a
+// container ID, its machine ID, and its primary network interface's
+// MAC address.
+func derivedHostname(env string) (name, source string) {
+	id, source := "", ""
+	if hn, err := osHostname(); err == nil && hn != "" && hn != "localhost" && !(isHex(hn) && inContainer() != "") {
+		id, source = hn, "machine's hostname"
+	} else if mid := machineID(); mid != "" {
+		id, source = mid, "machine ID"
+	} else if mac := primaryMAC(); mac != "" {
+		id, source = mac, "MAC address"
+	}
+	if id == "" {
+		return "", ""
+	}
+	if env != "" && !strings.HasPrefix(id, env) {
+		id = env + "-" + id
+	}
+	return id, source
+}
+
+// interfaceMAC returns the hex MAC address, without separators, of
+// the first network interface that's up and isn't a loopback, or the
+// empty string if there's none.
+func interfaceMAC() string {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, i := range ifs {
+		if i.Flags&net.FlagUp == 0 || i.Flags&net.FlagLoopback != 0 || len(i.HardwareAddr) == 0 {
+			continue
+		}
+		return strings.Replace(i.HardwareAddr.String(), ":", "", -1)
+	}
+	return ""
+}
+
+// isHex reports whether s is entirely lowercase hex digits, as Docker's
+// container IDs, which it uses as hostnames, are.
+func isHex(s string) bool {
+	for _, r := range s {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
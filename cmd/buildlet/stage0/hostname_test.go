@@ -0,0 +1,71 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDerivedHostname(t *testing.T) {
+	defer func(f func() (string, error)) { osHostname = f }(osHostname)
+	defer func(f func() string) { primaryMAC = f }(primaryMAC)
+	defer func(f func() string) { inContainer = f }(inContainer)
+	defer func(v string) { machineIDFile = v }(machineIDFile)
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(file, []byte("3af1c2d4e5f60718293a4b5c6d7e8f90\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	machineIDFile = file
+	primaryMAC = func() string { return "0242ac110002" }
+	inContainer = func() string { return "" }
+
+	check := func(what, env, wantName, wantSrc string) {
+		t.Helper()
+		if name, src := derivedHostname(env); name != wantName || src != wantSrc {
+			t.Errorf("%s: derivedHostname(%q) = %q, %q; want %q, %q", what, env, name, src, wantName, wantSrc)
+		}
+	}
+
+	osHostname = func() (string, error) { return "arm64-box3", nil }
+	check("hostname", "host-linux-arm64-packet", "host-linux-arm64-packet-arm64-box3", "machine's hostname")
+	check("no env", "", "arm64-box3", "machine's hostname")
+
+	osHostname = func() (string, error) { return "localhost", nil }
+	check("localhost", "host-linux-arm64-packet", "host-linux-arm64-packet-3af1c2d4e5f6", "machine ID")
+
+	osHostname = func() (string, error) { return "d3adb33fcafe", nil }
+	check("hex hostname outside a container", "", "d3adb33fcafe", "machine's hostname")
+	inContainer = func() string { return "test" }
+	check("container ID", "", "3af1c2d4e5f6", "machine ID")
+
+	osHostname = func() (string, error) { return "", errors.New("no hostname") }
+	machineIDFile = file + ".missing"
+	check("MAC address", "host-linux-arm64-linaro", "host-linux-arm64-linaro-0242ac110002", "MAC address")
+
+	primaryMAC = func() string { return "" }
+	check("nothing", "host-linux-arm64-linaro", "", "")
+}
+
+func TestHostnameFlag(t *testing.T) {
+	defer os.Setenv("HOSTNAME", os.Getenv("HOSTNAME"))
+	defer func(v string) { *hostnameFlag = v }(*hostnameFlag)
+	os.Setenv("HOSTNAME", "box1")
+
+	h := &hostConfig{Hostname: "${HOSTNAME}"}
+	if got := h.hostname(); got != "box1" {
+		t.Errorf("hostname = %q; want $HOSTNAME", got)
+	}
+	*hostnameFlag = "manual"
+	if got := h.hostname(); got != "manual" {
+		t.Errorf("with --hostname, hostname = %q; want manual", got)
+	}
+	if got := (&hostConfig{}).hostname(); got != "manual" {
+		t.Errorf("with --hostname and no configured hostname, hostname = %q; want manual", got)
+	}
+}
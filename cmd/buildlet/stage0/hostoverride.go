@@ -0,0 +1,259 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var hostConfigFile = flag.String("host-config", "", "if non-empty, a JSON file of host configuration fields (url, reverseType, workdir, hostname, args, writes, packages, onBuildletExit) overriding the built-in configuration for this host and the stage0-config metadata attribute")
+
+// hostConfigAttr is the optional GCE instance attribute with JSON
+// host configuration fields, as for --host-config. Off GCE, the
+// META_STAGE0_CONFIG environment variable is used instead.
+const hostConfigAttr = "stage0-config"
+
+// A hostOverride is the JSON form of a hostConfig, from --host-config
+// or the stage0-config attribute. Each field that's present replaces
+// the corresponding field of the built-in configuration.
+type hostOverride struct {
+	URL         *string   `json:"url"`
+	ReverseType *string   `json:"reverseType"`
+	Workdir     *string   `json:"workdir"`
+	Hostname    *string   `json:"hostname"`
+	Args        *[]string `json:"args"`
+	Writes      *[]string `json:"writes"`
+	Packages    *[]string `json:"packages"`
+
+	OnBuildletExit *string `json:"onBuildletExit"`
+
+	source string // for errors and dry runs
+}
+
+// A hostField is the value of one of a hostConfig's overridable
+// fields, and where it came from.
+type hostField struct {
+	Name   string `json:"name"` // JSON field name
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// hostFields returns h's overridable fields, in order, without their
+// sources.
+func hostFields(h *hostConfig) []hostField {
+	return []hostField{
+		{Name: "url", Value: h.URL},
+		{Name: "reverseType", Value: h.ReverseType},
+		{Name: "workdir", Value: h.Workdir},
+		{Name: "hostname", Value: h.Hostname},
+		{Name: "args", Value: strings.Join(h.Args, " ")},
+		{Name: "writes", Value: strings.Join(h.Writes, " ")},
+		{Name: "packages", Value: strings.Join(h.Packages, " ")},
+		{Name: "onBuildletExit", Value: h.OnBuildletExit},
+	}
+}
+
+// parseHostOverride parses data, from source, as a hostOverride and
+// validates it.
+func parseHostOverride(data []byte, source string) (*hostOverride, error) {
+	o := &hostOverride{source: source}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(o); err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	if err := o.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	return o, nil
+}
+
+// validate checks the fields o sets.
+func (o *hostOverride) validate() error {
+	var err error
+	field := func(name string, fieldErr error) {
+		if err == nil && fieldErr != nil {
+			err = fmt.Errorf("field %q: %v", name, fieldErr)
+		}
+	}
+	if o.URL != nil {
+		field("url", validHostURL(*o.URL))
+	}
+	if o.ReverseType != nil {
+		field("reverseType", validReverseType(*o.ReverseType))
+	}
+	if o.Workdir != nil {
+		field("workdir", validHostWorkdir(*o.Workdir))
+	}
+	if o.Hostname != nil {
+		field("hostname", validHostname(*o.Hostname))
+	}
+	if o.Args != nil {
+		field("args", validHostArgs(*o.Args))
+	}
+	if o.Writes != nil {
+		field("writes", validHostWrites(*o.Writes))
+	}
+	if o.Packages != nil {
+		field("packages", validHostPackages(*o.Packages))
+	}
+	if o.OnBuildletExit != nil {
+		field("onBuildletExit", validExitPolicy(*o.OnBuildletExit))
+	}
+	return err
+}
+
+// apply overrides h's fields with those o sets, recording o's source
+// for them in src.
+func (o *hostOverride) apply(h *hostConfig, src map[string]string) {
+	if o.URL != nil {
+		h.URL = *o.URL
+		src["url"] = o.source
+	}
+	if o.ReverseType != nil {
+		h.ReverseType = *o.ReverseType
+		src["reverseType"] = o.source
+	}
+	if o.Workdir != nil {
+		h.Workdir = *o.Workdir
+		src["workdir"] = o.source
+	}
+	if o.Hostname != nil {
+		h.Hostname = *o.Hostname
+		src["hostname"] = o.source
+	}
+	if o.Args != nil {
+		h.Args = append([]string(nil), *o.Args...)
+		src["args"] = o.source
+	}
+	if o.Writes != nil {
+		h.Writes = append([]string(nil), *o.Writes...)
+		src["writes"] = o.source
+	}
+	if o.Packages != nil {
+		h.Packages = append([]string(nil), *o.Packages...)
+		src["packages"] = o.source
+	}
+	if o.OnBuildletExit != nil {
+		h.OnBuildletExit = *o.OnBuildletExit
+		src["onBuildletExit"] = o.source
+	}
+}
+
+// hostOverrides returns the stage0-config attribute's and then
+// --host-config's overrides, if any, so the file's settings win.
+// Invalid configuration is fatal.
+func hostOverrides() []*hostOverride {
+	var list []*hostOverride
+	if v := metaValue(hostConfigAttr, "META_STAGE0_CONFIG"); v != "" {
+		o, err := parseHostOverride([]byte(v), hostConfigAttr+" metadata")
+		if err != nil {
+			configFatalf("%v", err)
+		}
+		list = append(list, o)
+	}
+	if *hostConfigFile != "" {
+		o, err := readHostOverride(*hostConfigFile)
+		if err != nil {
+			configFatalf("%v", err)
+		}
+		list = append(list, o)
+	}
+	return list
+}
+
+// readHostOverride reads and parses the --host-config file at file.
+func readHostOverride(file string) (*hostOverride, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("--host-config: %v", err)
+	}
+	return parseHostOverride(data, "--host-config="+file)
+}
+
+func validHostURL(v string) error {
+	urls := splitURLs(v)
+	if len(urls) == 0 {
+		return errors.New("empty URL")
+	}
+	for _, u := range urls {
+		x, err := expandURL(u)
+		if err != nil {
+			return err
+		}
+		pu, err := url.Parse(x)
+		if err != nil {
+			return err
+		}
+		if pu.Scheme != "https" && pu.Scheme != "http" || pu.Host == "" {
+			return fmt.Errorf("%q isn't an http or https URL", u)
+		}
+	}
+	return nil
+}
+
+func validReverseType(v string) error {
+	for _, r := range v {
+		if !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("%q isn't a host type; want lowercase letters, digits, and -_.", v)
+		}
+	}
+	return nil
+}
+
+func validHostWorkdir(dir string) error {
+	if dir == "" || strings.Contains(dir, "$") {
+		// Empty means none; variables are checked once
+		// expanded, by prepareWorkdir.
+		return nil
+	}
+	if !filepath.IsAbs(dir) && !path.IsAbs(dir) {
+		return fmt.Errorf("%q isn't an absolute path", dir)
+	}
+	return nil
+}
+
+func validHostname(v string) error {
+	if strings.ContainsAny(v, " \t\r\n/") {
+		return fmt.Errorf("%q isn't a hostname", v)
+	}
+	return nil
+}
+
+func validHostArgs(args []string) error {
+	for i, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			return fmt.Errorf("args[%d] = %q isn't a flag", i, a)
+		}
+	}
+	return nil
+}
+
+func validHostWrites(writes []string) error {
+	for i, w := range writes {
+		if _, _, err := parseSysWrite(w); err != nil {
+			return fmt.Errorf("writes[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func validHostPackages(pkgs []string) error {
+	for i, p := range pkgs {
+		if p == "" || strings.HasPrefix(p, "-") || strings.ContainsAny(p, " \t\r\n") {
+			return fmt.Errorf("packages[%d] = %q isn't a package name", i, p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHostOverride(t *testing.T) {
+	o, err := parseHostOverride([]byte(`{"workdir": "/scratch/workdir", "args": ["--reboot=false"]}`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hostConfig{
+		URL:         "https://example.com/buildlet",
+		ReverseType: "host-linux-arm64-packet",
+		Workdir:     "/workdir",
+		Args:        []string{"--halt=true"},
+	}
+	src := map[string]string{}
+	o.apply(&h, src)
+	want := hostConfig{
+		URL:         "https://example.com/buildlet",
+		ReverseType: "host-linux-arm64-packet",
+		Workdir:     "/scratch/workdir",
+		Args:        []string{"--reboot=false"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("after override: %+v; want %+v", h, want)
+	}
+	if want := map[string]string{"workdir": "test", "args": "test"}; !reflect.DeepEqual(src, want) {
+		t.Errorf("sources = %v; want %v", src, want)
+	}
+}
+
+func TestParseHostOverrideErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`{"wrokdir": "/w"}`, `unknown field "wrokdir"`},
+		{`{"workdir": 3}`, `workdir`},
+		{`{"workdir": "relative/dir"}`, `field "workdir": "relative/dir" isn't an absolute path`},
+		{`{"url": "ftp://example.com/buildlet"}`, `field "url": "ftp://example.com/buildlet" isn't an http or https URL`},
+		{`{"url": "https://example.com/buildlet.$OS"}`, `field "url": unknown placeholder $OS`},
+		{`{"url": ""}`, `field "url": empty URL`},
+		{`{"reverseType": "Host Linux"}`, `field "reverseType": "Host Linux" isn't a host type`},
+		{`{"hostname": "a b"}`, `field "hostname"`},
+		{`{"args": ["--ok", "bad"]}`, `field "args": args[1] = "bad" isn't a flag`},
+		{`{"writes": ["vm.swappiness"]}`, `field "writes": writes[0]: "vm.swappiness" isn't of the form key=value`},
+		{`{"writes": ["/etc/passwd=x"]}`, `field "writes": writes[0]: "/etc/passwd" isn't under /sys or /proc/sys`},
+		{`{"packages": ["gcc", "--force"]}`, `field "packages": packages[1] = "--force" isn't a package name`},
+		{`[1]`, `cannot unmarshal`},
+	}
+	for _, tt := range tests {
+		_, err := parseHostOverride([]byte(tt.in), "--host-config=/etc/stage0.json")
+		if err == nil {
+			t.Errorf("%s: no error; want %q", tt.in, tt.want)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "--host-config=/etc/stage0.json: ") || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %q; want source and %q", tt.in, err, tt.want)
+		}
+	}
+	for _, ok := range []string{
+		`{}`,
+		`{"workdir": "${WORKDIR}"}`,
+		`{"workdir": ""}`,
+		`{"url": "https://a.example.com/buildlet.$GOOS-$GOARCH,https://b.example.com/buildlet"}`,
+		`{"reverseType": "host-linux-arm64-newcloud", "hostname": "box1"}`,
+	} {
+		if _, err := parseHostOverride([]byte(ok), "test"); err != nil {
+			t.Errorf("%s: %v", ok, err)
+		}
+	}
+}
+
+func TestDryRunHostSources(t *testing.T) {
+	if osArch != "linux/amd64" {
+		t.Skip("test assumes a linux/amd64 host with no built-in URL")
+	}
+	for _, k := range []string{"IN_KUBERNETES", "GO_BUILDER_ENV", "META_STAGE0_CONFIG", "META_ON_BUILDLET_EXIT", "GOARCH"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("IN_KUBERNETES", "1")
+	os.Unsetenv("GO_BUILDER_ENV")
+	os.Setenv("GOARCH", "s390x") // so Adjust sets a URL
+	os.Setenv("META_ON_BUILDLET_EXIT", "halt")
+	os.Setenv("META_STAGE0_CONFIG", `{"reverseType": "host-linux-meta", "workdir": "/meta"}`)
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(file, []byte(`{"workdir": "/file"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(v string) { *hostConfigFile = v }(*hostConfigFile)
+	*hostConfigFile = file
+	defer func(v bool) { *jsonOutput = v }(*jsonOutput)
+	*jsonOutput = true
+
+	var buf bytes.Buffer
+	if err := printDryRun(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var c dryRunConfig
+	if err := json.Unmarshal(buf.Bytes(), &c); err != nil {
+		t.Fatalf("output isn't JSON: %v\n%s", err, buf.Bytes())
+	}
+	want := []hostField{
+		{"url", "https://storage.googleapis.com/go-builder-data/buildlet.linux-amd64", "built-in, adjusted at run time"},
+		{"reverseType", "host-linux-meta", "stage0-config metadata"},
+		{"workdir", "/file", "--host-config=" + file},
+		{"hostname", "", "built-in"},
+		{"args", "", "built-in"},
+		{"writes", "", "built-in"},
+		{"packages", "", "built-in"},
+		{"onBuildletExit", "halt", "on-buildlet-exit metadata"},
+	}
+	if !reflect.DeepEqual(c.Host, want) {
+		t.Errorf("host config =\n%+v\nwant\n%+v", c.Host, want)
+	}
+	args := strings.Join(c.Args, " ")
+	for _, a := range []string{"--reverse-type=host-linux-meta", "--workdir=/file", "--halt=true"} {
+		if !strings.Contains(args, a) {
+			t.Errorf("args = %s; want %s", args, a)
+		}
+	}
+}
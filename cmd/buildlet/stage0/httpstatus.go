@@ -0,0 +1,22 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/build/internal/httpdl"
+)
+
+// httpFailure returns the unsuccessful HTTP response that err, from a
+// download attempt, reports, or nil if it's some other failure.
+// httpdl, fetchGzip, and fetchGCS all report them as *httpdl.HTTPError.
+func httpFailure(err error) *httpdl.HTTPError {
+	var he *httpdl.HTTPError
+	if errors.As(err, &he) {
+		return he
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/build/internal/httpdl"
+)
+
+func TestDownloadHTTPStatus(t *testing.T) {
+	oldClient, oldRetries := http.DefaultClient, *downloadRetries
+	defer func() { http.DefaultClient, *downloadRetries = oldClient, oldRetries }()
+	*downloadRetries = 3
+	configureHTTPClient()
+
+	tests := []struct {
+		code       int
+		retryAfter string
+		wantTries  int32
+		wantSleep  time.Duration // minimum sleep before the second try
+	}{
+		{code: 404, wantTries: 1},
+		{code: 403, wantTries: 1},
+		{code: 400, wantTries: 1},
+		{code: 408, wantTries: 3},
+		{code: 429, retryAfter: "120", wantTries: 3, wantSleep: 2 * time.Minute},
+		{code: 429, retryAfter: "86400", wantTries: 3, wantSleep: 5 * time.Minute},
+		{code: 500, wantTries: 3},
+		{code: 503, retryAfter: "45", wantTries: 3, wantSleep: 45 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d", tt.code), func(t *testing.T) {
+			var slept []time.Duration
+			sleep = func(d time.Duration) { slept = append(slept, d) }
+			defer func() { sleep = time.Sleep }()
+
+			var tries int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" {
+					atomic.AddInt32(&tries, 1)
+				}
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.Header().Set("Last-Modified", time.Unix(1462292149, 0).UTC().Format(http.TimeFormat))
+				if r.Method == "HEAD" {
+					return
+				}
+				w.WriteHeader(tt.code)
+				fmt.Fprintf(w, "no buildlet for you\n")
+			}))
+			defer ts.Close()
+
+			file, cleanup := tempFile(t)
+			defer cleanup()
+			url := ts.URL + "/buildlet.linux-amd64"
+			err := download(file, url, nil)
+			if err == nil {
+				t.Fatal("download succeeded; want error")
+			}
+			for _, want := range []string{url, fmt.Sprint(tt.code), "no buildlet for you"} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't contain %q", err, want)
+				}
+			}
+			if got := atomic.LoadInt32(&tries); got != tt.wantTries {
+				t.Errorf("tries = %d; want %d", got, tt.wantTries)
+			}
+			if len(slept) != int(tt.wantTries)-1 {
+				t.Fatalf("slept %v; want %d sleeps", slept, tt.wantTries-1)
+			}
+			if tt.wantSleep != 0 && slept[0] < tt.wantSleep {
+				t.Errorf("first sleep = %v; want at least %v", slept[0], tt.wantSleep)
+			}
+			// httpdl caps Retry-After at 5 minutes.
+			if limit := 5 * time.Minute; tt.retryAfter != "" && slept[0] > limit {
+				t.Errorf("first sleep = %v; want at most %v", slept[0], limit)
+			}
+		})
+	}
+}
+
+func TestDownloadRetryAfterThenSuccess(t *testing.T) {
+	oldClient := http.DefaultClient
+	defer func() { http.DefaultClient = oldClient }()
+	configureHTTPClient()
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+
+	var tries int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && atomic.AddInt32(&tries, 1) == 1 {
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Last-Modified", time.Unix(1462292149, 0).UTC().Format(http.TimeFormat))
+		w.Write([]byte("buildlet"))
+	}))
+	defer ts.Close()
+
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	if err := download(file, ts.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(slept) != 1 || slept[0] < 10*time.Second {
+		t.Errorf("slept %v; want one sleep of at least 10s", slept)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != "buildlet" {
+		t.Errorf("file = %q, %v; want %q", b, err, "buildlet")
+	}
+}
+
+func TestDownloadGzipHTTPStatus(t *testing.T) {
+	oldClient, oldRetries := http.DefaultClient, *downloadRetries
+	defer func() { http.DefaultClient, *downloadRetries = oldClient, oldRetries }()
+	*downloadRetries = 3
+	configureHTTPClient()
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	var tries int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tries, 1)
+		http.Error(w, "no buildlet for you", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	err := download(file, ts.URL+"/buildlet.linux-amd64.gz", nil)
+	var he *httpdl.HTTPError
+	if !errors.As(err, &he) || he.StatusCode != 404 || he.Snippet != "no buildlet for you" {
+		t.Errorf("error = %v; want an *httpdl.HTTPError for the 404", err)
+	}
+	if got := atomic.LoadInt32(&tries); got != 1 {
+		t.Errorf("tries = %d; want 1", got)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"text/template"
+)
+
+// launchdLabel is stage0's launchd job label.
+const launchdLabel = "org.golang.build.stage0"
+
+// launchdPlistPath is where --install-launchd writes stage0's
+// launchd job definition.
+const launchdPlistPath = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+
+// defaultStage0EnvFile is the --stage0-env-file used by the launchd
+// job if none is given to --install-launchd.
+const defaultStage0EnvFile = "/usr/local/etc/stage0.env"
+
+var launchdTmpl = template.Must(template.New("plist").Funcs(template.FuncMap{
+	"xml": func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	},
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>ThrottleInterval</key>
+	<integer>30</integer>
+	<key>StandardOutPath</key>
+	<string>{{xml .Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Log}}</string>
+</dict>
+</plist>
+`))
+
+// launchdPlist returns a launchd job definition that keeps the
+// command args running, logging to logFile.
+func launchdPlist(args []string, logFile string) []byte {
+	var b bytes.Buffer
+	err := launchdTmpl.Execute(&b, struct {
+		Label string
+		Args  []string
+		Log   string
+	}{launchdLabel, args, logFile})
+	if err != nil {
+		panic(err) // can't happen writing to a bytes.Buffer
+	}
+	return b.Bytes()
+}
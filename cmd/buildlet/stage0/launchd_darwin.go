@@ -0,0 +1,72 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+)
+
+var (
+	installLaunchd   = flag.Bool("install-launchd", false, "install a launchd job at "+launchdPlistPath+" that keeps stage0 running with the other flags given, and exit; configuration comes from --stage0-env-file, by default "+defaultStage0EnvFile)
+	uninstallLaunchd = flag.Bool("uninstall-launchd", false, "unload and remove the launchd job installed by --install-launchd, and exit")
+)
+
+func init() {
+	serviceMain = launchdMain
+}
+
+func launchdMain(run func()) bool {
+	switch {
+	case *installLaunchd:
+		if err := installLaunchdJob(); err != nil {
+			log.Fatalf("installing launchd job: %v", err)
+		}
+		log.Printf("installed and loaded %s", launchdPlistPath)
+		return true
+	case *uninstallLaunchd:
+		if out, err := exec.Command("launchctl", "unload", "-w", launchdPlistPath).CombinedOutput(); err != nil {
+			log.Printf("launchctl unload: %v: %s", err, out)
+		}
+		if err := os.Remove(launchdPlistPath); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("removed %s", launchdPlistPath)
+		return true
+	}
+	return false
+}
+
+func installLaunchdJob() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{exe}
+	for _, a := range os.Args[1:] {
+		if flagName(a) != "install-launchd" {
+			args = append(args, a)
+		}
+	}
+	envFile := *stage0EnvFile
+	if envFile == "" {
+		envFile = defaultStage0EnvFile
+		args = append(args, "--stage0-env-file="+envFile)
+	}
+	if _, err := os.Stat(envFile); err != nil {
+		log.Printf("WARNING: %v; stage0 won't start until it exists", err)
+	}
+	if err := ioutil.WriteFile(launchdPlistPath, launchdPlist(args, "/var/log/stage0.log"), 0644); err != nil {
+		return err
+	}
+	if out, err := exec.Command("launchctl", "load", "-w", launchdPlistPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("launchctl load: %v: %s", err, out)
+	}
+	return nil
+}
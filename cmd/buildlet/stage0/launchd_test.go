@@ -0,0 +1,50 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLaunchdPlist(t *testing.T) {
+	args := []string{"/usr/local/bin/stage0", "--stage0-env-file=/usr/local/etc/stage0.env", "--buildlet-url=https://example.com/?a=1&b=<2>"}
+	p := launchdPlist(args, "/var/log/stage0.log")
+
+	// It must be well-formed XML, with the arguments escaped.
+	d := xml.NewDecoder(strings.NewReader(string(p)))
+	d.Strict = true
+	var strs []string
+	inString := false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("plist isn't valid XML: %v\n%s", err, p)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			inString = tok.Name.Local == "string"
+		case xml.EndElement:
+			inString = false
+		case xml.CharData:
+			if inString {
+				strs = append(strs, string(tok))
+			}
+		}
+	}
+	want := append([]string{launchdLabel}, args...)
+	want = append(want, "/var/log/stage0.log", "/var/log/stage0.log")
+	if strings.Join(strs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("plist strings = %q; want %q", strs, want)
+	}
+	if !strings.Contains(string(p), "<key>KeepAlive</key>\n\t<true/>") {
+		t.Errorf("plist lacks KeepAlive:\n%s", p)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var normalizeLocaleFlag = flag.Bool("normalize-locale", true, "run the buildlet with TZ=UTC and LANG=C.UTF-8 (or C), without the host's other LC_* variables, unless buildlet-env or --env-file sets them; overrides the normalize-locale metadata attribute")
+
+// normalizeLocaleAttr is the optional GCE instance attribute for
+// --normalize-locale, for host types that test locales on purpose.
+// Off GCE, the META_NORMALIZE_LOCALE environment variable is used
+// instead.
+const normalizeLocaleAttr = "normalize-locale"
+
+// localeNormalized reports whether the buildlet's locale and time zone
+// should be normalized.
+func localeNormalized() (bool, error) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		// Neither uses TZ, LANG and LC_* the way Unix
+		// systems do.
+		return false, nil
+	}
+	if flagWasSet("normalize-locale") {
+		return *normalizeLocaleFlag, nil
+	}
+	v := metaValue(normalizeLocaleAttr, "META_NORMALIZE_LOCALE")
+	if v == "" {
+		return *normalizeLocaleFlag, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s metadata: %q isn't true or false", normalizeLocaleAttr, v)
+	}
+	return on, nil
+}
+
+// utf8CLocale is the name of the C locale with UTF-8 encoding, or the
+// empty string if the system doesn't have one. It's a variable for
+// tests.
+var utf8CLocale = findUTF8CLocale
+
+// findUTF8CLocale looks for C.UTF-8 in the output of "locale -a",
+// which spells it either C.UTF-8 or C.utf8.
+func findUTF8CLocale() string {
+	path, err := lookPath("locale")
+	if err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "-a").Output()
+	if err != nil {
+		return ""
+	}
+	for _, l := range strings.Fields(string(out)) {
+		if strings.EqualFold(l, "C.UTF-8") || strings.EqualFold(l, "C.utf8") {
+			return l
+		}
+	}
+	return ""
+}
+
+// isLocaleVar reports whether the environment variable key affects the
+// locale or time zone.
+func isLocaleVar(key string) bool {
+	return key == "TZ" || key == "LANG" || key == "LANGUAGE" || strings.HasPrefix(key, "LC_")
+}
+
+// normalizeLocale returns env with TZ=UTC, LANG set to the UTF-8 C
+// locale (or C, if there's none), and no other locale variables,
+// except for those in explicit, which are the buildlet-env and
+// --env-file settings already merged into env.
+func normalizeLocale(env, explicit []string) []string {
+	keep := map[string]bool{}
+	for _, kv := range explicit {
+		if k := envKey(kv); isLocaleVar(k) {
+			keep[k] = true
+		}
+	}
+	var out []string
+	for _, kv := range env {
+		if k := envKey(kv); isLocaleVar(k) && !keep[k] {
+			continue
+		}
+		out = append(out, kv)
+	}
+	if !keep["TZ"] {
+		out = append(out, "TZ=UTC")
+	}
+	if !keep["LANG"] {
+		lang := utf8CLocale()
+		if lang == "" {
+			lang = "C"
+		}
+		out = append(out, "LANG="+lang)
+	}
+	return out
+}
+
+// envKey returns the KEY of a KEY=VALUE environment entry.
+func envKey(kv string) string {
+	if i := strings.Index(kv, "="); i > 0 {
+		return kv[:i]
+	}
+	return kv
+}
+
+// logLocale makes buildletLocaleEnv log the buildlet's locale only
+// once.
+var logLocale sync.Once
+
+// buildletLocaleEnv returns the buildlet's environment env, which
+// includes the explicit settings from buildletEnv, with its locale
+// normalized if --normalize-locale says to.
+func buildletLocaleEnv(env, explicit []string) []string {
+	on, err := localeNormalized()
+	if err != nil {
+		configFatalf("%v", err)
+	}
+	if on {
+		env = normalizeLocale(env, explicit)
+	}
+	logLocale.Do(func() {
+		var vars []string
+		for _, kv := range env {
+			if isLocaleVar(envKey(kv)) {
+				vars = append(vars, kv)
+			}
+		}
+		how := "normalized"
+		if !on {
+			how = "not normalized"
+		}
+		if len(vars) == 0 {
+			vars = []string{"(none set)"}
+		}
+		log.Printf("buildlet locale (%s): %s", how, strings.Join(vars, " "))
+	})
+	return env
+}
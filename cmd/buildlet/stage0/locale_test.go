@@ -0,0 +1,71 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	defer func(f func() string) { utf8CLocale = f }(utf8CLocale)
+	env := []string{"PATH=/bin", "TZ=America/New_York", "LANG=tr_TR.UTF-8", "LC_ALL=de_DE", "LC_NUMERIC=fr_FR", "LANGUAGE=tr", "GO_BUILDER_ENV=x"}
+	tests := []struct {
+		name     string
+		utf8     string
+		explicit []string
+		want     []string
+	}{
+		{
+			name: "C.UTF-8",
+			utf8: "C.utf8",
+			want: []string{"PATH=/bin", "GO_BUILDER_ENV=x", "TZ=UTC", "LANG=C.utf8"},
+		},
+		{
+			name: "C",
+			want: []string{"PATH=/bin", "GO_BUILDER_ENV=x", "TZ=UTC", "LANG=C"},
+		},
+		{
+			name:     "explicit",
+			utf8:     "C.UTF-8",
+			explicit: []string{"LANG=tr_TR.UTF-8", "LC_ALL=de_DE", "USER=root"},
+			want:     []string{"PATH=/bin", "LANG=tr_TR.UTF-8", "LC_ALL=de_DE", "GO_BUILDER_ENV=x", "TZ=UTC"},
+		},
+	}
+	for _, tt := range tests {
+		utf8CLocale = func() string { return tt.utf8 }
+		if got := normalizeLocale(env, tt.explicit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: normalizeLocale = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLocaleNormalized(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("never normalized on " + runtime.GOOS)
+	}
+	for _, k := range []string{"IN_KUBERNETES", "META_NORMALIZE_LOCALE"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("IN_KUBERNETES", "1")
+	for _, tt := range []struct {
+		meta    string
+		want    bool
+		wantErr bool
+	}{
+		{"", true, false},
+		{"false", false, false},
+		{"true", true, false},
+		{"sometimes", false, true},
+	} {
+		os.Setenv("META_NORMALIZE_LOCALE", tt.meta)
+		got, err := localeNormalized()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("META_NORMALIZE_LOCALE=%q: %v, %v; want %v, error %v", tt.meta, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
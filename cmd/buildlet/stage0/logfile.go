@@ -0,0 +1,122 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	logFilePath = flag.String("log-file", "", "if non-empty, a file to also write logs to, such as /var/log/stage0.log, for hosts without serial console capture")
+	logFileKeep = flag.Int("log-file-keep", 5, "number of rotated --log-file files to keep, named with suffixes .1 (newest) through .N")
+)
+
+var logFileMaxSize = byteSize(10 << 20)
+
+func init() {
+	flag.Var(&logFileMaxSize, "log-file-max-size", "size at which --log-file is rotated")
+}
+
+// logFile, if non-nil, is where logs are mirrored per --log-file.
+var logFile *rotatingFile
+
+// openLogFile opens --log-file, if set, and starts mirroring logs to
+// it. If the file can't be opened, such as on a read-only
+// filesystem, it logs a warning and logs go only to the console.
+func openLogFile() {
+	if *logFilePath == "" {
+		return
+	}
+	f, err := openRotatingFile(*logFilePath, int64(logFileMaxSize), *logFileKeep)
+	if err != nil {
+		logf(logFields{"level": "warn"}, "WARNING: not logging to --log-file: %v", err)
+		return
+	}
+	logFile = f
+	applyLogOutput()
+}
+
+// rotatingFile is an append-only log file that's rotated when it
+// would exceed max bytes, keeping keep old files. Its writes never
+// fail: after an error, it reports the error to stderr once and
+// drops further writes, so logging can't block bootstrapping.
+type rotatingFile struct {
+	path string
+	max  int64
+	keep int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	failed bool
+}
+
+func openRotatingFile(path string, max int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, max: max, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+// rotate renames path.N-1 to path.N, ..., path to path.1, and opens a
+// new path.
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	r.f = nil
+	if r.keep <= 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return r.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.keep))
+	for i := r.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return len(p), nil
+	}
+	var err error
+	if r.max > 0 && r.size > 0 && r.size+int64(len(p)) > r.max {
+		err = r.rotate()
+	}
+	if err == nil {
+		var n int
+		n, err = r.f.Write(p)
+		r.size += int64(n)
+	}
+	if err != nil {
+		r.failed = true
+		fmt.Fprintf(os.Stderr, "stage0: WARNING: writing to %s failed; no longer logging to it: %v\n", r.path, err)
+	}
+	return len(p), nil
+}
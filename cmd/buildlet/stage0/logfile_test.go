@@ -0,0 +1,86 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage0-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stage0.log")
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		if n, err := r.Write([]byte(line)); n != len(line) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", line, n, err)
+		}
+	}
+	r.f.Close()
+
+	want := map[string]string{
+		path:        "six\n",
+		path + ".1": "four\nfive\n",
+		path + ".2": "two\nthree\n",
+	}
+	for file, content := range want {
+		if b, err := ioutil.ReadFile(file); err != nil || string(b) != content {
+			t.Errorf("%s = %q, %v; want %q", filepath.Base(file), b, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists; want only 2 rotated files kept", filepath.Base(path))
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage0-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stage0.log")
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openRotatingFile(path, 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("new\n"))
+	r.f.Close()
+	if b, _ := ioutil.ReadFile(path); string(b) != "old\nnew\n" {
+		t.Errorf("log file = %q; want appended", b)
+	}
+}
+
+func TestOpenLogFileUnwritable(t *testing.T) {
+	defer func(v string) { *logFilePath = v }(*logFilePath)
+	*logFilePath = filepath.Join(os.DevNull, "nonexistent", "stage0.log")
+	logs := captureLogs(t, "text", func() {
+		openLogFile()
+		if logFile != nil {
+			t.Error("logFile set for unwritable path")
+			logFile = nil
+		}
+	})
+	if !strings.Contains(logs, "WARNING: not logging to --log-file") {
+		t.Errorf("logs = %q; want warning", logs)
+	}
+}
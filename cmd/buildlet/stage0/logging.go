@@ -0,0 +1,149 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logFormat = flag.String("log-format", "text", `log format: "text", or "json" for one JSON object per line`)
+
+// logFields are extra key/value pairs attached to a log entry in JSON
+// mode. The "level" key, if present, overrides the entry's level.
+type logFields map[string]interface{}
+
+// logState is the context attached to each JSON log entry.
+var logState struct {
+	sync.Mutex
+	phase   string // "network", "download", "exec", etc
+	attempt int    // or 0 before the first attempt
+}
+
+// setPhase records which phase of bootstrapping stage0 is in, and
+// tells systemd about it if stage0 is a Type=notify unit.
+func setPhase(phase string) {
+	logState.Lock()
+	logState.phase = phase
+	logState.Unlock()
+	notifyPhase(phase)
+}
+
+// setAttempt records which --loop attempt stage0 is on.
+func setAttempt(n int) {
+	logState.Lock()
+	logState.attempt = n
+	logState.Unlock()
+	if *logFormat != "json" && n > 1 {
+		log.SetPrefix(fmt.Sprintf("stage0 (attempt %d): ", n))
+	}
+}
+
+// configureLogFormat applies --log-format to the standard logger.
+func configureLogFormat() error {
+	switch *logFormat {
+	case "text":
+		return nil
+	case "json":
+		log.SetFlags(0)
+		log.SetPrefix("")
+		applyLogOutput()
+		return nil
+	}
+	return fmt.Errorf("unknown --log-format %q; want text or json", *logFormat)
+}
+
+// logBase is the output last given to setLogOutput.
+var logBase io.Writer = os.Stderr
+
+// logSinks are additional log outputs, such as the Windows event log
+// when running as a service. Their Write methods must not fail.
+var logSinks []io.Writer
+
+// setLogOutput sets the standard logger's output to w, also writing
+// to --log-file, if any, and converting entries to JSON if
+// --log-format=json.
+func setLogOutput(w io.Writer) {
+	logBase = w
+	applyLogOutput()
+}
+
+func applyLogOutput() {
+	// logTail, logFile, and logSinks never fail, so they can't
+	// stop logBase's writes.
+	w := io.MultiWriter(logBase, logTail)
+	if logFile != nil {
+		w = io.MultiWriter(w, logFile)
+	}
+	for _, s := range logSinks {
+		w = io.MultiWriter(w, s)
+	}
+	if *logFormat == "json" {
+		w = &jsonLogWriter{w: w}
+	}
+	log.SetOutput(w)
+}
+
+// logf is like log.Printf, but in JSON mode also attaches fields to
+// the entry.
+func logf(fields logFields, format string, args ...interface{}) {
+	if jw, ok := log.Writer().(*jsonLogWriter); ok {
+		jw.write(fields, fmt.Sprintf(format, args...))
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// jsonLogWriter is the standard logger's output in JSON mode. Each
+// Write is one log entry.
+type jsonLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (jw *jsonLogWriter) Write(p []byte) (int, error) {
+	if err := jw.write(nil, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (jw *jsonLogWriter) write(fields logFields, msg string) error {
+	msg = strings.TrimRight(msg, "\n")
+	e := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": "info",
+		"msg":   msg,
+	}
+	if strings.HasPrefix(msg, "WARNING") {
+		e["level"] = "warn"
+	}
+	logState.Lock()
+	if logState.phase != "" {
+		e["phase"] = logState.phase
+	}
+	if logState.attempt > 0 {
+		e["attempt"] = logState.attempt
+	}
+	logState.Unlock()
+	for k, v := range fields {
+		e[k] = v
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+	_, err = jw.w.Write(append(b, '\n'))
+	return err
+}
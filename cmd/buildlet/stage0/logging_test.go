@@ -0,0 +1,98 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+// captureLogs runs f with the standard logger in the given format,
+// writing to the returned buffer.
+func captureLogs(t *testing.T, format string, f func()) string {
+	oldFormat, oldBase, oldOut, oldFlags, oldPrefix := *logFormat, logBase, log.Writer(), log.Flags(), log.Prefix()
+	defer func() {
+		*logFormat = oldFormat
+		logBase = oldBase
+		log.SetOutput(oldOut)
+		log.SetFlags(oldFlags)
+		log.SetPrefix(oldPrefix)
+		setPhase("")
+		setAttempt(0)
+	}()
+	var buf bytes.Buffer
+	setLogOutput(&buf)
+	log.SetFlags(0)
+	log.SetPrefix("stage0: ")
+	*logFormat = format
+	if err := configureLogFormat(); err != nil {
+		t.Fatal(err)
+	}
+	f()
+	return buf.String()
+}
+
+func TestLogText(t *testing.T) {
+	got := captureLogs(t, "text", func() {
+		setPhase("download")
+		log.Printf("plain %d", 1)
+		logf(logFields{"url": "https://example.com/"}, "with fields")
+	})
+	if want := "stage0: plain 1\nstage0: with fields\n"; got != want {
+		t.Errorf("text logs = %q; want %q", got, want)
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	got := captureLogs(t, "json", func() {
+		setPhase("download")
+		setAttempt(2)
+		log.Printf("plain %d", 1)
+		logf(logFields{"url": "https://example.com/", "bytes": 42}, "downloaded")
+		log.Printf("WARNING: careful")
+		logf(logFields{"level": "error"}, "failed\n")
+	})
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines; want 4:\n%s", len(lines), got)
+	}
+	var entries []map[string]interface{}
+	for _, line := range lines {
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("line %q isn't JSON: %v", line, err)
+		}
+		if e["time"] == nil {
+			t.Errorf("line %q has no time", line)
+		}
+		entries = append(entries, e)
+	}
+	check := func(i int, key string, want interface{}) {
+		t.Helper()
+		if got := entries[i][key]; got != want {
+			t.Errorf("entry %d %s = %v; want %v", i, key, got, want)
+		}
+	}
+	check(0, "msg", "plain 1")
+	check(0, "level", "info")
+	check(0, "phase", "download")
+	check(0, "attempt", 2.0)
+	check(1, "url", "https://example.com/")
+	check(1, "bytes", 42.0)
+	check(2, "level", "warn")
+	check(3, "level", "error")
+	check(3, "msg", "failed")
+}
+
+func TestLogFormatInvalid(t *testing.T) {
+	defer func(v string) { *logFormat = v }(*logFormat)
+	*logFormat = "xml"
+	if err := configureLogFormat(); err == nil {
+		t.Error("configureLogFormat accepted --log-format=xml")
+	}
+}
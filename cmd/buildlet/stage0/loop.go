@@ -0,0 +1,90 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	loopFlag       = flag.Bool("loop", false, "on failure, restart from the network wait instead of exiting; defaults to true on reverse builders and Windows services, which have nothing else to restart stage0")
+	loopOnExit     = flag.Bool("loop-on-exit", false, "with --loop, also restart the buildlet when it exits successfully")
+	crashLoopCount = flag.Int("crash-loop-count", 5, "with --loop, how many restarts within --crash-loop-window count as a crash loop, which slows restarts further")
+	crashLoopWin   = flag.Duration("crash-loop-window", 10*time.Minute, "with --loop, the window for --crash-loop-count")
+)
+
+const (
+	// loopSlowDelay is the wait before restarting once in a crash
+	// loop. It doubles with each further restart, up to
+	// loopMaxDelay.
+	loopSlowDelay = time.Minute
+	loopMaxDelay  = 30 * time.Minute
+)
+
+// loopEnabled reports whether stage0 should restart after a failure,
+// per --loop if set, else whether the host's OnBuildletExit is
+// restart-buildlet or this is a reverse builder or an OS service.
+func loopEnabled() bool {
+	if flagWasSet("loop") {
+		return *loopFlag
+	}
+	return currentHost().OnBuildletExit == exitRestart || isReverseBuilder() || runningAsService
+}
+
+// runningAsService is whether stage0 is running as an OS service,
+// which nothing restarts if it exits.
+var runningAsService bool
+
+// isReverseBuilder reports whether this machine dials the coordinator
+// as a reverse builder, rather than being a VM the coordinator
+// creates (and recreates, should stage0 exit).
+func isReverseBuilder() bool {
+	env := os.Getenv("GO_BUILDER_ENV")
+	return strings.HasPrefix(env, "host-") || lookupHost(osArch, env).ReverseType != ""
+}
+
+// restartTracker tracks recent restarts to detect crash loops.
+type restartTracker struct {
+	count  int // restarts within window that make a crash loop
+	window time.Duration
+	times  []time.Time // restarts within window, oldest first
+}
+
+// add records a restart at t and returns how many restarts there have
+// been within the window ending at t.
+func (r *restartTracker) add(t time.Time) int {
+	i := 0
+	for i < len(r.times) && t.Sub(r.times[i]) > r.window {
+		i++
+	}
+	r.times = append(r.times[i:], t)
+	return len(r.times)
+}
+
+// crashLooping reports whether n restarts within the window are a
+// crash loop.
+func (r *restartTracker) crashLooping(n int) bool {
+	return r.count > 0 && n >= r.count
+}
+
+// delay returns how long to wait before the nth restart within the
+// window: backoff until it's a crash loop, then loopSlowDelay,
+// doubling with each further restart up to loopMaxDelay.
+func (r *restartTracker) delay(n int) time.Duration {
+	if !r.crashLooping(n) {
+		return backoff(n)
+	}
+	d := loopSlowDelay
+	for i := r.count; i < n && d < loopMaxDelay; i++ {
+		d *= 2
+	}
+	if d > loopMaxDelay {
+		d = loopMaxDelay
+	}
+	return d
+}
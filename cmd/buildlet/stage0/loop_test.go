@@ -0,0 +1,75 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRestartTrackerDelay(t *testing.T) {
+	r := &restartTracker{count: 5, window: 10 * time.Minute}
+	for n := 1; n < r.count; n++ {
+		if d := r.delay(n); d > maxBackoff {
+			t.Errorf("delay(%d) = %v; want at most %v", n, d, maxBackoff)
+		}
+	}
+	prev := time.Duration(0)
+	for n := r.count; n < r.count+10; n++ {
+		d := r.delay(n)
+		if d < loopSlowDelay || d > loopMaxDelay {
+			t.Errorf("delay(%d) = %v; want between %v and %v", n, d, loopSlowDelay, loopMaxDelay)
+		}
+		if d < prev {
+			t.Errorf("delay(%d) = %v; less than delay(%d) = %v", n, d, n-1, prev)
+		}
+		prev = d
+	}
+	if d := r.delay(r.count + 10); d != loopMaxDelay {
+		t.Errorf("delay(%d) = %v; want %v", r.count+10, d, loopMaxDelay)
+	}
+}
+
+func TestRestartTrackerWindow(t *testing.T) {
+	r := &restartTracker{count: 3, window: 10 * time.Minute}
+	t0 := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		at   time.Duration
+		want int
+	}{
+		{0, 1},
+		{time.Minute, 2},
+		{2 * time.Minute, 3},
+		{13 * time.Minute, 1}, // the others have aged out
+		{14 * time.Minute, 2},
+		{40 * time.Minute, 1},
+	}
+	for _, s := range steps {
+		if got := r.add(t0.Add(s.at)); got != s.want {
+			t.Errorf("add at +%v = %d; want %d", s.at, got, s.want)
+		}
+	}
+	if !r.crashLooping(3) || r.crashLooping(2) {
+		t.Error("crashLooping doesn't match count 3")
+	}
+	if (&restartTracker{}).crashLooping(100) {
+		t.Error("crashLooping with count 0 = true; want false")
+	}
+}
+
+func TestIsReverseBuilder(t *testing.T) {
+	defer os.Setenv("GO_BUILDER_ENV", os.Getenv("GO_BUILDER_ENV"))
+	os.Setenv("GO_BUILDER_ENV", "host-linux-arm64-packet")
+	if !isReverseBuilder() {
+		t.Error("host-linux-arm64-packet isn't a reverse builder")
+	}
+	if osArch == "linux/amd64" {
+		os.Setenv("GO_BUILDER_ENV", "")
+		if isReverseBuilder() {
+			t.Error("GCE VM is a reverse builder")
+		}
+	}
+}
@@ -0,0 +1,356 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// This lets us be lazy and put the stage0 start-up in rc.local where
+// it might race with the network coming up, rather than write proper
+// upstart+systemd+init scripts. Under systemd, prefer a Type=notify
+// unit (see sdNotify) ordered after network-online.target, with
+// --skip-network-wait:
+var (
+	networkWait     waitFlag
+	skipNetworkWait = flag.Bool("skip-network-wait", false, "don't wait for the network to come up before downloading the buildlet, for environments where it's known to be up; download retries are the only network resilience")
+)
+
+func init() {
+	flag.Var(&networkWait, "network-wait", `how long to wait for the network to come up; if zero, a default is used. "off" or a negative duration means don't wait, like --skip-network-wait`)
+}
+
+// waitFlag is a flag.Value for a duration that may also be "off".
+// Its zero value means to use a default.
+type waitFlag struct {
+	d   time.Duration
+	off bool
+}
+
+func (f *waitFlag) String() string {
+	if f.off {
+		return "off"
+	}
+	return f.d.String()
+}
+
+func (f *waitFlag) Set(s string) error {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "off", "none":
+		*f = waitFlag{off: true}
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*f = waitFlag{d: d, off: d < 0}
+	return nil
+}
+
+// The URLs probed to see whether the network is up come from, in
+// order of precedence: an explicit --netcheck-url flag, the
+// META_NETCHECK_URL environment variable, the netcheck-url GCE
+// instance attribute, and the --netcheck-url default. Other clouds'
+// metadata isn't consulted, since it may not be reachable yet. A
+// value of "off" or "none" skips waiting for the network.
+var (
+	netcheckURL      = flag.String("netcheck-url", defaultNetcheckURL, "comma-separated list of URLs to probe in parallel to determine whether the network is up; any HTTP response counts. If \"off\" or \"none\", don't wait for the network. Overrides $META_NETCHECK_URL and the netcheck-url GCE attribute.")
+	netcheckExtraURL = flag.String("netcheck-extra-url", "", "if non-empty, an additional URL to probe along with --netcheck-url")
+	netcheckHTTP     = flag.Bool("netcheck-http", true, "whether the network probe does an HTTP request after checking DNS and TCP")
+	preferIPv6       = flag.Bool("prefer-ipv6", false, "try IPv6 addresses before IPv4 when connecting, for IPv6-only and NAT64 networks")
+)
+
+// probeTimeout bounds each stage of a network probe.
+const probeTimeout = 5 * time.Second
+
+const defaultNetcheckURL = "https://farmer.golang.org/netcheck,https://storage.googleapis.com/"
+
+// awaitNetwork reports whether the network came up within
+// --network-wait, which defaults to 30 seconds, or 5 minutes on
+// Windows and under SMF. It first waits for an interface to get a
+// global unicast address, then probes the netcheck URLs (see
+// netcheckURLs) in parallel, once a second, until one responds. Each
+// probe checks DNS, then TCP, then, with --netcheck-http, does an
+// HTTP request, each stage bounded by probeTimeout; through a proxy,
+// it just does the HTTP request. It reports true without waiting if
+// --skip-network-wait is set, --network-wait is off, or the netcheck
+// URL is "off".
+func awaitNetwork() bool {
+	if *skipNetworkWait {
+		log.Printf("*** skipped waiting for network (--skip-network-wait); relying on download retries ***")
+		return true
+	}
+	if networkWait.off {
+		log.Printf("*** skipped waiting for network (--network-wait=%s); relying on download retries ***", networkWait.String())
+		return true
+	}
+	timeout := 30 * time.Second
+	switch {
+	case runtime.GOOS == "windows":
+		timeout = 5 * time.Minute // empirically slower sometimes?
+	case underSMF():
+		// The network milestone can be reached before NWAM
+		// has actually configured anything.
+		timeout = 5 * time.Minute
+	}
+	if networkWait.d != 0 {
+		timeout = networkWait.d
+	}
+	urls, src := netcheckURLs()
+	if urls == nil {
+		log.Printf("not waiting for network; disabled by %s", src)
+		return true
+	}
+	deadline := time.Now().Add(timeout)
+	if !awaitInterface(deadline) {
+		log.Printf("gave up waiting for network: no non-loopback interface ever got a global unicast address")
+		return false
+	}
+	var lastSpam time.Time
+	log.Printf("waiting for network; probing %s (from %s)", strings.Join(urls, ", "), src)
+	for time.Now().Before(deadline) {
+		t0 := time.Now()
+		via, err := checkNetwork(urls)
+		if err == nil {
+			log.Printf("network probe succeeded %s", via)
+			return true
+		}
+		noteProbeError(err)
+		failAfter := time.Since(t0)
+		if now := time.Now(); now.After(lastSpam.Add(5 * time.Second)) {
+			log.Printf("network still down for %v; probe failure took %v: %v",
+				prettyDuration(time.Since(timeStart)),
+				prettyDuration(failAfter), err)
+			lastSpam = now
+		}
+		time.Sleep(1 * time.Second)
+	}
+	log.Printf("gave up waiting for network")
+	return false
+}
+
+// awaitInterface waits until deadline for a non-loopback network
+// interface to have a global unicast address, so the probe loop
+// doesn't burn its timeouts before DHCP has even finished. It
+// reports whether one did.
+func awaitInterface(deadline time.Time) bool {
+	t0 := time.Now()
+	logged := false
+	for {
+		if ifName, ip := globalUnicastAddr(); ip != nil {
+			if logged {
+				log.Printf("interface %s came up with address %s after %v", ifName, ip, prettyDuration(time.Since(t0)))
+			} else {
+				log.Printf("interface %s has address %s", ifName, ip)
+			}
+			return true
+		}
+		if !logged {
+			log.Printf("waiting for a network interface to get an address")
+			logged = true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// globalUnicastAddr returns the first up, non-loopback network
+// interface with a global unicast address, and that address. It
+// returns a nil ip if there's none.
+func globalUnicastAddr() (ifName string, ip net.IP) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return "", nil
+	}
+	for _, ifi := range ifs {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && ipn.IP.IsGlobalUnicast() {
+				return ifi.Name, ipn.IP
+			}
+		}
+	}
+	return "", nil
+}
+
+// checkNetwork reports whether the network is up by probing
+// known-up HTTPS servers in parallel. It returns as soon as any of
+// them respond, with a description of the successful probe, or else
+// the probe failures. It might block for a few seconds before
+// returning an answer. The probes use http.DefaultClient, which
+// downloads use too, so they see the same proxy and dialer settings.
+func checkNetwork(urls []string) (via string, err error) {
+	c := http.DefaultClient
+	type result struct {
+		via string
+		err error
+	}
+	resc := make(chan result, len(urls))
+	for _, u := range urls {
+		go func(u string) {
+			via, err := probe(c, u)
+			resc <- result{via, err}
+		}(u)
+	}
+	var errs []string
+	for range urls {
+		r := <-resc
+		if r.err == nil {
+			return r.via, nil
+		}
+		errs = append(errs, r.err.Error())
+	}
+	return "", errors.New(strings.Join(errs, "; "))
+}
+
+// probe checks whether the server at u is reachable. Unless the
+// request would go through a proxy, it checks in stages (DNS, then
+// TCP, then optionally HTTP) so a failure says how far it got. On
+// success, it returns a description of how the server was reached.
+func probe(c *http.Client, u string) (via string, err error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", userAgent())
+	host, port := req.URL.Hostname(), req.URL.Port()
+	if port == "" {
+		port = "443"
+		if req.URL.Scheme == "http" {
+			port = "80"
+		}
+	}
+	proxy, _ := proxyFunc(req)
+	if proxy == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		cancel()
+		if err != nil {
+			return "", fmt.Errorf("%s: DNS lookup failed: %v", host, err)
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), probeTimeout)
+		conn, err := dialContext(ctx, "tcp", net.JoinHostPort(host, port))
+		cancel()
+		if err != nil {
+			return "", fmt.Errorf("%s: DNS works (%s) but TCP to :%s failed: %v", host, describeAddrs(addrs), port, err)
+		}
+		via = fmt.Sprintf("to %s over %s", conn.RemoteAddr(), ipFamily(conn.RemoteAddr()))
+		conn.Close()
+		if !*netcheckHTTP {
+			return via, nil
+		}
+	} else {
+		via = "via proxy " + redactURL(proxy)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	res, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("%s: HTTP request failed: %v", host, err)
+	}
+	res.Body.Close() // any status is fine
+	noteServerDate(res)
+	return via, nil
+}
+
+// dialContext dials addr for stage0's HTTP clients and network probe.
+// With --prefer-ipv6, it tries IPv6 first. Otherwise it's the
+// standard dual-stack (Happy Eyeballs) dial, which already works on
+// IPv6-only networks.
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if *preferIPv6 && network == "tcp" {
+		c, err := d.DialContext(ctx, "tcp6", addr)
+		if err == nil {
+			return c, nil
+		}
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// describeAddrs summarizes DNS results by address family, so
+// DNS64-only results (AAAA records and no A records) are obvious.
+func describeAddrs(addrs []net.IPAddr) string {
+	var v4, v6 int
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			v4++
+		} else {
+			v6++
+		}
+	}
+	switch {
+	case v4 == 0:
+		return fmt.Sprintf("%d IPv6 addresses and no IPv4 addresses; is IPv6 routing up?", v6)
+	case v6 == 0:
+		return fmt.Sprintf("%d IPv4 addresses", v4)
+	}
+	return fmt.Sprintf("%d IPv4 and %d IPv6 addresses", v4, v6)
+}
+
+// ipFamily returns "IPv4" or "IPv6" according to addr's IP.
+func ipFamily(addr net.Addr) string {
+	if ta, ok := addr.(*net.TCPAddr); ok && ta.IP.To4() == nil {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// netcheckURLs returns the URLs to probe to see whether the network
+// is up and a description of where they came from. It returns nil
+// URLs if the network check is disabled.
+func netcheckURLs() (urls []string, source string) {
+	setting, source := *netcheckURL, "--netcheck-url default"
+	switch {
+	case flagWasSet("netcheck-url"):
+		source = "--netcheck-url"
+	case os.Getenv("META_NETCHECK_URL") != "":
+		setting, source = os.Getenv("META_NETCHECK_URL"), "$META_NETCHECK_URL"
+	case os.Getenv("IN_KUBERNETES") != "1" && onGCE():
+		if v := gceValue("netcheck-url"); v != "" {
+			setting, source = v, "netcheck-url GCE attribute"
+		}
+	}
+	return parseNetcheckURLs(setting, *netcheckExtraURL), source
+}
+
+// parseNetcheckURLs parses a netcheck URL setting, adding extra if
+// non-empty. It returns nil if the setting disables the network check.
+func parseNetcheckURLs(setting, extra string) []string {
+	switch strings.ToLower(strings.TrimSpace(setting)) {
+	case "off", "none":
+		return nil
+	}
+	urls := splitURLs(setting)
+	if extra != "" {
+		urls = append(urls, extra)
+	}
+	if len(urls) == 0 {
+		urls = splitURLs(defaultNetcheckURL)
+	}
+	return urls
+}
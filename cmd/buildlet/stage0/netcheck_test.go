@@ -0,0 +1,137 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNetcheckURLs(t *testing.T) {
+	tests := []struct {
+		setting, extra string
+		want           []string
+	}{
+		{defaultNetcheckURL, "", []string{"https://farmer.golang.org/netcheck", "https://storage.googleapis.com/"}},
+		{"https://farmer.example.com/netcheck", "", []string{"https://farmer.example.com/netcheck"}},
+		{"https://a/, https://b/", "https://c/", []string{"https://a/", "https://b/", "https://c/"}},
+		{"", "", []string{"https://farmer.golang.org/netcheck", "https://storage.googleapis.com/"}},
+		{"off", "", nil},
+		{" None ", "https://c/", nil},
+	}
+	for _, tt := range tests {
+		if got := parseNetcheckURLs(tt.setting, tt.extra); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseNetcheckURLs(%q, %q) = %q; want %q", tt.setting, tt.extra, got, tt.want)
+		}
+	}
+}
+
+func TestAwaitNetworkDisabled(t *testing.T) {
+	defer func(old string) { *netcheckURL = old }(*netcheckURL)
+	if err := flag.Set("netcheck-url", "off"); err != nil {
+		t.Fatal(err)
+	}
+	if !awaitNetwork() {
+		t.Error("awaitNetwork = false with --netcheck-url=off; want true")
+	}
+}
+
+func TestAwaitNetworkSkipped(t *testing.T) {
+	defer func(old string) { *netcheckURL = old }(*netcheckURL)
+	defer func(old bool) { *skipNetworkWait = old }(*skipNetworkWait)
+	defer func(old waitFlag) { networkWait = old }(networkWait)
+	// A probe of this URL would fail, so a true result means
+	// awaitNetwork didn't wait.
+	*netcheckURL = "http://127.0.0.1:1/"
+
+	*skipNetworkWait = true
+	if !awaitNetwork() {
+		t.Error("awaitNetwork = false with --skip-network-wait; want true")
+	}
+	*skipNetworkWait = false
+	for _, v := range []string{"off", "-1s"} {
+		if err := networkWait.Set(v); err != nil {
+			t.Fatal(err)
+		}
+		if !awaitNetwork() {
+			t.Errorf("awaitNetwork = false with --network-wait=%s; want true", v)
+		}
+	}
+}
+
+func TestWaitFlag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    waitFlag
+		wantErr bool
+	}{
+		{in: "0", want: waitFlag{}},
+		{in: "0s", want: waitFlag{}},
+		{in: "90s", want: waitFlag{d: 90 * time.Second}},
+		{in: "off", want: waitFlag{off: true}},
+		{in: "None", want: waitFlag{off: true}},
+		{in: "-1s", want: waitFlag{d: -time.Second, off: true}},
+		{in: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		var f waitFlag
+		err := f.Set(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v; want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && f != tt.want {
+			t.Errorf("Set(%q) = %+v; want %+v", tt.in, f, tt.want)
+		}
+	}
+}
+
+func TestCheckNetwork(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler()) // any response is fine
+	defer ts.Close()
+
+	// Find a closed port.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + ln.Addr().String() + "/"
+	ln.Close()
+
+	via, err := checkNetwork([]string{closed, ts.URL})
+	if err != nil {
+		t.Errorf("checkNetwork with one server up = %v; want nil", err)
+	} else if !strings.Contains(via, "over IPv4") {
+		t.Errorf("checkNetwork via = %q; want IPv4", via)
+	}
+	_, err = checkNetwork([]string{closed})
+	if err == nil || !strings.Contains(err.Error(), "but TCP to") {
+		t.Errorf("checkNetwork with no server up = %v; want TCP stage failure", err)
+	}
+}
+
+func TestDescribeAddrs(t *testing.T) {
+	v4 := net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	v6 := net.IPAddr{IP: net.ParseIP("64:ff9b::c000:201")}
+	tests := []struct {
+		addrs []net.IPAddr
+		want  string
+	}{
+		{[]net.IPAddr{v4}, "1 IPv4 addresses"},
+		{[]net.IPAddr{v6, v6}, "2 IPv6 addresses and no IPv4 addresses; is IPv6 routing up?"},
+		{[]net.IPAddr{v4, v6}, "1 IPv4 and 1 IPv6 addresses"},
+	}
+	for _, tt := range tests {
+		if got := describeAddrs(tt.addrs); got != tt.want {
+			t.Errorf("describeAddrs(%v) = %q; want %q", tt.addrs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var configDrive = flag.String("openstack-config-drive", "", "if non-empty, path to an already-mounted OpenStack config drive to read metadata from")
+
+// openstackMetadataURL is the OpenStack metadata service's
+// meta_data.json URL. It's a variable for tests.
+var openstackMetadataURL = "http://169.254.169.254/openstack/latest/meta_data.json"
+
+// configDriveDev is the device of an unmounted OpenStack config
+// drive, on Linux.
+const configDriveDev = "/dev/disk/by-label/config-2"
+
+// openstackMetaData is the subset of OpenStack's meta_data.json used
+// by stage0.
+type openstackMetaData struct {
+	Meta map[string]string `json:"meta"`
+}
+
+// openstackProvider is a metadataProvider for OpenStack, reading
+// meta_data.json from either a config drive or the metadata service.
+// Values come from the instance's "meta" properties. Since OpenStack
+// property names conventionally use underscores, dashes in keys also
+// match underscores, so "buildlet-binary-url" finds
+// "buildlet_binary_url".
+type openstackProvider struct {
+	md *openstackMetaData
+}
+
+func (p *openstackProvider) Name() string { return "OpenStack" }
+
+func (p *openstackProvider) Detect() bool {
+	md, err := readConfigDrive()
+	if err != nil {
+		log.Printf("reading OpenStack config drive: %v", err)
+	}
+	if md == nil {
+		md, _ = fetchOpenStackMetaData()
+	}
+	p.md = md
+	return md != nil
+}
+
+func (p *openstackProvider) Value(key string) string {
+	if v, ok := p.md.Meta[key]; ok {
+		return v
+	}
+	return p.md.Meta[strings.Replace(key, "-", "_", -1)]
+}
+
+// readConfigDrive reads meta_data.json from the config drive named by
+// --openstack-config-drive or, on Linux, from the config drive device
+// if present. It returns (nil, nil) if there's no config drive.
+func readConfigDrive() (*openstackMetaData, error) {
+	dir := *configDrive
+	if dir == "" {
+		if runtime.GOOS != "linux" {
+			return nil, nil
+		}
+		if _, err := os.Stat(configDriveDev); err != nil {
+			return nil, nil
+		}
+		tmp, err := ioutil.TempDir("", "config-drive")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp)
+		if out, err := exec.Command("mount", "-o", "ro", configDriveDev, tmp).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("mounting %s: %v, %s", configDriveDev, err, out)
+		}
+		defer exec.Command("umount", tmp).Run()
+		dir = tmp
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "openstack", "latest", "meta_data.json"))
+	if err != nil {
+		return nil, err
+	}
+	md := new(openstackMetaData)
+	if err := json.Unmarshal(b, md); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+func fetchOpenStackMetaData() (*openstackMetaData, error) {
+	req, err := http.NewRequest("GET", openstackMetadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	b, err := metaGet(req, 1)
+	if err != nil {
+		return nil, err
+	}
+	md := new(openstackMetaData)
+	if err := json.Unmarshal(b, md); err != nil {
+		log.Printf("decoding OpenStack meta_data.json: %v", err)
+		return nil, err
+	}
+	return md, nil
+}
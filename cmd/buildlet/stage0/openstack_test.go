@@ -0,0 +1,65 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const openstackMetaJSON = `{
+  "uuid": "d8e02d56-2648-49a3-bf97-6be8f1204f38",
+  "meta": {"buildlet_binary_url": "https://example.com/buildlet.linux-ppc64le"},
+  "hostname": "builder-1"
+}`
+
+func TestOpenStackMetadataService(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, openstackMetaJSON)
+	}))
+	defer ts.Close()
+	defer func(old string) { openstackMetadataURL = old }(openstackMetadataURL)
+	openstackMetadataURL = ts.URL
+
+	p := new(openstackProvider)
+	if !p.Detect() {
+		t.Fatal("Detect = false; want true")
+	}
+	if got, want := p.Value("buildlet-binary-url"), "https://example.com/buildlet.linux-ppc64le"; got != want {
+		t.Errorf("Value = %q; want %q", got, want)
+	}
+	if got := p.Value("buildlet-binary-sha256"); got != "" {
+		t.Errorf("Value of unset key = %q; want empty", got)
+	}
+}
+
+func TestOpenStackConfigDrive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "openstack", "latest"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "openstack", "latest", "meta_data.json"), []byte(openstackMetaJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { *configDrive = old }(*configDrive)
+	*configDrive = dir
+
+	md, err := readConfigDrive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := md.Meta["buildlet_binary_url"], "https://example.com/buildlet.linux-ppc64le"; got != want {
+		t.Errorf("buildlet_binary_url = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// childOutputSize is how much of the buildlet's recent output is kept
+// for logging when it fails.
+const childOutputSize = 64 << 10
+
+// outputDrainTimeout bounds how long stage0 waits, after the buildlet
+// fails, for its last output to be copied into childOutput.
+const outputDrainTimeout = time.Second
+
+// childOutput holds the buildlet's recent standard output and
+// standard error, interleaved, for logging and failure reports when
+// it fails. It's reset at the start of each attempt.
+var childOutput = &tailBuffer{max: childOutputSize}
+
+// An outputCapture copies a child's standard output and standard
+// error to stage0's and to childOutput.
+//
+// The child is given the write ends of pipes as *os.Files, rather
+// than having os/exec make them, so that cmd.Wait doesn't also wait
+// for any grandchild that inherits them.
+type outputCapture struct {
+	w    []*os.File // write ends, closed after the child starts
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// captureOutput sets cmd's Stdout and Stderr to capture its output.
+// The caller must call closeWriters once cmd has been started, or has
+// failed to start.
+func captureOutput(cmd *exec.Cmd) (*outputCapture, error) {
+	c := new(outputCapture)
+	for _, dst := range []struct {
+		set *io.Writer
+		to  io.Writer
+	}{
+		{&cmd.Stdout, os.Stdout},
+		{&cmd.Stderr, os.Stderr},
+	} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			c.closeWriters()
+			return nil, err
+		}
+		c.w = append(c.w, w)
+		*dst.set = w
+		c.wg.Add(1)
+		go func(to io.Writer) {
+			defer c.wg.Done()
+			defer r.Close()
+			// Keep reading even if stage0's own output
+			// fails, so the child never blocks or gets
+			// EPIPE on account of it.
+			io.Copy(teeWriter{to}, r)
+		}(dst.to)
+	}
+	return c, nil
+}
+
+// closeWriters closes stage0's copies of the pipes' write ends, so
+// the copies finish once the child (and any of its children) exit.
+func (c *outputCapture) closeWriters() {
+	c.once.Do(func() {
+		for _, w := range c.w {
+			w.Close()
+		}
+	})
+}
+
+// wait waits up to timeout for the copies to finish, reporting
+// whether they did.
+func (c *outputCapture) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// teeWriter writes to childOutput, the serial console, if stage0 is
+// logging to one, and then to dst, ignoring dst's errors.
+type teeWriter struct {
+	dst io.Writer
+}
+
+func (t teeWriter) Write(p []byte) (int, error) {
+	childOutput.Write(p)
+	if serialLog != nil {
+		serialLog.relay(p)
+	}
+	t.dst.Write(p)
+	return len(p), nil
+}
+
+// dumpChildOutput logs the buildlet's recent output, from
+// childOutput, after it failed with state ps having run for d.
+func dumpChildOutput(ps *os.ProcessState, d time.Duration) {
+	out := childOutput.String()
+	what := "output"
+	if childOutput.full() {
+		// Drop the partial first line.
+		if i := strings.Index(out, "\n"); i >= 0 {
+			out = out[i+1:]
+		}
+		what = "last output"
+	}
+	if out == "" {
+		log.Printf("buildlet %s after %v with no output", exitReason(ps), prettyDuration(d))
+		return
+	}
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	log.Printf("buildlet %s after %v; its %s:\n"+
+		"==================== buildlet output ====================\n"+
+		"%s"+
+		"================== end buildlet output ==================",
+		exitReason(ps), prettyDuration(d), what, out)
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperCommand returns a command that runs the test binary as the
+// named helper process (see TestHelperProcess).
+func helperCommand(name string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$", "--", name)
+	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+	return cmd
+}
+
+// TestHelperProcess isn't a real test. It's run as a child process by
+// tests that need one.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		os.Exit(2)
+	}
+	switch args[1] {
+	case "fail":
+		fmt.Fprintln(os.Stdout, "starting up")
+		fmt.Fprintln(os.Stderr, "panic: something broke")
+		os.Exit(2)
+	case "daemon":
+		// Leave a child holding our stdout and stderr.
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$", "--", "sleep")
+		cmd.Env = os.Environ()
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Start(); err != nil {
+			os.Exit(3)
+		}
+		fmt.Fprintln(os.Stdout, "daemon started")
+		os.Exit(1)
+	case "exit3":
+		os.Exit(3)
+	case "kill":
+		p, _ := os.FindProcess(os.Getpid())
+		p.Kill()
+		time.Sleep(time.Minute)
+	case "sleep":
+		time.Sleep(5 * time.Second)
+		os.Exit(0)
+	case "orphan":
+		// Leave a child to be reparented, which exits soon
+		// after, and report its pid.
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$", "--", "nap")
+		cmd.Env = os.Environ()
+		if err := cmd.Start(); err != nil {
+			os.Exit(3)
+		}
+		fmt.Fprintln(os.Stdout, cmd.Process.Pid)
+		os.Exit(7)
+	case "nap":
+		time.Sleep(200 * time.Millisecond)
+		os.Exit(0)
+	case "fatal":
+		// Fail fatally outside the run loop, after the network
+		// is up, reporting to $STAGE0_TEST_REPORT_URL.
+		networkUp = true
+		*failureReportURL = os.Getenv("STAGE0_TEST_REPORT_URL")
+		sleepFatalf("no buildlet URL for %s", osArch)
+	}
+	os.Exit(2)
+}
+
+func TestCaptureOutput(t *testing.T) {
+	childOutput.Reset()
+	defer childOutput.Reset()
+	cmd := helperCommand("fail")
+	c, err := captureOutput(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	c.closeWriters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Fatal("helper succeeded; want failure")
+	}
+	if !c.wait(5 * time.Second) {
+		t.Fatal("output copies didn't finish")
+	}
+	out := childOutput.String()
+	for _, want := range []string{"starting up\n", "panic: something broke\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("childOutput = %q; want it to contain %q", out, want)
+		}
+	}
+}
+
+func TestCaptureOutputGrandchild(t *testing.T) {
+	childOutput.Reset()
+	defer childOutput.Reset()
+	cmd := helperCommand("daemon")
+	c, err := captureOutput(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	c.closeWriters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	cmd.Wait()
+	if d := time.Since(t0); d > 4*time.Second {
+		t.Errorf("Wait took %v; want it not to wait for the grandchild", d)
+	}
+	// The grandchild still holds the pipes, but what the child wrote
+	// has been copied.
+	if c.wait(100 * time.Millisecond) {
+		t.Error("copies finished while the grandchild holds the pipes")
+	}
+	if out := childOutput.String(); !strings.Contains(out, "daemon started") {
+		t.Errorf("childOutput = %q; want it to contain %q", out, "daemon started")
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var packageLockTimeout = flag.Duration("package-lock-timeout", 10*time.Minute, "how long to wait for another process, such as unattended-upgrades, to release the package manager's lock when installing packages")
+
+const (
+	// packageAttempts is how many times a failing package
+	// install is tried, not counting waits for the lock.
+	packageAttempts = 4
+
+	// packageLockPoll is how often to retry a package install
+	// while another process holds the lock.
+	packageLockPoll = 15 * time.Second
+
+	// packageOutputLines is how many of its last lines of output a
+	// failed package install's error includes.
+	packageOutputLines = 20
+)
+
+// A packageManager describes how to install packages with one OS
+// package manager, non-interactively.
+type packageManager struct {
+	name   string   // program name
+	args   []string // install arguments, before the package names
+	env    []string // added to the environment
+	locked []string // output meaning another process holds the lock
+}
+
+// packageManagers are the supported package managers, in order of
+// preference. Some systems have more than one: RHEL derivatives may
+// have both dnf and yum.
+var packageManagers = []packageManager{
+	{
+		name:   "apt-get",
+		args:   []string{"--yes", "--quiet", "install"},
+		env:    []string{"DEBIAN_FRONTEND=noninteractive", "NEEDRESTART_MODE=a"},
+		locked: []string{"Could not get lock", "Unable to acquire the dpkg frontend lock", "Unable to lock the administration directory"},
+	},
+	{
+		name: "dnf",
+		args: []string{"--assumeyes", "install"},
+	},
+	{
+		name:   "yum",
+		args:   []string{"--assumeyes", "install"},
+		locked: []string{"Another app is currently holding the yum lock"},
+	},
+	{
+		name:   "zypper",
+		args:   []string{"--non-interactive", "install"},
+		env:    []string{"ZYPP_LOCK_TIMEOUT=0"},
+		locked: []string{"System management is locked"},
+	},
+	{
+		name:   "apk",
+		args:   []string{"add", "--no-progress"},
+		locked: []string{"Unable to lock database"},
+	},
+	{
+		name: "pkg_add",
+		args: []string{"-I"},
+	},
+}
+
+// lookPath is exec.LookPath, except in tests.
+var lookPath = exec.LookPath
+
+// findPackageManager returns the first of packageManagers installed,
+// and its path.
+func findPackageManager() (*packageManager, string) {
+	for i := range packageManagers {
+		pm := &packageManagers[i]
+		if path, err := lookPath(pm.name); err == nil {
+			return pm, path
+		}
+	}
+	return nil, ""
+}
+
+// installCommand returns the system's package manager and the
+// command line to install pkgs with it.
+func installCommand(pkgs []string) (*packageManager, []string, error) {
+	pm, path := findPackageManager()
+	if pm == nil {
+		var names []string
+		for _, pm := range packageManagers {
+			names = append(names, pm.name)
+		}
+		return nil, nil, fmt.Errorf("installing %s: no supported package manager (%s) found", strings.Join(pkgs, " "), strings.Join(names, ", "))
+	}
+	argv := append([]string{path}, pm.args...)
+	return pm, append(argv, pkgs...), nil
+}
+
+// installPackages installs pkgs with the system's package manager.
+// While another process holds the package manager's lock, it waits,
+// up to --package-lock-timeout; other failures are retried with
+// backoff. The package manager's output is logged as it runs.
+func installPackages(pkgs ...string) error {
+	if len(pkgs) == 0 {
+		return nil
+	}
+	pm, argv, err := installCommand(pkgs)
+	if err != nil {
+		return err
+	}
+	path, args := argv[0], argv[1:]
+	cmdline := strings.Join(argv, " ")
+	lockDeadline := time.Now().Add(*packageLockTimeout)
+	for failures := 0; ; {
+		log.Printf("running %s", cmdline)
+		out, err := runLogged(pm, path, args)
+		if err == nil {
+			log.Printf("installed %s", strings.Join(pkgs, " "))
+			return nil
+		}
+		if pm.isLocked(out) {
+			if time.Now().After(lockDeadline) {
+				return fmt.Errorf("%s: still locked by another process after --package-lock-timeout=%v; last output:\n%s", cmdline, *packageLockTimeout, out)
+			}
+			log.Printf("%s is locked by another process; retrying in %v", pm.name, packageLockPoll)
+			sleep(packageLockPoll)
+			continue
+		}
+		failures++
+		if failures >= packageAttempts {
+			return fmt.Errorf("%s: %v; last output:\n%s", cmdline, err, out)
+		}
+		d := backoff(failures)
+		log.Printf("%s: %v; retrying in %v", cmdline, err, prettyDuration(d))
+		sleep(d)
+	}
+}
+
+// runLogged runs the package manager pm, at path, with args, logging
+// its output line by line. It returns the last lines of the output.
+func runLogged(pm *packageManager, path string, args []string) (string, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), pm.env...)
+	lw := &logLineWriter{prefix: pm.name + ": "}
+	tail := &tailBuffer{max: 8 << 10}
+	w := io.MultiWriter(lw, tail)
+	cmd.Stdout, cmd.Stderr = w, w
+	err := cmd.Run()
+	lw.flush()
+	return lastLines(tail.String(), packageOutputLines), err
+}
+
+// isLocked reports whether out, a failed install's output, says that
+// another process holds pm's lock.
+func (pm *packageManager) isLocked(out string) bool {
+	for _, s := range pm.locked {
+		if strings.Contains(out, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	s = strings.TrimRight(s, "\n")
+	lines := strings.Split(s, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// A logLineWriter logs each line written to it, with a prefix.
+type logLineWriter struct {
+	prefix string
+	buf    []byte
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		log.Printf("%s%s", w.prefix, bytes.TrimRight(w.buf[:i], "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs any final partial line.
+func (w *logLineWriter) flush() {
+	if len(w.buf) > 0 {
+		log.Printf("%s%s", w.prefix, w.buf)
+		w.buf = nil
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePackageManager installs a fake apt-get, a shell script with the
+// given body, and returns the directory it logs its runs to.
+func fakePackageManager(t *testing.T, body string) (dir string, cleanup func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake package manager is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "stage0-packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho \"$DEBIAN_FRONTEND $*\" >> " + filepath.Join(dir, "runs") + "\nn=$(wc -l < " + filepath.Join(dir, "runs") + ")\n" + body
+	if err := ioutil.WriteFile(filepath.Join(dir, "apt-get"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldLookPath, oldSleep := lookPath, sleep
+	lookPath = func(name string) (string, error) {
+		if name == "apt-get" {
+			return filepath.Join(dir, name), nil
+		}
+		return "", errors.New("not found")
+	}
+	sleep = func(time.Duration) {}
+	return dir, func() {
+		lookPath, sleep = oldLookPath, oldSleep
+		os.RemoveAll(dir)
+	}
+}
+
+func runs(t *testing.T, dir string) []string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "runs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestInstallPackagesRetries(t *testing.T) {
+	// Locked twice, then a transient failure, then success.
+	dir, cleanup := fakePackageManager(t, `
+case $n in
+1|2) echo "E: Could not get lock /var/lib/dpkg/lock-frontend" >&2; exit 100;;
+3) echo "E: Failed to fetch http://deb.debian.org/..." >&2; exit 100;;
+esac
+echo "Setting up gcc"
+`)
+	defer cleanup()
+
+	if err := installPackages("gcc", "gdb"); err != nil {
+		t.Fatal(err)
+	}
+	got := runs(t, dir)
+	if len(got) != 4 {
+		t.Fatalf("ran apt-get %d times; want 4:\n%s", len(got), strings.Join(got, "\n"))
+	}
+	if want := "noninteractive --yes --quiet install gcc gdb"; got[0] != want {
+		t.Errorf("ran apt-get as %q; want %q", got[0], want)
+	}
+}
+
+func TestInstallPackagesFailure(t *testing.T) {
+	dir, cleanup := fakePackageManager(t, `
+echo "Reading package lists..."
+echo "E: Unable to locate package nosuchpkg" >&2
+exit 100
+`)
+	defer cleanup()
+
+	err := installPackages("nosuchpkg")
+	if err == nil {
+		t.Fatal("installPackages succeeded")
+	}
+	for _, want := range []string{filepath.Join(dir, "apt-get") + " --yes --quiet install nosuchpkg", "exit status 100", "Unable to locate package nosuchpkg"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v; want it to contain %q", err, want)
+		}
+	}
+	if n := len(runs(t, dir)); n != packageAttempts {
+		t.Errorf("ran apt-get %d times; want %d", n, packageAttempts)
+	}
+}
+
+func TestInstallPackagesLockTimeout(t *testing.T) {
+	dir, cleanup := fakePackageManager(t, `
+echo "E: Could not get lock /var/lib/dpkg/lock-frontend" >&2
+exit 100
+`)
+	defer cleanup()
+	defer func(d time.Duration) { *packageLockTimeout = d }(*packageLockTimeout)
+	*packageLockTimeout = 0
+
+	err := installPackages("gcc")
+	if err == nil || !strings.Contains(err.Error(), "still locked") {
+		t.Errorf("error = %v; want lock timeout", err)
+	}
+	if n := len(runs(t, dir)); n != 1 {
+		t.Errorf("ran apt-get %d times; want 1", n)
+	}
+}
+
+func TestInstallPackagesNoManager(t *testing.T) {
+	defer func(f func(string) (string, error)) { lookPath = f }(lookPath)
+	lookPath = func(string) (string, error) { return "", errors.New("not found") }
+	if err := installPackages("gcc"); err == nil || !strings.Contains(err.Error(), "no supported package manager") {
+		t.Errorf("error = %v; want no package manager", err)
+	}
+	if err := installPackages(); err != nil {
+		t.Errorf("installing nothing: %v", err)
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	if got, want := lastLines("a\nb\nc\nd\n", 2), "c\nd"; got != want {
+		t.Errorf("lastLines = %q; want %q", got, want)
+	}
+	if got, want := lastLines("a\n", 2), "a"; got != want {
+		t.Errorf("lastLines = %q; want %q", got, want)
+	}
+}
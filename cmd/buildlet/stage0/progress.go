@@ -0,0 +1,122 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var progressInterval = flag.Duration("progress-interval", 10*time.Second, "how often to log the progress of a download; 0 disables progress logging")
+
+// progressTransport is an http.RoundTripper that logs the progress
+// of successful response bodies as they're read.
+type progressTransport struct {
+	rt       http.RoundTripper
+	interval time.Duration
+}
+
+func (t *progressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.rt.RoundTrip(req)
+	if err != nil || t.interval <= 0 || req.Method != "GET" || res.StatusCode != 200 {
+		return res, err
+	}
+	res.Body = newProgressReader(res.Body, redactURL(req.URL), res.ContentLength, t.interval, logf)
+	return res, nil
+}
+
+// progressReader wraps a response body, logging how much of it has
+// been read every interval until it's closed or hits EOF. Nothing is
+// logged for reads that finish within the first interval.
+type progressReader struct {
+	rc       io.ReadCloser
+	name     string
+	total    int64 // or -1 if unknown
+	interval time.Duration
+	logf     func(fields logFields, format string, args ...interface{})
+
+	mu       sync.Mutex
+	n        int64 // bytes read so far
+	lastN    int64 // n at last report
+	start    time.Time
+	lastTime time.Time
+	done     bool
+	timer    *time.Timer
+}
+
+func newProgressReader(rc io.ReadCloser, name string, total int64, interval time.Duration, logf func(logFields, string, ...interface{})) *progressReader {
+	now := time.Now()
+	r := &progressReader{
+		rc:       rc,
+		name:     name,
+		total:    total,
+		interval: interval,
+		logf:     logf,
+		start:    now,
+		lastTime: now,
+	}
+	r.timer = time.AfterFunc(interval, r.report)
+	return r
+}
+
+func (r *progressReader) report() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	now := time.Now()
+	r.logf(logFields{"url": r.name, "bytes": r.n}, "%s: %s", r.name, progressLine(r.n, r.total, r.n-r.lastN, now.Sub(r.lastTime)))
+	r.lastN, r.lastTime = r.n, now
+	r.timer.Reset(r.interval)
+}
+
+// progressLine describes n bytes read of total (-1 if unknown), with
+// a throughput of recent bytes in the recent elapsed time.
+func progressLine(n, total, recent int64, elapsed time.Duration) string {
+	s := "downloaded " + formatBytes(n)
+	if total > 0 {
+		s += fmt.Sprintf(" of %s (%d%%)", formatBytes(total), n*100/total)
+	}
+	if elapsed > 0 {
+		s += fmt.Sprintf(", %s/s", formatBytes(int64(float64(recent)/elapsed.Seconds())))
+	}
+	return s
+}
+
+func (r *progressReader) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	r.timer.Stop()
+	if time.Since(r.start) >= r.interval {
+		r.logf(logFields{"url": r.name, "bytes": r.n}, "%s: finished, %s in %v", r.name, formatBytes(r.n), prettyDuration(time.Since(r.start)))
+	}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.mu.Lock()
+	r.n += int64(n)
+	read := r.n
+	r.mu.Unlock()
+	noteDownloadProgress(read, r.total)
+	if err == io.EOF {
+		r.finish()
+	}
+	return n, err
+}
+
+func (r *progressReader) Close() error {
+	r.finish()
+	return r.rc.Close()
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressLine(t *testing.T) {
+	tests := []struct {
+		n, total, recent int64
+		elapsed          time.Duration
+		want             string
+	}{
+		{5 << 20, 20 << 20, 1 << 20, 2 * time.Second, "downloaded 5.0MB of 20.0MB (25%), 512.0KB/s"},
+		{5 << 20, -1, 1 << 20, time.Second, "downloaded 5.0MB, 1.0MB/s"},
+		{100, 0, 0, 0, "downloaded 100B"},
+	}
+	for _, tt := range tests {
+		if got := progressLine(tt.n, tt.total, tt.recent, tt.elapsed); got != tt.want {
+			t.Errorf("progressLine(%d, %d, %d, %v) = %q; want %q", tt.n, tt.total, tt.recent, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+// slowReader returns one byte per read, sleeping d first.
+type slowReader struct {
+	s string
+	d time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.s == "" {
+		return 0, io.EOF
+	}
+	time.Sleep(r.d)
+	p[0], r.s = r.s[0], r.s[1:]
+	return 1, nil
+}
+
+func TestProgressReader(t *testing.T) {
+	var mu sync.Mutex
+	var logs []string
+	logf := func(_ logFields, format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+
+	// Fast downloads log nothing.
+	r := newProgressReader(ioutil.NopCloser(strings.NewReader("buildlet")), "fast", 8, time.Hour, logf)
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if len(logs) != 0 {
+		t.Errorf("fast download logged %q; want nothing", logs)
+	}
+
+	// Slow ones report progress and completion.
+	r = newProgressReader(ioutil.NopCloser(&slowReader{"buildlet", 10 * time.Millisecond}), "slow", 8, 15*time.Millisecond, logf)
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(logs) < 2 {
+		t.Fatalf("slow download logged %q; want progress and completion", logs)
+	}
+	if !strings.HasPrefix(logs[0], "slow: downloaded ") || !strings.Contains(logs[0], " of 8B (") {
+		t.Errorf("first log = %q; want progress", logs[0])
+	}
+	if last := logs[len(logs)-1]; !strings.HasPrefix(last, "slow: finished, 8B in ") {
+		t.Errorf("last log = %q; want completion", last)
+	}
+}
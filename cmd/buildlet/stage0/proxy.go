@@ -0,0 +1,64 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var proxyFlag = flag.String("proxy", "", "if non-empty, URL of the HTTP proxy to use for the network probe and downloads, overriding $HTTP_PROXY and $HTTPS_PROXY")
+
+// noProxyHosts are hosts that are never reached through a proxy,
+// regardless of $NO_PROXY: the cloud metadata services, which are
+// only reachable directly from the instance.
+var noProxyHosts = map[string]bool{
+	"169.254.169.254":          true,
+	"169.254.42.42":            true,
+	"metadata.google.internal": true,
+	"metadata":                 true,
+}
+
+// proxyFunc is the http.Transport.Proxy func used by stage0's HTTP
+// clients. It uses --proxy if set, else the proxy environment
+// variables.
+func proxyFunc(req *http.Request) (*url.URL, error) {
+	if noProxyHosts[req.URL.Hostname()] {
+		return nil, nil
+	}
+	if *proxyFlag != "" {
+		return url.Parse(*proxyFlag)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// logProxy logs which proxy, if any, stage0 will use.
+func logProxy() {
+	req, _ := http.NewRequest("GET", "https://farmer.golang.org/", nil)
+	u, err := proxyFunc(req)
+	switch {
+	case err != nil:
+		log.Printf("invalid proxy configuration: %v", err)
+	case u == nil:
+		log.Printf("not using an HTTP proxy")
+	case *proxyFlag != "":
+		log.Printf("using HTTP proxy %s from --proxy", redactURL(u))
+	default:
+		log.Printf("using HTTP proxy %s from environment", redactURL(u))
+	}
+}
+
+// redactURL returns u as a string without any password.
+func redactURL(u *url.URL) string {
+	if _, ok := u.User.Password(); ok {
+		u2 := *u
+		u2.User = url.UserPassword(u.User.Username(), "xxxxx")
+		return u2.String()
+	}
+	return strings.TrimSuffix(u.String(), "/")
+}
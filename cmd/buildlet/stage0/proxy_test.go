@@ -0,0 +1,37 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProxyFunc(t *testing.T) {
+	defer func(old string) { *proxyFlag = old }(*proxyFlag)
+	*proxyFlag = "http://proxy.example.com:3128"
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://storage.googleapis.com/go-builder-data/buildlet.linux-amd64", "http://proxy.example.com:3128"},
+		{"http://169.254.169.254/latest/api/token", ""},
+		{"http://metadata.google.internal/computeMetadata/v1/", ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		u, err := proxyFunc(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("proxy for %s = %q; want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"time"
+)
+
+// downloadRateLimit is the maximum download rate in bytes per second,
+// or 0 for unlimited.
+var downloadRateLimit byteSize
+
+func init() {
+	flag.Var(&downloadRateLimit, "download-rate-limit", "if non-zero, the maximum rate in bytes per second at which to download the buildlet and bootstrap toolchain, such as 500k or 2M")
+}
+
+// rateLimitTransport is an http.RoundTripper that limits the rate at
+// which response bodies can be read to rate bytes per second.
+type rateLimitTransport struct {
+	rt   http.RoundTripper
+	rate int64
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.rt.RoundTrip(req)
+	if err != nil || t.rate <= 0 {
+		return res, err
+	}
+	res.Body = &rateLimitReader{rc: res.Body, b: newTokenBucket(t.rate)}
+	return res, nil
+}
+
+// tokenBucket is a token bucket holding up to one second's worth of
+// bytes at rate bytes per second.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	sleep  func(time.Duration)
+	now    func() time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{
+		rate:  float64(rate),
+		sleep: time.Sleep,
+		now:   time.Now,
+	}
+}
+
+// burst returns the most bytes that may be taken at once.
+func (b *tokenBucket) burst() int {
+	if b.rate < 1 {
+		return 1
+	}
+	return int(b.rate)
+}
+
+// take takes n tokens from the bucket, first sleeping until the
+// bucket would have enough.
+func (b *tokenBucket) take(n int) {
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if max := float64(b.burst()); b.tokens > max {
+			b.tokens = max
+		}
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens < 0 {
+		b.sleep(time.Duration(-b.tokens / b.rate * float64(time.Second)))
+	}
+}
+
+// rateLimitReader wraps a response body, reading from it no faster
+// than its token bucket allows.
+type rateLimitReader struct {
+	rc io.ReadCloser
+	b  *tokenBucket
+}
+
+func (r *rateLimitReader) Read(p []byte) (int, error) {
+	if max := r.b.burst(); len(p) > max {
+		p = p[:max]
+	}
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		r.b.take(n)
+	}
+	return n, err
+}
+
+func (r *rateLimitReader) Close() error { return r.rc.Close() }
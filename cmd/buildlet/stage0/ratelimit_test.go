@@ -0,0 +1,49 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitReader(t *testing.T) {
+	// Simulate time: each sleep advances the clock.
+	var now time.Time
+	var slept time.Duration
+	b := newTokenBucket(1000)
+	b.now = func() time.Time { return now }
+	b.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+	now = time.Unix(1e9, 0)
+
+	const size = 10000
+	r := &rateLimitReader{rc: ioutil.NopCloser(strings.NewReader(strings.Repeat("x", size))), b: b}
+	n, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n) != size {
+		t.Fatalf("read %d bytes; want %d", len(n), size)
+	}
+	// 10000 bytes at 1000 bytes/sec, with no initial burst
+	// allowance, takes 10 seconds.
+	if want := 10 * time.Second; slept < want-time.Millisecond || slept > want+time.Millisecond {
+		t.Errorf("slept %v; want %v", slept, want)
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	if got := newTokenBucket(500 << 10).burst(); got != 500<<10 {
+		t.Errorf("burst = %d; want %d", got, 500<<10)
+	}
+	if got := newTokenBucket(0).burst(); got != 1 {
+		t.Errorf("burst at rate 0 = %d; want 1", got)
+	}
+}
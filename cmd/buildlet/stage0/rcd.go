@@ -0,0 +1,79 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+)
+
+// rcdScriptPath is where --install-rcd writes stage0's rc.d script.
+const rcdScriptPath = "/etc/rc.d/stage0"
+
+// rcdLogFile is where the rc.d script sends stage0's output.
+const rcdLogFile = "/var/log/stage0.log"
+
+// rcdTmpl holds the rc.d script templates, by GOOS. Both run stage0
+// in the background, which itself keeps the buildlet running, and
+// stop it with SIGTERM.
+var rcdTmpl = map[string]*template.Template{
+	"openbsd": template.Must(template.New("openbsd").Parse(`#!/bin/ksh
+#
+# Installed by stage0 --install-rcd. Runs the Go buildlet's stage0.
+
+daemon={{.Exe}}
+daemon_flags="{{.Flags}} >>{{.Log}} 2>&1"
+
+. /etc/rc.d/rc.subr
+
+pexp="${daemon}.*"
+rc_bg=YES
+rc_reload=NO
+
+rc_cmd $1
+`)),
+	"netbsd": template.Must(template.New("netbsd").Parse(`#!/bin/sh
+#
+# PROVIDE: stage0
+# REQUIRE: NETWORKING DAEMON
+# KEYWORD: shutdown
+#
+# Installed by stage0 --install-rcd. Runs the Go buildlet's stage0.
+
+$_rc_subr_loaded . /etc/rc.subr
+
+name="stage0"
+rcvar=$name
+command={{.Exe}}
+command_args="{{.Flags}} >>{{.Log}} 2>&1 &"
+
+load_rc_config $name
+run_rc_command "$1"
+`)),
+}
+
+// rcdScript returns an rc.d script for goos, "netbsd" or "openbsd",
+// that runs the command args, logging to logFile.
+func rcdScript(goos string, args []string, logFile string) []byte {
+	var flags []string
+	for _, a := range args[1:] {
+		flags = append(flags, dquoteEscape(shellQuote(a)))
+	}
+	var b bytes.Buffer
+	err := rcdTmpl[goos].Execute(&b, struct {
+		Exe, Flags, Log string
+	}{shellQuote(args[0]), strings.Join(flags, " "), dquoteEscape(shellQuote(logFile))})
+	if err != nil {
+		panic(err) // can't happen writing to a bytes.Buffer
+	}
+	return b.Bytes()
+}
+
+// dquoteEscape escapes s for use within a double-quoted shell string,
+// which rc.subr later evaluates.
+func dquoteEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s)
+}
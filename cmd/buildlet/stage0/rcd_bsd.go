@@ -0,0 +1,95 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build netbsd || openbsd
+// +build netbsd openbsd
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+var installRCD = flag.Bool("install-rcd", false, "install and start an rc.d script at "+rcdScriptPath+" that runs stage0 at boot with the other flags given, and exit; configuration comes from --stage0-env-file, by default "+defaultStage0EnvFile)
+
+func init() {
+	serviceMain = rcdMain
+}
+
+func rcdMain(run func()) bool {
+	if !*installRCD {
+		return false
+	}
+	if err := installRCDScript(); err != nil {
+		log.Fatalf("installing rc.d script: %v", err)
+	}
+	log.Printf("installed and started %s", rcdScriptPath)
+	return true
+}
+
+func installRCDScript() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{exe}
+	for _, a := range os.Args[1:] {
+		if flagName(a) != "install-rcd" {
+			args = append(args, a)
+		}
+	}
+	envFile := *stage0EnvFile
+	if envFile == "" {
+		envFile = defaultStage0EnvFile
+		args = append(args, "--stage0-env-file="+envFile)
+	}
+	if _, err := os.Stat(envFile); err != nil {
+		log.Printf("WARNING: %v; stage0 won't start until it exists", err)
+	}
+	if err := ioutil.WriteFile(rcdScriptPath, rcdScript(runtime.GOOS, args, rcdLogFile), 0755); err != nil {
+		return err
+	}
+	var cmds [][]string
+	switch runtime.GOOS {
+	case "openbsd":
+		cmds = [][]string{{"rcctl", "enable", "stage0"}, {"rcctl", "start", "stage0"}}
+	case "netbsd":
+		if err := enableNetBSDService("/etc/rc.conf", "stage0"); err != nil {
+			return err
+		}
+		cmds = [][]string{{rcdScriptPath, "start"}}
+	}
+	for _, c := range cmds {
+		if out, err := exec.Command(c[0], c[1:]...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %v: %s", c, err, out)
+		}
+	}
+	return nil
+}
+
+// enableNetBSDService adds name=YES to the rc.conf file, unless it's
+// already there.
+func enableNetBSDService(rcConf, name string) error {
+	b, err := ioutil.ReadFile(rcConf)
+	if err != nil {
+		return err
+	}
+	line := []byte(name + "=YES")
+	for _, l := range bytes.Split(b, []byte("\n")) {
+		if bytes.Equal(bytes.TrimSpace(l), line) {
+			return nil
+		}
+	}
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		b = append(b, '\n')
+	}
+	return ioutil.WriteFile(rcConf, append(append(b, line...), '\n'), 0644)
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRCDScript(t *testing.T) {
+	args := []string{"/usr/local/bin/stage0", "--stage0-env-file=/usr/local/etc/stage0.env", "--note=it's $HOME"}
+	for _, goos := range []string{"netbsd", "openbsd"} {
+		s := string(rcdScript(goos, args, "/var/log/stage0.log"))
+		for _, want := range []string{
+			"/usr/local/bin/stage0\n",
+			`--stage0-env-file=/usr/local/etc/stage0.env '--note=it'\\''s \$HOME' >>/var/log/stage0.log 2>&1`,
+		} {
+			if !strings.Contains(s, want) {
+				t.Errorf("%s script doesn't contain %q:\n%s", goos, want, s)
+			}
+		}
+		if _, err := exec.LookPath("sh"); err == nil {
+			if out, err := exec.Command("sh", "-n", "-c", s).CombinedOutput(); err != nil {
+				t.Errorf("%s script isn't valid sh: %v: %s\n%s", goos, err, out, s)
+			}
+		}
+	}
+}
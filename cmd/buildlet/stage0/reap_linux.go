@@ -0,0 +1,96 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	startReaper = startReaperLinux
+}
+
+// startReaperLinux starts reaping stage0's zombie children other than
+// keep, whose exit status the caller waits for with cmd.Wait. As PID 1,
+// as in a container, stage0 is the parent of any process orphaned by
+// the buildlet, such as a leftover test process, and nothing else
+// would reap it. The returned function stops the reaper.
+func startReaperLinux(keep int) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGCHLD)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			if n := reapZombies(keep); n > 0 {
+				log.Printf("reaped %d orphaned processes", n)
+			}
+			select {
+			case <-c:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+		<-stopped
+	}
+}
+
+// reapZombies reaps stage0's zombie children other than keep,
+// returning how many it reaped. Unlike wait4(-1, ...), it can't steal
+// keep's exit status.
+func reapZombies(keep int) int {
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	me := os.Getpid()
+	n := 0
+	for _, f := range stats {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue // it exited and was reaped
+		}
+		pid, state, ppid, ok := parseProcStat(string(b))
+		if !ok || state != "Z" || ppid != me || pid == keep {
+			continue
+		}
+		var ws syscall.WaitStatus
+		if wpid, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); err == nil && wpid == pid {
+			n++
+		}
+	}
+	return n
+}
+
+// parseProcStat returns the pid, state, and parent pid from stat, the
+// contents of a /proc/<pid>/stat file.
+func parseProcStat(stat string) (pid int, state string, ppid int, ok bool) {
+	// The command name, in parentheses, may contain spaces and
+	// parentheses itself.
+	i := strings.IndexByte(stat, ' ')
+	j := strings.LastIndexByte(stat, ')')
+	if i < 0 || j < i {
+		return 0, "", 0, false
+	}
+	f := strings.Fields(stat[j+1:])
+	if len(f) < 2 {
+		return 0, "", 0, false
+	}
+	pid, err1 := strconv.Atoi(stat[:i])
+	ppid, err2 := strconv.Atoi(f[1])
+	if err1 != nil || err2 != nil {
+		return 0, "", 0, false
+	}
+	return pid, f[0], ppid, true
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	tests := []struct {
+		stat  string
+		pid   int
+		state string
+		ppid  int
+		ok    bool
+	}{
+		{"1234 (go) Z 1 1234 1234 0 -1 4227084", 1234, "Z", 1, true},
+		{"42 (a (weird) name) S 7 42 42 0", 42, "S", 7, true},
+		{"42 (no fields)", 0, "", 0, false},
+		{"garbage", 0, "", 0, false},
+	}
+	for _, tt := range tests {
+		pid, state, ppid, ok := parseProcStat(tt.stat)
+		if pid != tt.pid || state != tt.state || ppid != tt.ppid || ok != tt.ok {
+			t.Errorf("parseProcStat(%q) = %d, %q, %d, %v; want %d, %q, %d, %v", tt.stat, pid, state, ppid, ok, tt.pid, tt.state, tt.ppid, tt.ok)
+		}
+	}
+}
+
+func TestReaper(t *testing.T) {
+	// Have orphans reparented to the test, as they would be to
+	// stage0 as PID 1.
+	const prSetChildSubreaper = 36
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		t.Skipf("PR_SET_CHILD_SUBREAPER: %v", errno)
+	}
+	defer syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 0, 0)
+
+	cmd := helperCommand("orphan")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	stop := startReaperLinux(cmd.Process.Pid)
+	defer stop()
+	line, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading orphan's pid: %v", err)
+	}
+	orphan, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The orphan exits and is reaped, without waiting for the
+	// helper, which has exited too.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat("/proc/" + strconv.Itoa(orphan)); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("orphan %d wasn't reaped", orphan)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The helper's exit status is left for cmd.Wait.
+	err = cmd.Wait()
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != 7 {
+		t.Errorf("helper's cmd.Wait = %v; want exit status 7", err)
+	}
+}
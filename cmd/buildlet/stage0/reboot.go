@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	rebootAfterFailures = flag.Int("reboot-after-failures", 0, "with --loop, reboot the machine after the buildlet fails this many times in a row; 0 means never. Never reboots inside a container.")
+	rebootMinUptime     = flag.Duration("reboot-min-uptime", 30*time.Minute, "don't reboot for --reboot-after-failures until stage0 has been running this long, to avoid reboot loops")
+)
+
+// reboot is rebootMachine, except in tests.
+var reboot = rebootMachine
+
+// inContainer is detectContainer, except in tests.
+var inContainer = detectContainer
+
+// maybeReboot reboots the machine if the buildlet has failed at least
+// --reboot-after-failures times in a row, unless stage0 is running in
+// a container or hasn't been up for --reboot-min-uptime. It only
+// returns if it doesn't reboot, or rebooting fails.
+func maybeReboot(failures int) {
+	n := *rebootAfterFailures
+	if n <= 0 || failures < n {
+		return
+	}
+	if why := inContainer(); why != "" {
+		log.Printf("buildlet failed %d times in a row, but not rebooting: running in a container (%s)", failures, why)
+		return
+	}
+	if up := time.Since(timeStart); up < *rebootMinUptime {
+		log.Printf("buildlet failed %d times in a row, but not rebooting: up only %v, less than --reboot-min-uptime=%v", failures, prettyDuration(up), *rebootMinUptime)
+		return
+	}
+	log.Printf("**************************************************************")
+	log.Printf("*** buildlet failed %d times in a row; REBOOTING THE MACHINE ***", failures)
+	log.Printf("**************************************************************")
+	if err := reboot(); err != nil {
+		log.Printf("reboot failed: %v", err)
+	}
+}
+
+// detectContainer returns a description of why stage0 appears to be
+// running in a container, or the empty string if it doesn't.
+func detectContainer() string {
+	if os.Getenv("IN_KUBERNETES") == "1" {
+		return "IN_KUBERNETES=1"
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "/.dockerenv exists"
+	}
+	if b, err := ioutil.ReadFile("/proc/1/cgroup"); err == nil {
+		if kind := containerCgroup(string(b)); kind != "" {
+			return "/proc/1/cgroup mentions " + kind
+		}
+	}
+	return ""
+}
+
+// containerCgroup returns the container runtime named in the
+// contents of /proc/1/cgroup, if any.
+func containerCgroup(cgroup string) string {
+	for _, kind := range []string{"docker", "kubepods", "containerd", "lxc", "libpod"} {
+		if strings.Contains(cgroup, kind) {
+			return kind
+		}
+	}
+	return ""
+}
@@ -0,0 +1,27 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"os/exec"
+	"syscall"
+)
+
+// rebootMachine syncs filesystems and reboots.
+func rebootMachine() error {
+	syscall.Sync()
+	err := syscall.Reboot(syscall.LINUX_REBOOT_CMD_RESTART)
+	log.Printf("reboot syscall: %v; trying /sbin/reboot", err)
+	return exec.Command("/sbin/reboot").Run()
+}
+
+// haltMachine syncs filesystems and powers off.
+func haltMachine() error {
+	syscall.Sync()
+	err := syscall.Reboot(syscall.LINUX_REBOOT_CMD_POWER_OFF)
+	log.Printf("reboot syscall: %v; trying /sbin/poweroff", err)
+	return exec.Command("/sbin/poweroff").Run()
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// rebootMachine syncs filesystems and reboots.
+func rebootMachine() error {
+	exec.Command("sync").Run()
+	out, err := exec.Command("/sbin/reboot").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("/sbin/reboot: %v: %s", err, out)
+	}
+	return nil
+}
+
+// haltMachine syncs filesystems and powers off.
+func haltMachine() error {
+	exec.Command("sync").Run()
+	cmd := exec.Command("/sbin/halt", "-p")
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("/sbin/shutdown", "-h", "now")
+	case "illumos", "solaris":
+		cmd = exec.Command("/usr/sbin/poweroff")
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", cmd.Path, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaybeReboot(t *testing.T) {
+	rebooted := false
+	reboot = func() error { rebooted = true; return nil }
+	defer func() { reboot = rebootMachine }()
+	container := ""
+	inContainer = func() string { return container }
+	defer func() { inContainer = detectContainer }()
+	defer func(n int, d time.Duration) { *rebootAfterFailures, *rebootMinUptime = n, d }(*rebootAfterFailures, *rebootMinUptime)
+
+	tests := []struct {
+		after     int
+		minUptime time.Duration
+		container string
+		failures  int
+		want      bool
+	}{
+		{after: 0, failures: 100, want: false},
+		{after: 3, failures: 2, want: false},
+		{after: 3, failures: 3, want: true},
+		{after: 3, failures: 4, want: true},
+		{after: 3, failures: 3, container: "IN_KUBERNETES=1", want: false},
+		{after: 3, failures: 3, minUptime: time.Hour, want: false},
+	}
+	for _, tt := range tests {
+		rebooted = false
+		*rebootAfterFailures, *rebootMinUptime, container = tt.after, tt.minUptime, tt.container
+		maybeReboot(tt.failures)
+		if rebooted != tt.want {
+			t.Errorf("after=%d minUptime=%v container=%q failures=%d: rebooted = %v; want %v",
+				tt.after, tt.minUptime, tt.container, tt.failures, rebooted, tt.want)
+		}
+	}
+}
+
+func TestContainerCgroup(t *testing.T) {
+	tests := []struct {
+		cgroup, want string
+	}{
+		{"12:pids:/init.scope\n1:name=systemd:/init.scope\n", ""},
+		{"0::/\n", ""},
+		{"11:memory:/docker/3f2a9c\n", "docker"},
+		{"9:cpu:/kubepods/besteffort/pod1234/abcd\n", "kubepods"},
+		{"1:name=systemd:/lxc/builder\n", "lxc"},
+	}
+	for _, tt := range tests {
+		if got := containerCgroup(tt.cgroup); got != tt.want {
+			t.Errorf("containerCgroup(%q) = %q; want %q", tt.cgroup, got, tt.want)
+		}
+	}
+}
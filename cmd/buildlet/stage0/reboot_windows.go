@@ -0,0 +1,28 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// rebootMachine reboots immediately, forcing applications to close.
+func rebootMachine() error {
+	out, err := exec.Command("shutdown", "/r", "/f", "/t", "0").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("shutdown /r: %v: %s", err, out)
+	}
+	return nil
+}
+
+// haltMachine powers off immediately, forcing applications to close.
+func haltMachine() error {
+	out, err := exec.Command("shutdown", "/s", "/f", "/t", "0").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("shutdown /s: %v: %s", err, out)
+	}
+	return nil
+}
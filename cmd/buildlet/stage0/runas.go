@@ -0,0 +1,65 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+var runAsUserFlag = flag.String("run-as-user", "", "if non-empty, the user to run the buildlet as, such as gopher; stage0 itself must run as root")
+
+// A runAsUser is the user from --run-as-user.
+type runAsUser struct {
+	name, home string
+	uid, gid   uint32
+	groups     []uint32 // supplementary
+}
+
+// lookupRunAsUser returns the user named by --run-as-user, or nil if
+// it's not set.
+func lookupRunAsUser() (*runAsUser, error) {
+	if *runAsUserFlag == "" {
+		return nil, nil
+	}
+	if !runAsSupported {
+		return nil, fmt.Errorf("--run-as-user isn't supported on %s", osArch)
+	}
+	u, err := user.Lookup(*runAsUserFlag)
+	if err != nil {
+		return nil, err
+	}
+	ru := &runAsUser{name: u.Username, home: u.HomeDir}
+	if ru.uid, err = parseID(u.Uid); err != nil {
+		return nil, fmt.Errorf("user %s: uid: %v", u.Username, err)
+	}
+	if ru.gid, err = parseID(u.Gid); err != nil {
+		return nil, fmt.Errorf("user %s: gid: %v", u.Username, err)
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("user %s: groups: %v", u.Username, err)
+	}
+	for _, g := range gids {
+		id, err := parseID(g)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: group %q: %v", u.Username, g, err)
+		}
+		ru.groups = append(ru.groups, id)
+	}
+	return ru, nil
+}
+
+func parseID(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	return uint32(n), err
+}
+
+// env returns the USER, LOGNAME, and HOME settings for u.
+func (u *runAsUser) env() []string {
+	return []string{"USER=" + u.name, "LOGNAME=" + u.name, "HOME=" + u.home}
+}
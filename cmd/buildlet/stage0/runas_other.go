@@ -0,0 +1,25 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !illumos && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!illumos,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// runAsSupported is whether --run-as-user works here. It doesn't on
+// Windows or Plan 9, which have no setuid.
+const runAsSupported = false
+
+func setCredential(cmd *exec.Cmd, u *runAsUser) {
+	panic("unreachable")
+}
+
+func chownTree(dir string, u *runAsUser) error {
+	return errors.New("--run-as-user unsupported")
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os/user"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLookupRunAsUser(t *testing.T) {
+	defer func(v string) { *runAsUserFlag = v }(*runAsUserFlag)
+
+	*runAsUserFlag = ""
+	if u, err := lookupRunAsUser(); u != nil || err != nil {
+		t.Errorf("without --run-as-user: %v, %v; want nil, nil", u, err)
+	}
+
+	if !runAsSupported {
+		*runAsUserFlag = "gopher"
+		if _, err := lookupRunAsUser(); err == nil || !strings.Contains(err.Error(), "isn't supported") {
+			t.Errorf("error = %v; want unsupported error", err)
+		}
+		return
+	}
+
+	cur, err := user.Current()
+	if err != nil {
+		t.Skipf("user.Current: %v", err)
+	}
+	*runAsUserFlag = cur.Username
+	u, err := lookupRunAsUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strconv.Itoa(int(u.uid)) != cur.Uid || strconv.Itoa(int(u.gid)) != cur.Gid {
+		t.Errorf("uid, gid = %d, %d; want %s, %s", u.uid, u.gid, cur.Uid, cur.Gid)
+	}
+	env := strings.Join(buildletEnv(0, 0), " ")
+	for _, want := range []string{"USER=" + cur.Username, "HOME=" + cur.HomeDir} {
+		if !strings.Contains(env, want) {
+			t.Errorf("buildletEnv = %s; want %s", env, want)
+		}
+	}
+
+	*runAsUserFlag = "no-such-user-stage0-test"
+	if _, err := lookupRunAsUser(); err == nil {
+		t.Error("lookup of nonexistent user succeeded")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// scalewayMetadataURL is the Scaleway instance metadata endpoint. It's
+// a variable for tests.
+var scalewayMetadataURL = "http://169.254.42.42/conf?format=json"
+
+var scalewayClient = &http.Client{
+	Timeout:   metaProbeTimeout,
+	Transport: &http.Transport{}, // no proxy
+}
+
+// scalewayMetadata is the subset of the Scaleway instance metadata
+// used by stage0.
+type scalewayMetadata struct {
+	ID       string   `json:"id"`
+	Hostname string   `json:"hostname"`
+	Tags     []string `json:"tags"`
+}
+
+// scalewayURLTag is the prefix of the optional instance tag giving the
+// buildlet URL, as in "buildlet-url=https://...".
+const scalewayURLTag = "buildlet-url="
+
+// scaleway is the cached Scaleway metadata.
+var scaleway struct {
+	once sync.Once
+	md   *scalewayMetadata // nil if unavailable
+}
+
+// isScalewayHost reports whether GO_BUILDER_ENV says this is a
+// Scaleway host.
+func isScalewayHost() bool {
+	return os.Getenv("GO_BUILDER_ENV") == "host-linux-arm-scaleway"
+}
+
+// scalewayMeta returns the Scaleway instance metadata, or nil if this
+// isn't a Scaleway host or the metadata couldn't be fetched.
+func scalewayMeta() *scalewayMetadata {
+	if !isScalewayHost() {
+		return nil
+	}
+	scaleway.once.Do(func() {
+		md, err := fetchScalewayMetadata()
+		if err != nil {
+			log.Printf("fetching Scaleway metadata: %v; using the environment", err)
+			return
+		}
+		scaleway.md = md
+	})
+	return scaleway.md
+}
+
+func fetchScalewayMetadata() (*scalewayMetadata, error) {
+	res, err := scalewayClient.Get(scalewayMetadataURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %v", scalewayMetadataURL, res.Status)
+	}
+	md := new(scalewayMetadata)
+	if err := json.NewDecoder(res.Body).Decode(md); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", scalewayMetadataURL, err)
+	}
+	return md, nil
+}
+
+// buildletURL returns the value of md's buildlet-url tag, if any.
+func (md *scalewayMetadata) buildletURL() string {
+	for _, t := range md.Tags {
+		if strings.HasPrefix(t, scalewayURLTag) {
+			return strings.TrimSpace(strings.TrimPrefix(t, scalewayURLTag))
+		}
+	}
+	return ""
+}
+
+// adjustScaleway applies the Scaleway instance metadata, if any, to h.
+// Without it, the hostname comes from $HOSTNAME, as set by whatever
+// started stage0.
+func adjustScaleway(h *hostConfig, env string) {
+	md := scalewayMeta()
+	if md == nil {
+		return
+	}
+	if md.Hostname != "" {
+		h.Hostname = md.Hostname
+	}
+	if v := md.buildletURL(); v != "" {
+		h.URL = expandBuildletURL(v)
+	}
+}
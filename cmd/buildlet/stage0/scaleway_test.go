@@ -0,0 +1,85 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestFetchScalewayMetadata(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+  "id": "2f1e4d8c-5b7a-4e0b-9d3c-6a1f0e2b3c4d",
+  "name": "scw-golang-arm-7",
+  "hostname": "scw-golang-arm-7",
+  "commercial_type": "C1",
+  "tags": ["golang", "buildlet-url=https://example.com/buildlet.linux-arm"]
+}`)
+	}))
+	defer ts.Close()
+	defer func(old string) { scalewayMetadataURL = old }(scalewayMetadataURL)
+	scalewayMetadataURL = ts.URL
+
+	md, err := fetchScalewayMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := md.Hostname, "scw-golang-arm-7"; got != want {
+		t.Errorf("Hostname = %q; want %q", got, want)
+	}
+	if got, want := md.ID, "2f1e4d8c-5b7a-4e0b-9d3c-6a1f0e2b3c4d"; got != want {
+		t.Errorf("ID = %q; want %q", got, want)
+	}
+	if got, want := md.buildletURL(), "https://example.com/buildlet.linux-arm"; got != want {
+		t.Errorf("buildlet-url tag = %q; want %q", got, want)
+	}
+	if got := (&scalewayMetadata{Tags: []string{"golang"}}).buildletURL(); got != "" {
+		t.Errorf("buildlet-url without the tag = %q", got)
+	}
+}
+
+func TestAdjustScaleway(t *testing.T) {
+	for _, k := range []string{"GO_BUILDER_ENV", "HOSTNAME"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("GO_BUILDER_ENV", "host-linux-arm-scaleway")
+	os.Setenv("HOSTNAME", "from-env")
+	reset := func() {
+		scaleway.once = sync.Once{}
+		scaleway.md = nil
+	}
+	defer reset()
+	defer func(old string) { scalewayMetadataURL = old }(scalewayMetadataURL)
+
+	up := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"hostname": "scw-golang-arm-7", "tags": ["buildlet-url=https://example.com/b"]}`)
+	}))
+	defer ts.Close()
+	scalewayMetadataURL = ts.URL
+
+	adjusted := func() hostConfig {
+		reset()
+		c := *hosts["host-linux-arm-scaleway"]
+		c.Adjust(&c, "host-linux-arm-scaleway")
+		return c
+	}
+	if c := adjusted(); c.hostname() != "scw-golang-arm-7" || c.URL != "https://example.com/b" {
+		t.Errorf("with metadata: hostname %q, URL %q", c.hostname(), c.URL)
+	}
+	up = false
+	if c := adjusted(); c.hostname() != "from-env" || c.URL != "" {
+		t.Errorf("without metadata: hostname %q, URL %q; want $HOSTNAME and no URL", c.hostname(), c.URL)
+	}
+}
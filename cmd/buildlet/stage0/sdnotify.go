@@ -0,0 +1,92 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends state, such as "READY=1", to systemd's notification
+// socket, per sd_notify(3). It does nothing if $NOTIFY_SOCKET isn't
+// set, as when stage0 isn't run by systemd as a Type=notify unit.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if strings.HasPrefix(addr, "@") {
+		// An abstract socket.
+		addr = "\x00" + addr[1:]
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write([]byte(state))
+	return err
+}
+
+// phaseStatus is the systemd unit status shown for each phase.
+var phaseStatus = map[string]string{
+	"network":  "waiting for network",
+	"resolve":  "looking up buildlet URL",
+	"download": "downloading buildlet",
+	"exec":     "starting buildlet",
+}
+
+// notifyPhase tells systemd what stage0 is doing, if it's listening.
+func notifyPhase(phase string) {
+	if s, ok := phaseStatus[phase]; ok {
+		if err := sdNotify("STATUS=" + s); err != nil {
+			log.Printf("sd_notify: %v", err)
+		}
+	}
+}
+
+// notifyReady tells systemd, if it's listening, that the buildlet is
+// running.
+func notifyReady(pid int) {
+	if err := sdNotify("READY=1\nSTATUS=buildlet running (pid " + strconv.Itoa(pid) + ")"); err != nil {
+		log.Printf("sd_notify: %v", err)
+	}
+}
+
+// sdWatchdogInterval returns how often systemd expects WATCHDOG=1
+// pings, per $WATCHDOG_USEC and $WATCHDOG_PID, or 0 if it doesn't.
+// It pings at half the watchdog timeout, as sd_watchdog_enabled(3)
+// recommends.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if p := os.Getenv("WATCHDOG_PID"); p != "" && p != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// startSDWatchdog starts pinging systemd's watchdog, if it's
+// configured with WatchdogSec.
+func startSDWatchdog() {
+	d := sdWatchdogInterval()
+	if d == 0 || os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	log.Printf("pinging systemd watchdog every %v", d)
+	go func() {
+		for range time.Tick(d) {
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Printf("sd_notify: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSDNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unixgram sockets on Windows")
+	}
+	dir, err := ioutil.TempDir("", "stage0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "notify")
+	c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("can't listen on unixgram socket: %v", err)
+	}
+	defer c.Close()
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+	os.Setenv("NOTIFY_SOCKET", sock)
+
+	read := func() string {
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1024)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+	setPhase("download")
+	if got, want := read(), "STATUS=downloading buildlet"; got != want {
+		t.Errorf("after setPhase: got %q; want %q", got, want)
+	}
+	notifyReady(123)
+	if got, want := read(), "READY=1\nSTATUS=buildlet running (pid 123)"; got != want {
+		t.Errorf("after notifyReady: got %q; want %q", got, want)
+	}
+}
+
+func TestSDNotifyUnset(t *testing.T) {
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+	os.Unsetenv("NOTIFY_SOCKET")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify without $NOTIFY_SOCKET = %v; want nil", err)
+	}
+}
+
+func TestSDWatchdogInterval(t *testing.T) {
+	for _, k := range []string{"WATCHDOG_USEC", "WATCHDOG_PID"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"junk", "", 0},
+		{"30000000", "", 15 * time.Second},
+		{"30000000", pid, 15 * time.Second},
+		{"30000000", "1", 0},
+	}
+	for _, tt := range tests {
+		os.Setenv("WATCHDOG_USEC", tt.usec)
+		os.Setenv("WATCHDOG_PID", tt.pid)
+		if got := sdWatchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: interval = %v; want %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
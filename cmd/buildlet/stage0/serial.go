@@ -0,0 +1,148 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serialWriteTimeout bounds how long a log write to a serial console
+// may block, as when nothing drains the port and flow control stops
+// it. Writes that time out are dropped.
+var serialWriteTimeout = time.Second
+
+// serialTTYPrefixes are the names, less their unit numbers, of Linux
+// serial devices the kernel console can be on.
+var serialTTYPrefixes = []string{"ttyS", "ttyAMA", "ttymxc", "ttySAC", "ttyO", "ttyMSM", "ttyPS", "ttyLP", "ttyUSB", "hvc"}
+
+// serialConsole returns the device of the last serial kernel console
+// given by the console= parameters in cmdline, the contents of
+// /proc/cmdline, or the empty string if there's none. Non-serial
+// consoles, such as tty0, are skipped.
+func serialConsole(cmdline string) string {
+	dev := ""
+	for _, f := range strings.Fields(cmdline) {
+		if !strings.HasPrefix(f, "console=") {
+			continue
+		}
+		name := strings.TrimPrefix(f, "console=")
+		if i := strings.Index(name, ","); i >= 0 {
+			name = name[:i] // options, such as 115200n8
+		}
+		if isSerialTTY(name) {
+			dev = "/dev/" + name
+		}
+	}
+	return dev
+}
+
+// isSerialTTY reports whether name, such as ttyS0, is a serial
+// device.
+func isSerialTTY(name string) bool {
+	for _, p := range serialTTYPrefixes {
+		if !strings.HasPrefix(name, p) || len(name) == len(p) {
+			continue
+		}
+		if strings.Trim(name[len(p):], "0123456789") == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// serialLog, if non-nil, is the serial console logs are mirrored to,
+// set by configureSerialLogOutput. Unless the buildlet inherits the
+// port (see inheritSerialLog), its output is copied there too (see
+// teeWriter), so it needn't open the port itself.
+var serialLog *serialWriter
+
+// inheritSerialLog is set non-nil on platforms where the buildlet can
+// inherit stage0's handle to the serial port, as on Windows, where
+// only one process can open it. It arranges for cmd to inherit
+// serialLog's port and returns the KEY=VALUE environment variable
+// telling the buildlet about it, or the empty string if it can't.
+var inheritSerialLog func(cmd *exec.Cmd) string
+
+// serialHeldSize is how much of stage0's own logging is held while the
+// buildlet has the serial port. See serialWriter.handOff.
+const serialHeldSize = 64 << 10
+
+// serialWriter writes logs to a serial device. Like rotatingFile, its
+// writes never fail or block for long: a write that takes longer than
+// serialWriteTimeout is dropped, and after any other error it reports
+// the error to stderr once and drops further writes.
+type serialWriter struct {
+	name string
+
+	mu     sync.Mutex
+	w      io.Writer // the port; its write deadline is set, if it has one
+	failed bool
+	held   *tailBuffer // non-nil while handed off
+}
+
+func (w *serialWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.held != nil {
+		w.held.Write(p)
+		return len(p), nil
+	}
+	return w.write(p)
+}
+
+// write writes p to the port. w.mu must be held.
+func (w *serialWriter) write(p []byte) (int, error) {
+	if w.failed {
+		return len(p), nil
+	}
+	if d, ok := w.w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		d.SetWriteDeadline(time.Now().Add(serialWriteTimeout)) // fails for unpollable files; fine
+	}
+	if _, err := w.w.Write(p); err != nil && !os.IsTimeout(err) {
+		w.failed = true
+		fmt.Fprintf(os.Stderr, "stage0: WARNING: writing to %s failed; no longer logging to it: %v\n", w.name, err)
+	}
+	return len(p), nil
+}
+
+// relay writes the buildlet's output p, unless the buildlet has the
+// port and is writing to it itself.
+func (w *serialWriter) relay(p []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.held == nil {
+		w.write(p)
+	}
+}
+
+// handOff makes w hold stage0's writes, rather than interleaving them
+// with those of the buildlet, which has inherited the port, until
+// reclaim.
+func (w *serialWriter) handOff() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.held = &tailBuffer{max: serialHeldSize}
+}
+
+// reclaim writes what w held since handOff, once the buildlet is done
+// with the port, and resumes writing to it directly.
+func (w *serialWriter) reclaim() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.held == nil {
+		return
+	}
+	if w.held.full() {
+		w.write([]byte("stage0: (earlier logs from while the buildlet had the port dropped)\n"))
+	}
+	w.write([]byte(w.held.String()))
+	w.held = nil
+}
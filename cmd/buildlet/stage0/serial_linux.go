@@ -0,0 +1,52 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"syscall"
+)
+
+var serialLogFlag = flag.String("serial-log", "", `if non-empty, a serial device, such as /dev/ttyS0 or /dev/ttyAMA0, to also write logs to, for headless boards whose console doesn't show stdout; "auto" means the kernel's serial console, per /proc/cmdline`)
+
+func init() {
+	configureSerialLogOutput = configureSerialLogOutputLinux
+}
+
+// configureSerialLogOutputLinux starts mirroring logs to --serial-log,
+// if set. If the device is absent or busy, it logs why and logs go
+// only to stdout and stderr.
+func configureSerialLogOutputLinux() {
+	if serialLog != nil || *serialLogFlag == "" {
+		return
+	}
+	dev := *serialLogFlag
+	if dev == "auto" {
+		cmdline, err := ioutil.ReadFile("/proc/cmdline")
+		if err != nil {
+			log.Printf("not logging to the serial console: %v", err)
+			return
+		}
+		if dev = serialConsole(string(cmdline)); dev == "" {
+			log.Printf("not logging to the serial console: the kernel command line has no serial console=")
+			return
+		}
+	}
+	// The kernel configured the console's speed, so leave its
+	// terminal settings alone. O_NOCTTY keeps it from becoming
+	// stage0's controlling terminal.
+	f, err := os.OpenFile(dev, os.O_WRONLY|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		log.Printf("not logging to serial console %s: %v", dev, err)
+		return
+	}
+	serialLog = &serialWriter{name: dev, w: f}
+	logSinks = append(logSinks, serialLog)
+	applyLogOutput()
+	log.Printf("logging to serial console %s", dev)
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSerialConsole(t *testing.T) {
+	tests := []struct {
+		cmdline, want string
+	}{
+		{"", ""},
+		{"root=/dev/sda1 ro quiet", ""},
+		{"console=tty0", ""},
+		{"BOOT_IMAGE=/vmlinuz console=ttyS0,115200n8 ro", "/dev/ttyS0"},
+		{"console=ttyAMA0,115200 console=tty1", "/dev/ttyAMA0"},
+		{"console=tty1 console=ttyS1 console=hvc0", "/dev/hvc0"},
+		{"earlycon=uart8250,mmio32,0x1c28000 console=ttyS", ""},
+		{"console=ttySx", ""},
+		{"console=null", ""},
+	}
+	for _, tt := range tests {
+		if got := serialConsole(tt.cmdline); got != tt.want {
+			t.Errorf("serialConsole(%q) = %q; want %q", tt.cmdline, got, tt.want)
+		}
+	}
+}
+
+func TestSerialWriter(t *testing.T) {
+	defer func(d time.Duration) { serialWriteTimeout = d }(serialWriteTimeout)
+	serialWriteTimeout = 50 * time.Millisecond
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	sw := &serialWriter{name: "pipe", w: w}
+
+	// Writes to a port nothing drains are dropped, not blocked on.
+	big := bytes.Repeat([]byte("x"), 1<<20)
+	done := make(chan bool)
+	go func() {
+		sw.Write(big)
+		sw.Write(big)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("serialWriter.Write blocked")
+	}
+	if sw.failed {
+		t.Error("timed-out write disabled serialWriter")
+	}
+
+	// Other errors, such as the port going away, disable it.
+	w.Close()
+	if n, err := sw.Write([]byte("hello\n")); n != 6 || err != nil {
+		t.Errorf("Write after close = %d, %v; want 6, nil", n, err)
+	}
+	if !sw.failed {
+		t.Error("serialWriter still enabled after write error")
+	}
+}
+
+func TestSerialWriterHandOff(t *testing.T) {
+	var port bytes.Buffer
+	sw := &serialWriter{name: "COM1", w: &port}
+	sw.Write([]byte("before\n"))
+	sw.relay([]byte("buildlet relayed\n"))
+
+	// While the buildlet has the port, stage0's writes are held
+	// and its output isn't relayed: the buildlet writes it itself.
+	sw.handOff()
+	sw.Write([]byte("held\n"))
+	sw.relay([]byte("buildlet direct\n"))
+	port.WriteString("(buildlet)\n")
+
+	sw.reclaim()
+	sw.Write([]byte("after\n"))
+	const want = "before\nbuildlet relayed\n(buildlet)\nheld\nafter\n"
+	if got := port.String(); got != want {
+		t.Errorf("port got %q; want %q", got, want)
+	}
+
+	// Held writes beyond serialHeldSize are dropped, with a note.
+	port.Reset()
+	sw.handOff()
+	sw.Write(bytes.Repeat([]byte("x"), serialHeldSize+1))
+	sw.reclaim()
+	if got := port.String(); !strings.HasPrefix(got, "stage0: (earlier logs") || len(got) < serialHeldSize {
+		t.Errorf("after overflow, port got %.60q... (%d bytes)", got, len(got))
+	}
+}
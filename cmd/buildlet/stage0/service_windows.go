@@ -0,0 +1,188 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name stage0 is installed under as a Windows
+// service, and its event log source.
+const serviceName = "GoBuildletStage0"
+
+var (
+	installService   = flag.Bool("install-service", false, "install stage0 as a Windows service that starts at boot, with the other flags given, and exit")
+	uninstallService = flag.Bool("uninstall-service", false, "remove the Windows service installed by --install-service, and exit")
+	serviceFlag      = flag.Bool("service", false, "run under the Windows service control manager; used by --install-service")
+)
+
+func init() {
+	serviceMain = windowsServiceMain
+}
+
+func windowsServiceMain(run func()) bool {
+	switch {
+	case *installService:
+		if err := installWindowsService(); err != nil {
+			log.Fatalf("installing service: %v", err)
+		}
+		log.Printf("installed service %s", serviceName)
+		return true
+	case *uninstallService:
+		if err := uninstallWindowsService(); err != nil {
+			log.Fatalf("uninstalling service: %v", err)
+		}
+		log.Printf("uninstalled service %s", serviceName)
+		return true
+	case *serviceFlag:
+		runningAsService = true
+		if el, err := eventlog.Open(serviceName); err != nil {
+			log.Printf("opening event log: %v", err)
+		} else {
+			defer el.Close()
+			logSinks = append(logSinks, eventLogWriter{el})
+			applyLogOutput()
+		}
+		if err := svc.Run(serviceName, stage0Service{run}); err != nil {
+			log.Fatalf("running service: %v", err)
+		}
+		return true
+	}
+	return false
+}
+
+// serviceArgs returns stage0's arguments for running as a service:
+// args without the install flag, plus --service.
+func serviceArgs(args []string) []string {
+	out := []string{"--service"}
+	for _, a := range args {
+		if flagName(a) != "install-service" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func installWindowsService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Go buildlet stage0",
+		Description: "Downloads and runs the Go build system's buildlet.",
+		StartType:   mgr.StartAutomatic,
+	}, serviceArgs(os.Args[1:])...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("setting up event log source: %v", err)
+	}
+	return nil
+}
+
+func uninstallWindowsService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s isn't installed: %v", serviceName, err)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		log.Printf("removing event log source: %v", err)
+	}
+	return nil
+}
+
+// stage0Service is a svc.Handler that runs stage0 as a service.
+type stage0Service struct {
+	run func()
+}
+
+func (s stage0Service) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (svcSpecificEC bool, exitCode uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	changes <- svc.Status{State: svc.StartPending}
+
+	// When the buildlet exits after a stop request, waitBuildlet
+	// calls exit. Report its status to the service control
+	// manager by returning from Execute, instead of exiting
+	// out from under it.
+	exitc := make(chan int, 1)
+	exit = func(code int) {
+		exitc <- code
+		select {}
+	}
+	go func() {
+		s.run()
+		exitc <- 0
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	for {
+		select {
+		case code := <-exitc:
+			return false, uint32(code)
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				log.Printf("service stop requested")
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32((*stopGrace + 10*time.Second) / time.Millisecond)}
+				// Services have no console to send a
+				// CTRL_BREAK_EVENT with, so this usually
+				// kills the buildlet after
+				// --stop-grace-period.
+				if !requestStop(os.Interrupt) {
+					return false, 0
+				}
+			}
+		}
+	}
+}
+
+// eventLogWriter is an io.Writer that writes each log entry to the
+// Windows event log, as a warning if it looks like one.
+type eventLogWriter struct {
+	el *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if strings.Contains(msg, "WARNING") || strings.Contains(msg, `"level":"warn"`) {
+		w.el.Warning(1, msg)
+	} else {
+		w.el.Info(1, msg)
+	}
+	return len(p), nil
+}
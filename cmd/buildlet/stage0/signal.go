@@ -0,0 +1,118 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"time"
+)
+
+var stopGrace = flag.Duration("stop-grace-period", 30*time.Second, "on SIGTERM or SIGINT, how long to wait for the buildlet to exit after passing the signal on before killing it")
+
+// exit is os.Exit, except in tests and Windows services.
+var exit = os.Exit
+
+// stopc receives requests to stop stage0 while the buildlet runs:
+// signals and, on Windows, service stop requests.
+var stopc = make(chan os.Signal, 1)
+
+// buildletRunning is 1 while waitBuildlet is waiting for the buildlet.
+var buildletRunning int32
+
+// requestStop asks waitBuildlet to stop the buildlet as if stage0 had
+// received sig. It reports false if no buildlet is running.
+func requestStop(sig os.Signal) bool {
+	if atomic.LoadInt32(&buildletRunning) == 0 {
+		return false
+	}
+	select {
+	case stopc <- sig:
+	default:
+	}
+	return true
+}
+
+// waitBuildlet waits for the started buildlet cmd to exit. If stage0
+// is asked to stop meanwhile, the request is passed on to the
+// buildlet's process group; if the buildlet hasn't exited after
+// --stop-grace-period, the group is killed. Either way, stage0 then
+// exits with the buildlet's status rather than returning.
+//
+// If the buildlet's heartbeat goes stale (see startWatchdog), its
+// process group is killed and waitBuildlet returns an error.
+func waitBuildlet(cmd *exec.Cmd) error {
+	signal.Notify(stopc, stopSignals...)
+	defer signal.Stop(stopc)
+	atomic.StoreInt32(&buildletRunning, 1)
+	defer atomic.StoreInt32(&buildletRunning, 0)
+	stale, stop := startWatchdog(heartbeatFile(), *watchdogTimeout)
+	defer stop()
+	if startReaper != nil && os.Getpid() == 1 {
+		stopReaper := startReaper(cmd.Process.Pid)
+		defer stopReaper()
+	}
+	return waitBuildletSignals(cmd, stopc, stale)
+}
+
+// startReaper is set non-nil on platforms where stage0 reaps orphaned
+// processes while the buildlet runs, when it's PID 1. It starts
+// reaping zombie children other than keep, the buildlet, and returns
+// a function that stops it.
+var startReaper func(keep int) (stop func())
+
+func waitBuildletSignals(cmd *exec.Cmd, sigc <-chan os.Signal, stale <-chan time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var (
+		stopSig  os.Signal
+		kill     <-chan time.Time
+		staleAge time.Duration
+	)
+	for {
+		select {
+		case err := <-done:
+			if stopSig == nil {
+				if staleAge != 0 {
+					return fmt.Errorf("watchdog killed buildlet after %v without a heartbeat", prettyDuration(staleAge))
+				}
+				return err
+			}
+			code := exitCode(cmd.ProcessState)
+			log.Printf("buildlet exited after %v: %v; exiting with status %d", stopSig, cmd.ProcessState, code)
+			exit(code)
+			return err
+		case sig := <-sigc:
+			if stopSig == nil {
+				log.Printf("received %v; passing it on to the buildlet (pid %d) and waiting up to %v for it to exit", sig, cmd.Process.Pid, *stopGrace)
+				sdNotify("STOPPING=1")
+				kill = time.After(*stopGrace)
+			} else {
+				log.Printf("received %v again; passing it on to the buildlet", sig)
+			}
+			stopSig = sig
+			if err := signalGroup(cmd.Process, sig); err != nil {
+				log.Printf("signaling buildlet: %v", err)
+			}
+		case age := <-stale:
+			log.Printf("watchdog: killing buildlet (pid %d), which has gone %v without a heartbeat", cmd.Process.Pid, prettyDuration(age))
+			staleAge = age
+			if err := killGroup(cmd.Process); err != nil {
+				log.Printf("killing buildlet: %v", err)
+			}
+		case <-kill:
+			log.Printf("buildlet didn't exit within %v of %v; killing it", *stopGrace, stopSig)
+			kill = nil
+			if err := killGroup(cmd.Process); err != nil {
+				log.Printf("killing buildlet: %v", err)
+			}
+		}
+	}
+}
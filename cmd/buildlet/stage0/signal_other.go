@@ -0,0 +1,45 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !illumos && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!illumos,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// stopSignals are the signals that ask stage0 to stop. See waitBuildlet.
+var stopSignals = []os.Signal{os.Interrupt}
+
+// setProcessGroup does nothing; process groups aren't supported here.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup sends sig to p alone.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
+
+// killGroup kills p alone.
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// exitCode returns ps's exit code, or 1 if it has none.
+func exitCode(ps *os.ProcessState) int {
+	if c := ps.ExitCode(); c >= 0 {
+		return c
+	}
+	return 1
+}
+
+// exitReason describes how ps's process ended.
+func exitReason(ps *os.ProcessState) string {
+	return ps.String()
+}
+
+// killedBySIGKILL reports false, as the signal can't be determined.
+func killedBySIGKILL(ps *os.ProcessState) bool { return false }
@@ -0,0 +1,113 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd illumos linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startScript starts a shell running script in its own process
+// group and waits for it to print a line, to know its traps are set.
+func startScript(t *testing.T, script string) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", script)
+	setProcessGroup(cmd)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't run /bin/sh: %v", err)
+	}
+	if _, err := out.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("reading script's ready line: %v", err)
+	}
+	return cmd
+}
+
+func TestWaitBuildletForwardsSignal(t *testing.T) {
+	code := -1
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+
+	cmd := startScript(t, `trap 'exit 3' TERM; echo ready; while :; do sleep 0.1; done`)
+	sigc := make(chan os.Signal, 1)
+	sigc <- syscall.SIGTERM
+	if err := waitBuildletSignals(cmd, sigc, nil); err == nil {
+		t.Error("waitBuildletSignals = nil; want the exit error")
+	}
+	if code != 3 {
+		t.Errorf("exit status = %d; want 3", code)
+	}
+}
+
+func TestWaitBuildletKillsAfterGrace(t *testing.T) {
+	code := -1
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+	defer func(d time.Duration) { *stopGrace = d }(*stopGrace)
+	*stopGrace = 100 * time.Millisecond
+
+	cmd := startScript(t, `trap '' TERM; echo ready; sleep 30`)
+	sigc := make(chan os.Signal, 1)
+	sigc <- syscall.SIGTERM
+	done := make(chan struct{})
+	go func() {
+		waitBuildletSignals(cmd, sigc, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("buildlet wasn't killed after the grace period")
+	}
+	if want := 128 + int(syscall.SIGKILL); code != want {
+		t.Errorf("exit status = %d; want %d", code, want)
+	}
+}
+
+func TestWaitBuildletNoSignal(t *testing.T) {
+	exit = func(c int) { t.Errorf("exit(%d) called without a signal", c) }
+	defer func() { exit = os.Exit }()
+
+	cmd := startScript(t, `echo ready; exit 0`)
+	if err := waitBuildletSignals(cmd, make(chan os.Signal), nil); err != nil {
+		t.Errorf("waitBuildletSignals = %v; want nil", err)
+	}
+}
+
+func TestWaitBuildletWatchdog(t *testing.T) {
+	exit = func(c int) { t.Errorf("exit(%d) called without a signal", c) }
+	defer func() { exit = os.Exit }()
+
+	cmd := startScript(t, `echo ready; sleep 30`)
+	stale := make(chan time.Duration, 1)
+	stale <- 20 * time.Minute
+	err := waitBuildletSignals(cmd, make(chan os.Signal), stale)
+	if err == nil || !strings.Contains(err.Error(), "watchdog") {
+		t.Errorf("waitBuildletSignals = %v; want watchdog error", err)
+	}
+}
+
+func TestExitReasonSignal(t *testing.T) {
+	cmd := helperCommand("kill")
+	if err := cmd.Run(); err == nil {
+		t.Fatal("helper succeeded; want it killed")
+	}
+	if got, want := exitReason(cmd.ProcessState), "killed by signal 9 (killed)"; got != want {
+		t.Errorf("exitReason = %q; want %q", got, want)
+	}
+	if !killedBySIGKILL(cmd.ProcessState) {
+		t.Error("killedBySIGKILL = false; want true")
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// stopSignals are the signals that ask stage0 to stop. See waitBuildlet.
+// Go delivers SIGTERM on Windows for console close, logoff, and
+// shutdown events.
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+var procGenerateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// setProcessGroup makes cmd start in its own console process group,
+// so it can be sent a CTRL_BREAK_EVENT without stage0 getting it too.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// signalGroup sends a CTRL_BREAK_EVENT to the console process group
+// led by p, which is the closest Windows has to a SIGTERM. Any sig
+// is treated the same.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	r, _, err := procGenerateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(p.Pid))
+	if r == 0 {
+		return fmt.Errorf("GenerateConsoleCtrlEvent: %v", err)
+	}
+	return nil
+}
+
+// killGroup kills p and its descendants.
+func killGroup(p *os.Process) error {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run(); err != nil {
+		// Fall back to killing just the buildlet.
+		return p.Kill()
+	}
+	return nil
+}
+
+// exitCode returns ps's exit code.
+func exitCode(ps *os.ProcessState) int {
+	return ps.ExitCode()
+}
+
+// exitReason describes how ps's process ended. Exit codes that are
+// NTSTATUS error values, such as 0xC0000005 for an access violation,
+// are shown in hex.
+func exitReason(ps *os.ProcessState) string {
+	code := uint32(ps.ExitCode())
+	if code >= 0xC0000000 {
+		return fmt.Sprintf("exited with status 0x%X", code)
+	}
+	return fmt.Sprintf("exited with status %d", code)
+}
+
+// killedBySIGKILL reports false; Windows has no signals.
+func killedBySIGKILL(ps *os.ProcessState) bool { return false }
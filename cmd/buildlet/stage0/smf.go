@@ -0,0 +1,124 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// smfExitErrConfig is SMF_EXIT_ERR_CONFIG from <libscf.h>, the
+// method exit code for a misconfigured service, which SMF doesn't
+// restart. See smf_method(5).
+const smfExitErrConfig = 96
+
+// smfFMRI is stage0's SMF service.
+const smfFMRI = "svc:/application/go/buildlet-stage0:default"
+
+// smfManifestPath is where --install-smf writes stage0's SMF manifest.
+const smfManifestPath = "/var/svc/manifest/site/go-buildlet-stage0.xml"
+
+// underSMF reports whether stage0 was started by SMF's restarter.
+func underSMF() bool {
+	return os.Getenv("SMF_FMRI") != ""
+}
+
+// configExitCode is the status stage0 exits with on configuration
+// errors that restarting won't fix.
+func configExitCode() int {
+	if underSMF() {
+		// Put the service into maintenance instead of
+		// restarting it in a loop.
+		return smfExitErrConfig
+	}
+	return 1
+}
+
+// smfEnv returns the environment variables from env that an SMF
+// manifest should preserve for stage0, sorted.
+func smfEnv(env []string) []string {
+	var out []string
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GO_") || strings.HasPrefix(kv, "META_") || strings.HasPrefix(kv, "PATH=") {
+			out = append(out, kv)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+var smfTmpl = template.Must(template.New("manifest").Funcs(template.FuncMap{
+	"xml": func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	},
+	"key":   func(kv string) string { return kv[:strings.Index(kv, "=")] },
+	"value": func(kv string) string { return kv[strings.Index(kv, "=")+1:] },
+}).Parse(`<?xml version="1.0"?>
+<!DOCTYPE service_bundle SYSTEM "/usr/share/lib/xml/dtd/service_bundle.dtd.1">
+<service_bundle type="manifest" name="go-buildlet-stage0">
+	<service name="application/go/buildlet-stage0" type="service" version="1">
+		<create_default_instance enabled="true"/>
+		<single_instance/>
+		<dependency name="network" grouping="require_all" restart_on="none" type="service">
+			<service_fmri value="svc:/milestone/network:default"/>
+		</dependency>
+		<dependency name="filesystem" grouping="require_all" restart_on="none" type="service">
+			<service_fmri value="svc:/system/filesystem/local"/>
+		</dependency>
+		<method_context>
+			<method_environment>
+{{- range .Env}}
+				<envvar name="{{xml (key .)}}" value="{{xml (value .)}}"/>
+{{- end}}
+			</method_environment>
+		</method_context>
+		<exec_method type="method" name="start" exec="{{xml .Exec}}" timeout_seconds="60"/>
+		<exec_method type="method" name="stop" exec=":kill" timeout_seconds="{{.StopTimeout}}"/>
+		<property_group name="startd" type="framework">
+			<propval name="duration" type="astring" value="child"/>
+			<propval name="ignore_error" type="astring" value="core,signal"/>
+		</property_group>
+		<stability value="Unstable"/>
+		<template>
+			<common_name>
+				<loctext xml:lang="C">Go buildlet stage0</loctext>
+			</common_name>
+		</template>
+	</service>
+</service_bundle>
+`))
+
+// smfManifest returns an SMF manifest that runs the command args with
+// the environment env, giving it stopTimeout seconds to stop.
+func smfManifest(args, env []string, stopTimeout int) []byte {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	var b bytes.Buffer
+	err := smfTmpl.Execute(&b, struct {
+		Exec        string
+		Env         []string
+		StopTimeout int
+	}{strings.Join(quoted, " "), env, stopTimeout})
+	if err != nil {
+		panic(err) // can't happen writing to a bytes.Buffer
+	}
+	return b.Bytes()
+}
+
+// shellQuote returns s quoted for the Bourne shell, if it needs to be.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+.,/:@%") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+var installSMF = flag.Bool("install-smf", false, "write and import an SMF manifest that runs stage0 with the other flags given and the current GO_*, META_*, and PATH environment, and exit")
+
+func init() {
+	serviceMain = smfMain
+}
+
+func smfMain(run func()) bool {
+	if !*installSMF {
+		return false
+	}
+	if err := installSMFService(); err != nil {
+		log.Fatalf("installing SMF service: %v", err)
+	}
+	log.Printf("installed and enabled %s", smfFMRI)
+	return true
+}
+
+func installSMFService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{exe}
+	for _, a := range os.Args[1:] {
+		if flagName(a) != "install-smf" {
+			args = append(args, a)
+		}
+	}
+	stopTimeout := int((*stopGrace + 30*time.Second) / time.Second)
+	m := smfManifest(args, smfEnv(os.Environ()), stopTimeout)
+	if err := ioutil.WriteFile(smfManifestPath, m, 0444); err != nil {
+		return err
+	}
+	if out, err := exec.Command("/usr/sbin/svccfg", "import", smfManifestPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("svccfg import: %v: %s", err, out)
+	}
+	if out, err := exec.Command("/usr/sbin/svcadm", "enable", smfFMRI).CombinedOutput(); err != nil {
+		return fmt.Errorf("svcadm enable: %v: %s", err, out)
+	}
+	return nil
+}
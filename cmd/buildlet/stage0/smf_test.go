@@ -0,0 +1,83 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSMFManifest(t *testing.T) {
+	args := []string{"/opt/stage0", "--buildlet-url=https://example.com/?a=1&b=2", "--note=it's"}
+	env := smfEnv([]string{"HOME=/root", "META_BUILDLET_BINARY_URL=https://x/<y>", "GO_BUILDER_ENV=host-solaris-amd64", "PATH=/usr/bin", "SECRET=s3cret"})
+	if want := []string{"GO_BUILDER_ENV=host-solaris-amd64", "META_BUILDLET_BINARY_URL=https://x/<y>", "PATH=/usr/bin"}; !reflect.DeepEqual(env, want) {
+		t.Fatalf("smfEnv = %q; want %q", env, want)
+	}
+	m := smfManifest(args, env, 60)
+
+	var bundle struct {
+		Service struct {
+			Name string `xml:"name,attr"`
+			Env  []struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:"value,attr"`
+			} `xml:"method_context>method_environment>envvar"`
+			Methods []struct {
+				Name    string `xml:"name,attr"`
+				Exec    string `xml:"exec,attr"`
+				Timeout string `xml:"timeout_seconds,attr"`
+			} `xml:"exec_method"`
+		} `xml:"service"`
+	}
+	if err := xml.Unmarshal(m, &bundle); err != nil {
+		t.Fatalf("manifest isn't valid XML: %v\n%s", err, m)
+	}
+	s := bundle.Service
+	if s.Name != "application/go/buildlet-stage0" {
+		t.Errorf("service name = %q", s.Name)
+	}
+	if len(s.Env) != 3 || s.Env[1].Name != "META_BUILDLET_BINARY_URL" || s.Env[1].Value != "https://x/<y>" {
+		t.Errorf("environment = %+v", s.Env)
+	}
+	if len(s.Methods) != 2 {
+		t.Fatalf("methods = %+v; want start and stop", s.Methods)
+	}
+	if got, want := s.Methods[0].Exec, `/opt/stage0 '--buildlet-url=https://example.com/?a=1&b=2' '--note=it'\''s'`; got != want {
+		t.Errorf("start exec = %s; want %s", got, want)
+	}
+	if s.Methods[1].Exec != ":kill" || s.Methods[1].Timeout != "60" {
+		t.Errorf("stop method = %+v", s.Methods[1])
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", "''"},
+		{"/opt/stage0", "/opt/stage0"},
+		{"--workdir=/a/b", "--workdir=/a/b"},
+		{"a b", "'a b'"},
+		{"$HOME", "'$HOME'"},
+		{"it's", `'it'\''s'`},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s; want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestConfigExitCode(t *testing.T) {
+	defer os.Setenv("SMF_FMRI", os.Getenv("SMF_FMRI"))
+	os.Unsetenv("SMF_FMRI")
+	if c := configExitCode(); c != 1 {
+		t.Errorf("configExitCode outside SMF = %d; want 1", c)
+	}
+	os.Setenv("SMF_FMRI", smfFMRI)
+	if c := configExitCode(); c != smfExitErrConfig {
+		t.Errorf("configExitCode under SMF = %d; want %d", c, smfExitErrConfig)
+	}
+}
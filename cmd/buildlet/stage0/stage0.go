@@ -18,10 +18,13 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -30,41 +33,55 @@ import (
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
-	"golang.org/x/build/internal/httpdl"
 	"golang.org/x/build/internal/untar"
 )
 
-// This lets us be lazy and put the stage0 start-up in rc.local where
-// it might race with the network coming up, rather than write proper
-// upstart+systemd+init scripts:
-var networkWait = flag.Duration("network-wait", 0, "if zero, a default is used if needed")
+var buildletURLFlag = flag.String("buildlet-url", "", "if non-empty, the URL of the buildlet binary to use, overriding any metadata, environment, or built-in default")
 
 const osArch = runtime.GOOS + "/" + runtime.GOARCH
 
 const attr = "buildlet-binary-url"
 
+// sha256Attr is the optional GCE instance attribute containing the
+// hex SHA-256 of the buildlet binary. Off GCE, the
+// META_BUILDLET_BINARY_SHA256 environment variable is used instead.
+const sha256Attr = "buildlet-binary-sha256"
+
 // untar helper, for the Windows image prep script.
 var (
 	untarFile    = flag.String("untar-file", "", "if non-empty, tar.gz to untar to --untar-dest-dir")
 	untarDestDir = flag.String("untar-dest-dir", "", "destination directory to untar --untar-file to")
 )
 
-// configureSerialLogOutput and closeSerialLogOutput are set non-nil
-// on some platforms to configure log output to go to the serial
-// console and to close the serial port, respectively.
-var (
-	configureSerialLogOutput func()
-	closeSerialLogOutput     func()
-)
+// configureSerialLogOutput is set non-nil on some platforms to
+// configure log output to also go to the serial console, setting
+// serialLog. It's run after flag parsing, so it can use flags.
+var configureSerialLogOutput func()
+
+// serviceMain is set non-nil on platforms where stage0 can run as an
+// OS service. It handles the service-related flags, calling run if
+// stage0 is running as a service, and reports whether it did
+// anything.
+var serviceMain func(run func()) bool
 
 var timeStart = time.Now()
 
 func main() {
+	log.SetPrefix("stage0: ")
+	flag.Parse()
+	if err := configureLogFormat(); err != nil {
+		log.Fatal(err)
+	}
+	applyLogOutput()
 	if configureSerialLogOutput != nil {
 		configureSerialLogOutput()
+		serialSetupTime = time.Since(timeStart)
+	}
+	openLogFile()
+	if *versionFlag {
+		fmt.Println(stage0Version())
+		return
 	}
-	log.SetPrefix("stage0: ")
-	flag.Parse()
 
 	if *untarFile != "" {
 		log.Printf("running in untar mode, untarring %q to %q", *untarFile, *untarDestDir)
@@ -72,169 +89,395 @@ func main() {
 		log.Printf("done untarring; exiting")
 		return
 	}
-	log.Printf("bootstrap binary running")
+	if serviceMain != nil && serviceMain(run) {
+		return
+	}
+	run()
+}
 
-	var isMacStadiumVM bool
-	switch osArch {
-	case "linux/arm":
-		switch env := os.Getenv("GO_BUILDER_ENV"); env {
-		case "linux-arm-arm5spacemonkey", "host-linux-arm-scaleway":
-			// No setup currently.
-		default:
-			panic(fmt.Sprintf("unknown/unspecified $GO_BUILDER_ENV value %q", env))
+// run downloads and runs the buildlet, restarting it in --loop mode.
+func run() {
+	log.Printf("bootstrap binary running; version %s, %s", stage0Version(), osArch)
+	if err := loadStage0EnvFile(); err != nil {
+		configFatalf("loading --stage0-env-file: %v", err)
+	}
+	if _, err := lookupRunAsUser(); err != nil {
+		configFatalf("--run-as-user: %v", err)
+	}
+	if *envFile != "" {
+		// Check it early. It's reread for each run of the
+		// buildlet.
+		if _, err := readEnvFile(*envFile); err != nil {
+			configFatalf("--env-file: %v", err)
 		}
-	case "linux/arm64":
-		switch env := os.Getenv("GO_BUILDER_ENV"); env {
-		case "host-linux-arm64-packet", "host-linux-arm64-linaro":
-			// No special setup.
-		default:
-			panic(fmt.Sprintf("unknown/unspecified $GO_BUILDER_ENV value %q", env))
+	}
+	if *hostConfigFile != "" {
+		// Check it early, like --env-file.
+		if _, err := readHostOverride(*hostConfigFile); err != nil {
+			configFatalf("%v", err)
 		}
-	case "linux/ppc64":
-		initOregonStatePPC64()
-	case "linux/ppc64le":
-		initOregonStatePPC64le()
-	case "darwin/amd64":
-		// The MacStadium builders' baked-in stage0.sh
-		// bootstrap file doesn't set GO_BUILDER_ENV
-		// unfortunately, so use the filename it runs its
-		// downloaded bootstrap URL to determine whether we're
-		// in that environment.
-		isMacStadiumVM = len(os.Args) > 0 && strings.HasSuffix(os.Args[0], "run-builder")
-		log.Printf("isMacStadiumVM = %v", isMacStadiumVM)
-		os.Setenv("GO_BUILDER_ENV", "macstadium_vm")
 	}
+	if _, err := localeNormalized(); err != nil {
+		configFatalf("%v", err)
+	}
+	startSDWatchdog()
+	logProxy()
+	if *dryRun || *dryRunProbe {
+		if err := printDryRun(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	env := os.Getenv("GO_BUILDER_ENV")
+	if unknownBuilderEnv(osArch, env) {
+		log.Printf("*** warning: unknown/unspecified $GO_BUILDER_ENV value %q on %s; using generic reverse builder setup ***", env, osArch)
+	}
+	h := lookupHost(osArch, env)
+	h.applyDownloadTimeout()
+	if err := installPackages(currentHost().Packages...); err != nil {
+		sleepFatalf("%v", err)
+	}
+	if h.Init != nil {
+		h.Init()
+	}
+	setupSwap()
+	applySysWrites(sysWrites(currentHost()))
+	setupCgroup()
+
+	restarts := &restartTracker{count: *crashLoopCount, window: *crashLoopWin}
+	failures := 0 // consecutive
+	for attempt, start := 1, timeStart; ; attempt, start = attempt+1, time.Now() {
+		setAttempt(attempt)
+		err := runBuildlet(start, macStadiumVM)
+		if err == nil {
+			// Rebooting or halting takes precedence over
+			// restarting.
+			switch policy := currentHost().OnBuildletExit; policy {
+			case exitReboot, exitHalt:
+				finishMachine(policy)
+				return
+			case exitNone:
+				log.Printf("buildlet exited successfully; exiting (%s=%s)", onExitAttr, policy)
+				return
+			case exitRestart:
+				log.Printf("buildlet exited successfully; restarting (%s=%s)", onExitAttr, policy)
+			default:
+				if !loopEnabled() || !*loopOnExit {
+					return
+				}
+				log.Printf("buildlet exited successfully; restarting (--loop-on-exit)")
+			}
+			failures = 0
+		} else {
+			if !loopEnabled() {
+				sleepFatalf("%v", err) // reports the failure
+			}
+			reportFailure(err)
+			failures++
+			maybeReboot(failures)
+		}
+		n := restarts.add(time.Now())
+		d := restarts.delay(n)
+		if isOOMKill(err) && d < loopSlowDelay {
+			// Give the kernel time to reclaim memory, such as
+			// from the buildlet's leftover children.
+			d = loopSlowDelay
+		}
+		if restarts.crashLooping(n) {
+			log.Printf("crash loop: %d restarts in the last %v; restarting in %v", n, *crashLoopWin, prettyDuration(d))
+		} else if err != nil {
+			log.Printf("%v; restarting in %v", err, prettyDuration(d))
+		} else {
+			log.Printf("restarting in %v", prettyDuration(d))
+		}
+		sleep(d)
+	}
+}
 
+// runBuildlet waits for the network, downloads the buildlet, and runs
+// it, returning an error if any step fails. The start time is used to
+// report how long stage0 waited for the network.
+func runBuildlet(start time.Time, isMacStadiumVM bool) error {
+	startDebugServer()
+	childOutput.Reset()
+	setPhase("network")
+	configureHTTPClient()
 	if !awaitNetwork() {
-		sleepFatalf("network didn't become reachable")
+		return errors.New("network didn't become reachable")
 	}
+	networkUp = true
 	timeNetwork := time.Now()
-	netDelay := prettyDuration(timeNetwork.Sub(timeStart))
+	netDelay := prettyDuration(timeNetwork.Sub(start))
 	log.Printf("network up after %v", netDelay)
+	awaitSaneClock()
+	timings := &bootTimings{Network: timeNetwork.Sub(start)}
+	if start == timeStart {
+		// Serial setup happens once, at the start of the first
+		// attempt.
+		timings.Serial = serialSetupTime
+		timings.Network -= serialSetupTime
+	}
+
+	setPhase("resolve")
+	t0 := time.Now()
+	resolveBuilderEnv()
+	timings.Resolve = time.Since(t0)
+
+	for {
+		execed, err := runBuildletPass(start, timeNetwork, netDelay, timings)
+		if !execed {
+			return err
+		}
+		if !isMacStadiumVM {
+			if err != nil {
+				return fmt.Errorf("running buildlet: %v", err)
+			}
+			return nil
+		}
+		// Some of the MacStadium VM environments reuse their
+		// environment. Re-download the buildlet (if it
+		// changed-- httpdl does conditional downloading) and
+		// then re-run. At least on Sierra we never get this
+		// far because the buildlet will halt the machine
+		// before we get here. (and then cmd/makemac will
+		// recreate the VM)
+		// But if we get here, restart the process.
+		if err != nil {
+			log.Printf("error running buildlet: %v", err)
+			log.Printf("restarting in 2 seconds.")
+			time.Sleep(2 * time.Second) // in case we're spinning, slow it down
+		} else {
+			log.Printf("buildlet process exited; restarting.")
+		}
+	}
+}
 
-Download:
+// runBuildletPass downloads and runs the buildlet once, serving the
+// debug status page until it starts. It reports whether it got as far
+// as starting the buildlet, in which case err is the result of
+// running it; otherwise, err is why it couldn't be run.
+func runBuildletPass(start, timeNetwork time.Time, netDelay time.Duration, timings *bootTimings) (execed bool, err error) {
+	startDebugServer()
+	setPhase("download")
 	// Note: we name it ".exe" for Windows, but the name also
 	// works fine on Linux, etc.
 	target := filepath.FromSlash("./buildlet.exe")
-	if err := download(target, buildletURL()); err != nil {
-		sleepFatalf("Downloading %s: %v", buildletURL(), err)
+	if err := checkFreeSpace(target); err != nil {
+		return false, err
+	}
+	auth := newBuildletAuth()
+	resolveURL := func() string {
+		u := buildletURL()
+		if env := os.Getenv("GO_BUILDER_ENV"); u == "" && unknownBuilderEnv(osArch, env) {
+			// Without a host-specific default, there's
+			// nothing to download.
+			configFatalf("no buildlet URL for $GO_BUILDER_ENV %q on %s; set META_BUILDLET_BINARY_URL, the %s metadata attribute, or --buildlet-url", env, osArch, attr)
+		}
+		auth.addURLs(u)
+		return u
+	}
+	// Count the first resolution of the URL as part of the resolve
+	// phase, rather than the download.
+	var resolveTime time.Duration
+	first := true
+	resolve := func() string {
+		if !first {
+			return resolveURL()
+		}
+		first = false
+		t := time.Now()
+		defer func() { resolveTime = time.Since(t) }()
+		return resolveURL()
+	}
+	t0 := time.Now()
+	var burl string
+	withDownloadAuth(auth.header, func() {
+		burl, err = downloadWithFallback(target, resolve, func(file string) error {
+			if err := checkBinary(file, runtime.GOOS, runtime.GOARCH); err != nil {
+				return err
+			}
+			return verifySHA256(file, buildletSHA256())
+		})
+		if err != nil {
+			err = fmt.Errorf("downloading %s: %v", burl, err)
+			return
+		}
+		if err = verifySignature(target, burl); err != nil {
+			err = fmt.Errorf("verifying signature of %s: %v", burl, err)
+		}
+	})
+	if err != nil {
+		return false, err
 	}
+	noteBuildletURL(burl)
+
+	timings.Resolve += resolveTime
+	timings.Download = time.Since(t0) - resolveTime
 
+	t0 = time.Now()
 	if runtime.GOOS != "windows" {
 		if err := os.Chmod(target, 0755); err != nil {
-			log.Fatal(err)
+			return false, err
 		}
 	}
+	timings.Chmod = time.Since(t0)
 	downloadDelay := prettyDuration(time.Since(timeNetwork))
 	log.Printf("downloaded buildlet in %v", downloadDelay)
 
-	env := os.Environ()
-	if isUnix() && os.Getuid() == 0 {
-		if os.Getenv("USER") == "" {
-			env = append(env, "USER=root")
+	cmd := exec.Command(target, buildletArgs()...)
+	setProcessGroup(cmd)
+	if f := heartbeatFile(); f != "" {
+		// Don't let a previous buildlet's heartbeat arm the
+		// watchdog for this one.
+		os.Remove(f)
+	}
+	output, err := captureOutput(cmd)
+	if err != nil {
+		return false, err
+	}
+	defer output.closeWriters()
+	extraEnv := buildletEnv(netDelay, downloadDelay)
+	cmd.Env = buildletLocaleEnv(append(filterEnv(os.Environ()), extraEnv...), extraEnv)
+	cmd.Env = append(cmd.Env, timings.env())
+	runAs, err := lookupRunAsUser()
+	if err != nil {
+		return false, err
+	}
+	if dir := workdirArg(cmd.Args); dir != "" {
+		if err := prepareWorkdir(dir); err != nil {
+			return false, err
 		}
-		if os.Getenv("HOME") == "" {
-			env = append(env, "HOME=/root")
+		if runAs != nil {
+			if err := chownTree(dir, runAs); err != nil {
+				return false, fmt.Errorf("giving buildlet workdir to %s: %v", runAs.name, err)
+			}
 		}
 	}
-	env = append(env, fmt.Sprintf("GO_STAGE0_NET_DELAY=%v", netDelay))
-	env = append(env, fmt.Sprintf("GO_STAGE0_DL_DELAY=%v", downloadDelay))
+	if runAs != nil {
+		log.Printf("running buildlet as %s (uid %d, gid %d)", runAs.name, runAs.uid, runAs.gid)
+		setCredential(cmd, runAs)
+	}
 
-	cmd := exec.Command(target)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = env
-
-	// buildEnv is set by some builders. It's increasingly set by new ones.
-	// It predates the buildtype-vs-hosttype split, so the values aren't
-	// always host types, but they're often host types. They should probably
-	// be host types in the future, or we can introduce GO_BUILD_HOST_TYPE
-	// to be explicit and kill off GO_BUILDER_ENV.
-	buildEnv := os.Getenv("GO_BUILDER_ENV")
-
-	switch buildEnv {
-	case "linux-arm-arm5spacemonkey":
-		cmd.Args = append(cmd.Args, reverseHostTypeArgs("host-linux-arm5spacemonkey")...)
-		cmd.Args = append(cmd.Args, os.ExpandEnv("--workdir=${WORKDIR}"))
-	case "host-linux-arm-scaleway":
-		scalewayArgs := append(
-			reverseHostTypeArgs(buildEnv),
-			"--hostname="+os.Getenv("HOSTNAME"),
-		)
-		cmd.Args = append(cmd.Args,
-			scalewayArgs...,
-		)
-	}
-	switch osArch {
-	case "linux/s390x":
-		cmd.Args = append(cmd.Args, "--workdir=/data/golang/workdir")
-		cmd.Args = append(cmd.Args, reverseHostTypeArgs("host-linux-s390x")...)
-	case "linux/arm64":
-		switch buildEnv {
-		case "host-linux-arm64-packet", "host-linux-arm64-linaro":
-			hostname := os.Getenv("HOSTNAME") // if empty, docker container name is used
-			cmd.Args = append(cmd.Args,
-				"--reverse-type="+buildEnv,
-				"--workdir=/workdir",
-				"--hostname="+hostname,
-				"--halt=false",
-				"--reboot=false",
-				"--coordinator=farmer.golang.org:443",
-			)
-		default:
-			panic(fmt.Sprintf("unknown/unspecified $GO_BUILDER_ENV value %q", env))
+	// Where only one process can have the serial port open,
+	// hand stage0's to the buildlet. See inheritSerialLog.
+	inherited := false
+	if serialLog != nil && inheritSerialLog != nil {
+		if kv := inheritSerialLog(cmd); kv != "" {
+			cmd.Env = append(cmd.Env, kv)
+			serialLog.handOff()
+			inherited = true
 		}
-	case "linux/ppc64":
-		// Assume OSU (osuosl.org) host type for now. If we get more, use
-		// GO_BUILD_HOST_TYPE (see above) and check that.
-		cmd.Args = append(cmd.Args, reverseHostTypeArgs("host-linux-ppc64-osu")...)
-	case "linux/ppc64le":
-		// Assume OSU (osuosl.org) host type for now. If we get more, use
-		// GO_BUILD_HOST_TYPE (see above) and check that.
-		cmd.Args = append(cmd.Args, reverseHostTypeArgs("host-linux-ppc64le-osu")...)
-	case "solaris/amd64":
-		if buildEnv != "" {
-			// Explicit value given. Treat it like a host type.
-			cmd.Args = append(cmd.Args, reverseHostTypeArgs(buildEnv)...)
+	}
+
+	setPhase("exec")
+	stopDebugServer()
+	t0 = time.Now()
+	uptime := kernelUptime()
+	inCgroup := false
+	if buildletCgroup != "" && startInCgroup != nil {
+		cmd, inCgroup, err = startInCgroup(cmd, buildletCgroup)
+	} else {
+		err = cmd.Start()
+	}
+	output.closeWriters()
+	if os.IsPermission(err) {
+		err = fmt.Errorf("%v (is %s on a file system mounted noexec?)", err, target)
+	}
+	oomKills := 0
+	if err == nil && inCgroup {
+		oomKills = cgroupOOMKills(buildletCgroup)
+	} else if err == nil && buildletCgroup != "" {
+		if cerr := joinCgroup(buildletCgroup, cmd.Process.Pid); cerr == nil {
+			oomKills = cgroupOOMKills(buildletCgroup)
+		} else if *cgroupStrict {
+			cmd.Process.Kill()
+			cmd.Wait()
+			err = fmt.Errorf("moving buildlet into cgroup %s: %v", buildletCgroup, cerr)
 		} else {
-			// If there's no value, assume it's the old Joyent builders,
-			// which are currently GOOS=solaris, but will be illumos after
-			// golang.org/issue/20603.
-			cmd.Args = append(cmd.Args, reverseHostTypeArgs("host-solaris-amd64")...)
+			log.Printf("moving buildlet into cgroup %s: %v; running it outside", buildletCgroup, cerr)
 		}
 	}
-	// Release the serial port (if we opened it) so the buildlet
-	// process can open & write to it. At least on Windows, only
-	// one process can have it open.
-	if closeSerialLogOutput != nil {
-		closeSerialLogOutput()
+	timings.Exec = time.Since(t0)
+	timings.Total = time.Since(start)
+	log.Printf("boot timings: %v", timings)
+	if err == nil {
+		notifyReady(cmd.Process.Pid)
+		go timings.report()
+		t0 = time.Now()
+		err = waitBuildlet(cmd)
+	}
+	if inherited {
+		serialLog.reclaim()
+	}
+	if buildletCgroup != "" {
+		if n := cgroupOOMKills(buildletCgroup) - oomKills; n > 0 {
+			log.Printf("OOM killer killed %d processes in cgroup %s for exceeding its memory limits", n, buildletCgroup)
+		}
 	}
-	err := cmd.Run()
-	if isMacStadiumVM {
+	if cmd.ProcessState != nil {
+		log.Printf("buildlet process %s", exitReason(cmd.ProcessState))
+		if _, ok := err.(*exec.ExitError); ok {
+			err = newExitError(cmd.ProcessState, cmd.Process.Pid, uptime)
+		}
 		if err != nil {
-			log.Printf("error running buildlet: %v", err)
-			log.Printf("restarting in 2 seconds.")
-			time.Sleep(2 * time.Second) // in case we're spinning, slow it down
-		} else {
-			log.Printf("buildlet process exited; restarting.")
+			output.wait(outputDrainTimeout)
+			dumpChildOutput(cmd.ProcessState, time.Since(t0))
 		}
-		// Some of the MacStadium VM environments reuse their
-		// environment. Re-download the buildlet (if it
-		// changed-- httpdl does conditional downloading) and
-		// then re-run. At least on Sierra we never get this
-		// far because the buildlet will halt the machine
-		// before we get here. (and then cmd/makemac will
-		// recreate the VM)
-		// But if we get here, restart the process.
-		goto Download
 	}
-	if err != nil {
-		if configureSerialLogOutput != nil {
-			configureSerialLogOutput()
+	return true, err
+}
+
+// resolveBuilderEnv sets $GO_BUILDER_ENV from metadata, if it's unset.
+func resolveBuilderEnv() {
+	if os.Getenv("GO_BUILDER_ENV") == "" {
+		if v := metaValue("go-builder-env", "GO_BUILDER_ENV"); v != "" {
+			log.Printf("using GO_BUILDER_ENV=%q from metadata", v)
+			os.Setenv("GO_BUILDER_ENV", v)
 		}
-		sleepFatalf("Error running buildlet: %v", err)
 	}
+}
 
+// buildletEnv returns the environment variables stage0 adds to its
+// allowlisted ones when running the buildlet: those from
+// buildletEnvVars, then the USER and HOME fixups, then stage0's own.
+func buildletEnv(netDelay, downloadDelay time.Duration) []string {
+	env := buildletEnvVars()
+	unset := func(k string) bool { return os.Getenv(k) == "" && !envHas(env, k) }
+	if u, err := lookupRunAsUser(); err == nil && u != nil {
+		env = append(env, u.env()...)
+	} else if isUnix() && os.Getuid() == 0 {
+		if unset("USER") {
+			env = append(env, "USER=root")
+		}
+		if unset("HOME") {
+			env = append(env, "HOME=/root")
+		}
+	}
+	env = append(env, "GO_STAGE0_VERSION="+stage0Version())
+	env = append(env, fmt.Sprintf("GO_STAGE0_NET_DELAY=%v", netDelay))
+	env = append(env, fmt.Sprintf("GO_STAGE0_DL_DELAY=%v", downloadDelay))
+	if f := heartbeatFile(); f != "" {
+		env = append(env, "GO_BUILDLET_HEARTBEAT_FILE="+f)
+	}
+	if serialLog != nil {
+		// The buildlet's output is copied to the port, which on
+		// Windows only one process can have open, so it
+		// shouldn't open the port itself.
+		env = append(env, "GO_STAGE0_SERIAL_LOG="+serialLog.name)
+	}
+	if bootstrapVersion != "" {
+		env = append(env, "GO_BOOTSTRAP_VERSION="+bootstrapVersion)
+	}
+	return env
+}
+
+// buildletArgs returns the arguments to run the buildlet with,
+// depending on the host type.
+func buildletArgs() []string {
+	args := currentHost().args()
+	args = setWorkdir(args, configuredWorkdir())
+	return appendExtraArgs(args, buildletExtraArgs())
 }
 
 // reverseHostTypeArgs returns the default arguments for the buildlet
@@ -248,176 +491,168 @@ func reverseHostTypeArgs(hostType string) []string {
 	}
 }
 
-// awaitNetwork reports whether the network came up within 30 seconds,
-// determined somewhat arbitrarily via a DNS lookup for google.com.
-func awaitNetwork() bool {
-	timeout := 30 * time.Second
-	if runtime.GOOS == "windows" {
-		timeout = 5 * time.Minute // empirically slower sometimes?
-	}
-	if *networkWait != 0 {
-		timeout = *networkWait
-	}
-	deadline := time.Now().Add(timeout)
-	var lastSpam time.Time
-	log.Printf("waiting for network.")
-	for time.Now().Before(deadline) {
-		t0 := time.Now()
-		if isNetworkUp() {
-			return true
+// buildletURL returns the URL (or comma-separated mirror URLs) of the
+// buildlet binary, or the empty string if none is configured.
+func buildletURL() string {
+	u, src := resolveBuildletURL(resolveHost())
+	switch {
+	case src == "--buildlet-url":
+		log.Printf("*** using buildlet URL %q from --buildlet-url; ignoring metadata and defaults ***", u)
+	case u != "":
+		log.Printf("using buildlet URL %q from %s", u, src)
+	}
+	return u
+}
+
+// resolveBuildletURL returns the buildlet URL for host h, whose
+// fields' sources are hostSrc (see resolveHost), and a description of
+// where it came from. A URL configured for the machine, by
+// --buildlet-url, a host config override, $META_BUILDLET_BINARY_URL,
+// or metadata, in that order, takes precedence over h's hardcoded
+// default.
+func resolveBuildletURL(h *hostConfig, hostSrc map[string]string) (url, source string) {
+	if *buildletURLFlag != "" {
+		return *buildletURLFlag, "--buildlet-url"
+	}
+	if src := hostSrc["url"]; h.URL != "" && src != "generic" && !strings.HasPrefix(src, "built-in") {
+		return h.URL, src
+	}
+	if v := strings.TrimSpace(os.Getenv("META_BUILDLET_BINARY_URL")); v != "" {
+		return expandBuildletURL(v), "env"
+	}
+	if onGCE() && os.Getenv("IN_KUBERNETES") != "1" {
+		if v := gceValue(attr); v != "" {
+			return expandBuildletURL(v), "GCE attribute"
 		}
-		failAfter := time.Since(t0)
-		if now := time.Now(); now.After(lastSpam.Add(5 * time.Second)) {
-			log.Printf("network still down for %v; probe failure took %v",
-				prettyDuration(time.Since(timeStart)),
-				prettyDuration(failAfter))
-			lastSpam = now
+	} else if p := cloudProvider(); p != nil {
+		if v := strings.TrimSpace(p.Value(attr)); v != "" {
+			return expandBuildletURL(v), p.Name() + " metadata"
 		}
-		time.Sleep(1 * time.Second)
 	}
-	log.Printf("gave up waiting for network")
-	return false
+	if h.URL != "" {
+		return h.URL, "hardcoded default"
+	}
+	log.Printf("no buildlet URL from --buildlet-url, $META_BUILDLET_BINARY_URL, or the %s metadata attribute", attr)
+	if h.generic {
+		log.Printf("stage0 has no built-in buildlet URL for %s; it knows %s", osArch, strings.Join(knownOSArches(), ", "))
+	}
+	return "", ""
 }
 
-// isNetworkUp reports whether the network is up by hitting an
-// known-up HTTP server. It might block for a few seconds before
-// returning an answer.
-func isNetworkUp() bool {
-	const probeURL = "http://farmer.golang.org/netcheck" // 404 is fine.
-	c := &http.Client{
-		Timeout: 5 * time.Second,
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-		},
-	}
-	res, err := c.Get(probeURL)
-	if err != nil {
-		return false
-	}
-	res.Body.Close()
-	return true
+// buildletSHA256 returns the expected lowercase hex SHA-256 of the
+// buildlet binary, or the empty string if none is configured.
+func buildletSHA256() string {
+	return strings.ToLower(metaValue(sha256Attr, "META_BUILDLET_BINARY_SHA256"))
 }
 
-func buildletURL() string {
-	switch os.Getenv("GO_BUILDER_ENV") {
-	case "linux-arm-arm5spacemonkey":
-		return "https://storage.googleapis.com/go-builder-data/buildlet.linux-arm-arm5"
-	}
-	switch osArch {
-	case "linux/amd64":
-		// Issue 25760: the s390x cross-compile builder is
-		// working under Kubernetes (which sets
-		// IN_KUBERNETES=1 in the env), but isn't working when
-		// run under Docker in COS (a Container-Optimized OS
-		// VM on GCE). Maybe something is hiding the GCE
-		// metadata service from the COS container now. As a
-		// test, just hard code the s390x builder:
-		if os.Getenv("GOARCH") == "s390x" {
-			return "https://storage.googleapis.com/go-builder-data/buildlet.linux-amd64"
-		}
-	case "linux/s390x":
-		return "https://storage.googleapis.com/go-builder-data/buildlet.linux-s390x"
-	case "linux/arm64":
-		return "https://storage.googleapis.com/go-builder-data/buildlet.linux-arm64"
-	case "linux/ppc64":
-		return "https://storage.googleapis.com/go-builder-data/buildlet.linux-ppc64"
-	case "linux/ppc64le":
-		return "https://storage.googleapis.com/go-builder-data/buildlet.linux-ppc64le"
-	case "solaris/amd64":
-		return "https://storage.googleapis.com/go-builder-data/buildlet.solaris-amd64"
-	case "darwin/amd64":
-		return "https://storage.googleapis.com/go-builder-data/buildlet.darwin-amd64"
-	}
-	// The buildlet download URL is located in an env var
-	// when the buildlet is not running on GCE, or is running
-	// on Kubernetes.
-	if !metadata.OnGCE() || os.Getenv("IN_KUBERNETES") == "1" {
-		if v := os.Getenv("META_BUILDLET_BINARY_URL"); v != "" {
-			return v
-		}
-		sleepFatalf("Not on GCE, and no META_BUILDLET_BINARY_URL specified.")
+// verifySHA256 checks that file has the provided lowercase hex SHA-256.
+// If want is empty, the file is not checked and a note is logged.
+func verifySHA256(file, want string) error {
+	if want == "" {
+		log.Printf("no SHA-256 configured; %s is unverified", file)
+		return nil
 	}
-	v, err := metadata.InstanceAttributeValue(attr)
+	got, err := fileSHA256(file)
 	if err != nil {
-		sleepFatalf("Failed to look up %q attribute value: %v", attr, err)
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("SHA-256 mismatch for %s: got %s, want %s", file, got, want)
 	}
-	return v
+	log.Printf("verified SHA-256 of %s", file)
+	return nil
 }
 
-func sleepFatalf(format string, args ...interface{}) {
-	log.Printf(format, args...)
-	if runtime.GOOS == "windows" {
-		log.Printf("(sleeping for 1 minute before failing)")
-		time.Sleep(time.Minute) // so user has time to see it in cmd.exe, maybe
+// fileSHA256 returns the lowercase hex SHA-256 of the named file.
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
 	}
-	os.Exit(1)
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func download(file, url string) error {
-	log.Printf("downloading %s to %s ...\n", url, file)
-	const maxTry = 3
-	var lastErr error
-	for try := 1; try <= maxTry; try++ {
-		if try > 1 {
-			// network should be up by now per awaitNetwork, so just retry
-			// shortly a few time on errors.
-			time.Sleep(2)
+// metaValue returns the value of the optional GCE instance attribute
+// attr. When not on GCE or when running on Kubernetes, it instead
+// returns the value of the environment variable envKey or, if that's
+// unset, the value of attr from another cloud's metadata service
+// (see metadataProviders). It returns the empty string if the value
+// is not set.
+func metaValue(attr, envKey string) string {
+	if !onGCE() || os.Getenv("IN_KUBERNETES") == "1" {
+		if v := strings.TrimSpace(os.Getenv(envKey)); v != "" {
+			return v
 		}
-		err := httpdl.Download(file, url)
-		if err == nil {
-			fi, err := os.Stat(file)
-			if err != nil {
-				return err
-			}
-			log.Printf("downloaded %s (%d bytes)", file, fi.Size())
-			return nil
+		if p := cloudProvider(); p != nil {
+			return strings.TrimSpace(p.Value(attr))
 		}
-		lastErr = err
-		log.Printf("try %d/%d download failure: %v", try, maxTry, err)
+		return ""
 	}
-	return lastErr
+	return gceValue(attr)
 }
 
-func aptGetInstall(pkgs ...string) {
-	args := append([]string{"--yes", "install"}, pkgs...)
-	cmd := exec.Command("apt-get", args...)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Fatalf("error running apt-get install: %s", out)
+// gceValue returns the value of the optional GCE instance attribute
+// attr, or the empty string if it's not set.
+func gceValue(attr string) string {
+	v, err := metadata.InstanceAttributeValue(attr)
+	if err != nil {
+		if _, ok := err.(metadata.NotDefinedError); !ok {
+			log.Printf("failed to look up %q attribute value: %v", attr, err)
+		}
+		return ""
 	}
+	return strings.TrimSpace(v)
 }
 
-func initBootstrapDir(destDir, tgzCache string) {
-	if err := os.MkdirAll(destDir, 0755); err != nil {
-		log.Fatal(err)
-	}
-	// TODO(bradfitz): rewrite this to use Go instead of curl+tar
-	// if this ever gets used on platforms besides Unix. For
-	// Windows and Plan 9 we bake in the bootstrap tarball into
-	// the image anyway. So this works for now. Solaris might require
-	// tweaking to use gtar instead or something.
-	latestURL := fmt.Sprintf("https://storage.googleapis.com/go-builder-data/gobootstrap-%s-%s.tar.gz",
-		runtime.GOOS, runtime.GOARCH)
-	curl := exec.Command("/usr/bin/curl", "-R", "-o", tgzCache, "-z", tgzCache, latestURL)
-	out, err := curl.CombinedOutput()
-	if err != nil {
-		log.Fatalf("curl error fetching %s to %s: %s", latestURL, out, err)
-	}
-	tar := exec.Command("tar", "zxf", tgzCache)
-	tar.Dir = destDir
-	out, err = tar.CombinedOutput()
-	if err != nil {
-		log.Fatalf("error untarring %s to %s: %s", tgzCache, destDir, out)
-	}
+// flagWasSet reports whether the named flag was explicitly set on
+// the command line.
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
-func initOregonStatePPC64() {
-	aptGetInstall("gcc", "strace", "libc6-dev", "gdb")
-	initBootstrapDir("/usr/local/go-bootstrap", "/usr/local/go-bootstrap.tar.gz")
+func sleepFatalf(format string, args ...interface{}) {
+	sleepExitf(1, format, args...)
+}
+
+// configFatalf is like sleepFatalf, for configuration errors that
+// restarting stage0 won't fix.
+func configFatalf(format string, args ...interface{}) {
+	sleepExitf(configExitCode(), format, args...)
+}
+
+// sleepExitf logs the error, reports it (see reportFailure) if the
+// network is up, and exits with code.
+func sleepExitf(code int, format string, args ...interface{}) {
+	logf(logFields{"level": "error"}, format, args...)
+	reportFailure(fmt.Errorf(format, args...))
+	if runtime.GOOS == "windows" {
+		log.Printf("(sleeping for 1 minute before failing)")
+		time.Sleep(time.Minute) // so user has time to see it in cmd.exe, maybe
+	}
+	os.Exit(code)
 }
 
-func initOregonStatePPC64le() {
-	aptGetInstall("gcc", "strace", "libc6-dev", "gdb")
-	initBootstrapDir("/usr/local/go-bootstrap", "/usr/local/go-bootstrap.tar.gz")
+// initGoBootstrap installs the Go bootstrap toolchain for hosts
+// without one baked into their image.
+func initGoBootstrap() {
+	configureHTTPClient() // for --download-rate-limit, etc.
+	url, version, source := bootstrapURL()
+	log.Printf("installing Go bootstrap toolchain %s from %s, per %s", version, url, source)
+	if err := installBootstrap(url, "/usr/local/go-bootstrap", "/usr/local/go-bootstrap.tar.gz"); err != nil {
+		sleepFatalf("%v", err)
+	}
+	bootstrapVersion = version
 }
 
 func isUnix() bool {
@@ -5,24 +5,33 @@
 package main
 
 import (
-	"io"
+	"fmt"
 	"log"
 	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
 
 	"github.com/tarm/serial"
 )
 
 func init() {
 	configureSerialLogOutput = configureSerialLogOutputWindows
-	closeSerialLogOutput = closeSerialLogOutputWindows
+	inheritSerialLog = inheritSerialLogWindows
 }
 
-var com1 *serial.Port
+// com1 is stage0's inheritable handle to COM1, if it has it open.
+var com1 syscall.Handle
 
 func configureSerialLogOutputWindows() {
+	if serialLog != nil {
+		return
+	}
+	// Package serial configures the port's line settings, which
+	// outlast its handle. Its handle can't be shared with the
+	// buildlet, though: it's overlapped and not inheritable.
 	c := &serial.Config{Name: "COM1", Baud: 9600}
-	var err error
-	com1, err = serial.OpenPort(c)
+	p, err := serial.OpenPort(c)
 	if err != nil {
 		// Oh well, we tried. This empirically works
 		// on Windows on GCE.
@@ -31,13 +40,39 @@ func configureSerialLogOutputWindows() {
 		log.Printf("serial.OpenPort: %v", err)
 		return
 	}
-	log.SetOutput(io.MultiWriter(com1, os.Stderr))
+	p.Close()
+	h, err := createInheritable(`\\.\COM1`)
+	if err != nil {
+		log.Printf("opening COM1: %v", err)
+		return
+	}
+	com1 = h
+	// Keep logging to the console too, for anyone looking at
+	// it over RDP.
+	serialLog = &serialWriter{name: "COM1", w: os.NewFile(uintptr(h), "COM1")}
+	logSinks = append(logSinks, serialLog)
+	applyLogOutput()
+}
+
+// createInheritable opens the device name for synchronous writes with
+// a handle child processes can inherit.
+func createInheritable(name string) (syscall.Handle, error) {
+	sa := &syscall.SecurityAttributes{InheritHandle: 1}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+	return syscall.CreateFile(syscall.StringToUTF16Ptr(name), syscall.GENERIC_WRITE, 0, sa, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
 }
 
-func closeSerialLogOutputWindows() {
-	if com1 != nil {
-		com1.Close()
-		com1 = nil
-		log.SetOutput(os.Stderr)
+// inheritSerialLogWindows makes cmd inherit com1, as
+// GO_STAGE0_SERIAL_HANDLE. Only the handles listed in
+// AdditionalInheritedHandles are inherited, so other children don't
+// get it.
+func inheritSerialLogWindows(cmd *exec.Cmd) string {
+	if com1 == 0 {
+		return ""
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
 	}
+	cmd.SysProcAttr.AdditionalInheritedHandles = append(cmd.SysProcAttr.AdditionalInheritedHandles, com1)
+	return fmt.Sprintf("GO_STAGE0_SERIAL_HANDLE=%d", com1)
 }
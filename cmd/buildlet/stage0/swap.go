@@ -0,0 +1,225 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	setupSwapFlag   = flag.String("setup-swap", "", "if non-empty, the size of a swap file, such as 2G, to create and enable on Linux before running the buildlet, unless there's already that much swap; overrides the setup-swap metadata attribute")
+	setupZramFlag   = flag.String("setup-zram", "", "like --setup-swap, but the size of a zram swap device to configure, unless there's already one; overrides the setup-zram metadata attribute")
+	setupSwapStrict = flag.Bool("setup-swap-strict", false, "exit if --setup-swap or --setup-zram fails, instead of logging the failure and running the buildlet anyway")
+)
+
+const (
+	// setupSwapAttr and setupZramAttr are the optional GCE
+	// instance attributes for --setup-swap and --setup-zram. Off
+	// GCE, the META_SETUP_SWAP and META_SETUP_ZRAM environment
+	// variables are used instead.
+	setupSwapAttr = "setup-swap"
+	setupZramAttr = "setup-zram"
+
+	// swapFile is the swap file --setup-swap creates.
+	swapFile = "/var/stage0.swap"
+)
+
+// swapConfig returns the configured swap file and zram sizes, in
+// bytes, or 0 if unset. Both are off unless configured, so the step
+// never runs on GCE VMs without an explicit attribute.
+func swapConfig() (swap, zram int64, err error) {
+	get := func(name, value, attr, envKey string) (int64, error) {
+		if !flagWasSet(name) {
+			value = metaValue(attr, envKey)
+		}
+		if value == "" {
+			return 0, nil
+		}
+		n, err := parseSize(value)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", name, err)
+		}
+		return n, nil
+	}
+	if swap, err = get("setup-swap", *setupSwapFlag, setupSwapAttr, "META_SETUP_SWAP"); err != nil {
+		return 0, 0, err
+	}
+	if zram, err = get("setup-zram", *setupZramFlag, setupZramAttr, "META_SETUP_ZRAM"); err != nil {
+		return 0, 0, err
+	}
+	return swap, zram, nil
+}
+
+// setupSwap creates the swap file and zram device configured by
+// --setup-swap and --setup-zram, if any. Failures are logged, unless
+// --setup-swap-strict makes them fatal.
+func setupSwap() {
+	swap, zram, err := swapConfig()
+	if err != nil {
+		configFatalf("%v", err)
+	}
+	if swap == 0 && zram == 0 {
+		return
+	}
+	if why := inContainer(); why != "" {
+		log.Printf("not setting up swap: running in a container (%s)", why)
+		return
+	}
+	fail := func(err error) {
+		if *setupSwapStrict {
+			sleepFatalf("setting up swap: %v", err)
+		}
+		log.Printf("setting up swap: %v; continuing without it", err)
+	}
+	if runtime.GOOS != "linux" {
+		fail(errSwapUnsupported)
+		return
+	}
+	existing, err := readSwaps()
+	if err != nil {
+		fail(err)
+		return
+	}
+	if zram > 0 {
+		if dev := zramSwap(existing); dev != "" {
+			log.Printf("zram swap %s already enabled", dev)
+		} else if dev, err := makeZramSwap(zram); err != nil {
+			fail(err)
+		} else {
+			log.Printf("enabled %s of zram swap on %s", formatSize(zram), dev)
+		}
+	}
+	if swap > 0 {
+		if total := totalSwap(existing); total >= swap-swapSlack {
+			log.Printf("%s of swap already enabled; not creating %s", formatSize(total), swapFile)
+		} else if err := makeSwapFile(swapFile, swap); err != nil {
+			fail(err)
+		} else {
+			log.Printf("enabled %s of swap in %s", formatSize(swap), swapFile)
+		}
+	}
+}
+
+// swapSlack is how much smaller than the requested size enabled swap
+// may be and still count: mkswap keeps the first page for its header.
+const swapSlack = 64 << 10
+
+// A swapArea is an enabled swap file or device, from /proc/swaps.
+type swapArea struct {
+	name string
+	size int64 // bytes
+}
+
+// readSwaps returns the swap areas in /proc/swaps.
+func readSwaps() ([]swapArea, error) {
+	f, err := os.Open("/proc/swaps")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSwaps(f)
+}
+
+// parseSwaps parses the contents of /proc/swaps.
+func parseSwaps(r io.Reader) ([]swapArea, error) {
+	var areas []swapArea
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		f := strings.Fields(s.Text())
+		if line == 1 || len(f) == 0 {
+			continue // header
+		}
+		if len(f) < 3 {
+			return nil, fmt.Errorf("/proc/swaps:%d: too few fields", line)
+		}
+		kb, err := strconv.ParseInt(f[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("/proc/swaps:%d: bad size %q", line, f[2])
+		}
+		areas = append(areas, swapArea{name: f[0], size: kb << 10})
+	}
+	return areas, s.Err()
+}
+
+// totalSwap returns the total size of areas.
+func totalSwap(areas []swapArea) int64 {
+	var n int64
+	for _, a := range areas {
+		n += a.size
+	}
+	return n
+}
+
+// zramSwap returns the name of a zram device among areas, or the
+// empty string if there's none.
+func zramSwap(areas []swapArea) string {
+	for _, a := range areas {
+		if strings.HasPrefix(a.name, "/dev/zram") {
+			return a.name
+		}
+	}
+	return ""
+}
+
+// parseSize parses a size in bytes with an optional binary K, M, G,
+// or T suffix (optionally followed by "B" or "iB"), such as "2G".
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	shift := uint(0)
+	if i := strings.IndexAny(num, "KMGT"); i >= 0 && i == len(num)-1 {
+		shift = 10 * uint(1+strings.IndexByte("KMGT", num[i]))
+		num = num[:i]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > (1<<62)>>shift {
+		return 0, fmt.Errorf("size %q too large", s)
+	}
+	return n << shift, nil
+}
+
+// formatSize formats n bytes for logging.
+func formatSize(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		shift  uint
+	}{{"G", 30}, {"M", 20}, {"K", 10}} {
+		if n >= 1<<u.shift && n%(1<<u.shift) == 0 {
+			return fmt.Sprintf("%d%s", n>>u.shift, u.suffix)
+		}
+	}
+	if n >= 1<<20 {
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// runCommand runs the named program, returning an error that includes
+// its output if it fails.
+func runCommand(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		return fmt.Errorf("%s: %v: %s", name, err, msg)
+	}
+	return nil
+}
+
+var errSwapUnsupported = errors.New("swap setup is only supported on Linux")
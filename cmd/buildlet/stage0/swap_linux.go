@@ -0,0 +1,83 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// makeSwapFile creates a swap file of size bytes and enables it.
+func makeSwapFile(file string, size int64) error {
+	// Start afresh: fallocate won't shrink a file left by an earlier
+	// boot, and it may not have been a complete swap file.
+	os.Remove(file)
+	if err := runCommand("fallocate", "-l", strconv.FormatInt(size, 10), file); err != nil {
+		// Some file systems don't support fallocate, and swapon
+		// rejects files with holes, so write zeros instead.
+		log.Printf("%v; writing zeros instead", err)
+		mb := (size + 1<<20 - 1) >> 20
+		if err := runCommand("dd", "if=/dev/zero", "of="+file, "bs=1M", "count="+strconv.FormatInt(mb, 10)); err != nil {
+			os.Remove(file)
+			return err
+		}
+	}
+	if err := os.Chmod(file, 0600); err != nil {
+		return err
+	}
+	if err := runCommand("mkswap", file); err != nil {
+		return err
+	}
+	return runCommand("swapon", file)
+}
+
+// makeZramSwap configures a zram device of size bytes, enables it as
+// swap, and returns its path.
+func makeZramSwap(size int64) (string, error) {
+	if _, err := os.Stat("/sys/class/zram-control"); err != nil {
+		if err := runCommand("modprobe", "zram", "num_devices=0"); err != nil {
+			return "", err
+		}
+	}
+	dev, err := freeZramDevice()
+	if err != nil {
+		return "", err
+	}
+	sys := filepath.Join("/sys/block", dev)
+	if err := ioutil.WriteFile(filepath.Join(sys, "disksize"), []byte(strconv.FormatInt(size, 10)), 0644); err != nil {
+		return "", err
+	}
+	path := "/dev/" + dev
+	if err := runCommand("mkswap", path); err != nil {
+		return "", err
+	}
+	// Prefer the compressed RAM to any swap file.
+	if err := runCommand("swapon", "-p", "100", path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// freeZramDevice returns the name of an unused zram device, such as
+// "zram0", adding one if needed.
+func freeZramDevice() (string, error) {
+	devs, _ := filepath.Glob("/sys/block/zram*")
+	for _, d := range devs {
+		b, err := ioutil.ReadFile(filepath.Join(d, "disksize"))
+		if err == nil && strings.TrimSpace(string(b)) == "0" {
+			return filepath.Base(d), nil
+		}
+	}
+	b, err := ioutil.ReadFile("/sys/class/zram-control/hot_add")
+	if err != nil {
+		return "", fmt.Errorf("adding zram device: %v", err)
+	}
+	return "zram" + strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,16 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+func makeSwapFile(file string, size int64) error {
+	return errSwapUnsupported
+}
+
+func makeZramSwap(size int64) (string, error) {
+	return "", errSwapUnsupported
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"2G", 2 << 30},
+		{"512m", 512 << 20},
+		{"1GiB", 1 << 30},
+		{"64KB", 64 << 10},
+		{"4096", 4096},
+		{" 1T ", 1 << 40},
+	}
+	for _, tt := range tests {
+		if got, err := parseSize(tt.in); got != tt.want || err != nil {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "G", "2X", "-1G", "0", "1.5G", "2GG", "99999999999T"} {
+		if n, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) = %d; want error", in, n)
+		}
+	}
+}
+
+func TestParseSwaps(t *testing.T) {
+	const in = `Filename				Type		Size		Used		Priority
+/dev/zram0                              partition	1015804		0		100
+/swapfile                               file		2097148		1024		-2
+`
+	got, err := parseSwaps(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []swapArea{{"/dev/zram0", 1015804 << 10}, {"/swapfile", 2097148 << 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseSwaps = %v; want %v", got, want)
+	}
+	if dev := zramSwap(got); dev != "/dev/zram0" {
+		t.Errorf("zramSwap = %q", dev)
+	}
+	if total := totalSwap(got); total < 2<<30-swapSlack {
+		t.Errorf("totalSwap = %d; want to count as 2G of swap", total)
+	}
+	if _, err := parseSwaps(strings.NewReader("header\n/swapfile file lots\n")); err == nil {
+		t.Error("parseSwaps with bad size succeeded")
+	}
+}
+
+func TestSwapConfig(t *testing.T) {
+	for _, k := range []string{"IN_KUBERNETES", "META_SETUP_SWAP", "META_SETUP_ZRAM"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("IN_KUBERNETES", "1") // not GCE; use the environment
+	os.Unsetenv("META_SETUP_SWAP")
+	os.Unsetenv("META_SETUP_ZRAM")
+
+	if swap, zram, err := swapConfig(); swap != 0 || zram != 0 || err != nil {
+		t.Errorf("unconfigured: %d, %d, %v; want 0, 0, nil", swap, zram, err)
+	}
+	os.Setenv("META_SETUP_SWAP", "2G")
+	os.Setenv("META_SETUP_ZRAM", "512M")
+	if swap, zram, err := swapConfig(); swap != 2<<30 || zram != 512<<20 || err != nil {
+		t.Errorf("from env: %d, %d, %v", swap, zram, err)
+	}
+	os.Setenv("META_SETUP_SWAP", "lots")
+	if _, _, err := swapConfig(); err == nil || !strings.Contains(err.Error(), "setup-swap") {
+		t.Errorf("bad size error = %v", err)
+	}
+}
+
+func TestSetupSwapInContainer(t *testing.T) {
+	for _, k := range []string{"IN_KUBERNETES", "META_SETUP_SWAP"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("IN_KUBERNETES", "1")
+	os.Setenv("META_SETUP_SWAP", "1G")
+	defer func(f func() string) { inContainer = f }(inContainer)
+	inContainer = func() string { return "test" }
+	// It must return without touching the machine's swap.
+	setupSwap()
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var cpuGovernorFlag = flag.String("cpu-governor", "", "if non-empty, the Linux CPU frequency governor, such as performance, to set for all CPUs before running the buildlet; overrides the cpu-governor metadata attribute")
+
+// cpuGovernorAttr is the optional GCE instance attribute for
+// --cpu-governor. Off GCE, the META_CPU_GOVERNOR environment variable
+// is used instead.
+const cpuGovernorAttr = "cpu-governor"
+
+// cpuGovernorFiles matches each CPU's governor setting. CPUs without
+// frequency scaling have none.
+const cpuGovernorFiles = "/sys/devices/system/cpu/cpu*/cpufreq/scaling_governor"
+
+// sysWrites returns the settings to make before running the buildlet:
+// h's Writes and then any --cpu-governor.
+func sysWrites(h *hostConfig) []string {
+	writes := h.Writes
+	gov := *cpuGovernorFlag
+	if !flagWasSet("cpu-governor") {
+		gov = metaValue(cpuGovernorAttr, "META_CPU_GOVERNOR")
+	}
+	if gov != "" {
+		writes = append(writes[:len(writes):len(writes)], cpuGovernorFiles+"="+gov)
+	}
+	return writes
+}
+
+// applySysWrites makes the settings in writes (see hostConfig.Writes)
+// on Linux, outside containers. Failures are logged, but don't stop
+// the buildlet from running.
+func applySysWrites(writes []string) {
+	if len(writes) == 0 {
+		return
+	}
+	if runtime.GOOS != "linux" {
+		log.Printf("ignoring sysctl and sysfs settings on %s: %s", runtime.GOOS, strings.Join(writes, " "))
+		return
+	}
+	if why := inContainer(); why != "" {
+		log.Printf("ignoring sysctl and sysfs settings: running in a container (%s)", why)
+		return
+	}
+	for _, w := range writes {
+		file, value, err := parseSysWrite(w)
+		if err == nil {
+			err = writeSysFiles(file, value)
+		}
+		if err != nil {
+			log.Printf("setting %s: %v", w, err)
+		}
+	}
+}
+
+// parseSysWrite parses a setting of the form "key=value", returning
+// the file (or glob pattern) to write value to.
+func parseSysWrite(w string) (file, value string, err error) {
+	i := strings.Index(w, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("%q isn't of the form key=value", w)
+	}
+	key, value := w[:i], w[i+1:]
+	if !strings.HasPrefix(key, "/") {
+		for _, r := range key {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-' || r == '.') {
+				return "", "", fmt.Errorf("%q isn't a sysctl name", key)
+			}
+		}
+		return "/proc/sys/" + strings.Replace(key, ".", "/", -1), value, nil
+	}
+	if c := path.Clean(key); c != key || !strings.HasPrefix(c, "/sys/") && !strings.HasPrefix(c, "/proc/sys/") {
+		return "", "", fmt.Errorf("%q isn't under /sys or /proc/sys", key)
+	}
+	if _, err := filepath.Match(key, ""); err != nil {
+		return "", "", fmt.Errorf("%q: %v", key, err)
+	}
+	return key, value, nil
+}
+
+// writeSysFiles writes value to each file matching pattern, logging
+// the values before and after. It tries every file, returning the
+// first error.
+func writeSysFiles(pattern, value string) error {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no files match")
+	}
+	var firstErr error
+	for _, f := range files {
+		before := readSysFile(f)
+		if before == value {
+			log.Printf("%s: already %s", f, value)
+			continue
+		}
+		if err := ioutil.WriteFile(f, []byte(value+"\n"), 0644); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Printf("%s: %s -> %s", f, before, readSysFile(f))
+	}
+	return firstErr
+}
+
+// readSysFile returns the trimmed contents of file, or a description
+// of the error reading it.
+func readSysFile(file string) string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Sprintf("(%v)", err)
+	}
+	return strings.TrimSpace(string(b))
+}
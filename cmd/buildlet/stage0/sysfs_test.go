@@ -0,0 +1,88 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSysWrite(t *testing.T) {
+	tests := []struct {
+		in, file, value string
+	}{
+		{"vm.swappiness=10", "/proc/sys/vm/swappiness", "10"},
+		{"kernel.core_pattern=core.%e", "/proc/sys/kernel/core_pattern", "core.%e"},
+		{cpuGovernorFiles + "=performance", cpuGovernorFiles, "performance"},
+		{"/proc/sys/vm/overcommit_memory=1", "/proc/sys/vm/overcommit_memory", "1"},
+	}
+	for _, tt := range tests {
+		file, value, err := parseSysWrite(tt.in)
+		if file != tt.file || value != tt.value || err != nil {
+			t.Errorf("parseSysWrite(%q) = %q, %q, %v; want %q, %q", tt.in, file, value, err, tt.file, tt.value)
+		}
+	}
+	for _, in := range []string{"vm.swappiness", "=1", "vm/swappiness=1", "/etc/hosts=x", "/sys/../etc/hosts=x", "/sys/[=x"} {
+		if _, _, err := parseSysWrite(in); err == nil {
+			t.Errorf("parseSysWrite(%q) succeeded; want error", in)
+		}
+	}
+}
+
+func TestWriteSysFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage0-sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, cpu := range []string{"cpu0", "cpu1"} {
+		if err := os.MkdirAll(filepath.Join(dir, cpu, "cpufreq"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, cpu, "cpufreq", "scaling_governor"), []byte("ondemand\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A CPU without frequency scaling.
+	if err := os.Mkdir(filepath.Join(dir, "cpu2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeSysFiles(filepath.Join(dir, "cpu*", "cpufreq", "scaling_governor"), "performance"); err != nil {
+		t.Fatal(err)
+	}
+	for _, cpu := range []string{"cpu0", "cpu1"} {
+		if got := readSysFile(filepath.Join(dir, cpu, "cpufreq", "scaling_governor")); got != "performance" {
+			t.Errorf("%s governor = %q; want performance", cpu, got)
+		}
+	}
+	if err := writeSysFiles(filepath.Join(dir, "nope", "*"), "1"); err == nil {
+		t.Error("writing to no files succeeded")
+	}
+}
+
+func TestSysWrites(t *testing.T) {
+	for _, k := range []string{"IN_KUBERNETES", "META_CPU_GOVERNOR"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("IN_KUBERNETES", "1") // not GCE; use the environment
+	os.Unsetenv("META_CPU_GOVERNOR")
+
+	h := &hostConfig{Writes: []string{"vm.swappiness=10"}}
+	if got, want := sysWrites(h), h.Writes; !reflect.DeepEqual(got, want) {
+		t.Errorf("without governor: %q; want %q", got, want)
+	}
+	os.Setenv("META_CPU_GOVERNOR", "performance")
+	want := []string{"vm.swappiness=10", cpuGovernorFiles + "=performance"}
+	if got := sysWrites(h); !reflect.DeepEqual(got, want) {
+		t.Errorf("with governor: %q; want %q", got, want)
+	}
+	if len(h.Writes) != 1 {
+		t.Errorf("sysWrites modified the host config: %q", h.Writes)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var timingsURL = flag.String("timings-url", "https://farmer.golang.org/stage0-timings", `URL to POST bootstrap phase timings to as JSON, or "off"`)
+
+// timingsReportTimeout bounds how long reporting timings may take.
+const timingsReportTimeout = 5 * time.Second
+
+// serialSetupTime is how long configuring serial console logging
+// took, if it was done.
+var serialSetupTime time.Duration
+
+// bootTimings records how long each phase of bootstrapping took.
+// Phases that were skipped are zero.
+type bootTimings struct {
+	Serial   time.Duration
+	Network  time.Duration
+	Resolve  time.Duration
+	Download time.Duration
+	Chmod    time.Duration
+	Exec     time.Duration
+	Total    time.Duration
+}
+
+// phases returns the timings' names and values, in order.
+func (t *bootTimings) phases() []struct {
+	name string
+	d    time.Duration
+} {
+	return []struct {
+		name string
+		d    time.Duration
+	}{
+		{"serial", t.Serial},
+		{"network", t.Network},
+		{"resolve", t.Resolve},
+		{"download", t.Download},
+		{"chmod", t.Chmod},
+		{"exec", t.Exec},
+		{"total", t.Total},
+	}
+}
+
+// String returns the timings as space-separated name=duration pairs.
+func (t *bootTimings) String() string {
+	var parts []string
+	for _, p := range t.phases() {
+		parts = append(parts, fmt.Sprintf("%s=%v", p.name, prettyDuration(p.d)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// env returns the environment variable passing the timings to the
+// buildlet.
+func (t *bootTimings) env() string {
+	return "GO_STAGE0_TIMINGS=" + strings.Replace(t.String(), " ", ",", -1)
+}
+
+// timingsReport is the JSON body of a timings report. Durations are
+// in seconds.
+type timingsReport struct {
+	Hostname   string             `json:"hostname"`
+	BuilderEnv string             `json:"builderEnv"`
+	OSArch     string             `json:"osArch"`
+	Version    string             `json:"version"`
+	Timings    map[string]float64 `json:"timings"`
+}
+
+// report makes a best-effort attempt to POST t to --timings-url.
+func (t *bootTimings) report() {
+	if *timingsURL == "" || *timingsURL == "off" {
+		return
+	}
+	r := timingsReport{
+		BuilderEnv: os.Getenv("GO_BUILDER_ENV"),
+		OSArch:     osArch,
+		Version:    stage0Version(),
+		Timings:    map[string]float64{},
+	}
+	r.Hostname, _ = os.Hostname()
+	for _, p := range t.phases() {
+		r.Timings[p.name] = p.d.Seconds()
+	}
+	body, _ := json.Marshal(r)
+	c := &http.Client{
+		Timeout:   timingsReportTimeout,
+		Transport: http.DefaultClient.Transport,
+	}
+	res, err := c.Post(*timingsURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("reporting boot timings: %v", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		log.Printf("reporting boot timings to %s: %v", *timingsURL, res.Status)
+	}
+}
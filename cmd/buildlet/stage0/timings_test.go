@@ -0,0 +1,55 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBootTimingsString(t *testing.T) {
+	bt := &bootTimings{
+		Network:  4200 * time.Millisecond,
+		Download: 38 * time.Second,
+		Total:    44 * time.Second,
+	}
+	want := "serial=0s network=4.2s resolve=0s download=38s chmod=0s exec=0s total=44s"
+	if got := bt.String(); got != want {
+		t.Errorf("String = %q; want %q", got, want)
+	}
+	if got, want := bt.env(), "GO_STAGE0_TIMINGS=serial=0s,network=4.2s,resolve=0s,download=38s,chmod=0s,exec=0s,total=44s"; got != want {
+		t.Errorf("env = %q; want %q", got, want)
+	}
+}
+
+func TestBootTimingsReport(t *testing.T) {
+	var got timingsReport
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+	defer func(v string) { *timingsURL = v }(*timingsURL)
+	*timingsURL = ts.URL
+
+	bt := &bootTimings{Network: 2 * time.Second, Total: 3 * time.Second}
+	bt.report()
+	if got.OSArch != osArch {
+		t.Errorf("osArch = %q; want %q", got.OSArch, osArch)
+	}
+	want := map[string]float64{"serial": 0, "network": 2, "resolve": 0, "download": 0, "chmod": 0, "exec": 0, "total": 3}
+	if len(got.Timings) != len(want) {
+		t.Errorf("timings = %v; want %v", got.Timings, want)
+	}
+	for k, v := range want {
+		if d, ok := got.Timings[k]; !ok || d != v {
+			t.Errorf("timings[%q] = %v, %v; want %v", k, d, ok, v)
+		}
+	}
+}
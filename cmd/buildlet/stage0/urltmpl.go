@@ -0,0 +1,56 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// expandBuildletURL expands the placeholders in a buildlet URL from
+// the environment or metadata (see expandURL), returning the empty
+// string if it has unknown placeholders.
+func expandBuildletURL(u string) string {
+	x, err := expandURL(u)
+	if err != nil {
+		log.Printf("invalid buildlet URL %q: %v", u, err)
+		return ""
+	}
+	if x != u {
+		log.Printf("expanded buildlet URL %q to %q", u, x)
+	}
+	return x
+}
+
+// expandURL expands $GOOS, $GOARCH, and $GO_BUILDER_ENV (or their
+// ${...} forms) in u, so one URL setting can serve several kinds of
+// hosts. "$$" is a literal "$". Any other placeholder is an error.
+func expandURL(u string) (string, error) {
+	if !strings.Contains(u, "$") {
+		return u, nil
+	}
+	var unknown []string
+	x := os.Expand(u, func(name string) string {
+		switch name {
+		case "GOOS":
+			return runtime.GOOS
+		case "GOARCH":
+			return runtime.GOARCH
+		case "GO_BUILDER_ENV":
+			return os.Getenv("GO_BUILDER_ENV")
+		case "$":
+			return "$"
+		}
+		unknown = append(unknown, "$"+name)
+		return ""
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholder %s; want $GOOS, $GOARCH, or $GO_BUILDER_ENV", strings.Join(unknown, ", "))
+	}
+	return x, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestExpandURL(t *testing.T) {
+	defer os.Setenv("GO_BUILDER_ENV", os.Getenv("GO_BUILDER_ENV"))
+	os.Setenv("GO_BUILDER_ENV", "host-linux-arm64-qemu")
+	goosArch := runtime.GOOS + "-" + runtime.GOARCH
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "https://example.com/buildlet.linux-amd64", want: "https://example.com/buildlet.linux-amd64"},
+		{in: "https://example.com/buildlet?a=b&c=%20d", want: "https://example.com/buildlet?a=b&c=%20d"},
+		{in: "https://example.com/buildlet.$GOOS-$GOARCH", want: "https://example.com/buildlet." + goosArch},
+		{in: "https://example.com/buildlet.${GOOS}-${GOARCH}.gz", want: "https://example.com/buildlet." + goosArch + ".gz"},
+		{in: "https://example.com/$GO_BUILDER_ENV/buildlet", want: "https://example.com/host-linux-arm64-qemu/buildlet"},
+		{in: "https://a.example/$GOOS,https://b.example/$GOOS", want: "https://a.example/" + runtime.GOOS + ",https://b.example/" + runtime.GOOS},
+		{in: "https://example.com/price$$", want: "https://example.com/price$"},
+		{in: "https://example.com/buildlet.$GOHOSTOS", wantErr: true},
+		{in: "https://example.com/${HOME}/buildlet", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := expandURL(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandURL(%q) error = %v; want error = %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expandURL(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+var (
+	pubKeyFile       = flag.String("buildlet-pubkey-file", "", "if non-empty, file containing the base64 Ed25519 public key used to verify the buildlet's detached signature, overriding the built-in key")
+	insecureNoVerify = flag.Bool("insecure-no-verify", false, "skip verifying the buildlet's detached signature; for local development only")
+)
+
+// buildletPubKey is the base64 Ed25519 public key used to verify
+// buildlet binaries. It's set at build time with:
+//
+//	go build -ldflags="-X main.buildletPubKey=<base64 key>"
+//
+// If it's empty and --buildlet-pubkey-file isn't set, signatures aren't
+// checked, and a warning is logged.
+var buildletPubKey string
+
+// verifySignature downloads the detached Ed25519 signature at
+// url+".sig" and checks it against the contents of file.
+// It's a no-op if --insecure-no-verify is set or no public key is
+// configured.
+func verifySignature(file, url string) error {
+	if *insecureNoVerify {
+		log.Printf("--insecure-no-verify set; not checking signature of %s", url)
+		return nil
+	}
+	pub, err := signingKey()
+	if err != nil {
+		return err
+	}
+	if pub == nil {
+		log.Printf("*** no buildlet public key configured; not checking signature of %s ***", url)
+		return nil
+	}
+	sigFile := file + ".sig"
+	defer removeCache(sigFile)
+	if err := download(sigFile, url+".sig", nil); err != nil {
+		return err
+	}
+	sig, err := readSignature(sigFile)
+	if err != nil {
+		return err
+	}
+	msg, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, msg, sig) {
+		return fmt.Errorf("bad signature for %s with key %s", url, keyFingerprint(pub))
+	}
+	log.Printf("verified signature of %s with key %s", url, keyFingerprint(pub))
+	return nil
+}
+
+// signingKey returns the configured buildlet public key, or nil if
+// none is configured.
+func signingKey() (ed25519.PublicKey, error) {
+	src, v := "built-in key", buildletPubKey
+	if *pubKeyFile != "" {
+		b, err := ioutil.ReadFile(*pubKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		src, v = *pubKeyFile, string(b)
+	}
+	if v == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil {
+		return nil, fmt.Errorf("decoding public key from %s: %v", src, err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key from %s is %d bytes; want %d", src, len(b), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// readSignature reads an Ed25519 signature from file, in either raw
+// or base64 form.
+func readSignature(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == ed25519.SignatureSize {
+		return b, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, errors.New("malformed signature file")
+	}
+	return sig, nil
+}
+
+// keyFingerprint returns a short identifier for pub, suitable for
+// logging.
+func keyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+	defer func(v string) { buildletPubKey = v }(buildletPubKey)
+	defer func(v string) { *pubKeyFile = v }(*pubKeyFile)
+	defer func(v bool) { *insecureNoVerify = v }(*insecureNoVerify)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const binary = "buildlet binary"
+	goodSig := ed25519.Sign(priv, []byte(binary))
+	pubB64 := base64.StdEncoding.EncodeToString(pub)
+
+	tmpDir, err := ioutil.TempDir("", "stage0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	writeFile := func(name, content string) string {
+		file := filepath.Join(tmpDir, name)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	target := writeFile("buildlet.exe", binary)
+
+	tests := []struct {
+		name     string
+		sig      string // served at the .sig URL, or "" for 404
+		key      string // built-in key
+		keyFile  string // --buildlet-pubkey-file
+		insecure bool
+		wantErr  string // or "" for success
+	}{
+		{name: "good raw", sig: string(goodSig), key: pubB64},
+		{name: "good base64", sig: base64.StdEncoding.EncodeToString(goodSig) + "\n", key: pubB64},
+		{name: "good from key file", sig: string(goodSig), keyFile: writeFile("good.pub", pubB64+"\n")},
+		{name: "bad", sig: string(ed25519.Sign(otherPriv, []byte(binary))), key: pubB64, wantErr: "bad signature for URL with key " + keyFingerprint(pub)},
+		{name: "malformed", sig: "not a signature", key: pubB64, wantErr: "malformed signature file"},
+		{name: "missing", key: pubB64, wantErr: "404 Not Found"},
+		{name: "unreadable key file", sig: string(goodSig), keyFile: filepath.Join(tmpDir, "missing.pub"), wantErr: "missing.pub"},
+		{name: "bad key file", sig: string(goodSig), keyFile: writeFile("bad.pub", "not base64!"), wantErr: "decoding public key from"},
+		{name: "short key file", sig: string(goodSig), keyFile: writeFile("short.pub", base64.StdEncoding.EncodeToString(pub[:16])), wantErr: "is 16 bytes; want 32"},
+		{name: "no key", sig: "not a signature"},
+		{name: "no key insecure", insecure: true},
+		{name: "bad insecure", sig: "not a signature", key: pubB64, insecure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/buildlet.sig" || tt.sig == "" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(tt.sig))
+			}))
+			defer ts.Close()
+			buildletPubKey, *pubKeyFile, *insecureNoVerify = tt.key, tt.keyFile, tt.insecure
+
+			url := ts.URL + "/buildlet"
+			err := verifySignature(target, url)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("verifySignature succeeded; want error")
+			}
+			if want := strings.Replace(tt.wantErr, "URL", url, 1); !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q doesn't contain %q", err, want)
+			}
+			if tt.name == "bad" {
+				// run logs this error, wrapped, as its fatal line; an
+				// operator needs both the URL and which key rejected it.
+				for _, want := range []string{url, keyFingerprint(pub)} {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("fatal error %q doesn't name %q", err, want)
+					}
+				}
+			}
+			if _, err := os.Stat(target + ".sig"); !os.IsNotExist(err) {
+				t.Errorf("signature file left behind: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"runtime/debug"
+)
+
+var versionFlag = flag.Bool("version", false, "print stage0's version and exit")
+
+// version is stage0's version. It may be set at build time with:
+//
+//	go build -ldflags="-X main.version=$(git rev-parse --short HEAD)"
+//
+// Otherwise, the VCS revision recorded by the go command is used, if
+// any.
+var version string
+
+// stage0Version returns stage0's version, or "devel" if unknown.
+func stage0Version() string {
+	if version != "" {
+		return version
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	var rev string
+	var dirty bool
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if rev != "" {
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		if dirty {
+			rev += "-dirty"
+		}
+		return rev
+	}
+	if v := bi.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	return "devel"
+}
+
+// userAgent returns the User-Agent header stage0's HTTP requests use,
+// so server logs can identify stale stage0 binaries.
+func userAgent() string {
+	return "Go-stage0/" + stage0Version()
+}
+
+// userAgentTransport is an http.RoundTripper that sets the User-Agent
+// of requests without one.
+type userAgentTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", userAgent())
+	}
+	return t.rt.RoundTrip(req)
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var watchdogTimeout = flag.Duration("watchdog-timeout", 15*time.Minute, "kill the buildlet if its heartbeat file ($GO_BUILDLET_HEARTBEAT_FILE) goes this long without being touched; 0 disables. Buildlets that never write the file aren't affected.")
+
+// heartbeatCheckInterval is how often the watchdog checks the
+// heartbeat file.
+var heartbeatCheckInterval = time.Minute
+
+// heartbeatFile returns the path of the file the buildlet is asked to
+// touch periodically, or the empty string if the watchdog is off.
+func heartbeatFile() string {
+	if *watchdogTimeout <= 0 {
+		return ""
+	}
+	return filepath.Join(os.TempDir(), "go-buildlet-heartbeat")
+}
+
+// startWatchdog starts watching file, which must not exist yet, for
+// the buildlet's heartbeat. Once the file appears, if its
+// modification time gets more than timeout old, the watchdog sends
+// its age on the returned channel and stops. Calling stop stops the
+// watchdog. If file is empty, the channel never receives.
+func startWatchdog(file string, timeout time.Duration) (stale <-chan time.Duration, stop func()) {
+	c := make(chan time.Duration, 1)
+	if file == "" {
+		return c, func() {}
+	}
+	done := make(chan struct{})
+	go watchHeartbeat(file, timeout, heartbeatCheckInterval, done, c)
+	return c, func() { close(done) }
+}
+
+func watchHeartbeat(file string, timeout, interval time.Duration, done <-chan struct{}, stale chan<- time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	seen := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			if seen {
+				log.Printf("watchdog: heartbeat file %s: %v", file, err)
+			}
+			continue
+		}
+		if !seen {
+			seen = true
+			log.Printf("watchdog: buildlet heartbeat file %s appeared at %v; killing the buildlet if it goes %v without a heartbeat", file, fi.ModTime().Format(time.RFC3339), timeout)
+		}
+		if age := time.Since(fi.ModTime()); age > timeout {
+			log.Printf("watchdog: last buildlet heartbeat was at %v, %v ago", fi.ModTime().Format(time.RFC3339), prettyDuration(age))
+			stale <- age
+			return
+		}
+	}
+}
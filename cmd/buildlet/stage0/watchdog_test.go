@@ -0,0 +1,81 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchHeartbeat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "heartbeat")
+	const (
+		timeout  = 200 * time.Millisecond
+		interval = 10 * time.Millisecond
+	)
+
+	// No heartbeat file: the watchdog never fires.
+	done := make(chan struct{})
+	stale := make(chan time.Duration, 1)
+	go watchHeartbeat(file, timeout, interval, done, stale)
+	select {
+	case age := <-stale:
+		t.Fatalf("watchdog fired (age %v) without a heartbeat file", age)
+	case <-time.After(3 * timeout):
+	}
+
+	// A fresh heartbeat keeps it quiet.
+	beat := func() {
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	beat()
+	for i := 0; i < 10; i++ {
+		select {
+		case age := <-stale:
+			t.Fatalf("watchdog fired (age %v) with a fresh heartbeat", age)
+		case <-time.After(timeout / 4):
+			beat()
+		}
+	}
+
+	// A stale heartbeat fires it.
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case age := <-stale:
+		if age < time.Hour {
+			t.Errorf("stale age = %v; want at least 1h", age)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("watchdog didn't fire on a stale heartbeat")
+	}
+	close(done)
+}
+
+func TestHeartbeatFileDisabled(t *testing.T) {
+	defer func(d time.Duration) { *watchdogTimeout = d }(*watchdogTimeout)
+	*watchdogTimeout = 0
+	if f := heartbeatFile(); f != "" {
+		t.Errorf("heartbeatFile with --watchdog-timeout=0 = %q; want empty", f)
+	}
+	for _, kv := range buildletEnv(0, 0) {
+		if strings.HasPrefix(kv, "GO_BUILDLET_HEARTBEAT_FILE=") {
+			t.Errorf("buildletEnv contains %s with the watchdog off", kv)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	workdirFlag  = flag.String("buildlet-workdir", "", "if non-empty, the --workdir to pass to the buildlet, overriding any metadata or built-in default")
+	workdirClean = flag.Bool("workdir-clean", false, "empty the buildlet's workdir when stage0 starts, for hosts where state left by previous runs causes flakes")
+)
+
+var workdirMinFree = byteSize(1 << 30)
+
+func init() {
+	flag.Var(&workdirMinFree, "workdir-min-free-space", "minimum free space required on the buildlet workdir's filesystem, such as 10GB; 0 disables the check")
+}
+
+// workdirCleaned is whether --workdir-clean has been done. It's done
+// once, when stage0 starts, not each time the buildlet is restarted.
+var workdirCleaned bool
+
+// workdirAttr is the optional GCE instance attribute containing the
+// buildlet's work directory. Off GCE, the META_BUILDLET_WORKDIR
+// environment variable is used instead.
+const workdirAttr = "buildlet-workdir"
+
+// configuredWorkdir returns the buildlet work directory from
+// --buildlet-workdir or, failing that, metadata, or the empty string
+// to use the built-in default.
+func configuredWorkdir() string {
+	if *workdirFlag != "" {
+		return *workdirFlag
+	}
+	return metaValue(workdirAttr, "META_BUILDLET_WORKDIR")
+}
+
+// setWorkdir returns args with any --workdir flags replaced by one for
+// dir, if dir is non-empty.
+func setWorkdir(args []string, dir string) []string {
+	if dir == "" {
+		return args
+	}
+	var out []string
+	for _, a := range args {
+		if flagName(a) == "workdir" {
+			log.Printf("using workdir %q instead of built-in %q", dir, a)
+			continue
+		}
+		out = append(out, a)
+	}
+	return append(out, "--workdir="+dir)
+}
+
+// workdirArg returns the value of the last --workdir flag in args,
+// or the empty string if there is none.
+func workdirArg(args []string) string {
+	dir := ""
+	for _, a := range args {
+		if flagName(a) == "workdir" {
+			if i := strings.Index(a, "="); i >= 0 {
+				dir = a[i+1:]
+			}
+		}
+	}
+	return dir
+}
+
+// prepareWorkdir creates dir if needed, first emptying it per
+// --workdir-clean, and checks that it's writable and has
+// --workdir-min-free-space free. Its errors name dir's filesystem, in
+// case the one meant to be mounted there isn't.
+func prepareWorkdir(dir string) error {
+	if *workdirClean && !workdirCleaned {
+		if err := cleanWorkdir(dir); err != nil {
+			return err
+		}
+		workdirCleaned = true
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating buildlet workdir %s%s: %v", dir, onMount(dir), err)
+	}
+	f, err := ioutil.TempFile(dir, "stage0-writable-check")
+	if err != nil {
+		return fmt.Errorf("buildlet workdir %s%s isn't writable: %v", dir, onMount(dir), err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	if workdirMinFree <= 0 {
+		return nil
+	}
+	have, mount, err := diskFree(dir)
+	switch {
+	case err == errDiskFreeUnsupported:
+	case err != nil:
+		log.Printf("can't determine free space for buildlet workdir %s: %v; continuing", dir, err)
+	case have < int64(workdirMinFree):
+		return fmt.Errorf("buildlet workdir %s (on %s) has only %s free; need %s (--workdir-min-free-space)", dir, mount, formatBytes(have), formatBytes(int64(workdirMinFree)))
+	}
+	return nil
+}
+
+// onMount returns " (on MOUNT)", naming the filesystem holding dir or,
+// if it doesn't exist, its nearest parent that does, or the empty
+// string if that can't be determined.
+func onMount(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			if _, mount, err := diskFree(d); err == nil {
+				return " (on " + mount + ")"
+			}
+			return ""
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// cleanWorkdir removes the contents of dir, for --workdir-clean.
+func cleanWorkdir(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(abs) == abs {
+		return fmt.Errorf("--workdir-clean: refusing to empty %s", abs)
+	}
+	ents, err := ioutil.ReadDir(abs)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("--workdir-clean: %v", err)
+	}
+	for _, e := range ents {
+		p := filepath.Join(abs, e.Name())
+		if err := os.RemoveAll(p); err != nil {
+			// The module cache, for one, is read-only.
+			makeWritable(p)
+			if err := os.RemoveAll(p); err != nil {
+				return fmt.Errorf("--workdir-clean: %v", err)
+			}
+		}
+	}
+	log.Printf("emptied buildlet workdir %s (--workdir-clean): removed %d entries", abs, len(ents))
+	return nil
+}
+
+// makeWritable makes the directories in the tree rooted at root
+// writable by their owner, so their contents can be removed.
+func makeWritable(root string) {
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() && fi.Mode().Perm()&0700 != 0700 {
+			os.Chmod(p, fi.Mode().Perm()|0700)
+		}
+		return nil
+	})
+}
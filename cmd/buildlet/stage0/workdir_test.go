@@ -0,0 +1,158 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSetWorkdir(t *testing.T) {
+	args := []string{"./buildlet.exe", "--workdir=/workdir", "--halt=false"}
+	if got := setWorkdir(args, ""); !reflect.DeepEqual(got, args) {
+		t.Errorf("setWorkdir(args, \"\") = %q; want unchanged", got)
+	}
+	got := setWorkdir(args, "/mnt/big")
+	want := []string{"./buildlet.exe", "--halt=false", "--workdir=/mnt/big"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("setWorkdir = %q; want %q", got, want)
+	}
+	if dir := workdirArg(got); dir != "/mnt/big" {
+		t.Errorf("workdirArg = %q; want %q", dir, "/mnt/big")
+	}
+	if dir := workdirArg([]string{"./buildlet.exe"}); dir != "" {
+		t.Errorf("workdirArg without --workdir = %q; want empty", dir)
+	}
+}
+
+func TestConfiguredWorkdirPrecedence(t *testing.T) {
+	defer os.Setenv("META_BUILDLET_WORKDIR", os.Getenv("META_BUILDLET_WORKDIR"))
+	defer func(v string) { *workdirFlag = v }(*workdirFlag)
+	if os.Getenv("IN_KUBERNETES") != "1" {
+		os.Setenv("IN_KUBERNETES", "1")
+		defer os.Unsetenv("IN_KUBERNETES")
+	}
+
+	os.Setenv("META_BUILDLET_WORKDIR", "/from/meta")
+	if got := configuredWorkdir(); got != "/from/meta" {
+		t.Errorf("with metadata: %q; want /from/meta", got)
+	}
+	*workdirFlag = "/from/flag"
+	if got := configuredWorkdir(); got != "/from/flag" {
+		t.Errorf("with flag and metadata: %q; want /from/flag", got)
+	}
+}
+
+func TestPrepareWorkdir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "stage0-workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func() { diskFree = statDiskFree }()
+	diskFree = func(dir string) (int64, string, error) { return 2 << 30, "/data", nil }
+
+	dir := filepath.Join(tmp, "a", "b")
+	if err := prepareWorkdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		t.Fatalf("workdir not created: %v", err)
+	}
+	if ents, _ := ioutil.ReadDir(dir); len(ents) != 0 {
+		t.Errorf("writability check left files behind: %v", ents)
+	}
+
+	if runtime.GOOS == "windows" || os.Getuid() == 0 {
+		return // permissions aren't enforced
+	}
+	ro := filepath.Join(tmp, "ro")
+	if err := os.Mkdir(ro, 0555); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareWorkdir(ro); err == nil {
+		t.Error("prepareWorkdir of read-only dir succeeded")
+	}
+}
+
+func TestPrepareWorkdirFreeSpace(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "stage0-workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func() { diskFree = statDiskFree }()
+	defer func(v byteSize) { workdirMinFree = v }(workdirMinFree)
+	workdirMinFree = 1 << 30
+
+	diskFree = func(dir string) (int64, string, error) { return 100 << 20, "/", nil }
+	err = prepareWorkdir(tmp)
+	if want := "buildlet workdir " + tmp + " (on /) has only 100.0MB free; need 1.0GB"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("prepareWorkdir error = %v; want %q", err, want)
+	}
+
+	workdirMinFree = 0
+	if err := prepareWorkdir(tmp); err != nil {
+		t.Errorf("with the check disabled: %v", err)
+	}
+	workdirMinFree = 1 << 30
+	diskFree = func(dir string) (int64, string, error) { return 0, "", errDiskFreeUnsupported }
+	if err := prepareWorkdir(tmp); err != nil {
+		t.Errorf("on unsupported platform: %v", err)
+	}
+}
+
+func TestCleanWorkdir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "stage0-workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func() { diskFree = statDiskFree }()
+	diskFree = func(dir string) (int64, string, error) { return 2 << 30, "/data", nil }
+	defer func(v bool) { *workdirClean, workdirCleaned = v, false }(*workdirClean)
+	*workdirClean = true
+	workdirCleaned = false
+
+	// A read-only tree, like the module cache.
+	mod := filepath.Join(tmp, "gopath", "pkg", "mod", "example.com@v1")
+	if err := os.MkdirAll(mod, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(mod, "go.mod"), nil, 0444); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(mod, 0555)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "stale"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := prepareWorkdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if ents, _ := ioutil.ReadDir(tmp); len(ents) != 0 {
+		t.Errorf("workdir not emptied: %v", ents)
+	}
+
+	// It's only emptied once.
+	if err := ioutil.WriteFile(filepath.Join(tmp, "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareWorkdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "keep")); err != nil {
+		t.Errorf("workdir emptied again: %v", err)
+	}
+
+	if err := cleanWorkdir(string(filepath.Separator)); err == nil {
+		t.Error("cleanWorkdir of the root directory succeeded")
+	}
+}
@@ -35,6 +35,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"math/rand"
@@ -350,11 +351,16 @@ func (p *reverseBuildletPool) WriteHTMLStatus(w io.Writer) {
 		if b.inUse {
 			machStatus = "working"
 		}
-		fmt.Fprintf(&buf, "<li>%s (%s) version %s, %s: connected %s, %s for %s</li>\n",
+		tags := ""
+		if len(b.hostTags) > 0 {
+			tags = " [" + html.EscapeString(strings.Join(b.hostTags, " ")) + "]"
+		}
+		fmt.Fprintf(&buf, "<li>%s (%s) version %s, %s%s: connected %s, %s for %s</li>\n",
 			b.hostname,
 			b.conn.RemoteAddr(),
 			b.version,
 			b.hostType,
+			tags,
 			friendlyDuration(time.Since(b.regTime)),
 			machStatus,
 			friendlyDuration(time.Since(b.inUseTime)))
@@ -451,6 +457,9 @@ type reverseBuildlet struct {
 	hostname string
 	// version is the reverse buildlet's version.
 	version string
+	// hostTags are the buildlet's --host-tag values, describing
+	// the machine, such as "facility=ams1".
+	hostTags []string
 
 	// sessRand is the unique random number for every unique buildlet session.
 	sessRand string
@@ -498,6 +507,7 @@ func handleReverse(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	hostname := r.Header.Get("X-Go-Builder-Hostname")
+	hostTags := r.Header["X-Go-Builder-Host-Tag"]
 
 	for i, m := range modes {
 		if gobuildkeys[i] != builderKey(m) {
@@ -535,8 +545,8 @@ func handleReverse(w http.ResponseWriter, r *http.Request) {
 	}
 
 	revDialer := revdial.NewDialer(bufrw, conn)
-	log.Printf("Registering reverse buildlet %q (%s) for host type %v%s",
-		hostname, r.RemoteAddr, hostType, legacyNote)
+	log.Printf("Registering reverse buildlet %q (%s) for host type %v%s, tags %q",
+		hostname, r.RemoteAddr, hostType, legacyNote, hostTags)
 
 	(&http.Response{StatusCode: http.StatusSwitchingProtocols, Proto: "HTTP/1.1"}).Write(conn)
 
@@ -593,6 +603,7 @@ func handleReverse(w http.ResponseWriter, r *http.Request) {
 	b := &reverseBuildlet{
 		hostname:  hostname,
 		version:   r.Header.Get("X-Go-Builder-Version"),
+		hostTags:  hostTags,
 		hostType:  hostType,
 		client:    client,
 		conn:      conn,
@@ -0,0 +1,144 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestHelperDownload isn't a real test. It's used as a subprocess by
+// TestDownloadKilled, so the download can be killed partway through.
+func TestHelperDownload(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	Download(os.Getenv("HTTPDL_FILE"), os.Getenv("HTTPDL_URL"), WithResume(os.Getenv("HTTPDL_RESUME") == "1"))
+	os.Exit(0)
+}
+
+func TestDownloadKilled(t *testing.T) {
+	defer resetHooks()
+
+	const oldContent = "old content"
+	newContent := strings.Repeat("new content ", 1000)
+	someTime := time.Unix(1462292149, 0)
+	// mode is what GET requests get: the whole file, or half of it
+	// followed by a hang (for the download to be killed during)
+	// or by the connection closing.
+	const (
+		whole = iota
+		hang
+		cut
+	)
+	var mode int32 // atomic
+	started := make(chan bool, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := atomic.LoadInt32(&mode)
+		if r.Method != "GET" || m == whole {
+			http.ServeContent(w, r, "foo.txt", someTime, strings.NewReader(newContent))
+			return
+		}
+		w.Header().Set("Last-Modified", someTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(newContent)))
+		io.WriteString(w, newContent[:len(newContent)/2])
+		w.(http.Flusher).Flush()
+		if m == cut {
+			panic(http.ErrAbortHandler)
+		}
+		select {
+		case started <- true:
+		default:
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		name   string
+		old    bool // whether there's an old file to replace
+		resume bool
+	}{
+		{"replace", true, false},
+		{"replace with resume", true, true},
+		{"new", false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+			if tt.old {
+				if err := ioutil.WriteFile(dstFile, []byte(oldContent), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkDst := func(when string) {
+				t.Helper()
+				b, err := ioutil.ReadFile(dstFile)
+				switch {
+				case tt.old && (err != nil || string(b) != oldContent):
+					t.Errorf("%s: file = %q, %v; want the old content", when, b, err)
+				case !tt.old && !os.IsNotExist(err):
+					t.Errorf("%s: file = %d bytes, %v; want no file", when, len(b), err)
+				}
+			}
+
+			atomic.StoreInt32(&mode, hang)
+			cmd := exec.Command(os.Args[0], "-test.run=^TestHelperDownload$")
+			cmd.Env = append(os.Environ(),
+				"GO_WANT_HELPER_PROCESS=1",
+				"HTTPDL_FILE="+dstFile,
+				"HTTPDL_URL="+ts.URL+"/foo.txt",
+				"HTTPDL_RESUME="+map[bool]string{true: "1"}[tt.resume],
+			)
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-started:
+			case <-time.After(10 * time.Second):
+				cmd.Process.Kill()
+				t.Fatal("download didn't start")
+			}
+			if err := cmd.Process.Kill(); err != nil {
+				t.Fatal(err)
+			}
+			cmd.Wait()
+			checkDst("after killed download")
+
+			// A download that fails without being killed
+			// doesn't touch the file either.
+			atomic.StoreInt32(&mode, cut)
+			if err := Download(dstFile, ts.URL+"/foo.txt", WithResume(tt.resume)); err == nil {
+				t.Fatal("cut-off download succeeded")
+			}
+			checkDst("after failed download")
+
+			atomic.StoreInt32(&mode, whole)
+			if err := Download(dstFile, ts.URL+"/foo.txt", WithResume(tt.resume)); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := ioutil.ReadFile(dstFile); err != nil || string(b) != newContent {
+				t.Errorf("after complete download: file = %d bytes, %v; want the new content", len(b), err)
+			}
+		})
+	}
+}
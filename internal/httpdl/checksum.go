@@ -0,0 +1,72 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is the error returned when a downloaded file
+// doesn't have the SHA-256 checksum given by WithSHA256 or
+// WithSHA256Sum. The file isn't kept.
+type ErrChecksumMismatch struct {
+	Got, Want string // lowercase hex
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("SHA-256 checksum mismatch: got %s, want %s", e.Got, e.Want)
+}
+
+// WithSHA256 makes the download fail with an *ErrChecksumMismatch
+// unless the downloaded file's SHA-256 checksum is sum, in hex.
+// The checksum is computed as the file is written.
+func WithSHA256(sum string) Option {
+	return func(o *options) {
+		b, err := hex.DecodeString(strings.TrimSpace(sum))
+		if err != nil || len(b) != sha256.Size {
+			o.err = fmt.Errorf("httpdl: invalid SHA-256 checksum %q", sum)
+			return
+		}
+		o.sha256 = b
+	}
+}
+
+// WithSHA256Sum is like WithSHA256, but takes the checksum as raw
+// bytes, such as those returned by sha256.Sum256.
+func WithSHA256Sum(sum []byte) Option {
+	return func(o *options) {
+		if len(sum) != sha256.Size {
+			o.err = fmt.Errorf("httpdl: SHA-256 checksum is %d bytes, not %d", len(sum), sha256.Size)
+			return
+		}
+		o.sha256 = append([]byte(nil), sum...)
+	}
+}
+
+// checkSum returns an *ErrChecksumMismatch if h's sum isn't want.
+func checkSum(h hash.Hash, want []byte) error {
+	got := h.Sum(nil)
+	if string(got) != string(want) {
+		return &ErrChecksumMismatch{Got: hex.EncodeToString(got), Want: hex.EncodeToString(want)}
+	}
+	return nil
+}
+
+// hashFile adds the contents of file to h.
+func hashFile(h hash.Hash, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
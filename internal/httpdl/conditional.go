@@ -0,0 +1,67 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrNotModified is returned by a download made WithConditional when
+// the local file is already up to date. The file isn't touched.
+var ErrNotModified = errors.New("httpdl: not modified")
+
+// WithConditional makes the download ask the server for the file only
+// if it's changed since the local copy was downloaded, like curl's -z
+// option. Instead of the usual HEAD request, it sends a GET request
+// with If-Modified-Since set to the local file's modification time
+// and If-None-Match set to the ETag stored when it was downloaded. If
+// the server says the file hasn't changed, the download returns
+// ErrNotModified.
+//
+// The ETag is stored in a file alongside the downloaded one. See
+// etagFile.
+func WithConditional() Option {
+	return func(o *options) { o.conditional = true }
+}
+
+// etagFile returns the name of the file holding the ETag of file, for
+// conditional downloads. Its contents are the ETag header the server
+// sent with file, exactly as sent, including any W/ prefix and the
+// quotes, followed by a newline. It only exists if the server sent
+// one.
+func etagFile(file string) string { return file + ".etag" }
+
+// setConditionalHeaders sets the headers in h that make a GET request
+// for file conditional on its having changed.
+func setConditionalHeaders(h http.Header, file string) {
+	fi, err := os.Stat(file)
+	if err != nil || !fi.Mode().IsRegular() {
+		return
+	}
+	h.Set("If-Modified-Since", fi.ModTime().UTC().Format(http.TimeFormat))
+	if b, err := ioutil.ReadFile(etagFile(file)); err == nil {
+		if etag := strings.TrimSuffix(string(b), "\n"); etag != "" {
+			h.Set("If-None-Match", etag)
+		}
+	}
+}
+
+// saveETag records the ETag of res, the response that file was just
+// downloaded from, for a later conditional download, or removes the
+// stale one if conditional is false or res has none.
+func saveETag(file string, res *http.Response, conditional bool) error {
+	etag := res.Header.Get("ETag")
+	if !conditional || etag == "" {
+		if err := os.Remove(etagFile(file)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(etagFile(file), []byte(etag+"\n"), 0666)
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// validatorServer serves content with an optional ETag and
+// Last-Modified time, answering conditional requests.
+type validatorServer struct {
+	mu      sync.Mutex
+	content string
+	etag    string
+	modTime time.Time // or zero for no Last-Modified
+	reqs    []http.Header
+}
+
+func (s *validatorServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	content, etag, modTime := s.content, s.etag, s.modTime
+	s.reqs = append(s.reqs, r.Header)
+	s.mu.Unlock()
+	if etag == "" && modTime.IsZero() {
+		io.WriteString(w, content)
+		return
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, "foo.txt", modTime, strings.NewReader(content))
+}
+
+func (s *validatorServer) set(content, etag string, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content, s.etag, s.modTime = content, etag, modTime
+	s.reqs = nil
+}
+
+func TestDownloadConditional(t *testing.T) {
+	t1 := time.Unix(1462292149, 0)
+	t2 := t1.Add(time.Hour)
+	tests := []struct {
+		name              string
+		etag1, etag2      string
+		mod1, mod2        time.Time
+		content2          string
+		wantNotModified   bool
+		wantIfNoneMatch   string
+		wantIfModSince    string
+		wantETagFileAfter string // or "" for none
+	}{
+		{
+			name:  "not modified",
+			etag1: `"v1"`, etag2: `"v1"`,
+			mod1: t1, mod2: t1,
+			content2:          "old",
+			wantNotModified:   true,
+			wantIfNoneMatch:   `"v1"`,
+			wantIfModSince:    t1.UTC().Format(http.TimeFormat),
+			wantETagFileAfter: `"v1"`,
+		},
+		{
+			name:  "new etag",
+			etag1: `"v1"`, etag2: `"v2"`,
+			mod1: t1, mod2: t2,
+			content2:          "new",
+			wantIfNoneMatch:   `"v1"`,
+			wantIfModSince:    t1.UTC().Format(http.TimeFormat),
+			wantETagFileAfter: `"v2"`,
+		},
+		{
+			name:     "modified, no etag",
+			mod1:     t1,
+			mod2:     t2,
+			content2: "new",
+			// The server's response had no ETag, so
+			// there's none to send.
+			wantIfModSince: t1.UTC().Format(http.TimeFormat),
+		},
+		{
+			name:     "no validators",
+			content2: "new",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := new(validatorServer)
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+
+			s.set("old", tt.etag1, tt.mod1)
+			if err := Download(dstFile, ts.URL+"/foo.txt", WithConditional()); err != nil {
+				t.Fatal(err)
+			}
+			if len(s.reqs) != 1 {
+				t.Fatalf("first download made %d requests; want just a GET", len(s.reqs))
+			}
+			fi1, err := os.Stat(dstFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.mod1.IsZero() {
+				// Make sure If-Modified-Since would
+				// change the outcome if it were sent.
+				os.Chtimes(dstFile, t1, t1)
+				fi1, _ = os.Stat(dstFile)
+			}
+
+			s.set(tt.content2, tt.etag2, tt.mod2)
+			err = Download(dstFile, ts.URL+"/foo.txt", WithConditional())
+			if tt.wantNotModified {
+				if err != ErrNotModified {
+					t.Fatalf("second download = %v; want ErrNotModified", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadFile(dstFile)
+			if string(b) != tt.content2 {
+				t.Errorf("file = %q; want %q", b, tt.content2)
+			}
+			if fi2, _ := os.Stat(dstFile); tt.wantNotModified && !fi2.ModTime().Equal(fi1.ModTime()) {
+				t.Errorf("not-modified file's modtime changed from %v to %v", fi1.ModTime(), fi2.ModTime())
+			}
+			h := s.reqs[0]
+			if got := h.Get("If-None-Match"); got != tt.wantIfNoneMatch {
+				t.Errorf("If-None-Match = %q; want %q", got, tt.wantIfNoneMatch)
+			}
+			if got := h.Get("If-Modified-Since"); tt.wantIfModSince != "" && got != tt.wantIfModSince {
+				t.Errorf("If-Modified-Since = %q; want %q", got, tt.wantIfModSince)
+			}
+			etag, err := ioutil.ReadFile(etagFile(dstFile))
+			if tt.wantETagFileAfter == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("ETag file = %q, %v; want none", etag, err)
+				}
+			} else if string(etag) != tt.wantETagFileAfter+"\n" {
+				t.Errorf("ETag file = %q, %v; want %q", etag, err, tt.wantETagFileAfter+"\n")
+			}
+		})
+	}
+}
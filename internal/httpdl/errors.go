@@ -0,0 +1,135 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// An HTTPError is an unsuccessful HTTP response.
+type HTTPError struct {
+	Method     string
+	URL        string
+	Status     string        // such as "404 Not Found"
+	StatusCode int           // such as 404
+	Snippet    string        // the start of the response body, if any
+	RetryAfter time.Duration // from the Retry-After header, at most 5 minutes, or 0
+}
+
+// snippetLen is how much of an unsuccessful response's body is kept
+// in its HTTPError.
+const snippetLen = 256
+
+// CheckResponse returns an *HTTPError for res unless its status is
+// 2xx, reading the start of the body for its Snippet. It's for
+// callers making their own requests to classify the failures like the
+// downloads' with IsRetryable.
+func CheckResponse(res *http.Response) error {
+	if res.StatusCode/100 == 2 {
+		return nil
+	}
+	return newHTTPError(res.Request.URL.String(), res)
+}
+
+// newHTTPError returns the error for the unsuccessful response res to
+// a request for url.
+func newHTTPError(url string, res *http.Response) *HTTPError {
+	e := &HTTPError{
+		Method:     res.Request.Method,
+		URL:        url,
+		Status:     res.Status,
+		StatusCode: res.StatusCode,
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+	}
+	if res.Request.Method != "HEAD" {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, snippetLen))
+		e.Snippet = strings.TrimSpace(string(b))
+	}
+	return e
+}
+
+func (e *HTTPError) Error() string {
+	var msg string
+	if e.Method == "HEAD" {
+		msg = fmt.Sprintf("HTTP response of %s was %v (after HEAD request)", e.URL, e.Status)
+	} else {
+		msg = fmt.Sprintf("HTTP status code of %s was %v", e.URL, e.Status)
+	}
+	if e.Snippet != "" {
+		msg += fmt.Sprintf(" (%q)", e.Snippet)
+	}
+	return msg
+}
+
+// A WriteError is a failure to write a download to its destination,
+// such as a full disk.
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string { return "writing download: " + e.Err.Error() }
+func (e *WriteError) Unwrap() error { return e.Err }
+
+// NoSpace reports whether the write failed because the disk is full.
+func (e *WriteError) NoSpace() bool { return isNoSpace(e.Err) }
+
+// writeError returns err as a *WriteError, or nil if it's nil.
+func writeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &WriteError{err}
+}
+
+// A writeErrWriter is an io.Writer whose errors are *WriteErrors.
+type writeErrWriter struct {
+	w io.Writer
+}
+
+func (w writeErrWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	return n, writeError(err)
+}
+
+// ErrTruncated is wrapped by the error returned when the server sends
+// less of the file than it said it would, or the connection is cut
+// off in the middle of it.
+var ErrTruncated = errors.New("httpdl: download truncated")
+
+// IsRetryable reports whether the failure err might not happen again,
+// even if the download was already retried: network errors, including
+// timeouts and truncated downloads, and HTTP 5xx, 408 Request Timeout,
+// and 429 Too Many Requests responses. A *MirrorsError is retryable if
+// any of the mirrors' errors is.
+func IsRetryable(err error) bool {
+	var me *MirrorsError
+	if errors.As(err, &me) {
+		for _, err := range me.Errs {
+			if IsRetryable(err) {
+				return true
+			}
+		}
+		return false
+	}
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.StatusCode/100 == 5 || he.StatusCode == http.StatusRequestTimeout || he.StatusCode == http.StatusTooManyRequests
+	}
+	if errors.As(err, new(*WriteError)) {
+		return false
+	}
+	if errors.Is(err, ErrTruncated) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
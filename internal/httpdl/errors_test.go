@@ -0,0 +1,111 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDownloadErrors(t *testing.T) {
+	const content = "some content, which is long enough to cut in half"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, "no such file", http.StatusNotFound)
+		case "/busy":
+			w.Header().Set("Retry-After", "7")
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		case "/broken":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		case "/short":
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			io.WriteString(w, content[:len(content)/2])
+		default:
+			io.WriteString(w, content)
+		}
+	}))
+	defer ts.Close()
+	tmpDir, err := ioutil.TempDir("", "dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dstFile := filepath.Join(tmpDir, "foo.txt")
+
+	err = Download(dstFile, ts.URL+"/missing")
+	var he *HTTPError
+	if !errors.As(err, &he) || he.StatusCode != 404 || he.URL != ts.URL+"/missing" || he.Method != "HEAD" {
+		t.Errorf("404 error = %#v; want an *HTTPError for the HEAD request", err)
+	}
+	if IsRetryable(err) {
+		t.Errorf("404 error %v is retryable", err)
+	}
+
+	_, err = DownloadTo(context.Background(), ioutil.Discard, ts.URL+"/busy")
+	if !errors.As(err, &he) || he.StatusCode != 503 || he.Snippet != "try later" || he.RetryAfter != 7*time.Second {
+		t.Errorf("503 error = %#v; want an *HTTPError with the body and Retry-After", err)
+	}
+	if !IsRetryable(err) {
+		t.Errorf("503 error %v isn't retryable", err)
+	}
+	_, err = DownloadTo(context.Background(), ioutil.Discard, ts.URL+"/broken", WithRetry(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}))
+	if !errors.As(err, &he) || he.StatusCode != 500 || !IsRetryable(err) {
+		t.Errorf("500 error after retries = %v; want a retryable *HTTPError", err)
+	}
+
+	_, err = DownloadTo(context.Background(), ioutil.Discard, ts.URL+"/short")
+	if !errors.Is(err, ErrTruncated) || !IsRetryable(err) {
+		t.Errorf("truncated download error = %v; want a retryable ErrTruncated", err)
+	}
+
+	sum := sha256.Sum256([]byte("other content"))
+	err = Download(dstFile, ts.URL+"/foo.txt", WithSHA256Sum(sum[:]))
+	var ce *ErrChecksumMismatch
+	if !errors.As(err, &ce) || IsRetryable(err) {
+		t.Errorf("checksum error = %v; want an *ErrChecksumMismatch that's not retryable", err)
+	}
+
+	err = Download(filepath.Join(tmpDir, "no-such-dir", "foo.txt"), ts.URL+"/foo.txt")
+	var we *WriteError
+	if !errors.As(err, &we) || !os.IsNotExist(we.Err) || we.NoSpace() || IsRetryable(err) {
+		t.Errorf("error downloading to a missing directory = %v; want a *WriteError", err)
+	}
+
+	err = DownloadFrom(dstFile, []string{ts.URL + "/missing", ts.URL + "/busy"})
+	if !IsRetryable(err) {
+		t.Errorf("mirrors error %v isn't retryable, though one mirror was busy", err)
+	}
+	err = DownloadFrom(dstFile, []string{ts.URL + "/missing", ts.URL + "/missing"})
+	if IsRetryable(err) {
+		t.Errorf("mirrors error %v is retryable, though both mirrors said 404", err)
+	}
+}
+
+func TestHTTPErrorMessage(t *testing.T) {
+	tests := []struct {
+		e    *HTTPError
+		want string
+	}{
+		{&HTTPError{Method: "HEAD", URL: "http://x/y", Status: "404 Not Found", StatusCode: 404}, "HTTP response of http://x/y was 404 Not Found (after HEAD request)"},
+		{&HTTPError{Method: "GET", URL: "http://x/y", Status: "404 Not Found", StatusCode: 404}, "HTTP status code of http://x/y was 404 Not Found"},
+		{&HTTPError{Method: "GET", URL: "http://x/y", Status: "403 Forbidden", StatusCode: 403, Snippet: "go away"}, `HTTP status code of http://x/y was 403 Forbidden ("go away")`},
+	}
+	for _, tt := range tests {
+		if got := tt.e.Error(); got != tt.want {
+			t.Errorf("Error() = %q; want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/build/internal/httpdl"
+)
+
+func ExampleWithProgress() {
+	content := bytes.Repeat([]byte("go"), 1<<20)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "go.tar.gz", time.Unix(1462292149, 0), bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "httpdl")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// How often progress is called depends on the network, so
+	// just keep the last report and check the count never went
+	// backwards.
+	var written, total int64
+	monotonic := true
+	progress := func(w, t int64) {
+		if w < written {
+			monotonic = false
+		}
+		written, total = w, t
+	}
+	err = httpdl.Download(filepath.Join(dir, "go.tar.gz"), ts.URL+"/go.tar.gz",
+		httpdl.WithProgress(httpdl.MinProgressInterval, progress))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("downloaded %d of %d bytes; monotonic: %v\n", written, total, monotonic)
+	// Output: downloaded 2097152 of 2097152 bytes; monotonic: true
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// WithHeader adds the header key: value to every request the download
+// sends, including retries and Range and conditional requests, such as
+// an Authorization header for a private server. It may be given more
+// than once, even for the same key.
+//
+// So credentials don't leak, the headers aren't sent on to other hosts
+// that the server redirects to.
+func WithHeader(key, value string) Option {
+	return func(o *options) {
+		if o.header == nil {
+			o.header = make(http.Header)
+		}
+		o.header.Add(key, value)
+	}
+}
+
+// WithUserAgent sets the User-Agent header of every request the
+// download sends. Unlike the headers of WithHeader, it's also sent to
+// the hosts the server redirects to.
+func WithUserAgent(ua string) Option {
+	return func(o *options) { o.userAgent = ua }
+}
+
+// newRequest returns a request for url with the download's headers.
+func (o *options) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range o.header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	if o.userAgent != "" {
+		req.Header.Set("User-Agent", o.userAgent)
+	}
+	return req.WithContext(ctx), nil
+}
+
+// stripOnRedirect returns a copy of c that removes the headers in h
+// from requests redirected to a host other than the original
+// request's. The http package only does that for a few headers it
+// knows are sensitive, such as Authorization.
+func stripOnRedirect(c *http.Client, h http.Header) *http.Client {
+	c2 := *c
+	check := c.CheckRedirect
+	c2.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+			for k := range h {
+				req.Header.Del(k)
+			}
+		}
+		if check != nil {
+			return check(req, via)
+		}
+		// The http package's default policy.
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &c2
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// headerRecorder records the method, path and headers of each request
+// before passing it on to h.
+type headerRecorder struct {
+	h http.Handler
+
+	mu   sync.Mutex
+	reqs []string // "METHOD /path"
+	hdrs []http.Header
+}
+
+func (s *headerRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.reqs = append(s.reqs, r.Method+" "+r.URL.Path)
+	s.hdrs = append(s.hdrs, r.Header)
+	s.mu.Unlock()
+	s.h.ServeHTTP(w, r)
+}
+
+var testHeaders = []Option{
+	WithHeader("Authorization", "Bearer secret"),
+	WithHeader("X-Token", "a"),
+	WithHeader("X-Token", "b"),
+	WithUserAgent("test-builder/1.0"),
+}
+
+// checkHeaders reports whether h has the headers of testHeaders, or if
+// want is false, has none of them but the User-Agent.
+func checkHeaders(h http.Header, want bool) bool {
+	if h.Get("User-Agent") != "test-builder/1.0" {
+		return false
+	}
+	if !want {
+		return h.Get("Authorization") == "" && h.Get("X-Token") == ""
+	}
+	return h.Get("Authorization") == "Bearer secret" && reflect.DeepEqual(h["X-Token"], []string{"a", "b"})
+}
+
+func TestDownloadHeaders(t *testing.T) {
+	defer func(v int64) { minChunkSize = v }(minChunkSize)
+	minChunkSize = 10
+
+	const content = "some content, which is long enough to cut in half"
+	retry := WithRetry(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	tests := []struct {
+		name     string
+		h        http.Handler
+		opts     []Option
+		twice    bool   // download twice
+		wantHdr  string // a header some request must have
+		wantReqs int
+	}{
+		{
+			name:     "retry cut",
+			h:        &flakyServer{content: content, mode: "cut", fails: 1},
+			opts:     []Option{retry},
+			wantHdr:  "Range",
+			wantReqs: 3,
+		},
+		{
+			name:     "conditional",
+			h:        &validatorServer{content: content, etag: `"v1"`},
+			opts:     []Option{WithConditional()},
+			twice:    true,
+			wantHdr:  "If-None-Match",
+			wantReqs: 2,
+		},
+		{
+			name:     "parallel",
+			h:        &rangeServer{content: content, failChunks: 1},
+			opts:     []Option{WithParallel(2), retry},
+			wantHdr:  "If-Range",
+			wantReqs: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &headerRecorder{h: tt.h}
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+
+			opts := append(tt.opts, testHeaders...)
+			for i := 0; i < 1 || tt.twice && i < 2; i++ {
+				if err := Download(dstFile, ts.URL+"/foo.txt", opts...); err != nil && err != ErrNotModified {
+					t.Fatal(err)
+				}
+			}
+			if len(s.reqs) != tt.wantReqs {
+				t.Errorf("requests = %q; want %d", s.reqs, tt.wantReqs)
+			}
+			found := false
+			for i, h := range s.hdrs {
+				if !checkHeaders(h, true) {
+					t.Errorf("%s request headers = %v; want the ones given", s.reqs[i], h)
+				}
+				if h.Get(tt.wantHdr) != "" {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("no request had a %s header", tt.wantHdr)
+			}
+		})
+	}
+}
+
+func TestDownloadHeadersRedirect(t *testing.T) {
+	const content = "some content"
+	other := &headerRecorder{h: &validatorServer{content: content, modTime: time.Unix(1462292149, 0)}}
+	otherTS := httptest.NewServer(other)
+	defer otherTS.Close()
+	origin := &headerRecorder{h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/foo.txt", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, otherTS.URL+"/foo.txt", http.StatusFound)
+		default:
+			http.ServeContent(w, r, "foo.txt", time.Unix(1462292149, 0), strings.NewReader(content))
+		}
+	})}
+	originTS := httptest.NewServer(origin)
+	defer originTS.Close()
+	tmpDir, err := ioutil.TempDir("", "dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for i, path := range []string{"/same", "/away"} {
+		dstFile := filepath.Join(tmpDir, "foo"+strconv.Itoa(i))
+		if err := Download(dstFile, originTS.URL+path, testHeaders...); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadFile(dstFile); err != nil || string(b) != content {
+			t.Errorf("downloaded %q, %v; want %q", b, err, content)
+		}
+	}
+	wantOrigin := []string{"HEAD /same", "HEAD /foo.txt", "GET /same", "GET /foo.txt", "HEAD /away", "GET /away"}
+	if !reflect.DeepEqual(origin.reqs, wantOrigin) {
+		t.Errorf("origin requests = %q; want %q", origin.reqs, wantOrigin)
+	}
+	for i, h := range origin.hdrs {
+		if !checkHeaders(h, true) {
+			t.Errorf("origin %s request headers = %v; want the ones given", origin.reqs[i], h)
+		}
+	}
+	wantOther := []string{"HEAD /foo.txt", "GET /foo.txt"}
+	if !reflect.DeepEqual(other.reqs, wantOther) {
+		t.Errorf("other host requests = %q; want %q", other.reqs, wantOther)
+	}
+	for i, h := range other.hdrs {
+		if !checkHeaders(h, false) {
+			t.Errorf("other host %s request headers = %v; want only User-Agent", other.reqs[i], h)
+		}
+	}
+}
@@ -6,6 +6,9 @@
 package httpdl
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -32,68 +35,209 @@ func init() {
 // Download downloads url to the named local file.
 //
 // It stops after a HEAD request if the local file's modtime and size
-// look correct.
-func Download(file, url string) error {
+// look correct. Otherwise, it downloads to a temporary file in the
+// same directory with DownloadTo and renames that over file only once
+// it's complete and verified, so file is never left truncated.
+func Download(file, url string, opts ...Option) error {
+	return DownloadContext(context.Background(), file, url, opts...)
+}
+
+// DownloadContext is like Download, but gives up when ctx is done,
+// returning ctx.Err() and removing any partially downloaded file
+// (unless it's kept for WithResume).
+func DownloadContext(ctx context.Context, file, url string, opts ...Option) error {
+	o := newOptions(opts)
+	if o.err != nil {
+		return o.err
+	}
+	return o.download(ctx, file, url)
+}
+
+// download downloads url to file, retrying according to o.retry.
+func (o *options) download(ctx context.Context, file, url string) error {
+	o, err := o.withSidecar(ctx, url)
+	if err != nil {
+		return err
+	}
+	if !o.resume {
+		removePartial(file)
+	}
+	err = o.downloadFile(ctx, file, cacheBust(url), url)
+	if err != nil && !o.resume {
+		removePartial(file)
+	}
+	return err
+}
+
+// cacheBust returns url with a query added to defeat caches, if it's
+// a Google Cloud Storage URL without one.
+func cacheBust(url string) string {
 	// Special case hack to recognize GCS URLs and append a
 	// timestamp as a cache buster...
 	if strings.HasPrefix(url, "https://storage.googleapis.com") && !strings.Contains(url, "?") {
 		url += fmt.Sprintf("?%d", time.Now().Unix())
 	}
+	return url
+}
 
-	if res, err := head(url); err != nil {
-		return err
-	} else if diskFileIsCurrent(file, res) {
-		hookIsCurrent()
-		return nil
+// downloadFile does the work of download. It continues a partial
+// download of origURL, which url may have a cache-busting query added
+// to, if there's one to resume.
+func (o *options) downloadFile(ctx context.Context, file, url, origURL string) error {
+	s := new(streamState)
+	var headRes *http.Response
+	if o.conditional {
+		// A conditional GET takes the place of the HEAD
+		// request.
+		s.cond = make(http.Header)
+		if o.sha256 == nil || fileHasSum(file, o.sha256) {
+			setConditionalHeaders(s.cond, file)
+		}
+	} else {
+		err := o.retry.do(ctx, func() error {
+			res, err := o.head(ctx, url)
+			headRes = res
+			return ctxErr(ctx, err)
+		})
+		if err != nil {
+			return err
+		}
+		if diskFileIsCurrent(file, headRes) && (o.sha256 == nil || fileHasSum(file, o.sha256)) {
+			hookIsCurrent()
+			return nil
+		}
 	}
 
-	res, err := http.Get(url)
-	if err != nil {
-		return err
+	if o.resume {
+		s.off, s.validator = partialState(file, origURL, o.mirrors)
 	}
-	if res.StatusCode != 200 {
-		return fmt.Errorf("HTTP status code of %s was %v", url, res.Status)
+	if s.off == 0 {
+		removePartial(file)
+		if o.parallel > 1 && headRes != nil {
+			if done, err := o.fetchParallel(ctx, file, url, headRes); done {
+				return err
+			}
+		}
 	}
-	modStr := res.Header.Get("Last-Modified")
-	modTime, err := http.ParseTime(modStr)
+	// Write to a temporary file and only replace file with it
+	// once it's complete and verified, so a failed or killed
+	// download never leaves file truncated.
+	tmp := partialFile(file)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
-		return fmt.Errorf("invalid or missing Last-Modified header %q: %v", modStr, err)
+		return &WriteError{err}
+	}
+	if s.off > 0 && o.sha256 != nil {
+		s.h = sha256.New()
+		if err := hashFile(s.h, tmp); err != nil {
+			f.Close()
+			return err
+		}
 	}
-	tmp := file + ".tmp"
-	os.Remove(tmp)
-	os.Remove(file)
-	f, err := os.Create(tmp)
+	var res *http.Response
+	s.check = func(r *http.Response) error {
+		res = r
+		if o.resume {
+			if v := resumeValidator(r); v != "" {
+				writeResumeState(file, origURL, v)
+			}
+		}
+		return nil
+	}
+	st, err := o.stream(ctx, f, url, s)
 	if err != nil {
+		f.Close()
+		if s.off == 0 || errors.As(err, new(*ErrChecksumMismatch)) {
+			// There's nothing to resume, or it's corrupt.
+			removePartial(file)
+		}
 		return err
 	}
-	_, err = io.Copy(f, res.Body)
-	res.Body.Close()
-	if err != nil {
-		return fmt.Errorf("error copying %v to %v: %v", url, file, err)
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
-	if err := f.Close(); err != nil {
-		return err
+	os.Remove(resumeStateFile(file))
+	if err == nil && !o.noModTime && !st.ModTime.IsZero() {
+		err = os.Chtimes(tmp, st.ModTime, st.ModTime)
 	}
-	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
-		return err
+	if err == nil {
+		err = os.Rename(tmp, file)
 	}
-	if err := os.Rename(tmp, file); err != nil {
-		return err
+	if err == nil {
+		err = saveETag(file, res, o.conditional)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error copying %v to %v: %w", url, file, &WriteError{err})
 	}
 	return nil
 }
 
-func head(url string) (*http.Response, error) {
-	res, err := http.Head(url)
+func (o *options) head(ctx context.Context, url string) (*http.Response, error) {
+	req, err := o.newRequest(ctx, "HEAD", url)
+	if err != nil {
+		return nil, err
+	}
+	res, err := o.httpClient().Do(req)
 	if err != nil {
 		return nil, err
 	}
+	res.Body.Close()
 	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("HTTP response of %s was %v (after HEAD request)", url, res.Status)
+		return nil, newHTTPError(url, res)
 	}
 	return res, nil
 }
 
+// get sends a GET request for url, adding the headers in cond. If
+// offset is positive, it asks for the bytes from offset on, if the
+// object still matches validator.
+func (o *options) get(ctx context.Context, url string, offset int64, validator string, cond http.Header) (*http.Response, error) {
+	req, err := o.newRequest(ctx, "GET", url)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range cond {
+		req.Header[k] = v
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+	return o.httpClient().Do(req)
+}
+
+// ctxErr returns ctx.Err() if ctx is done, since that's why err
+// happened, and otherwise err.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// ctxReader is an io.Reader that stops reading once ctx is done.
+// Cancelling a request's context usually unblocks reads of its body
+// anyway, but not always promptly when data is already buffered.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// fileHasSum reports whether file's SHA-256 checksum is sum.
+func fileHasSum(file string, sum []byte) bool {
+	h := sha256.New()
+	return hashFile(h, file) == nil && checkSum(h, sum) == nil
+}
+
 func diskFileIsCurrent(file string, res *http.Response) bool {
 	fi, err := os.Stat(file)
 	if err != nil || !fi.Mode().IsRegular() {
@@ -5,12 +5,21 @@
 package httpdl
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -79,3 +88,362 @@ func TestDownload(t *testing.T) {
 		t.Fatal("should've re-downloaded after size change")
 	}
 }
+
+func TestDownloadContextCancel(t *testing.T) {
+	defer resetHooks()
+
+	someTime := time.Unix(1462292149, 0)
+	started := make(chan bool, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", someTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", "1000000")
+		if r.Method == "HEAD" {
+			return
+		}
+		w.Write([]byte(strings.Repeat("x", 1000)))
+		w.(http.Flusher).Flush()
+		started <- true
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	tmpDir, err := ioutil.TempDir("", "dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dstFile := filepath.Join(tmpDir, "foo.txt")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	errc := make(chan error, 1)
+	go func() { errc <- DownloadContext(ctx, dstFile, ts.URL+"/foo.txt") }()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("DownloadContext = %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("DownloadContext didn't return after its context was cancelled")
+	}
+	if fis, err := ioutil.ReadDir(tmpDir); err != nil {
+		t.Fatal(err)
+	} else if len(fis) != 0 {
+		t.Errorf("%d files left behind, including %s", len(fis), fis[0].Name())
+	}
+}
+
+// resumeServer serves content with the given ETag. If cut is set, GET
+// responses are cut off after their first half.
+type resumeServer struct {
+	mu      sync.Mutex
+	content string
+	etag    string
+	cut     bool
+	ranges  []string // Range headers of GET requests
+}
+
+func (s *resumeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	content, etag, cut := s.content, s.etag, s.cut
+	if r.Method == "GET" {
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+	}
+	s.mu.Unlock()
+	someTime := time.Unix(1462292149, 0)
+	w.Header().Set("ETag", etag)
+	if r.Method == "GET" && cut {
+		w.Header().Set("Last-Modified", someTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		io.WriteString(w, content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	http.ServeContent(w, r, "foo.txt", someTime, strings.NewReader(content))
+}
+
+func (s *resumeServer) set(content, etag string, cut bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content, s.etag, s.cut = content, etag, cut
+	s.ranges = nil
+}
+
+func TestDownloadResume(t *testing.T) {
+	defer resetHooks()
+
+	const oldContent = "this is the old content, which is long enough to cut in half"
+	const newContent = "THIS IS THE NEW CONTENT, WHICH IS LONG ENOUGH TO CUT IN HALF"
+	tests := []struct {
+		name      string
+		content   string
+		etag      string
+		wantRange string
+	}{
+		{"same", oldContent, `"v1"`, fmt.Sprintf("bytes=%d-", len(oldContent)/2)},
+		{"changed", newContent, `"v2"`, fmt.Sprintf("bytes=%d-", len(oldContent)/2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := new(resumeServer)
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+
+			s.set(oldContent, `"v1"`, true)
+			if err := Download(dstFile, ts.URL+"/foo.txt", WithResume(true)); err == nil {
+				t.Fatal("cut-off download succeeded")
+			}
+			if b, err := ioutil.ReadFile(partialFile(dstFile)); err != nil || string(b) != oldContent[:len(oldContent)/2] {
+				t.Fatalf("partial file = %q, %v; want first half", b, err)
+			}
+
+			s.set(tt.content, tt.etag, false)
+			if err := Download(dstFile, ts.URL+"/foo.txt", WithResume(true)); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := ioutil.ReadFile(dstFile); err != nil || string(b) != tt.content {
+				t.Errorf("downloaded %q, %v; want %q", b, err, tt.content)
+			}
+			if len(s.ranges) != 1 || s.ranges[0] != tt.wantRange {
+				t.Errorf("Range headers = %q; want [%q]", s.ranges, tt.wantRange)
+			}
+			if fis, _ := ioutil.ReadDir(tmpDir); len(fis) != 1 {
+				t.Errorf("%d files left in download directory; want 1", len(fis))
+			}
+		})
+	}
+}
+
+func TestDownloadNoResume(t *testing.T) {
+	defer resetHooks()
+
+	s := new(resumeServer)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	tmpDir, err := ioutil.TempDir("", "dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dstFile := filepath.Join(tmpDir, "foo.txt")
+
+	const content = "some content, which is long enough to cut in half"
+	s.set(content, `"v1"`, true)
+	if err := Download(dstFile, ts.URL+"/foo.txt"); err == nil {
+		t.Fatal("cut-off download succeeded")
+	}
+	if _, err := os.Stat(partialFile(dstFile)); !os.IsNotExist(err) {
+		t.Errorf("partial file kept without WithResume: %v", err)
+	}
+	s.set(content, `"v1"`, false)
+	if err := Download(dstFile, ts.URL+"/foo.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.ranges) != 1 || s.ranges[0] != "" {
+		t.Errorf("Range headers = %q; want none", s.ranges)
+	}
+}
+
+func TestCheckContentRange(t *testing.T) {
+	for _, tt := range []struct {
+		v  string
+		ok bool
+	}{
+		{"bytes 100-199/200", true},
+		{"bytes 100-199/*", true},
+		{"bytes 0-199/200", false},
+		{"100-199/200", false},
+		{"bytes */200", false},
+		{"", false},
+	} {
+		if err := checkContentRange(tt.v, 100); (err == nil) != tt.ok {
+			t.Errorf("checkContentRange(%q, 100) = %v; want ok %v", tt.v, err, tt.ok)
+		}
+	}
+}
+
+func TestDownloadSHA256(t *testing.T) {
+	defer resetHooks()
+
+	const content = "some content, which is long enough to cut in half"
+	sum := sha256.Sum256([]byte(content))
+	good := hex.EncodeToString(sum[:])
+	bad := strings.Repeat("0", 64)
+	s := new(resumeServer)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		opt     Option
+		cut     bool // whether to resume after a cut-off first try
+		wantErr bool
+	}{
+		{"hex", WithSHA256(good), false, false},
+		{"bytes", WithSHA256Sum(sum[:]), false, false},
+		{"mismatch", WithSHA256(bad), false, true},
+		{"resumed", WithSHA256(good), true, false},
+		{"resumed mismatch", WithSHA256(bad), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+
+			if tt.cut {
+				s.set(content, `"v1"`, true)
+				if err := Download(dstFile, ts.URL+"/foo.txt", WithResume(true), tt.opt); err == nil {
+					t.Fatal("cut-off download succeeded")
+				}
+			}
+			s.set(content, `"v1"`, false)
+			err = Download(dstFile, ts.URL+"/foo.txt", WithResume(tt.cut), tt.opt)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b, err := ioutil.ReadFile(dstFile); err != nil || string(b) != content {
+					t.Errorf("downloaded %q, %v; want %q", b, err, content)
+				}
+				return
+			}
+			e, ok := err.(*ErrChecksumMismatch)
+			if !ok {
+				t.Fatalf("error = %v; want an *ErrChecksumMismatch", err)
+			}
+			if e.Got != good || e.Want != bad {
+				t.Errorf("error = %+v; want Got %s, Want %s", e, good, bad)
+			}
+			if fis, _ := ioutil.ReadDir(tmpDir); len(fis) != 0 {
+				t.Errorf("%d files left behind, including %s", len(fis), fis[0].Name())
+			}
+		})
+	}
+
+	for _, opt := range []Option{WithSHA256("xyz"), WithSHA256("abcd"), WithSHA256Sum([]byte{1, 2, 3})} {
+		if err := Download(filepath.Join(os.TempDir(), "never"), ts.URL+"/foo.txt", opt); err == nil || !strings.Contains(err.Error(), "SHA-256") {
+			t.Errorf("Download with invalid checksum = %v; want error", err)
+		}
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	defer resetHooks()
+
+	const chunk, chunks = 1000, 6
+	someTime := time.Unix(1462292149, 0)
+	for _, chunked := range []bool{false, true} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Last-Modified", someTime.UTC().Format(http.TimeFormat))
+			if !chunked {
+				w.Header().Set("Content-Length", strconv.Itoa(chunk*chunks))
+			}
+			if r.Method == "HEAD" {
+				return
+			}
+			for i := 0; i < chunks; i++ {
+				w.Write(bytes.Repeat([]byte{'x'}, chunk))
+				w.(http.Flusher).Flush()
+				time.Sleep(50 * time.Millisecond)
+			}
+		}))
+		defer ts.Close()
+		tmpDir, err := ioutil.TempDir("", "dl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		var calls [][2]int64
+		progress := func(written, total int64) { calls = append(calls, [2]int64{written, total}) }
+		if err := Download(filepath.Join(tmpDir, "foo.txt"), ts.URL+"/foo.txt", WithProgress(time.Nanosecond, progress)); err != nil {
+			t.Fatal(err)
+		}
+		wantTotal := int64(chunk * chunks)
+		if chunked {
+			wantTotal = -1
+		}
+		if len(calls) < 2 {
+			t.Fatalf("chunked=%v: progress calls = %v; want at least one before the final one", chunked, calls)
+		}
+		if len(calls) > chunks*50*int(time.Millisecond)/int(MinProgressInterval)+2 {
+			t.Errorf("chunked=%v: %d progress calls; want at most one per %v", chunked, len(calls), MinProgressInterval)
+		}
+		for i, c := range calls {
+			if c[1] != wantTotal {
+				t.Errorf("chunked=%v: call %d total = %d; want %d", chunked, i, c[1], wantTotal)
+			}
+			if i > 0 && c[0] < calls[i-1][0] {
+				t.Errorf("chunked=%v: written went from %d to %d", chunked, calls[i-1][0], c[0])
+			}
+		}
+		if last := calls[len(calls)-1]; last[0] != chunk*chunks {
+			t.Errorf("chunked=%v: final call written = %d; want %d", chunked, last[0], chunk*chunks)
+		}
+	}
+}
+
+// countingTransport counts the requests it sends.
+type countingTransport struct {
+	n int32 // atomic
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.n, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestDownloadWithClient(t *testing.T) {
+	defer resetHooks()
+
+	someTime := time.Unix(1462292149, 0)
+	slow := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" && r.Method == "GET" {
+			w.Header().Set("Last-Modified", someTime.UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "1000")
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			<-slow
+			return
+		}
+		http.ServeContent(w, r, "foo.txt", someTime, strings.NewReader("some content"))
+	}))
+	defer ts.Close()
+	defer close(slow) // before ts.Close
+	tmpDir, err := ioutil.TempDir("", "dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tr := new(countingTransport)
+	c := &http.Client{Transport: tr, Timeout: 200 * time.Millisecond}
+	if err := Download(filepath.Join(tmpDir, "foo.txt"), ts.URL+"/foo.txt", WithClient(c)); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&tr.n); n != 2 {
+		t.Errorf("client sent %d requests; want HEAD and GET", n)
+	}
+
+	t0 := time.Now()
+	if err := Download(filepath.Join(tmpDir, "slow.txt"), ts.URL+"/slow", WithClient(c)); err == nil {
+		t.Error("download of stalled body succeeded")
+	}
+	if d := time.Since(t0); d > 10*time.Second {
+		t.Errorf("download took %v; want it to give up after the client's timeout", d)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DownloadFrom downloads a file from the first of urls, a list of
+// mirrors, that works, trying each in turn with the full retry policy
+// of WithRetry. See WithUsedMirror to find out which one that was.
+//
+// If they all fail, the error is a *MirrorsError with each one's
+// error, unless there's only one URL, in which case it's that URL's.
+func DownloadFrom(file string, urls []string, opts ...Option) error {
+	return DownloadFromContext(context.Background(), file, urls, opts...)
+}
+
+// DownloadFromContext is like DownloadFrom, but gives up when ctx is
+// done, returning ctx.Err().
+func DownloadFromContext(ctx context.Context, file string, urls []string, opts ...Option) error {
+	if len(urls) == 0 {
+		return errors.New("httpdl: no URLs to download from")
+	}
+	o := newOptions(opts)
+	if o.err != nil {
+		return o.err
+	}
+	o.mirrors = urls
+	var errs []error
+	for i, url := range urls {
+		err := o.download(ctx, file, url)
+		if err == nil || err == ErrNotModified {
+			if o.usedMirror != nil {
+				o.usedMirror(i, url)
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		errs = append(errs, err)
+	}
+	if len(urls) == 1 {
+		return errs[0]
+	}
+	return &MirrorsError{URLs: urls, Errs: errs}
+}
+
+// WithUsedMirror makes DownloadFrom call f with the index in its list
+// of URLs, and the URL, of the mirror the file was downloaded from.
+// It's not called if every mirror fails.
+func WithUsedMirror(f func(index int, url string)) Option {
+	return func(o *options) { o.usedMirror = f }
+}
+
+// A MirrorsError is the error returned by DownloadFrom when every
+// mirror fails.
+type MirrorsError struct {
+	URLs []string
+	Errs []error // Errs[i] is why URLs[i] failed
+}
+
+func (e *MirrorsError) Error() string {
+	msgs := make([]string, len(e.URLs))
+	for i, url := range e.URLs {
+		msgs[i] = fmt.Sprintf("%s: %v", url, e.Errs[i])
+	}
+	return fmt.Sprintf("all %d mirrors failed: %s", len(e.URLs), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the mirrors' errors, for errors.Is and errors.As.
+func (e *MirrorsError) Unwrap() []error { return e.Errs }
@@ -0,0 +1,147 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadFrom(t *testing.T) {
+	const content = "some content"
+	policy := RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	broken := &flakyServer{content: content, mode: "500", fails: 1 << 30}
+	brokenTS := httptest.NewServer(broken)
+	defer brokenTS.Close()
+	good := new(resumeServer)
+	good.set(content, `"v1"`, false)
+	goodTS := httptest.NewServer(good)
+	defer goodTS.Close()
+
+	tmpDir, err := ioutil.TempDir("", "dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dstFile := filepath.Join(tmpDir, "foo.txt")
+
+	urls := []string{notFound.URL + "/foo.txt", brokenTS.URL + "/foo.txt", goodTS.URL + "/foo.txt"}
+	used := -1
+	err = DownloadFrom(dstFile, urls, WithRetry(policy), WithUsedMirror(func(i int, url string) {
+		if url != urls[i] {
+			t.Errorf("used mirror %d is %q; want %q", i, url, urls[i])
+		}
+		used = i
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 2 {
+		t.Errorf("used mirror %d; want 2", used)
+	}
+	if b, err := ioutil.ReadFile(dstFile); err != nil || string(b) != content {
+		t.Errorf("downloaded %q, %v; want %q", b, err, content)
+	}
+	if len(broken.ranges) != 2 {
+		t.Errorf("broken mirror got %d GETs; want 2", len(broken.ranges))
+	}
+
+	// Without the good mirror, it fails with both errors.
+	os.Remove(dstFile)
+	used = -1
+	err = DownloadFrom(dstFile, urls[:2], WithRetry(policy), WithUsedMirror(func(i int, url string) { used = i }))
+	var merr *MirrorsError
+	if !errors.As(err, &merr) {
+		t.Fatalf("error = %v; want a *MirrorsError", err)
+	}
+	if len(merr.Errs) != 2 || !strings.Contains(merr.Errs[0].Error(), "404 Not Found") || !strings.Contains(merr.Errs[1].Error(), "giving up after 2 attempts") {
+		t.Errorf("mirror errors = %q", merr.Errs)
+	}
+	for _, url := range urls[:2] {
+		if !strings.Contains(err.Error(), url) {
+			t.Errorf("error %q doesn't mention %s", err, url)
+		}
+	}
+	if used != -1 {
+		t.Errorf("used mirror %d after failing", used)
+	}
+	if fis, _ := ioutil.ReadDir(tmpDir); len(fis) != 0 {
+		t.Errorf("%d files left in download directory; want 0", len(fis))
+	}
+
+	// A single mirror's error is returned as is.
+	err = DownloadFrom(dstFile, urls[:1])
+	if err == nil || errors.As(err, &merr) {
+		t.Errorf("error = %#v; want the mirror's error", err)
+	}
+}
+
+func TestDownloadFromResume(t *testing.T) {
+	const content = "some content, which is long enough to cut in half"
+	tests := []struct {
+		name      string
+		etag1     string
+		etag2     string
+		wantRange string
+	}{
+		{"same etag", `"v1"`, `"v1"`, fmt.Sprintf("bytes=%d-", len(content)/2)},
+		// The If-Range header makes the second mirror send
+		// all of its different file.
+		{"different etag", `"v1"`, `"v2"`, fmt.Sprintf("bytes=%d-", len(content)/2)},
+		{"last-modified", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s1, s2 := new(resumeServer), new(resumeServer)
+			s1.set(content, tt.etag1, true)
+			s2.set(content, tt.etag2, false)
+			ts1, ts2 := httptest.NewServer(s1), httptest.NewServer(s2)
+			defer ts1.Close()
+			defer ts2.Close()
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+
+			urls := []string{ts1.URL + "/foo.txt", ts2.URL + "/foo.txt"}
+			if err := DownloadFrom(dstFile, urls, WithResume(true)); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := ioutil.ReadFile(dstFile); err != nil || string(b) != content {
+				t.Errorf("downloaded %q, %v; want %q", b, err, content)
+			}
+			if len(s2.ranges) != 1 || s2.ranges[0] != tt.wantRange {
+				t.Errorf("second mirror's Range headers = %q; want [%q]", s2.ranges, tt.wantRange)
+			}
+
+			// A partial download isn't resumed from a URL
+			// that's not one of the mirrors.
+			s1.set(content, tt.etag1, true)
+			os.Remove(dstFile)
+			if err := DownloadFrom(dstFile, urls[:1], WithResume(true)); err == nil {
+				t.Fatal("cut-off download succeeded")
+			}
+			s2.set(content, tt.etag1, false)
+			if err := DownloadFrom(dstFile, urls[1:], WithResume(true)); err != nil {
+				t.Fatal(err)
+			}
+			if len(s2.ranges) != 1 || s2.ranges[0] != "" {
+				t.Errorf("Range headers from another mirror list = %q; want [\"\"]", s2.ranges)
+			}
+		})
+	}
+}
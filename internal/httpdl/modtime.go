@@ -0,0 +1,42 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// WithModTime sets whether the downloaded file's modification time is
+// set to the server's Last-Modified time, like curl's -R option,
+// rather than left as the time of the download. The default is true.
+// Without it, Download can't tell from a HEAD request that the file is
+// already current, so it always downloads it again.
+//
+// If the server sends no Last-Modified header, or an invalid one
+// (which is logged), the time of the download is left either way.
+func WithModTime(on bool) Option {
+	return func(o *options) { o.noModTime = !on }
+}
+
+// logf logs problems that don't stop a download. It's a variable for
+// tests.
+var logf = log.Printf
+
+// lastModified returns the Last-Modified time of res, or the zero time
+// if it doesn't have a valid one.
+func lastModified(res *http.Response) time.Time {
+	v := res.Header.Get("Last-Modified")
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		logf("httpdl: ignoring invalid Last-Modified header %q from %s", v, res.Request.URL)
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadModTime(t *testing.T) {
+	defer func(f func(string, ...interface{})) { logf = f }(logf)
+	var logged []string
+	logf = func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+
+	someTime := time.Unix(1462292149, 0)
+	tests := []struct {
+		name    string
+		lastMod string // or "" for none
+		opts    []Option
+		want    time.Time // or zero for the download time
+		wantLog string    // or "" for none
+	}{
+		{name: "valid", lastMod: someTime.UTC().Format(http.TimeFormat), want: someTime},
+		{name: "RFC 850", lastMod: "Tuesday, 03-May-16 16:15:49 GMT", want: someTime},
+		{name: "valid off", lastMod: someTime.UTC().Format(http.TimeFormat), opts: []Option{WithModTime(false)}},
+		{name: "missing"},
+		{name: "malformed", lastMod: "yesterday", wantLog: `ignoring invalid Last-Modified header "yesterday"`},
+		{name: "malformed zone", lastMod: "Tue, 03 May 2016 16:15:49 PDT", wantLog: "ignoring invalid Last-Modified header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged = nil
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.lastMod != "" {
+					w.Header().Set("Last-Modified", tt.lastMod)
+				}
+				io.WriteString(w, "content")
+			}))
+			defer ts.Close()
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+
+			t0 := time.Now().Add(-time.Second) // allow for coarse file times
+			if err := Download(dstFile, ts.URL+"/foo.txt", tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(dstFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.want.IsZero() {
+				if !fi.ModTime().Equal(tt.want) {
+					t.Errorf("modtime = %v; want %v", fi.ModTime(), tt.want)
+				}
+			} else if fi.ModTime().Before(t0) {
+				t.Errorf("modtime = %v; want the download time, after %v", fi.ModTime(), t0)
+			}
+			if tt.wantLog == "" {
+				if len(logged) != 0 {
+					t.Errorf("logged %q; want nothing", logged)
+				}
+			} else if len(logged) == 0 || !strings.Contains(logged[0], tt.wantLog) {
+				t.Errorf("logged %q; want %q", logged, tt.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+// isNoSpace always reports false: Plan 9's file servers each describe
+// a full disk in their own words.
+func isNoSpace(err error) bool {
+	return false
+}
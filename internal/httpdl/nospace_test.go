@@ -0,0 +1,46 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !plan9
+// +build !plan9
+
+package httpdl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestWriteErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "some content")
+	}))
+	defer ts.Close()
+
+	var we *WriteError
+	enospc := &os.PathError{Op: "write", Path: "foo.txt", Err: syscall.ENOSPC}
+	_, err := DownloadTo(context.Background(), failWriter{enospc}, ts.URL+"/foo.txt")
+	if !errors.As(err, &we) || !we.NoSpace() || !errors.Is(err, syscall.ENOSPC) || IsRetryable(err) {
+		t.Errorf("error writing to a full disk = %v; want a *WriteError for which NoSpace is true", err)
+	}
+	// A network error from the writer isn't the download's.
+	_, err = DownloadTo(context.Background(), failWriter{&net.OpError{Op: "write", Err: syscall.EPIPE}}, ts.URL+"/foo.txt")
+	if !errors.As(err, &we) || IsRetryable(err) {
+		t.Errorf("error writing to a closed connection = %v; want a *WriteError that's not retryable", err)
+	}
+}
+
+// failWriter is an io.Writer that fails with err.
+type failWriter struct {
+	err error
+}
+
+func (w failWriter) Write(p []byte) (int, error) { return 0, w.err }
@@ -0,0 +1,144 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// An Option configures a download.
+type Option func(*options)
+
+// options are the settings for a download. The zero value is the
+// default.
+type options struct {
+	// resume is whether to keep the partially downloaded file of
+	// a failed download and continue it with a Range request next
+	// time. See WithResume.
+	resume bool
+
+	// sha256, if non-nil, is the downloaded file's expected
+	// SHA-256 checksum. See WithSHA256.
+	sha256 []byte
+
+	// sidecar is whether to get sha256 from the file's checksum
+	// file, and sidecarOptional whether it may be missing. See
+	// WithSidecarChecksum.
+	sidecar         bool
+	sidecarOptional bool
+
+	// progress, if non-nil, is called with the download's
+	// progress every progressInterval. See WithProgress.
+	progress         func(written, total int64)
+	progressInterval time.Duration
+
+	// client, if non-nil, sends the download's requests. See
+	// WithClient.
+	client *http.Client
+
+	// header has the extra headers to send with each request,
+	// and userAgent, if non-empty, is the User-Agent header. See
+	// WithHeader and WithUserAgent.
+	header    http.Header
+	userAgent string
+
+	// conditional is whether to ask the server for the file only
+	// if it's changed. See WithConditional.
+	conditional bool
+
+	// parallel, if more than 1, is how many concurrent Range
+	// requests to split the download into. See WithParallel.
+	parallel int
+
+	// rateLimit, if positive, is the most bytes per second to
+	// download, using bucket. See WithRateLimit.
+	rateLimit int64
+	bucket    *tokenBucket
+
+	// mirrors are the URLs passed to DownloadFrom, and
+	// usedMirror, if non-nil, is called with the one that
+	// worked. See WithUsedMirror.
+	mirrors    []string
+	usedMirror func(index int, url string)
+
+	// noModTime is whether to leave the downloaded file's
+	// modification time alone. See WithModTime.
+	noModTime bool
+
+	// restart, if non-nil, resets the writer of DownloadTo so a
+	// retry can write the body from its start again. See
+	// WithRestart.
+	restart func() error
+
+	// retry says whether and how failures are retried. See
+	// WithRetry.
+	retry RetryPolicy
+
+	// err is an error in the options themselves, such as an
+	// invalid checksum, which is returned by the download.
+	err error
+}
+
+func newOptions(opts []Option) *options {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.rateLimit > 0 {
+		o.bucket = newTokenBucket(o.rateLimit)
+	}
+	if o.header != nil {
+		o.client = stripOnRedirect(o.httpClient(), o.header)
+	}
+	return o
+}
+
+// WithResume sets whether a failed download's partial file is kept,
+// so a later download of the same URL to the same file can continue
+// where it stopped instead of starting over. The default is false.
+//
+// A download is only continued if the server's response had an
+// ETag or Last-Modified header, which is sent back as If-Range, so
+// the pieces of two different versions are never joined together.
+func WithResume(on bool) Option {
+	return func(o *options) { o.resume = on }
+}
+
+// WithClient makes the download send its requests with c, such as one
+// with a proxy, custom TLS roots, or an instrumented transport.
+// Any timeouts c has apply to each request, including the time to
+// read its body. By default, an internal client is used.
+func WithClient(c *http.Client) Option {
+	return func(o *options) { o.client = c }
+}
+
+// defaultClient is the client used without WithClient. Unlike
+// http.DefaultClient, it gives up on servers that accept connections
+// but don't answer, but it doesn't limit how long a response body
+// may take, so big downloads on slow links can finish.
+var defaultClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		ExpectContinueTimeout: time.Second,
+	},
+}
+
+// httpClient returns the client to send the download's requests with.
+func (o *options) httpClient() *http.Client {
+	if o.client != nil {
+		return o.client
+	}
+	return defaultClient
+}
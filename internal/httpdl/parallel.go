@@ -0,0 +1,191 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// minChunkSize is the smallest piece WithParallel splits a download
+// into. It's a variable for tests.
+var minChunkSize int64 = 4 << 20
+
+// WithParallel makes the download split the file into n pieces of at
+// least a few megabytes, fetched with concurrent Range requests, which
+// is often faster over high-latency links. It's only done if the
+// server's response to the HEAD request advertised Accept-Ranges and
+// had a Content-Length and an ETag or Last-Modified time, and not at
+// all for downloads made WithConditional; otherwise, or if the server
+// ignores the Range requests, the file is downloaded as one stream.
+// Each piece is retried according to WithRetry.
+//
+// The default, and anything less than 2, is one stream.
+func WithParallel(n int) Option {
+	return func(o *options) { o.parallel = n }
+}
+
+// errRangeIgnored is returned by fetchChunk if the server ignored its
+// Range request, because it doesn't support them or the file changed.
+var errRangeIgnored = errors.New("server ignored Range request")
+
+// fetchParallel downloads url to file in o.parallel concurrent
+// pieces, as described by head, the response to a HEAD request for
+// it. If the server doesn't support that, it returns false, and the
+// file should be downloaded normally.
+func (o *options) fetchParallel(ctx context.Context, file, url string, head *http.Response) (done bool, err error) {
+	size := head.ContentLength
+	validator := resumeValidator(head)
+	if head.Header.Get("Accept-Ranges") != "bytes" || size < 2*minChunkSize || validator == "" {
+		return false, nil
+	}
+	n := int64(o.parallel)
+	if max := size / minChunkSize; n > max {
+		n = max
+	}
+
+	tmp := partialFile(file)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return true, &WriteError{err}
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+	if err := f.Truncate(size); err != nil {
+		return true, &WriteError{err}
+	}
+
+	var p *progressWriter
+	if o.progress != nil {
+		p = startProgress(o.progress, o.progressInterval, 0, size)
+	}
+	chunkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, n)
+	var wg sync.WaitGroup
+	chunk := size / n
+	for i := int64(0); i < n; i++ {
+		start, end := i*chunk, (i+1)*chunk
+		if i == n-1 {
+			end = size
+		}
+		wg.Add(1)
+		go func(i, start, end int64) {
+			defer wg.Done()
+			if err := o.fetchChunk(chunkCtx, f, url, validator, start, end, p); err != nil {
+				errc <- fmt.Errorf("piece %d/%d: %w", i+1, n, err)
+				cancel()
+			}
+		}(i, start, end)
+	}
+	wg.Wait()
+	close(errc)
+	err = <-errc // the first error, if any
+	if err == nil {
+		err = writeError(f.Sync())
+	}
+	if p != nil {
+		p.finish(err == nil)
+	}
+	if cerr := f.Close(); err == nil {
+		err = writeError(cerr)
+	}
+	if err != nil {
+		if errors.Is(err, errRangeIgnored) {
+			os.Remove(tmp)
+			return false, nil
+		}
+		return true, ctxErr(ctx, err)
+	}
+
+	if o.sha256 != nil {
+		h := sha256.New()
+		if err = hashFile(h, tmp); err == nil {
+			err = checkSum(h, o.sha256)
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+	if modTime := lastModified(head); !o.noModTime && !modTime.IsZero() {
+		if err = os.Chtimes(tmp, modTime, modTime); err != nil {
+			return true, &WriteError{err}
+		}
+	}
+	if err = os.Rename(tmp, file); err != nil {
+		return true, &WriteError{err}
+	}
+	return true, writeError(saveETag(file, head, false))
+}
+
+// fetchChunk downloads the bytes [start, end) of url, which must
+// still match validator, to the same place in f, retrying according
+// to o.retry. It adds the bytes it writes to p, if it's non-nil.
+func (o *options) fetchChunk(ctx context.Context, f *os.File, url, validator string, start, end int64, p *progressWriter) error {
+	w := &offsetWriter{w: f, off: start}
+	return o.retry.do(ctx, func() error {
+		return o.fetchRange(ctx, w, url, validator, end, p)
+	})
+}
+
+// fetchRange makes one attempt at fetching the bytes from w.off up to
+// end of url into w.
+func (o *options) fetchRange(ctx context.Context, w *offsetWriter, url, validator string, end int64, p *progressWriter) error {
+	req, err := o.newRequest(ctx, "GET", url)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", w.off, end-1))
+	req.Header.Set("If-Range", validator)
+	res, err := o.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return errRangeIgnored
+	default:
+		return newHTTPError(url, res)
+	}
+	if err := checkContentRange(res.Header.Get("Content-Range"), w.off); err != nil {
+		return err
+	}
+	var dst io.Writer = writeErrWriter{w}
+	if p != nil {
+		dst = io.MultiWriter(dst, p)
+	}
+	want := end - w.off
+	n, err := io.Copy(dst, io.LimitReader(o.body(ctx, res.Body), want))
+	if err == nil && n < want {
+		err = fmt.Errorf("%w: got %d of %d bytes", ErrTruncated, n, want)
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w after %d bytes: %w", ErrTruncated, n, err)
+	}
+	return err
+}
+
+// An offsetWriter writes to w sequentially from off.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
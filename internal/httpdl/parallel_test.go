@@ -0,0 +1,150 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeServer serves content, recording the Range headers of GET
+// requests. If noRanges is set, it doesn't support Range requests.
+// The first failChunks GET requests with a Range header fail with
+// 503 Service Unavailable.
+type rangeServer struct {
+	content    string
+	noRanges   bool
+	failChunks int
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	someTime := time.Unix(1462292149, 0)
+	if r.Method == "GET" {
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		fail := false
+		if r.Header.Get("Range") != "" && s.failChunks > 0 {
+			s.failChunks--
+			fail = true
+		}
+		s.mu.Unlock()
+		if fail {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if s.noRanges {
+		w.Header().Set("Last-Modified", someTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+		if r.Method == "GET" {
+			io.WriteString(w, s.content)
+		}
+		return
+	}
+	w.Header().Set("ETag", `"v1"`)
+	http.ServeContent(w, r, "foo.txt", someTime, strings.NewReader(s.content))
+}
+
+func TestDownloadParallel(t *testing.T) {
+	defer func(v int64) { minChunkSize = v }(minChunkSize)
+	minChunkSize = 1000
+
+	var b strings.Builder
+	for i := 0; b.Len() < 10500; i++ {
+		b.WriteString(strconv.Itoa(i))
+	}
+	content := b.String()[:10500]
+	sum := sha256.Sum256([]byte(content))
+	retry := WithRetry(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond})
+
+	tests := []struct {
+		name       string
+		n          int
+		noRanges   bool
+		failChunks int
+		wantRanges []string
+	}{
+		{
+			name:       "4 pieces",
+			n:          4,
+			wantRanges: []string{"bytes=0-2624", "bytes=2625-5249", "bytes=5250-7874", "bytes=7875-10499"},
+		},
+		{
+			name:       "capped by minChunkSize",
+			n:          100,
+			wantRanges: []string{"bytes=0-1049", "bytes=1050-2099", "bytes=2100-3149", "bytes=3150-4199", "bytes=4200-5249", "bytes=5250-6299", "bytes=6300-7349", "bytes=7350-8399", "bytes=8400-9449", "bytes=9450-10499"},
+		},
+		{
+			name:       "retried piece",
+			n:          2,
+			failChunks: 1,
+			// One of the two is sent twice.
+			wantRanges: []string{"bytes=0-5249", "bytes=5250-10499"},
+		},
+		{
+			name:       "no Range support",
+			n:          4,
+			noRanges:   true,
+			wantRanges: []string{""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &rangeServer{content: content, noRanges: tt.noRanges, failChunks: tt.failChunks}
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+
+			if err := Download(dstFile, ts.URL+"/foo.txt", WithParallel(tt.n), WithSHA256(hex.EncodeToString(sum[:])), retry); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := ioutil.ReadFile(dstFile); err != nil || string(got) != content {
+				t.Errorf("downloaded %d bytes, %v; want the %d bytes of content", len(got), err, len(content))
+			}
+			if len(s.ranges) != len(tt.wantRanges)+tt.failChunks {
+				t.Errorf("%d GET requests; want %d", len(s.ranges), len(tt.wantRanges)+tt.failChunks)
+			}
+			ranges := dedup(s.ranges)
+			if strings.Join(ranges, ",") != strings.Join(tt.wantRanges, ",") {
+				t.Errorf("Range headers = %q; want %q", ranges, tt.wantRanges)
+			}
+			if fis, _ := ioutil.ReadDir(tmpDir); len(fis) != 1 {
+				t.Errorf("%d files in download directory; want 1", len(fis))
+			}
+		})
+	}
+}
+
+// dedup returns the sorted, distinct elements of l.
+func dedup(l []string) []string {
+	sort.Strings(l)
+	var out []string
+	for i, s := range l {
+		if i == 0 || s != l[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
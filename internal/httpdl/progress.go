@@ -0,0 +1,90 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// MinProgressInterval is the shortest interval WithProgress allows
+// between progress reports.
+const MinProgressInterval = 100 * time.Millisecond
+
+// WithProgress makes the download call f with the number of bytes
+// written so far and the total size, or -1 if the server didn't say,
+// every interval (or MinProgressInterval, if that's longer) while
+// they change, and once when the download completes.
+//
+// The calls are made from a single goroutine, one at a time. A slow f
+// doesn't slow the download down; it just gets fewer calls.
+func WithProgress(interval time.Duration, f func(written, total int64)) Option {
+	return func(o *options) {
+		if interval < MinProgressInterval {
+			interval = MinProgressInterval
+		}
+		o.progress = f
+		o.progressInterval = interval
+	}
+}
+
+// A progressWriter counts the bytes written through it and reports
+// the count to a progress callback from its own goroutine.
+type progressWriter struct {
+	written int64 // atomic; first so it's 64-bit aligned
+	total   int64
+	f       func(written, total int64)
+	stop    chan bool // receives whether the download succeeded
+	done    chan struct{}
+}
+
+// startProgress starts reporting progress to f every interval. The
+// download has already written offset of total bytes.
+func startProgress(f func(written, total int64), interval time.Duration, offset, total int64) *progressWriter {
+	p := &progressWriter{
+		written: offset,
+		total:   total,
+		f:       f,
+		stop:    make(chan bool),
+		done:    make(chan struct{}),
+	}
+	go p.loop(interval)
+	return p
+}
+
+func (p *progressWriter) loop(interval time.Duration) {
+	defer close(p.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	last := int64(-1)
+	for {
+		select {
+		case <-t.C:
+			// The ticker drops ticks while f is slow, so the
+			// next report just has the latest count.
+			if n := atomic.LoadInt64(&p.written); n != last {
+				p.f(n, p.total)
+				last = n
+			}
+		case ok := <-p.stop:
+			if ok {
+				p.f(atomic.LoadInt64(&p.written), p.total)
+			}
+			return
+		}
+	}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	atomic.AddInt64(&p.written, int64(len(b)))
+	return len(b), nil
+}
+
+// finish stops the progress reports, after a final one if the
+// download succeeded.
+func (p *progressWriter) finish(ok bool) {
+	p.stop <- ok
+	<-p.done
+}
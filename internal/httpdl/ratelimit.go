@@ -0,0 +1,102 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// WithRateLimit limits the download to bytesPerSec bytes per second,
+// in total across all its requests, including the concurrent ones
+// made WithParallel. Progress reports made WithProgress reflect the
+// limited rate. Zero, the default, means no limit.
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(o *options) { o.rateLimit = bytesPerSec }
+}
+
+// tokenBucket is a token bucket holding up to one second's worth of
+// bytes at rate bytes per second. It's safe for concurrent use.
+type tokenBucket struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{rate: float64(rate)}
+}
+
+// burst returns the most bytes that may be taken at once.
+func (b *tokenBucket) burst() int {
+	if b.rate < 1 {
+		return 1
+	}
+	return int(b.rate)
+}
+
+// take takes n tokens from the bucket, first waiting until the bucket
+// would have enough. It returns ctx.Err() if ctx is done first.
+func (b *tokenBucket) take(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if max := float64(b.burst()); b.tokens > max {
+			b.tokens = max
+		}
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	// Concurrent takers each wait for the tokens owed when they
+	// took theirs, so between them they keep to the rate.
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitReader reads from r no faster than b allows.
+type rateLimitReader struct {
+	ctx context.Context
+	r   io.Reader
+	b   *tokenBucket
+}
+
+func (r rateLimitReader) Read(p []byte) (int, error) {
+	if max := r.b.burst(); len(p) > max {
+		p = p[:max]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if terr := r.b.take(r.ctx, n); terr != nil {
+			return n, terr
+		}
+	}
+	return n, err
+}
+
+// body returns a reader for the response body rc that stops when ctx
+// is done and keeps to the download's rate limit, if any.
+func (o *options) body(ctx context.Context, rc io.Reader) io.Reader {
+	var r io.Reader = ctxReader{ctx, rc}
+	if o.bucket != nil {
+		r = rateLimitReader{ctx, r, o.bucket}
+	}
+	return r
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadRateLimit(t *testing.T) {
+	defer func(v int64) { minChunkSize = v }(minChunkSize)
+	minChunkSize = 1000
+
+	const size, rate = 20000, 20000
+	const want = time.Second * size / rate
+	content := strings.Repeat("x", size)
+	for _, parallel := range []int{1, 4} {
+		s := &rangeServer{content: content}
+		ts := httptest.NewServer(s)
+		defer ts.Close()
+		tmpDir, err := ioutil.TempDir("", "dl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		var first int64 = -1
+		progress := func(written, total int64) {
+			if first < 0 {
+				first = written
+			}
+		}
+		t0 := time.Now()
+		err = Download(filepath.Join(tmpDir, "foo.txt"), ts.URL+"/foo.txt",
+			WithRateLimit(rate), WithParallel(parallel), WithProgress(MinProgressInterval, progress))
+		d := time.Since(t0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d < want*8/10 || d > want*5 {
+			t.Errorf("parallel=%d: download took %v; want about %v", parallel, d, want)
+		}
+		if first < 0 || first > size/2 {
+			t.Errorf("parallel=%d: first progress report had %d of %d bytes written; want a throttled amount", parallel, first, size)
+		}
+		if len(s.ranges) != parallel {
+			t.Errorf("parallel=%d: %d GET requests", parallel, len(s.ranges))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A partial download to file is kept in partialFile(file), alongside
+// a state file, partialFile(file)+".resume", of two lines: the URL
+// being downloaded and the validator (a strong ETag or a
+// Last-Modified time) of the server's response, which is sent as
+// If-Range when the download is resumed.
+
+// partialFile returns the name of the file a download to file is
+// written to, and synced, before it's renamed to file.
+func partialFile(file string) string { return file + ".partial" }
+
+func resumeStateFile(file string) string { return partialFile(file) + ".resume" }
+
+// removePartial removes the partial download of file and its state.
+func removePartial(file string) {
+	os.Remove(partialFile(file))
+	os.Remove(resumeStateFile(file))
+}
+
+// partialState returns the size of the partial download of url to
+// file, and the validator to send with the request for the rest of
+// it. It returns 0 if there's nothing to resume.
+//
+// A partial download from one of mirrors, the URLs of the same file,
+// can be resumed from another only if its validator is an ETag. Two
+// servers might well have different versions of a file with the same
+// modification time, but not with the same strong ETag.
+func partialState(file, url string, mirrors []string) (offset int64, validator string) {
+	b, err := ioutil.ReadFile(resumeStateFile(file))
+	if err != nil {
+		return 0, ""
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 || lines[1] == "" {
+		return 0, ""
+	}
+	if lines[0] != url && !(isETag(lines[1]) && contains(mirrors, lines[0]) && contains(mirrors, url)) {
+		return 0, ""
+	}
+	fi, err := os.Stat(partialFile(file))
+	if err != nil || !fi.Mode().IsRegular() {
+		return 0, ""
+	}
+	return fi.Size(), lines[1]
+}
+
+// writeResumeState records that the partial download of file is of
+// url, with the given validator.
+func writeResumeState(file, url, validator string) error {
+	return ioutil.WriteFile(resumeStateFile(file), []byte(url+"\n"+validator+"\n"), 0666)
+}
+
+// resumeValidator returns the value for an If-Range header that makes
+// a later Range request for the rest of res's body fail unless the
+// object is unchanged, or "" if there's none.
+func resumeValidator(res *http.Response) string {
+	// Weak ETags can't be used with If-Range.
+	if etag := res.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	if mod := res.Header.Get("Last-Modified"); mod != "" {
+		if _, err := http.ParseTime(mod); err == nil {
+			return mod
+		}
+	}
+	return ""
+}
+
+// checkContentRange checks that the Content-Range header v of a 206
+// response is for the bytes from offset on.
+func checkContentRange(v string, offset int64) error {
+	rest := strings.TrimPrefix(v, "bytes ")
+	i := strings.Index(rest, "-")
+	if rest == v || i < 0 {
+		return fmt.Errorf("invalid Content-Range %q", v)
+	}
+	start, err := strconv.ParseInt(rest[:i], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid Content-Range %q", v)
+	}
+	if start != offset {
+		return fmt.Errorf("Content-Range %q doesn't start at the requested byte %d", v, offset)
+	}
+	return nil
+}
+
+// isETag reports whether the validator v is an ETag rather than a
+// Last-Modified time.
+func isETag(v string) bool { return strings.HasPrefix(v, `"`) }
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A RetryPolicy says whether and how a failed download is retried.
+// Only failures that might not happen again, as reported by
+// IsRetryable, are retried: network errors, including timeouts and
+// connections cut off early, and HTTP 5xx, 408 Request Timeout, and
+// 429 Too Many Requests responses.
+// Each retry continues from where the last attempt stopped if the
+// server supports Range requests.
+type RetryPolicy struct {
+	// MaxAttempts is the most attempts to make, including the
+	// first. If it's less than 2, nothing is retried.
+	MaxAttempts int
+
+	// Backoff is how long to wait before the first retry. Each
+	// later wait doubles, up to MaxBackoff, if that's positive.
+	// A server's Retry-After header, up to maxRetryAfter, can
+	// make a wait longer.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction, from 0 to 1, of each wait that's
+	// random, so clients that failed together don't all retry
+	// together.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is a RetryPolicy for WithRetry that suits most
+// downloads.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     time.Second,
+	MaxBackoff:  30 * time.Second,
+	Jitter:      0.5,
+}
+
+// WithRetry makes the download retry failures according to p. By
+// default, nothing is retried.
+//
+// When the retries are used up, the error returned wraps the last
+// attempt's error and says how many attempts were made.
+func WithRetry(p RetryPolicy) Option {
+	return func(o *options) { o.retry = p }
+}
+
+// maxRetryAfter caps how long a server's Retry-After header can make
+// a download wait before its next attempt.
+const maxRetryAfter = 5 * time.Minute
+
+// wait waits before the retry after the nth attempt (starting at 1),
+// which failed with err. It returns ctx.Err() if ctx is done first.
+func (p RetryPolicy) wait(ctx context.Context, n int, err error) error {
+	d := p.Backoff
+	for i := 1; i < n && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if jitter := math.Min(p.Jitter, 1); jitter > 0 {
+		j := time.Duration(jitter * float64(d))
+		d = d - j + time.Duration(rand.Int63n(int64(j)+1))
+	}
+	var he *HTTPError
+	if errors.As(err, &he) && he.RetryAfter > d {
+		d = he.RetryAfter
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do calls f until it succeeds, fails in a way that's not retryable,
+// or has been called p.MaxAttempts times, waiting between calls. It
+// returns f's last error, which, if it's been retried, says how many
+// attempts were made.
+func (p RetryPolicy) do(ctx context.Context, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			if attempt > 1 {
+				err = fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return finalError{err}
+		}
+		if werr := p.wait(ctx, attempt, err); werr != nil {
+			return werr
+		}
+	}
+}
+
+// A finalError is an error that's already been retried as much as it
+// should be.
+type finalError struct {
+	err error
+}
+
+func (e finalError) Error() string { return e.err.Error() }
+func (e finalError) Unwrap() error { return e.err }
+
+// retryable reports whether the failure err should be retried: if it
+// might not happen again, and it hasn't been retried already.
+func retryable(err error) bool {
+	return !errors.As(err, new(finalError)) && IsRetryable(err)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is
+// either a number of seconds or an HTTP date, into a delay from now
+// of at most maxRetryAfter. It returns 0 if v is empty or invalid.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	var d time.Duration
+	v = strings.TrimSpace(v)
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if n > int64(maxRetryAfter/time.Second) {
+			n = int64(maxRetryAfter / time.Second)
+		}
+		d = time.Duration(n) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(now)
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
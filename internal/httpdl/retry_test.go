@@ -0,0 +1,146 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer serves content, but fails the first fails GET requests
+// in the way given by mode: "cut" cuts the response off halfway, and
+// a number is a status code to respond with.
+type flakyServer struct {
+	content    string
+	mode       string
+	fails      int
+	retryAfter string
+
+	mu     sync.Mutex
+	ranges []string // Range headers of GET requests
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	someTime := time.Unix(1462292149, 0)
+	w.Header().Set("ETag", `"v1"`)
+	if r.Method == "GET" {
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		fail := len(s.ranges) <= s.fails
+		s.mu.Unlock()
+		if fail && s.mode == "cut" {
+			w.Header().Set("Last-Modified", someTime.UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+			io.WriteString(w, s.content[:len(s.content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		if fail {
+			code, _ := strconv.Atoi(s.mode)
+			if s.retryAfter != "" {
+				w.Header().Set("Retry-After", s.retryAfter)
+			}
+			http.Error(w, "flaky", code)
+			return
+		}
+	}
+	http.ServeContent(w, r, "foo.txt", someTime, strings.NewReader(s.content))
+}
+
+func TestDownloadRetry(t *testing.T) {
+	const content = "some content, which is long enough to cut in half"
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Jitter: 0.5}
+	tests := []struct {
+		name       string
+		mode       string
+		fails      int
+		retryAfter string
+		wantErr    string // or "" for success
+		wantGets   int
+		wantRange  string // Range header of the last GET
+		minTime    time.Duration
+	}{
+		{name: "503 once", mode: "503", fails: 1, wantGets: 2},
+		{name: "408 twice", mode: "408", fails: 2, wantGets: 3},
+		{name: "429 Retry-After", mode: "429", fails: 1, retryAfter: "1", wantGets: 2, minTime: time.Second},
+		{name: "cut", mode: "cut", fails: 1, wantGets: 2, wantRange: "bytes=" + strconv.Itoa(len(content)/2) + "-"},
+		{name: "500 exhausted", mode: "500", fails: 3, wantErr: "giving up after 3 attempts: HTTP status code of", wantGets: 3},
+		{name: "404", mode: "404", fails: 1, wantErr: "404 Not Found", wantGets: 1},
+		{name: "403", mode: "403", fails: 1, wantErr: "403 Forbidden", wantGets: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &flakyServer{content: content, mode: tt.mode, fails: tt.fails, retryAfter: tt.retryAfter}
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+
+			t0 := time.Now()
+			err = Download(dstFile, ts.URL+"/foo.txt", WithRetry(policy))
+			if d := time.Since(t0); d < tt.minTime {
+				t.Errorf("download took %v; want at least %v", d, tt.minTime)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b, err := ioutil.ReadFile(dstFile); err != nil || string(b) != content {
+					t.Errorf("downloaded %q, %v; want %q", b, err, content)
+				}
+			} else {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v; want %q", err, tt.wantErr)
+				}
+				var he *HTTPError
+				if !errors.As(err, &he) {
+					t.Errorf("error %v doesn't wrap the last attempt's", err)
+				}
+				if fis, _ := ioutil.ReadDir(tmpDir); len(fis) != 0 {
+					t.Errorf("%d files left behind, including %s", len(fis), fis[0].Name())
+				}
+			}
+			if len(s.ranges) != tt.wantGets {
+				t.Errorf("%d GET requests; want %d", len(s.ranges), tt.wantGets)
+			} else if got := s.ranges[len(s.ranges)-1]; got != tt.wantRange {
+				t.Errorf("last Range header = %q; want %q", got, tt.wantRange)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		v    string
+		want time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"100000", maxRetryAfter},
+		{"-5", 0},
+		{"soon", 0},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	} {
+		if got := parseRetryAfter(tt.v, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v; want %v", tt.v, got, tt.want)
+		}
+	}
+}
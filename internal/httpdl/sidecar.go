@@ -0,0 +1,131 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// WithSidecarChecksum makes the download fetch the file's SHA-256
+// checksum from url + ".sha256" and fail with an *ErrChecksumMismatch
+// unless the downloaded file has it, as WithSHA256 does. The checksum
+// file holds either just the checksum in hex or the output of
+// sha256sum. It's fetched from the same mirror as the file itself,
+// before the file, so the file can be verified as it's written, and
+// fetching it is retried according to WithRetry. If it doesn't exist,
+// the download fails.
+//
+// If WithSHA256 or WithSHA256Sum is also given, that checksum is used
+// and the checksum file isn't fetched.
+func WithSidecarChecksum() Option {
+	return func(o *options) { o.sidecar, o.sidecarOptional = true, false }
+}
+
+// WithOptionalSidecarChecksum is like WithSidecarChecksum, but if the
+// checksum file doesn't exist, that's logged and the download isn't
+// verified.
+func WithOptionalSidecarChecksum() Option {
+	return func(o *options) { o.sidecar, o.sidecarOptional = true, true }
+}
+
+// maxSidecarSize is the largest checksum file fetchSidecar reads.
+const maxSidecarSize = 64 << 10
+
+// withSidecar returns the options for downloading url, which are o
+// with the checksum of its checksum file, if o says to use one.
+func (o *options) withSidecar(ctx context.Context, url string) (*options, error) {
+	if !o.sidecar || o.sha256 != nil {
+		return o, nil
+	}
+	sum, err := o.fetchSidecar(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	o2 := *o
+	o2.sha256 = sum
+	return &o2, nil
+}
+
+// fetchSidecar fetches the SHA-256 checksum of url from its checksum
+// file. It returns nil if there's none and o.sidecarOptional is set.
+func (o *options) fetchSidecar(ctx context.Context, url string) ([]byte, error) {
+	sumURL := url + ".sha256"
+	var b []byte
+	err := o.retry.do(ctx, func() error {
+		res, err := o.get(ctx, cacheBust(sumURL), 0, "", nil)
+		if err != nil {
+			return ctxErr(ctx, err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return newHTTPError(sumURL, res)
+		}
+		b, err = ioutil.ReadAll(io.LimitReader(res.Body, maxSidecarSize))
+		return ctxErr(ctx, err)
+	})
+	if err != nil {
+		var he *HTTPError
+		if errors.As(err, &he) && he.StatusCode == http.StatusNotFound && o.sidecarOptional {
+			logf("httpdl: no checksum file for %s; not verifying it", url)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("fetching checksum: %w", err)
+	}
+	sum, err := parseSidecar(b, path.Base(strings.SplitN(url, "?", 2)[0]))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", sumURL, err)
+	}
+	return sum, nil
+}
+
+// parseSidecar parses the contents of the checksum file for the file
+// named name. It's either just the checksum in hex or lines of the
+// checksum, a space, a space or asterisk, and a file name, as written
+// by sha256sum; with more than one line, one must be for name.
+func parseSidecar(b []byte, name string) ([]byte, error) {
+	var lines [][]string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		if f := strings.Fields(sc.Text()); len(f) > 0 {
+			lines = append(lines, f)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	var hexSum string
+	switch {
+	case len(lines) == 0:
+		return nil, fmt.Errorf("empty checksum file")
+	case len(lines) == 1:
+		hexSum = lines[0][0]
+	default:
+		for _, f := range lines {
+			if len(f) >= 2 && strings.TrimPrefix(f[1], "*") == name {
+				hexSum = f[0]
+				break
+			}
+		}
+		if hexSum == "" {
+			return nil, fmt.Errorf("no checksum for %s", name)
+		}
+	}
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 checksum %q", hexSum)
+	}
+	return sum, nil
+}
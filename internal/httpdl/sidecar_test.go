@@ -0,0 +1,235 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sidecarServer serves sum at paths ending in .sha256, or 404 Not
+// Found if it's empty, and passes other requests on to h.
+type sidecarServer struct {
+	h   http.Handler
+	sum string
+
+	mu   sync.Mutex
+	gets int // of the checksum file
+}
+
+func (s *sidecarServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, ".sha256") {
+		s.h.ServeHTTP(w, r)
+		return
+	}
+	s.mu.Lock()
+	s.gets++
+	s.mu.Unlock()
+	if s.sum == "" {
+		http.NotFound(w, r)
+		return
+	}
+	io.WriteString(w, s.sum)
+}
+
+func hexSum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseSidecar(t *testing.T) {
+	sum := hexSum("x")
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{in: sum},
+		{in: sum + "\n"},
+		{in: strings.ToUpper(sum)},
+		{in: sum + "  foo.tar.gz\n"},
+		{in: sum + " *foo.tar.gz\n"},
+		{in: sum + "  other.tar.gz\n"}, // only one line, so it's used
+		{in: hexSum("y") + "  bar.tar.gz\n" + sum + "  foo.tar.gz\n\n"},
+		{in: hexSum("y") + "  bar.tar.gz\n" + sum + "  baz.tar.gz\n", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "\n\n", wantErr: true},
+		{in: "not hex", wantErr: true},
+		{in: sum[:62], wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSidecar([]byte(tt.in), "foo.tar.gz")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSidecar(%q) = %x; want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || hex.EncodeToString(got) != sum {
+			t.Errorf("parseSidecar(%q) = %x, %v; want %s", tt.in, got, err, sum)
+		}
+	}
+}
+
+func TestDownloadSidecar(t *testing.T) {
+	defer func(f func(string, ...interface{})) { logf = f }(logf)
+	var logged []string
+	logf = func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+
+	const content = "some content"
+	tests := []struct {
+		name     string
+		sum      string
+		optional bool
+		wantErr  string // or "" for success
+		wantLog  bool
+	}{
+		{name: "bare", sum: hexSum(content) + "\n"},
+		{name: "sha256sum", sum: hexSum(content) + "  foo.txt\n"},
+		{name: "mismatch", sum: hexSum("other content"), wantErr: "checksum mismatch"},
+		{name: "missing", wantErr: "404 Not Found"},
+		{name: "missing optional", optional: true, wantLog: true},
+		{name: "invalid", sum: "not a checksum", wantErr: "invalid SHA-256 checksum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged = nil
+			s := &sidecarServer{h: &flakyServer{content: content}, sum: tt.sum}
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+			tmpDir, err := ioutil.TempDir("", "dl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dstFile := filepath.Join(tmpDir, "foo.txt")
+
+			opt := WithSidecarChecksum()
+			if tt.optional {
+				opt = WithOptionalSidecarChecksum()
+			}
+			err = Download(dstFile, ts.URL+"/foo.txt", opt)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b, err := ioutil.ReadFile(dstFile); err != nil || string(b) != content {
+					t.Errorf("downloaded %q, %v; want %q", b, err, content)
+				}
+			} else {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v; want %q", err, tt.wantErr)
+				}
+				if fis, _ := ioutil.ReadDir(tmpDir); len(fis) != 0 {
+					t.Errorf("%d files left behind, including %s", len(fis), fis[0].Name())
+				}
+			}
+			if got := len(logged) > 0; got != tt.wantLog {
+				t.Errorf("logged %q; want a log message: %v", logged, tt.wantLog)
+			}
+			if s.gets != 1 {
+				t.Errorf("checksum file fetched %d times; want 1", s.gets)
+			}
+		})
+	}
+
+	// The checksum given explicitly takes precedence.
+	s := &sidecarServer{h: &flakyServer{content: content}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	tmpDir, err := ioutil.TempDir("", "dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := Download(filepath.Join(tmpDir, "foo.txt"), ts.URL+"/foo.txt", WithSidecarChecksum(), WithSHA256(hexSum(content))); err != nil {
+		t.Fatal(err)
+	}
+	if s.gets != 0 {
+		t.Errorf("checksum file fetched %d times; want 0", s.gets)
+	}
+}
+
+func TestDownloadSidecarMirrors(t *testing.T) {
+	// The first mirror's file is broken, and the second mirror
+	// has a different version of it, with a different checksum.
+	s1 := &sidecarServer{h: &flakyServer{content: "old content", mode: "500", fails: 1 << 30}, sum: hexSum("old content")}
+	s2 := &sidecarServer{h: &flakyServer{content: "new content"}, sum: hexSum("new content")}
+	ts1, ts2 := httptest.NewServer(s1), httptest.NewServer(s2)
+	defer ts1.Close()
+	defer ts2.Close()
+	tmpDir, err := ioutil.TempDir("", "dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dstFile := filepath.Join(tmpDir, "foo.txt")
+
+	urls := []string{ts1.URL + "/foo.txt", ts2.URL + "/foo.txt"}
+	if err := DownloadFrom(dstFile, urls, WithSidecarChecksum()); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(dstFile); err != nil || string(b) != "new content" {
+		t.Errorf("downloaded %q, %v; want %q", b, err, "new content")
+	}
+	if s1.gets != 1 || s2.gets != 1 {
+		t.Errorf("checksum files fetched %d and %d times; want 1 each", s1.gets, s2.gets)
+	}
+}
+
+func TestDownloadSidecarResume(t *testing.T) {
+	const content = "some content, which is long enough to cut in half"
+	rs := new(resumeServer)
+	s := &sidecarServer{h: rs, sum: hexSum(content)}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	tmpDir, err := ioutil.TempDir("", "dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dstFile := filepath.Join(tmpDir, "foo.txt")
+
+	rs.set(content, `"v1"`, true)
+	if err := Download(dstFile, ts.URL+"/foo.txt", WithResume(true), WithSidecarChecksum()); err == nil {
+		t.Fatal("cut-off download succeeded")
+	}
+	rs.set(content, `"v1"`, false)
+	if err := Download(dstFile, ts.URL+"/foo.txt", WithResume(true), WithSidecarChecksum()); err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.ranges) != 1 || rs.ranges[0] == "" {
+		t.Errorf("Range headers = %q; want one resuming the download", rs.ranges)
+	}
+
+	// A download resumed from a partial file of different content
+	// fails, and the partial file is removed.
+	os.Remove(dstFile)
+	rs.set("SOME CONTENT, WHICH IS LONG ENOUGH TO CUT IN HALF", `"v1"`, true)
+	Download(dstFile, ts.URL+"/foo.txt", WithResume(true), WithSidecarChecksum())
+	rs.set(content, `"v1"`, false)
+	var mismatch *ErrChecksumMismatch
+	if err := Download(dstFile, ts.URL+"/foo.txt", WithResume(true), WithSidecarChecksum()); !errors.As(err, &mismatch) {
+		t.Fatalf("error = %v; want an *ErrChecksumMismatch", err)
+	}
+	if _, err := os.Stat(partialFile(dstFile)); !os.IsNotExist(err) {
+		t.Errorf("partial file left after checksum mismatch: %v", err)
+	}
+	if err := Download(dstFile, ts.URL+"/foo.txt", WithResume(true), WithSidecarChecksum()); err != nil {
+		t.Fatalf("download after mismatch: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Stats describes a completed download.
+type Stats struct {
+	// Size is the size of the downloaded file.
+	Size int64
+
+	// Received is how many bytes of the body were received. It's
+	// more than Size if a retry had to start over, and less if
+	// the download continued an earlier one.
+	Received int64
+
+	// Attempts is how many requests were made for the body,
+	// including retries.
+	Attempts int
+
+	// ModTime is the server's Last-Modified time for the file,
+	// or the zero time if it didn't send a valid one.
+	ModTime time.Time
+}
+
+// DownloadTo downloads url to w, such as a decompressor or a hash,
+// with the retries, checksum verification, and other settings of
+// opts. WithResume, WithConditional, and WithParallel only apply to
+// downloads to files, and are ignored.
+//
+// A retry can only continue after part of the body has been written
+// if w is an io.WriterAt, such as an *os.File, in which case the body
+// is written with WriteAt at offsets from 0 and the retry continues
+// where the failed attempt stopped, using a Range request. (If the
+// server sends the whole body instead, it's written again from 0,
+// after truncating w if it has a Truncate method.) Otherwise, what's
+// been written can't be taken back, so such failures are only retried
+// WithRestart.
+func DownloadTo(ctx context.Context, w io.Writer, url string, opts ...Option) (Stats, error) {
+	o := newOptions(opts)
+	if o.err != nil {
+		return Stats{}, o.err
+	}
+	o, err := o.withSidecar(ctx, url)
+	if err != nil {
+		return Stats{}, err
+	}
+	return o.stream(ctx, w, cacheBust(url), new(streamState))
+}
+
+// WithRestart lets DownloadTo retry a failure after writing part of
+// the body to a writer that isn't an io.WriterAt. Before writing the
+// body from its start again, it calls reset, which should discard
+// what was written so far, such as by resetting a buffer. If reset
+// fails, so does the download.
+func WithRestart(reset func() error) Option {
+	return func(o *options) { o.restart = reset }
+}
+
+// A streamState is the state of a download carried from one attempt
+// to the next.
+type streamState struct {
+	off       int64     // bytes of the body written so far
+	validator string    // the ETag or Last-Modified time of that part
+	h         hash.Hash // the hash of that part, for WithSHA256
+
+	// cond has the headers that make the request conditional,
+	// or is nil if it's not.
+	cond http.Header
+
+	// check, if non-nil, is called with each response before its
+	// body is written, and fails the attempt if it returns an
+	// error.
+	check func(res *http.Response) error
+}
+
+// restart sets s up to write the body to w from its start again.
+func (s *streamState) restart(w io.Writer) error {
+	s.off, s.validator = 0, ""
+	if s.h != nil {
+		s.h.Reset()
+	}
+	if t, ok := w.(interface{ Truncate(int64) error }); ok {
+		return writeError(t.Truncate(0))
+	}
+	return nil
+}
+
+// stream downloads url to w from s.off on, retrying according to
+// o.retry.
+func (o *options) stream(ctx context.Context, w io.Writer, url string, s *streamState) (Stats, error) {
+	var st Stats
+	if o.sha256 != nil && s.h == nil {
+		s.h = sha256.New()
+	}
+	_, canResume := w.(io.WriterAt)
+	err := o.retry.do(ctx, func() error {
+		st.Attempts++
+		err := o.attempt(ctx, w, url, s, &st)
+		if err != nil && s.off > 0 && !canResume && o.restart == nil {
+			return finalError{err}
+		}
+		return err
+	})
+	st.Size = s.off
+	if err == nil && s.h != nil {
+		err = checkSum(s.h, o.sha256)
+	}
+	return st, err
+}
+
+// attempt makes one attempt at writing the rest of url's body to w.
+func (o *options) attempt(ctx context.Context, w io.Writer, url string, s *streamState, st *Stats) error {
+	wa, _ := w.(io.WriterAt)
+	if s.off > 0 && (wa == nil || s.validator == "") {
+		// There's no continuing where the last attempt
+		// stopped, so start over.
+		if wa == nil {
+			if err := o.restart(); err != nil {
+				return finalError{err}
+			}
+		}
+		if err := s.restart(w); err != nil {
+			return finalError{err}
+		}
+	}
+	res, err := o.get(ctx, url, s.off, s.validator, s.cond)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	if res.StatusCode == http.StatusRequestedRangeNotSatisfiable && s.off > 0 {
+		// The part written so far is no good after all. Start
+		// over.
+		res.Body.Close()
+		if err := s.restart(w); err != nil {
+			return finalError{err}
+		}
+		res, err = o.get(ctx, url, 0, "", s.cond)
+		if err != nil {
+			return ctxErr(ctx, err)
+		}
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotModified && s.cond != nil:
+		return ErrNotModified
+	case res.StatusCode == http.StatusPartialContent && s.off > 0:
+		if err := checkContentRange(res.Header.Get("Content-Range"), s.off); err != nil {
+			s.restart(w)
+			return fmt.Errorf("resuming download of %s: %v", url, err)
+		}
+	case res.StatusCode == 200:
+		// The server ignored the Range request, or the object
+		// changed since the last attempt. Start over.
+		if s.off > 0 {
+			if err := s.restart(w); err != nil {
+				return finalError{err}
+			}
+		}
+	default:
+		return newHTTPError(url, res)
+	}
+	if s.check != nil {
+		if err := s.check(res); err != nil {
+			return err
+		}
+	}
+	if s.off == 0 {
+		s.validator = resumeValidator(res)
+	}
+	st.ModTime = lastModified(res)
+
+	var dst io.Writer = writeErrWriter{w}
+	if wa != nil {
+		dst = writeErrWriter{&offsetWriter{w: wa, off: s.off}}
+	}
+	if s.h != nil {
+		dst = io.MultiWriter(dst, s.h)
+	}
+	var p *progressWriter
+	if o.progress != nil {
+		total := res.ContentLength
+		if total >= 0 {
+			total += s.off
+		}
+		p = startProgress(o.progress, o.progressInterval, s.off, total)
+		dst = io.MultiWriter(dst, p)
+	}
+	n, err := io.Copy(dst, o.body(ctx, res.Body))
+	s.off += n
+	st.Received += n
+	if err == nil && res.ContentLength >= 0 && n != res.ContentLength {
+		err = fmt.Errorf("%w: got %d of %d bytes", ErrTruncated, n, res.ContentLength)
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w after %d bytes: %w", ErrTruncated, n, err)
+	}
+	if p != nil {
+		p.finish(err == nil)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("error copying %v: %w", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpdl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadTo(t *testing.T) {
+	const content = "some content, which is long enough to cut in half"
+	half := len(content) / 2
+	retry := WithRetry(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	tests := []struct {
+		name      string
+		mode      string // of the flakyServer, which fails once
+		file      bool   // write to a file, not a bytes.Buffer
+		restart   bool
+		wantErr   string // or "" for success
+		wantStats Stats
+		wantRange string // Range header of the last GET
+	}{
+		{
+			name:      "503",
+			mode:      "503",
+			wantStats: Stats{Size: int64(len(content)), Received: int64(len(content)), Attempts: 2},
+		},
+		{
+			name:      "cut",
+			mode:      "cut",
+			wantErr:   "unexpected EOF",
+			wantStats: Stats{Size: int64(half), Received: int64(half), Attempts: 1},
+		},
+		{
+			name:      "cut restart",
+			mode:      "cut",
+			restart:   true,
+			wantStats: Stats{Size: int64(len(content)), Received: int64(len(content) + half), Attempts: 2},
+		},
+		{
+			name:      "cut file",
+			mode:      "cut",
+			file:      true,
+			wantStats: Stats{Size: int64(len(content)), Received: int64(len(content)), Attempts: 2},
+			wantRange: "bytes=" + strconv.Itoa(half) + "-",
+		},
+		{
+			name:      "404 file",
+			mode:      "404",
+			file:      true,
+			wantErr:   "404 Not Found",
+			wantStats: Stats{Attempts: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &flakyServer{content: content, mode: tt.mode, fails: 1}
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+
+			var w io.Writer
+			var buf bytes.Buffer
+			var f *os.File
+			opts := []Option{retry}
+			if tt.file {
+				var err error
+				f, err = ioutil.TempFile("", "dl")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.Remove(f.Name())
+				defer f.Close()
+				w = f
+			} else {
+				w = &buf
+				if tt.restart {
+					opts = append(opts, WithRestart(func() error {
+						buf.Reset()
+						return nil
+					}))
+				}
+			}
+			st, err := DownloadTo(context.Background(), w, ts.URL+"/foo.txt", opts...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := buf.String()
+				if f != nil {
+					b, _ := ioutil.ReadFile(f.Name())
+					got = string(b)
+				}
+				if got != content {
+					t.Errorf("downloaded %q; want %q", got, content)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v; want %q", err, tt.wantErr)
+			}
+			if tt.wantStats.Size > 0 {
+				tt.wantStats.ModTime = time.Unix(1462292149, 0)
+			}
+			if !st.ModTime.Equal(tt.wantStats.ModTime) {
+				t.Errorf("ModTime = %v; want %v", st.ModTime, tt.wantStats.ModTime)
+			}
+			st.ModTime = tt.wantStats.ModTime
+			if st != tt.wantStats {
+				t.Errorf("stats = %+v; want %+v", st, tt.wantStats)
+			}
+			if got := s.ranges[len(s.ranges)-1]; got != tt.wantRange {
+				t.Errorf("last Range header = %q; want %q", got, tt.wantRange)
+			}
+		})
+	}
+}
+
+func TestDownloadToSHA256(t *testing.T) {
+	const content = "some content"
+	ts := httptest.NewServer(&flakyServer{content: content})
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	good := sha256.Sum256([]byte(content))
+	if _, err := DownloadTo(context.Background(), &buf, ts.URL+"/foo.txt", WithSHA256Sum(good[:])); err != nil {
+		t.Fatal(err)
+	}
+	bad := sha256.Sum256([]byte("other content"))
+	_, err := DownloadTo(context.Background(), &buf, ts.URL+"/foo.txt", WithSHA256Sum(bad[:]))
+	if !errors.As(err, new(*ErrChecksumMismatch)) {
+		t.Errorf("error = %v; want an *ErrChecksumMismatch", err)
+	}
+}